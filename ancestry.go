@@ -0,0 +1,30 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func (db *merkleDB) Ancestry(root Root, n int) ([]Anchor, error) {
+	a, err := db.GetAnchor(root)
+	if err != nil {
+		return nil, err
+	}
+	var out []Anchor
+	seen := map[Root]struct{}{root: {}}
+	for len(out) < n && a.Parent != (Root{}) {
+		if _, ok := seen[a.Parent]; ok {
+			// a tree cannot be its own ancestor, the recorded parents are wrong
+			break
+		}
+		a, err = db.GetAnchor(a.Parent)
+		if err == leveldb.ErrNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		seen[a.Root] = struct{}{}
+		out = append(out, a)
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Ancestry(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	var roots []Root
+	for slot := uint64(0); slot < 5; slot++ {
+		var opts []PutOption
+		if slot > 0 {
+			opts = append(opts, WithParent(roots[slot-1]))
+		}
+		n := randomTree(4)
+		if _, err := mdb.Put(slot, n, hFn, opts...); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.MerkleRoot(hFn))
+	}
+	// a fork off slot 2
+	fork := randomTree(4)
+	if _, err := mdb.Put(3, fork, hFn, WithParent(roots[2])); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.Ancestry(roots[4], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 || out[0].Root != roots[3] || out[2].Root != roots[1] {
+		t.Fatalf("unexpected ancestry: %v", out)
+	}
+	out, err = mdb.Ancestry(fork.MerkleRoot(hFn), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 || out[0].Root != roots[2] || out[2].Root != roots[0] {
+		t.Fatalf("unexpected fork ancestry: %v", out)
+	}
+	// parents are kept when the tree is put again without one, and stop at pruned anchors
+	n, _ := mdb.Get(RootGindex, roots[1])
+	if _, err := mdb.Put(1, n.Node, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Delete(RootGindex, roots[0]); err != nil {
+		t.Fatal(err)
+	}
+	out, err = mdb.Ancestry(roots[4], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 || out[2].Root != roots[1] || out[2].Parent != roots[0] {
+		t.Fatalf("unexpected ancestry after delete: %v", out)
+	}
+}
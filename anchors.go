@@ -0,0 +1,177 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"time"
+)
+
+const (
+	metaAnchor    byte = 'a'
+	metaRef       byte = 'r'
+	metaTombstone byte = 't'
+)
+
+const metaKeyLen = prefixLen + gindexLenByteLen + 1
+
+// Anchor is the record of a tree that was Put in the DB
+type Anchor struct {
+	Root Root
+	Slot uint64
+	// InsertedAt is the zero time if no clock was configured during the Put
+	InsertedAt time.Time
+	// Canonical is true if the anchor was marked canonical, see SetCanonical
+	Canonical bool
+	// Parent is the anchor root of the parent tree, zero if none was recorded. See WithParent.
+	Parent Root
+	// Provenance identifies what produced the tree, e.g. the beacon block root, zero if none was recorded. See WithProvenance.
+	Provenance Root
+}
+
+const anchorVersion = 0
+
+// Fields are only ever appended to the anchor record; shorter records of older versions decode with zero values.
+const anchorRecordMinLen = 1 + 8
+
+const anchorRecordLen = 1 + 8 + 8 + 1 + 32 + 32
+
+const anchorFlagCanonical byte = 1 << 0
+
+func (a *Anchor) encode() []byte {
+	out := make([]byte, anchorRecordLen)
+	out[0] = anchorVersion
+	binary.LittleEndian.PutUint64(out[1:1+8], a.Slot)
+	if !a.InsertedAt.IsZero() {
+		binary.LittleEndian.PutUint64(out[1+8:1+8+8], uint64(a.InsertedAt.UnixNano()))
+	}
+	if a.Canonical {
+		out[1+8+8] |= anchorFlagCanonical
+	}
+	copy(out[1+8+8+1:1+8+8+1+32], a.Parent[:])
+	copy(out[1+8+8+1+32:], a.Provenance[:])
+	return out
+}
+
+func (a *Anchor) decode(root Root, v []byte) error {
+	if len(v) < anchorRecordMinLen {
+		return fmt.Errorf("%w: anchor '%x' has corrupt record, too short: '%x'", ErrMalformed, root, v)
+	}
+	if v[0] != anchorVersion {
+		return fmt.Errorf("%w: anchor '%x' has unknown record version: %d", ErrMalformed, root, v[0])
+	}
+	*a = Anchor{Root: root}
+	a.Slot = binary.LittleEndian.Uint64(v[1 : 1+8])
+	if len(v) >= 1+8+8 {
+		if t := binary.LittleEndian.Uint64(v[1+8 : 1+8+8]); t != 0 {
+			a.InsertedAt = time.Unix(0, int64(t))
+		}
+	}
+	if len(v) >= 1+8+8+1 {
+		a.Canonical = v[1+8+8]&anchorFlagCanonical != 0
+	}
+	if len(v) >= 1+8+8+1+32 {
+		copy(a.Parent[:], v[1+8+8+1:1+8+8+1+32])
+	}
+	if len(v) >= 1+8+8+1+32+32 {
+		copy(a.Provenance[:], v[1+8+8+1+32:1+8+8+1+32+32])
+	}
+	return nil
+}
+
+func (db *merkleDB) metaKey(kind byte, id []byte) []byte {
+	return metaKeyOf(db.prefix, kind, id)
+}
+
+func metaKeyOf(prefix [prefixLen]byte, kind byte, id []byte) []byte {
+	out := make([]byte, metaKeyLen+len(id))
+	copy(out[0:prefixLen], prefix[:])
+	out[prefixLen+gindexLenByteLen] = kind
+	copy(out[metaKeyLen:], id)
+	return out
+}
+
+// metaKind returns the kind of a metadata key, or false if the key is a node key.
+func metaKind(key []byte) (byte, bool) {
+	if len(key) < metaKeyLen || key[prefixLen] != 0 || key[prefixLen+1] != 0 {
+		return 0, false
+	}
+	return key[prefixLen+gindexLenByteLen], true
+}
+
+func (db *merkleDB) putAnchor(b *leveldb.Batch, root Root, slot uint64, opts []PutOption) error {
+	if hidden, err := db.isHidden(root); err != nil {
+		return err
+	} else if hidden {
+		return ErrHidden
+	}
+	putOpts := applyPutOptions(opts)
+	a := Anchor{Root: root, Slot: slot, Parent: putOpts.Parent, Provenance: putOpts.Provenance}
+	if db.opts.Clock != nil {
+		a.InsertedAt = db.opts.Clock()
+	}
+	// a tree that is put again keeps its canonicality, and its parent and provenance if none are given
+	prev, err := db.GetAnchor(root)
+	again := err == nil
+	if again {
+		a.Canonical = prev.Canonical
+		if a.Parent == (Root{}) {
+			a.Parent = prev.Parent
+		}
+		if a.Provenance == (Root{}) {
+			a.Provenance = prev.Provenance
+		}
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+	if err := db.putTags(b, root, putOpts.Tags, again); err != nil {
+		return err
+	}
+	b.Put(db.metaKey(metaAnchor, root[:]), a.encode())
+	// a tree that is put again is whole again
+	b.Delete(db.metaKey(metaTrimmed, root[:]))
+	db.putIdempotencyKey(b, root, slot, opts)
+	db.audit(b, AuditRecord{Op: AuditPut, Root: root, Slot: slot})
+	return nil
+}
+
+func (db *merkleDB) GetAnchor(root Root) (Anchor, error) {
+	v, err := db.getMeta(metaAnchor, root[:])
+	if err != nil {
+		return Anchor{}, err
+	}
+	var a Anchor
+	if err := a.decode(root, v); err != nil {
+		return Anchor{}, corruptMeta(metaAnchor, root[:], v, err)
+	}
+	return a, nil
+}
+
+func (db *merkleDB) Anchors() ([]Anchor, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaAnchor, nil)), nil)
+	defer iter.Release()
+	var out []Anchor
+	for iter.Next() {
+		k := iter.Key()
+		if len(k) != metaKeyLen+32 {
+			return nil, corruptMeta(metaAnchor, k[metaKeyLen:], iter.Value(), errors.New("corrupt anchor key"))
+		}
+		var root Root
+		copy(root[:], k[metaKeyLen:])
+		if err := db.checkValue(metaAnchor, root[:], iter.Value()); err != nil {
+			return nil, err
+		}
+		var a Anchor
+		if err := a.decode(root, iter.Value()); err != nil {
+			return nil, corruptMeta(metaAnchor, root[:], iter.Value(), err)
+		}
+		out = append(out, a)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+	"time"
+)
+
+func TestMerkleDB_Anchors(t *testing.T) {
+	db := newMemoryDB()
+	now := time.Unix(1600000000, 0)
+	mdb := New(testPrefix, db, WithClock(func() time.Time {
+		return now
+	}))
+	hFn := GetHashFn()
+	a := randomTree(8)
+	b := randomRoot()
+	if _, err := mdb.Put(10, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := mdb.Put(20, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchors, err := mdb.Anchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 2 {
+		t.Fatalf("expected 2 anchors, got %d", len(anchors))
+	}
+	got, err := mdb.GetAnchor(a.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Slot != 10 || !got.InsertedAt.Equal(time.Unix(1600000000, 0)) {
+		t.Fatalf("unexpected anchor: %+v", got)
+	}
+	got, err = mdb.GetAnchor(*b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Slot != 20 || !got.InsertedAt.Equal(now) {
+		t.Fatalf("unexpected anchor: %+v", got)
+	}
+
+	if err := mdb.Prune([]Root{*b}); err != nil {
+		t.Fatal(err)
+	}
+	anchors, err = mdb.Anchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 1 || anchors[0].Root != *b {
+		t.Fatalf("expected only anchor b to remain, got %v", anchors)
+	}
+	if err := mdb.Delete(RootGindex, *b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GetAnchor(*b); err == nil {
+		t.Fatal("expected anchor to be deleted with its root node")
+	}
+}
+
+func TestMerkleDB_AnchorsNoClock(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	root := randomRoot()
+	if _, err := mdb.Put(3, root, Hash); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mdb.GetAnchor(*root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Slot != 3 || !got.InsertedAt.IsZero() {
+		t.Fatalf("unexpected anchor: %+v", got)
+	}
+}
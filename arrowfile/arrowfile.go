@@ -0,0 +1,469 @@
+// Package arrowfile writes Arrow IPC files, the "Feather V2" format, of fixed-width columns without dependencies:
+// pyarrow, polars, DuckDB and the Arrow libraries read them, and convert them to Parquet.
+// Only what merkledb exports is supported: non-nullable unsigned integers and fixed-size binary columns,
+// in a single record batch.
+package arrowfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Column is a column of fixed-width values
+type Column struct {
+	Name string
+	// Width is the number of bytes of every value
+	Width int
+	// Uint makes a column of 1, 2, 4 or 8 byte values an unsigned little-endian integer column,
+	// other columns are fixed-size binary
+	Uint bool
+	// Values are the values of all rows, the value of row i at Values[i*Width:(i+1)*Width]
+	Values []byte
+}
+
+func (c *Column) check(rows int) error {
+	if c.Width <= 0 {
+		return fmt.Errorf("column %s: invalid width %d", c.Name, c.Width)
+	}
+	if c.Uint && c.Width != 1 && c.Width != 2 && c.Width != 4 && c.Width != 8 {
+		return fmt.Errorf("column %s: no integer of %d bytes", c.Name, c.Width)
+	}
+	if len(c.Values) != rows*c.Width {
+		return fmt.Errorf("column %s: %d bytes of values, expected %d rows of %d bytes", c.Name, len(c.Values), rows, c.Width)
+	}
+	return nil
+}
+
+var magic = [8]byte{'A', 'R', 'R', 'O', 'W', '1'}
+
+const (
+	metadataV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeInt             = 2
+	typeFixedSizeBinary = 15
+)
+
+// Write writes the columns as an Arrow IPC file, with one record batch of the rows.
+func Write(w io.Writer, rows int, columns []Column) error {
+	if rows < 0 {
+		return errors.New("negative number of rows")
+	}
+	for i := range columns {
+		if err := columns[i].check(rows); err != nil {
+			return err
+		}
+	}
+	out := &offsetWriter{w: w}
+	// the magic is padded to 8 bytes
+	out.write(magic[:])
+	schema := &table{fields: []field{{slot: 1, ref: fbTables(schemaFields(columns))}}}
+	if _, err := out.message(headerSchema, schema, nil, 0); err != nil {
+		return err
+	}
+
+	// the validity bitmaps are empty, no value is null
+	var nodes, buffers []byte
+	bodyLen := 0
+	for i := range columns {
+		n := len(columns[i].Values)
+		nodes = appendLongs(nodes, int64(rows), 0)
+		buffers = appendLongs(buffers, int64(bodyLen), 0, int64(bodyLen), int64(n))
+		bodyLen += pad8(n)
+	}
+	batch := &table{fields: []field{
+		{slot: 0, scalar: appendLongs(nil, int64(rows))},
+		{slot: 1, ref: structs{size: 16, data: nodes}},
+		{slot: 2, ref: structs{size: 16, data: buffers}},
+	}}
+	block, err := out.message(headerRecordBatch, batch, columns, bodyLen)
+	if err != nil {
+		return err
+	}
+	// the end of the stream
+	out.write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+
+	footer := &table{fields: []field{
+		{slot: 0, scalar: []byte{metadataV5, 0}},
+		{slot: 1, ref: &table{fields: []field{{slot: 1, ref: fbTables(schemaFields(columns))}}}},
+		{slot: 2, ref: structs{size: 24}},
+		{slot: 3, ref: structs{size: 24, data: block}},
+	}}
+	meta := finish(footer)
+	out.write(meta)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta)))
+	out.write(size[:])
+	out.write(magic[:6])
+	return out.err
+}
+
+func schemaFields(columns []Column) []*table {
+	out := make([]*table, len(columns))
+	for i := range columns {
+		c := &columns[i]
+		typ := &table{fields: []field{{slot: 0, scalar: appendInt(nil, int32(c.Width))}}}
+		typeID := byte(typeFixedSizeBinary)
+		if c.Uint {
+			typ = &table{fields: []field{{slot: 0, scalar: appendInt(nil, int32(8*c.Width))}, {slot: 1, scalar: []byte{0}}}}
+			typeID = typeInt
+		}
+		// name, nullable, the type of the union, the type, and the children, which readers expect
+		out[i] = &table{fields: []field{
+			{slot: 0, ref: fbString(c.Name)},
+			{slot: 1, scalar: []byte{0}},
+			{slot: 2, scalar: []byte{typeID}},
+			{slot: 3, ref: typ},
+			{slot: 5, ref: fbTables(nil)},
+		}}
+	}
+	return out
+}
+
+// offsetWriter tracks the offset in the file, and keeps the first error
+type offsetWriter struct {
+	w      io.Writer
+	offset int64
+	err    error
+}
+
+func (o *offsetWriter) write(b []byte) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.w.Write(b)
+	o.offset += int64(n)
+	o.err = err
+}
+
+// message writes an encapsulated message with the header, and the values of the columns as body.
+// It returns the block of the message, for the footer.
+func (o *offsetWriter) message(headerType byte, header *table, columns []Column, bodyLen int) ([]byte, error) {
+	msg := &table{fields: []field{
+		{slot: 0, scalar: []byte{metadataV5, 0}},
+		{slot: 1, scalar: []byte{headerType}},
+		{slot: 2, ref: header},
+		{slot: 3, scalar: appendLongs(nil, int64(bodyLen))},
+	}}
+	meta := finish(msg)
+	metaLen := pad8(len(meta))
+	start := o.offset
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[:4], 0xffffffff)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(metaLen))
+	o.write(prefix[:])
+	o.write(meta)
+	o.write(make([]byte, metaLen-len(meta)))
+	for i := range columns {
+		o.write(columns[i].Values)
+		o.write(make([]byte, pad8(len(columns[i].Values))-len(columns[i].Values)))
+	}
+	// offset, metadata length including the prefix, padding of the struct, body length
+	block := appendLongs(nil, start)
+	block = appendInt(block, int32(8+metaLen))
+	block = appendInt(block, 0)
+	block = appendLongs(block, int64(bodyLen))
+	return block, o.err
+}
+
+func pad8(n int) int {
+	return (n + 7) &^ 7
+}
+
+func appendInt(dst []byte, v int32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	return append(dst, b[:]...)
+}
+
+func appendLongs(dst []byte, vs ...int64) []byte {
+	var b [8]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		dst = append(dst, b[:]...)
+	}
+	return dst
+}
+
+// table is a flatbuffers table: the fields are scalars, or references to other objects
+type table struct {
+	fields []field
+}
+
+type field struct {
+	// slot is the index of the field in the schema, union fields take two slots
+	slot   int
+	scalar []byte
+	ref    interface{}
+}
+
+// fbString and fbTables are the other objects that fields refer to, with structs: a string, and a vector of tables
+type (
+	fbString string
+	fbTables []*table
+)
+
+// structs is a vector of 8-byte aligned structs of the size, as their concatenated bytes
+type structs struct {
+	size int
+	data []byte
+}
+
+// builder lays out a flatbuffer front to back: vtables before their tables, the referred objects after the referrer,
+// so all unsigned offsets point forward.
+type builder struct {
+	buf []byte
+}
+
+func finish(root *table) []byte {
+	b := &builder{buf: make([]byte, 4)}
+	pos := b.table(root)
+	binary.LittleEndian.PutUint32(b.buf, uint32(pos))
+	return b.buf
+}
+
+func (b *builder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *builder) table(t *table) int {
+	slots := 0
+	align := 4
+	for _, f := range t.fields {
+		if f.slot+1 > slots {
+			slots = f.slot + 1
+		}
+		if len(f.scalar) == 8 {
+			align = 8
+		}
+	}
+	// the fields follow the offset to the vtable, each aligned to its size
+	offsets := make([]int, len(t.fields))
+	size := 4
+	for i, f := range t.fields {
+		n := 4
+		if f.ref == nil {
+			n = len(f.scalar)
+		}
+		for size%n != 0 {
+			size++
+		}
+		offsets[i] = size
+		size += n
+	}
+	b.pad(2)
+	vt := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*slots)...)
+	binary.LittleEndian.PutUint16(b.buf[vt:], uint16(4+2*slots))
+	binary.LittleEndian.PutUint16(b.buf[vt+2:], uint16(size))
+	for i, f := range t.fields {
+		binary.LittleEndian.PutUint16(b.buf[vt+4+2*f.slot:], uint16(offsets[i]))
+	}
+	b.pad(align)
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(pos-vt))
+	for i, f := range t.fields {
+		if f.ref == nil {
+			copy(b.buf[pos+offsets[i]:], f.scalar)
+			continue
+		}
+		// the object is written first, it may grow the buffer
+		at := pos + offsets[i]
+		child := b.object(f.ref)
+		binary.LittleEndian.PutUint32(b.buf[at:], uint32(child-at))
+	}
+	return pos
+}
+
+func (b *builder) object(obj interface{}) int {
+	switch o := obj.(type) {
+	case *table:
+		return b.table(o)
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = appendInt(b.buf, int32(len(o)))
+		b.buf = append(append(b.buf, o...), 0)
+		return pos
+	case structs:
+		// the elements after the length are 8-byte aligned
+		for (len(b.buf)+4)%8 != 0 {
+			b.buf = append(b.buf, 0)
+		}
+		pos := len(b.buf)
+		b.buf = appendInt(b.buf, int32(len(o.data)/o.size))
+		b.buf = append(b.buf, o.data...)
+		return pos
+	case fbTables:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = appendInt(b.buf, int32(len(o)))
+		b.buf = append(b.buf, make([]byte, 4*len(o))...)
+		for i, t := range o {
+			at := pos + 4 + 4*i
+			child := b.table(t)
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(child-at))
+		}
+		return pos
+	default:
+		panic(fmt.Sprintf("unknown flatbuffer object %T", obj))
+	}
+}
+
+// ErrMalformed is returned by Read for input that is not an Arrow IPC file of Write
+var ErrMalformed = errors.New("malformed arrow file")
+
+// reader reads the tables of a flatbuffer, failing with ErrMalformed out of bounds
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) check(pos int, n int) bool {
+	if r.err == nil && (pos < 0 || n < 0 || pos+n > len(r.buf)) {
+		r.err = fmt.Errorf("%w: %d bytes at %d out of %d", ErrMalformed, n, pos, len(r.buf))
+	}
+	return r.err == nil
+}
+
+func (r *reader) u32(pos int) int {
+	if !r.check(pos, 4) {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+func (r *reader) long(pos int) int {
+	if !r.check(pos, 8) {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint64(r.buf[pos:]))
+}
+
+func (r *reader) byteAt(pos int) byte {
+	if !r.check(pos, 1) {
+		return 0
+	}
+	return r.buf[pos]
+}
+
+// field returns the position of the field of the table, -1 if absent
+func (r *reader) field(t int, slot int) int {
+	vt := t - int(int32(r.u32(t)))
+	if !r.check(vt, 4) {
+		return -1
+	}
+	if 4+2*slot+2 > int(binary.LittleEndian.Uint16(r.buf[vt:])) || !r.check(vt+4+2*slot, 2) {
+		return -1
+	}
+	off := int(binary.LittleEndian.Uint16(r.buf[vt+4+2*slot:]))
+	if off == 0 {
+		return -1
+	}
+	return t + off
+}
+
+func (r *reader) ref(t int, slot int) int {
+	p := r.field(t, slot)
+	if p < 0 {
+		if r.err == nil {
+			r.err = fmt.Errorf("%w: missing field %d", ErrMalformed, slot)
+		}
+		return 0
+	}
+	return p + r.u32(p)
+}
+
+// vector returns the length of the vector of the field, and the position of its first element
+func (r *reader) vector(t int, slot int) (int, int) {
+	v := r.ref(t, slot)
+	return r.u32(v), v + 4
+}
+
+func (r *reader) scalar(t int, slot int) int {
+	p := r.field(t, slot)
+	if p < 0 {
+		return 0
+	}
+	return int(r.byteAt(p))
+}
+
+// Read reads the columns of a file of Write, with the number of rows
+func Read(file []byte) (int, []Column, error) {
+	if len(file) < 18 || !bytes.Equal(file[:8], magic[:]) || !bytes.Equal(file[len(file)-6:], magic[:6]) {
+		return 0, nil, fmt.Errorf("%w: no arrow file magic", ErrMalformed)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-10:]))
+	if footerLen > len(file)-18 {
+		return 0, nil, fmt.Errorf("%w: footer of %d bytes", ErrMalformed, footerLen)
+	}
+	footer := &reader{buf: file[len(file)-10-footerLen : len(file)-10]}
+	ft := footer.u32(0)
+	schema := footer.ref(ft, 1)
+	n, fields := footer.vector(schema, 1)
+	if footer.err == nil && n > footerLen/4 {
+		return 0, nil, fmt.Errorf("%w: %d fields", ErrMalformed, n)
+	}
+	columns := make([]Column, 0, n)
+	for i := 0; i < n && footer.err == nil; i++ {
+		fld := fields + 4*i + footer.u32(fields+4*i)
+		name := footer.ref(fld, 0)
+		nameLen := footer.u32(name)
+		var c Column
+		if footer.check(name+4, nameLen) {
+			c.Name = string(footer.buf[name+4 : name+4+nameLen])
+		}
+		typ := footer.ref(fld, 3)
+		switch footer.scalar(fld, 2) {
+		case typeInt:
+			c.Uint, c.Width = true, footer.u32(footer.field(typ, 0))/8
+		case typeFixedSizeBinary:
+			c.Width = footer.u32(footer.field(typ, 0))
+		default:
+			return 0, nil, fmt.Errorf("%w: field %d of unsupported type", ErrMalformed, i)
+		}
+		columns = append(columns, c)
+	}
+	blocks, block := footer.vector(ft, 3)
+	offset, metaLen, bodyLen := footer.long(block), footer.u32(block+8), footer.long(block+16)
+	if footer.err != nil {
+		return 0, nil, footer.err
+	}
+	if blocks != 1 {
+		return 0, nil, fmt.Errorf("%w: %d record batches", ErrMalformed, blocks)
+	}
+	file = file[:len(file)-10-footerLen]
+	if offset < 0 || metaLen < 8 || bodyLen < 0 || offset+metaLen+bodyLen > len(file) || offset+metaLen+bodyLen < offset {
+		return 0, nil, fmt.Errorf("%w: record batch out of bounds", ErrMalformed)
+	}
+	msg := &reader{buf: file[offset+8 : offset+metaLen]}
+	batch := msg.ref(msg.u32(0), 2)
+	rows := msg.long(msg.field(batch, 0))
+	buffers, buffer := msg.vector(batch, 2)
+	if msg.err != nil {
+		return 0, nil, msg.err
+	}
+	if buffers != 2*len(columns) {
+		return 0, nil, fmt.Errorf("%w: %d buffers for %d columns", ErrMalformed, buffers, len(columns))
+	}
+	body := &reader{buf: file[offset+metaLen : offset+metaLen+bodyLen]}
+	for i := range columns {
+		start, length := msg.long(buffer+32*i+16), msg.long(buffer+32*i+24)
+		if msg.err != nil {
+			return 0, nil, msg.err
+		}
+		if columns[i].Width <= 0 || rows < 0 || length != rows*columns[i].Width || !body.check(start, length) {
+			return 0, nil, fmt.Errorf("%w: column %d of %d bytes", ErrMalformed, i, length)
+		}
+		columns[i].Values = append([]byte(nil), body.buf[start:start+length]...)
+	}
+	return rows, columns, nil
+}
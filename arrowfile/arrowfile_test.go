@@ -0,0 +1,166 @@
+package arrowfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// flatbuffer reads the tables of a flatbuffer, to check the layout that Arrow readers see
+type flatbuffer []byte
+
+func (f flatbuffer) u32(pos int) int {
+	return int(binary.LittleEndian.Uint32(f[pos:]))
+}
+
+func (f flatbuffer) root() int {
+	return f.u32(0)
+}
+
+// field returns the position of the field in the table, 0 if absent
+func (f flatbuffer) field(t int, slot int) int {
+	vt := t - int(int32(binary.LittleEndian.Uint32(f[t:])))
+	if 4+2*slot >= int(binary.LittleEndian.Uint16(f[vt:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(f[vt+4+2*slot:]))
+	if off == 0 {
+		return 0
+	}
+	return t + off
+}
+
+func (f flatbuffer) ref(t int, slot int) int {
+	p := f.field(t, slot)
+	return p + f.u32(p)
+}
+
+// vector returns the length of the vector of the field, and the position of its first element
+func (f flatbuffer) vector(t int, slot int) (int, int) {
+	v := f.ref(t, slot)
+	return f.u32(v), v + 4
+}
+
+func (f flatbuffer) long(pos int) int {
+	return int(binary.LittleEndian.Uint64(f[pos:]))
+}
+
+func TestWrite(t *testing.T) {
+	columns := []Column{
+		{Name: "root", Width: 32, Values: bytes.Repeat([]byte{0xaa}, 3*32)},
+		{Name: "slot", Width: 8, Uint: true, Values: []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0}},
+		{Name: "flag", Width: 1, Uint: true, Values: []byte{1, 0, 1}},
+	}
+	var out bytes.Buffer
+	if err := Write(&out, 3, columns); err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+	if !bytes.Equal(file[:8], magic[:]) || !bytes.Equal(file[len(file)-6:], magic[:6]) {
+		t.Fatal("expected the magic at both ends")
+	}
+	// the schema message is first, the same schema is in the footer
+	schemaLen := int(binary.LittleEndian.Uint32(file[12:]))
+	schemaMsg := flatbuffer(file[16 : 16+schemaLen])
+	if schemaMsg[schemaMsg.field(schemaMsg.root(), 1)] != headerSchema || schemaLen%8 != 0 {
+		t.Fatal("expected the schema message first")
+	}
+	if n, _ := schemaMsg.vector(schemaMsg.ref(schemaMsg.root(), 2), 1); n != len(columns) {
+		t.Fatalf("expected %d fields in the schema message, got %d", len(columns), n)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-10:]))
+	footer := flatbuffer(file[len(file)-10-footerLen : len(file)-10])
+	if (len(file)-10-footerLen)%8 != 0 {
+		t.Fatal("expected an aligned footer")
+	}
+	ft := footer.root()
+	if v := binary.LittleEndian.Uint16(footer[footer.field(ft, 0):]); v != metadataV5 {
+		t.Fatalf("unexpected version %d", v)
+	}
+	schema := footer.ref(ft, 1)
+	n, fields := footer.vector(schema, 1)
+	if n != len(columns) {
+		t.Fatalf("expected %d fields, got %d", len(columns), n)
+	}
+	for i := 0; i < n; i++ {
+		fld := fields + 4*i + footer.u32(fields+4*i)
+		name := footer.ref(fld, 0)
+		if got := string(footer[name+4 : name+4+footer.u32(name)]); got != columns[i].Name {
+			t.Fatalf("field %d: unexpected name %q", i, got)
+		}
+		typeID := footer[footer.field(fld, 2)]
+		typ := footer.ref(fld, 3)
+		width := footer.u32(footer.field(typ, 0))
+		if columns[i].Uint && (typeID != typeInt || width != 8*columns[i].Width || footer[footer.field(typ, 1)] != 0) {
+			t.Fatalf("field %d: expected an unsigned integer, got type %d of %d bits", i, typeID, width)
+		}
+		if !columns[i].Uint && (typeID != typeFixedSizeBinary || width != columns[i].Width) {
+			t.Fatalf("field %d: expected fixed-size binary, got type %d of %d bytes", i, typeID, width)
+		}
+		if children, _ := footer.vector(fld, 5); children != 0 {
+			t.Fatalf("field %d: unexpected children", i)
+		}
+	}
+
+	blocks, block := footer.vector(ft, 3)
+	if blocks != 1 || block%8 != 0 {
+		t.Fatalf("expected one aligned record batch block, got %d at %d", blocks, block)
+	}
+	offset, metaLen, bodyLen := footer.long(block), footer.u32(block+8), footer.long(block+16)
+	if offset%8 != 0 || binary.LittleEndian.Uint32(file[offset:]) != 0xffffffff || metaLen != 8+int(binary.LittleEndian.Uint32(file[offset+4:])) {
+		t.Fatalf("unexpected message prefix at %d", offset)
+	}
+	msg := flatbuffer(file[offset+8 : offset+metaLen])
+	mt := msg.root()
+	if msg[msg.field(mt, 1)] != headerRecordBatch || msg.long(msg.field(mt, 3)) != bodyLen {
+		t.Fatal("unexpected record batch message")
+	}
+	batch := msg.ref(mt, 2)
+	if msg.long(msg.field(batch, 0)) != 3 {
+		t.Fatal("expected 3 rows")
+	}
+	nodes, node := msg.vector(batch, 1)
+	buffers, buffer := msg.vector(batch, 2)
+	if nodes != len(columns) || buffers != 2*len(columns) || node%8 != 0 || buffer%8 != 0 {
+		t.Fatalf("unexpected %d nodes and %d buffers", nodes, buffers)
+	}
+	body := file[offset+metaLen : offset+metaLen+bodyLen]
+	for i := range columns {
+		if msg.long(node+16*i) != 3 || msg.long(node+16*i+8) != 0 {
+			t.Fatalf("column %d: unexpected field node", i)
+		}
+		validity, data := buffer+32*i, buffer+32*i+16
+		if msg.long(validity+8) != 0 {
+			t.Fatalf("column %d: expected no validity bitmap", i)
+		}
+		start, length := msg.long(data), msg.long(data+8)
+		if start%8 != 0 || !bytes.Equal(body[start:start+length], columns[i].Values) {
+			t.Fatalf("column %d: unexpected values", i)
+		}
+	}
+
+	rows, read, err := Read(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 || len(read) != len(columns) {
+		t.Fatalf("expected %d columns of 3 rows, got %d of %d", len(columns), len(read), rows)
+	}
+	for i := range read {
+		if read[i].Name != columns[i].Name || read[i].Width != columns[i].Width || read[i].Uint != columns[i].Uint || !bytes.Equal(read[i].Values, columns[i].Values) {
+			t.Fatalf("column %d: read %+v, expected %+v", i, read[i], columns[i])
+		}
+	}
+	for n := 0; n < len(file); n++ {
+		if _, _, err := Read(file[:n]); err == nil {
+			t.Fatalf("expected an error for a file truncated to %d bytes", n)
+		}
+	}
+
+	if err := Write(&out, 2, columns); err == nil {
+		t.Fatal("expected an error for columns of other lengths")
+	}
+	if err := Write(&out, 1, []Column{{Name: "odd", Width: 3, Uint: true, Values: []byte{1, 2, 3}}}); err == nil {
+		t.Fatal("expected an error for an integer of 3 bytes")
+	}
+}
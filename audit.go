@@ -0,0 +1,188 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// metaAudit records the logical operations, by sequence number, see WithAuditLog
+const metaAudit byte = 'l'
+
+const auditVersion = 0
+
+const auditRecordLen = 1 + 1 + 8 + 8 + 32 + 8 + 8
+
+// AuditOp is the kind of operation of an audit record
+type AuditOp byte
+
+const (
+	// AuditPut is a tree that was put, with its anchor root and slot
+	AuditPut AuditOp = iota + 1
+	// AuditDelete is a node that was deleted, with its root and gindex
+	AuditDelete
+	// AuditPrune is a prune, with the number of live roots it kept
+	AuditPrune
+	// AuditHide and AuditUnhide are anchors that were hidden and made visible again, with their root and slot
+	AuditHide
+	AuditUnhide
+)
+
+func (op AuditOp) String() string {
+	switch op {
+	case AuditPut:
+		return "put"
+	case AuditDelete:
+		return "delete"
+	case AuditPrune:
+		return "prune"
+	case AuditHide:
+		return "hide"
+	case AuditUnhide:
+		return "unhide"
+	default:
+		return fmt.Sprintf("AuditOp(%d)", byte(op))
+	}
+}
+
+func (op AuditOp) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// AuditRecord is an operation in the audit log
+type AuditRecord struct {
+	// Seq orders the records. It has gaps where operations failed after they were numbered.
+	Seq  uint64    `json:"seq"`
+	Op   AuditOp   `json:"op"`
+	Time time.Time `json:"time"`
+	Slot uint64    `json:"slot,omitempty"`
+	Root Root      `json:"root"`
+	// Gindex of the deleted node, 0 for other operations
+	Gindex uint64 `json:"gindex,omitempty"`
+	// Count of the live roots of a prune
+	Count uint64 `json:"count,omitempty"`
+}
+
+func (r *AuditRecord) encode() []byte {
+	out := make([]byte, auditRecordLen)
+	out[0] = auditVersion
+	out[1] = byte(r.Op)
+	binary.LittleEndian.PutUint64(out[2:10], uint64(r.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(out[10:18], r.Slot)
+	copy(out[18:50], r.Root[:])
+	binary.LittleEndian.PutUint64(out[50:58], r.Gindex)
+	binary.LittleEndian.PutUint64(out[58:66], r.Count)
+	return out
+}
+
+func (r *AuditRecord) decode(seq uint64, v []byte) error {
+	if len(v) < auditRecordLen {
+		return fmt.Errorf("audit record %d is corrupt, too short: '%x'", seq, v)
+	}
+	if v[0] != auditVersion {
+		return fmt.Errorf("audit record %d has unknown version: %d", seq, v[0])
+	}
+	*r = AuditRecord{
+		Seq:    seq,
+		Op:     AuditOp(v[1]),
+		Time:   time.Unix(0, int64(binary.LittleEndian.Uint64(v[2:10]))),
+		Slot:   binary.LittleEndian.Uint64(v[10:18]),
+		Root:   toRoot(v[18:50]),
+		Gindex: binary.LittleEndian.Uint64(v[50:58]),
+		Count:  binary.LittleEndian.Uint64(v[58:66]),
+	}
+	return nil
+}
+
+func (db *merkleDB) auditKey(seq uint64) []byte {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], seq)
+	return db.metaKey(metaAudit, id[:])
+}
+
+// initAudit continues the sequence of the audit log after its last record
+func (db *merkleDB) initAudit() error {
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaAudit, nil)), nil)
+	defer iter.Release()
+	if iter.Last() {
+		k := iter.Key()
+		if len(k) != metaKeyLen+8 {
+			return errors.New("corrupt audit key")
+		}
+		db.auditSeq = binary.BigEndian.Uint64(k[metaKeyLen:])
+	}
+	return iter.Error()
+}
+
+// audit adds the record to the batch of the operation, if the audit log is enabled
+func (db *merkleDB) audit(b *leveldb.Batch, r AuditRecord) {
+	if !db.opts.AuditLog {
+		return
+	}
+	if db.base != nil {
+		db = db.base
+	}
+	r.Seq = atomic.AddUint64(&db.auditSeq, 1)
+	r.Time = db.now()
+	b.Put(db.auditKey(r.Seq), r.encode())
+}
+
+func (db *merkleDB) AuditLog(from uint64, limit int) ([]AuditRecord, error) {
+	scan := util.BytesPrefix(db.metaKey(metaAudit, nil))
+	scan.Start = db.auditKey(from)
+	iter := db.r.NewIterator(scan, nil)
+	defer iter.Release()
+	var out []AuditRecord
+	for iter.Next() && (limit <= 0 || len(out) < limit) {
+		k := iter.Key()
+		if len(k) != metaKeyLen+8 {
+			return nil, corruptMeta(metaAudit, k[metaKeyLen:], iter.Value(), errors.New("corrupt audit key"))
+		}
+		if err := db.checkValue(metaAudit, k[metaKeyLen:], iter.Value()); err != nil {
+			return nil, err
+		}
+		var r AuditRecord
+		if err := r.decode(binary.BigEndian.Uint64(k[metaKeyLen:]), iter.Value()); err != nil {
+			return nil, corruptMeta(metaAudit, k[metaKeyLen:], iter.Value(), err)
+		}
+		out = append(out, r)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *merkleDB) ExportAuditLog(w io.Writer) (n int, err error) {
+	err = db.consistent(func(view *merkleDB) error {
+		enc := json.NewEncoder(w)
+		iter := view.r.NewIterator(util.BytesPrefix(view.metaKey(metaAudit, nil)), nil)
+		defer iter.Release()
+		for iter.Next() {
+			k := iter.Key()
+			if len(k) != metaKeyLen+8 {
+				return corruptMeta(metaAudit, k[metaKeyLen:], iter.Value(), errors.New("corrupt audit key"))
+			}
+			if err := view.checkValue(metaAudit, k[metaKeyLen:], iter.Value()); err != nil {
+				return err
+			}
+			var r AuditRecord
+			if err := r.decode(binary.BigEndian.Uint64(k[metaKeyLen:]), iter.Value()); err != nil {
+				return corruptMeta(metaAudit, k[metaKeyLen:], iter.Value(), err)
+			}
+			if err := enc.Encode(&r); err != nil {
+				return err
+			}
+			n += 1
+		}
+		return iter.Error()
+	})
+	return n, err
+}
@@ -0,0 +1,139 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	. "github.com/protolambda/ztyp/tree"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerkleDB_AuditLog(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	clock := WithClock(func() time.Time {
+		return now
+	})
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb, clock, WithAuditLog())
+	hFn := GetHashFn()
+	a, b := randomTree(3), randomTree(3)
+	if _, err := mdb.Put(1, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if _, err := mdb.Put(2, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	left := a.(*PairNode).LeftChild.MerkleRoot(hFn)
+	if err := mdb.Delete(RootGindex.Left(), left); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Prune([]Root{b.MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := mdb.AuditLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AuditRecord{
+		{Seq: 1, Op: AuditPut, Time: time.Unix(1600000000, 0), Slot: 1, Root: a.MerkleRoot(hFn)},
+		{Seq: 2, Op: AuditPut, Time: now, Slot: 2, Root: b.MerkleRoot(hFn)},
+		{Seq: 3, Op: AuditDelete, Time: now, Root: left, Gindex: 2},
+		{Seq: 4, Op: AuditPrune, Time: now, Count: 1},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d: %+v", len(expected), len(records), records)
+	}
+	for i, r := range records {
+		if !r.Time.Equal(expected[i].Time) {
+			t.Fatalf("record %d: expected time %s, got %s", i, expected[i].Time, r.Time)
+		}
+		r.Time = expected[i].Time
+		if r != expected[i] {
+			t.Fatalf("record %d: expected %+v, got %+v", i, expected[i], r)
+		}
+	}
+
+	page, err := mdb.AuditLog(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].Seq != 2 || page[1].Seq != 3 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	// the sequence continues after the last record when opened again
+	again := New(testPrefix, ldb, clock, WithAuditLog())
+	if _, err := again.Put(3, randomTree(2), hFn); err != nil {
+		t.Fatal(err)
+	}
+	if last, err := again.AuditLog(5, 0); err != nil {
+		t.Fatal(err)
+	} else if len(last) != 1 || last[0].Op != AuditPut || last[0].Slot != 3 {
+		t.Fatalf("expected the put as record 5: %+v", last)
+	}
+
+	var buf bytes.Buffer
+	n, err := again.ExportAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 exported records, got %d", n)
+	}
+	scanner := bufio.NewScanner(&buf)
+	lines := 0
+	for scanner.Scan() {
+		var r map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if lines == 2 && (r["op"] != "delete" || r["gindex"] != float64(2)) {
+			t.Fatalf("unexpected delete record: %s", scanner.Text())
+		}
+		lines += 1
+	}
+	if lines != 5 {
+		t.Fatalf("expected 5 lines, got %d", lines)
+	}
+
+	var dump strings.Builder
+	if _, err := Dump(ldb, testPrefix, &dump, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump.String(), "audit seq=4 op=prune") {
+		t.Fatalf("expected the prune in the dump:\n%s", dump.String())
+	}
+}
+
+func TestMerkleDB_AuditLogCorrupt(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb).(*merkleDB)
+	if err := ldb.Put(mdb.metaKey(metaAudit, []byte{1, 2, 3}), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var reported error
+	if _, err := Open(testPrefix, ldb, WithAuditLog(), WithBackgroundErrors(func(err error) { reported = err })); err == nil {
+		t.Fatal("expected Open to fail for a corrupt audit key")
+	} else if reported == nil {
+		t.Fatal("expected the error to be reported")
+	}
+	if _, err := Open(testPrefix, ldb); err != nil {
+		t.Fatalf("expected Open without the audit log to pass, got %v", err)
+	}
+}
+
+func TestMerkleDB_AuditLogDisabled(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	if _, err := mdb.Put(1, randomTree(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := mdb.AuditLog(0, 0); err != nil {
+		t.Fatal(err)
+	} else if len(records) != 0 {
+		t.Fatalf("expected no records, got %+v", records)
+	}
+}
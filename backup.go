@@ -0,0 +1,391 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+)
+
+// backupMagic starts every backup stream, followed by the version byte
+var backupMagic = [4]byte{'m', 'd', 'b', 'k'}
+
+const backupVersion = 1
+
+// DefaultRestoreBatchSize is the number of records written per batch by Restore
+const DefaultRestoreBatchSize = 10_000
+
+// maxBackupRecordLen bounds the key lengths read from a backup, and the values that are allocated up front,
+// to not allocate for corrupt lengths
+const maxBackupRecordLen = 1 << 16
+
+// maxBackupValueLen bounds the value lengths read from a backup, like the blobs.
+// Values over maxBackupRecordLen are read as they arrive, a corrupt length fails at the end of the backup.
+const maxBackupValueLen = DefaultMaxValueSize
+
+// backupKind is true for the metadata that is included in backups: the anchor records with their
+// canonicality, parent and provenance, hidden or not, their tags, the named references, the pins, the compact history
+// and the blobs and SSZ encodings. Tombstones, prune checkpoints and repair marks are state of the original database, and are left out.
+func backupKind(kind byte) bool {
+	return kind == metaAnchor || kind == metaHidden || kind == metaTags || kind == metaRef || kind == metaPin ||
+		kind == metaHistory || kind == metaBlob || kind == metaSSZ
+}
+
+func (db *merkleDB) Backup(w io.Writer) (n int, err error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(backupMagic[:]); err != nil {
+		return 0, err
+	}
+	if err := bw.WriteByte(backupVersion); err != nil {
+		return 0, err
+	}
+	manifest := BackupManifest{Version: backupVersion}
+	h := sha256.New()
+	var lenBuf [binary.MaxVarintLen64]byte
+	writeField := func(b []byte, hashed bool) error {
+		size := lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(b)))]
+		if hashed {
+			h.Write(size)
+			h.Write(b)
+		}
+		if _, err := bw.Write(size); err != nil {
+			return err
+		}
+		_, err := bw.Write(b)
+		return err
+	}
+	err = db.consistent(func(view *merkleDB) error {
+		iter := view.r.NewIterator(util.BytesPrefix(view.prefix[:]), nil)
+		defer iter.Release()
+		for iter.Next() {
+			key, value := iter.Key(), iter.Value()
+			kind, meta := metaKind(key)
+			// the values in the value log are backed up inline, as the records they are the value of
+			if pointed, id, ok := view.pointed(key); ok && backupKind(pointed) {
+				var p valuePointer
+				if err := p.decode(value); err != nil {
+					return corruptMeta(metaValuePointer, key[metaKeyLen:], value, err)
+				}
+				v, err := view.vlog.read(p)
+				if err != nil {
+					return err
+				}
+				key, value, kind = view.metaKey(pointed, id), v, pointed
+			} else if meta && !backupKind(kind) {
+				continue
+			}
+			// keys are written without prefix, a backup can be restored under any prefix
+			if err := writeField(key[prefixLen:], true); err != nil {
+				return err
+			}
+			if err := writeField(value, true); err != nil {
+				return err
+			}
+			manifest.count(key, kind, meta)
+			n += 1
+		}
+		return iter.Error()
+	})
+	if err != nil {
+		return n, err
+	}
+	// an empty key ends the records, to detect truncated backups
+	if err := writeField(nil, true); err != nil {
+		return n, err
+	}
+	copy(manifest.ContentHash[:], h.Sum(nil))
+	if err := writeField(manifest.encode(), false); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// BackupManifest describes the contents of a backup. Backup writes it after the records, Restore checks the records
+// against it, so a backup that was downloaded in parts, or from untrusted peers, can be verified end to end.
+type BackupManifest struct {
+	// Version is the format version of the backup
+	Version uint8
+	// ContentHash is the SHA-256 hash of the records: the uvarint length and the bytes of every key and value,
+	// and the empty key that ends them, as they are in the stream
+	ContentHash Root
+	// Records is the number of records, Nodes the number of those that are nodes
+	Records uint64
+	Nodes   uint64
+	// Anchors are the roots of the anchor records, in the order of the backup
+	Anchors []Root
+}
+
+func (m *BackupManifest) count(key []byte, kind byte, meta bool) {
+	m.Records += 1
+	if !meta {
+		m.Nodes += 1
+	} else if kind == metaAnchor {
+		m.Anchors = append(m.Anchors, toRoot(key[metaKeyLen:]))
+	}
+}
+
+// encode is the manifest after the records: content hash (32) | records u64 | nodes u64 | anchor count u32 | anchors,
+// all integers little-endian. The version is in the header of the backup.
+func (m *BackupManifest) encode() []byte {
+	out := make([]byte, 32+8+8+4, 32+8+8+4+32*len(m.Anchors))
+	copy(out, m.ContentHash[:])
+	binary.LittleEndian.PutUint64(out[32:], m.Records)
+	binary.LittleEndian.PutUint64(out[40:], m.Nodes)
+	binary.LittleEndian.PutUint32(out[48:], uint32(len(m.Anchors)))
+	for i := range m.Anchors {
+		out = append(out, m.Anchors[i][:]...)
+	}
+	return out
+}
+
+func (m *BackupManifest) decode(data []byte) error {
+	if len(data) < 32+8+8+4 {
+		return fmt.Errorf("%w: manifest too short: %d bytes", ErrMalformed, len(data))
+	}
+	count := binary.LittleEndian.Uint32(data[48:])
+	if uint64(len(data)) != 32+8+8+4+32*uint64(count) {
+		return fmt.Errorf("%w: manifest of %d bytes does not fit %d anchors", ErrMalformed, len(data), count)
+	}
+	m.ContentHash = toRoot(data[:32])
+	m.Records = binary.LittleEndian.Uint64(data[32:])
+	m.Nodes = binary.LittleEndian.Uint64(data[40:])
+	m.Anchors = make([]Root, count)
+	for i := range m.Anchors {
+		m.Anchors[i] = toRoot(data[52+32*i:])
+	}
+	return nil
+}
+
+func (m *BackupManifest) check(expected *BackupManifest) error {
+	switch {
+	case m.ContentHash != expected.ContentHash:
+		return fmt.Errorf("%w: backup content hash %x does not match the manifest %x", ErrMalformed, m.ContentHash[:], expected.ContentHash[:])
+	case m.Records != expected.Records || m.Nodes != expected.Nodes:
+		return fmt.Errorf("%w: backup has %d records and %d nodes, the manifest %d and %d", ErrMalformed,
+			m.Records, m.Nodes, expected.Records, expected.Nodes)
+	case len(m.Anchors) != len(expected.Anchors):
+		return fmt.Errorf("%w: backup has %d anchors, the manifest %d", ErrMalformed, len(m.Anchors), len(expected.Anchors))
+	}
+	for i := range m.Anchors {
+		if m.Anchors[i] != expected.Anchors[i] {
+			return fmt.Errorf("%w: backup anchor %d is %s, the manifest has %s", ErrMalformed, i, m.Anchors[i], expected.Anchors[i])
+		}
+	}
+	return nil
+}
+
+// ReadBackupManifest reads a whole backup, validates its records, and returns its manifest after verifying them against it.
+// Backups of version 0 have no manifest.
+func ReadBackupManifest(r io.Reader) (*BackupManifest, error) {
+	m, _, err := readBackup(r, [prefixLen]byte{}, func(key []byte, value []byte) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("backup of version 0 has no manifest")
+	}
+	return m, nil
+}
+
+// readBackup reads the records of the backup under the prefix, validates them, and calls fn with every record.
+// The manifest is verified after the last record, fn has to hold back what must not be kept if it does not verify.
+// The manifest is nil for backups of version 0, which have none.
+func readBackup(r io.Reader, prefix [prefixLen]byte, fn func(key []byte, value []byte) error) (*BackupManifest, int, error) {
+	br := bufio.NewReader(r)
+	var header [len(backupMagic) + 1]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, 0, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	if !bytes.Equal(header[:len(backupMagic)], backupMagic[:]) {
+		return nil, 0, fmt.Errorf("%w: not a merkledb backup", ErrMalformed)
+	}
+	version := header[len(backupMagic)]
+	if version > backupVersion {
+		return nil, 0, fmt.Errorf("%w: unknown backup version: %d", ErrMalformed, version)
+	}
+	computed := BackupManifest{Version: version}
+	h := sha256.New()
+	var lenBuf [binary.MaxVarintLen64]byte
+	readField := func(max uint64) ([]byte, error) {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		if size > max {
+			return nil, fmt.Errorf("%w: backup record of %d bytes is too large", ErrMalformed, size)
+		}
+		var out []byte
+		if size <= maxBackupRecordLen {
+			out = make([]byte, size)
+			if _, err := io.ReadFull(br, out); err != nil {
+				return nil, err
+			}
+		} else {
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(io.LimitReader(br, int64(size))); err != nil {
+				return nil, err
+			}
+			if uint64(buf.Len()) != size {
+				return nil, io.ErrUnexpectedEOF
+			}
+			out = buf.Bytes()
+		}
+		h.Write(lenBuf[:binary.PutUvarint(lenBuf[:], size)])
+		h.Write(out)
+		return out, nil
+	}
+	n := 0
+	for {
+		id, err := readField(maxBackupRecordLen)
+		if err != nil {
+			return nil, n, fmt.Errorf("failed to read key of record %d: %w", n, err)
+		}
+		if len(id) == 0 {
+			break
+		}
+		value, err := readField(maxBackupValueLen)
+		if err != nil {
+			return nil, n, fmt.Errorf("failed to read value of record %d: %w", n, err)
+		}
+		key := append(append(make([]byte, 0, prefixLen+len(id)), prefix[:]...), id...)
+		if err := checkBackupRecord(key, value); err != nil {
+			return nil, n, fmt.Errorf("invalid record %d: %w", n, err)
+		}
+		kind, meta := metaKind(key)
+		computed.count(key, kind, meta)
+		if err := fn(key, value); err != nil {
+			return nil, n, err
+		}
+		n += 1
+	}
+	if version == 0 {
+		return nil, n, nil
+	}
+	copy(computed.ContentHash[:], h.Sum(nil))
+	data, err := readField(maxBackupRecordLen)
+	if err != nil {
+		return nil, n, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	expected := BackupManifest{Version: version}
+	if err := expected.decode(data); err != nil {
+		return nil, n, err
+	}
+	if err := computed.check(&expected); err != nil {
+		return nil, n, err
+	}
+	return &expected, n, nil
+}
+
+func (db *merkleDB) Restore(r io.Reader) (n int, err error) {
+	return db.restore(r, func(b *leveldb.Batch, key []byte, value []byte) error {
+		b.Put(key, value)
+		return nil
+	})
+}
+
+// restore reads the records of the backup, and validates them, for apply to write them to the batch.
+// The nodes are written as they are read, the metadata only after the manifest verified:
+// a corrupt backup leaves no anchors of missing nodes, only unreferenced nodes for Reclaim.
+func (db *merkleDB) restore(r io.Reader, apply func(b *leveldb.Batch, key []byte, value []byte) error) (n int, err error) {
+	defer db.resetUsage()
+	defer db.resetHead()
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	b := new(leveldb.Batch)
+	// like puts, the restored anchors are not behind the last key of a resumed prune, see invalidateCheckpoint
+	if err := db.invalidateCheckpoint(b); err != nil {
+		return 0, err
+	}
+	var meta [][2][]byte
+	_, n, err = readBackup(r, db.prefix, func(key []byte, value []byte) error {
+		if _, ok := metaKind(key); ok {
+			meta = append(meta, [2][]byte{key, value})
+			return nil
+		}
+		if err := apply(b, key, value); err != nil {
+			return err
+		}
+		if b.Len() >= DefaultRestoreBatchSize {
+			if err := db.write(b); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	for _, rec := range meta {
+		if err := apply(b, rec[0], rec[1]); err != nil {
+			return n, err
+		}
+	}
+	return n, db.write(b)
+}
+
+func checkBackupRecord(key []byte, value []byte) error {
+	kind, ok := metaKind(key)
+	if !ok {
+		if _, err := ParseNodeKey(key); err != nil {
+			return err
+		}
+		_, err := ParseNodeValue(value)
+		return err
+	}
+	id := key[metaKeyLen:]
+	if err := checkValueSize(kind, id, value, maxBackupValueLen); err != nil {
+		return err
+	}
+	switch kind {
+	case metaAnchor, metaHidden:
+		if len(id) != 32 {
+			return fmt.Errorf("%w: anchor root of %d bytes", ErrMalformed, len(id))
+		}
+		var a Anchor
+		return a.decode(toRoot(id), value)
+	case metaRef:
+		if len(value) != 32 {
+			return fmt.Errorf("%w: ref '%s' has corrupt value: '%x'", ErrMalformed, id, value)
+		}
+	case metaPin:
+		if len(id) != 32 {
+			return fmt.Errorf("%w: pin root of %d bytes", ErrMalformed, len(id))
+		}
+	case metaTags:
+		if len(id) != 32 {
+			return fmt.Errorf("%w: tags root of %d bytes", ErrMalformed, len(id))
+		}
+		_, err := decodeTags(value)
+		return err
+	case metaHistory:
+		if len(id) != historyIDLen {
+			return fmt.Errorf("%w: history entry id of %d bytes", ErrMalformed, len(id))
+		}
+	case metaBlob:
+		if len(id) != 32 {
+			return fmt.Errorf("%w: blob root of %d bytes", ErrMalformed, len(id))
+		}
+	case metaSSZ:
+		if len(id) != 32+8 {
+			return fmt.Errorf("%w: SSZ record id of %d bytes", ErrMalformed, len(id))
+		}
+		var r SSZRecord
+		if err := r.decode(value); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+	default:
+		return fmt.Errorf("%w: unexpected metadata kind '%c'", ErrMalformed, kind)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"reflect"
+	"testing"
+)
+
+func TestMerkleDB_BackupRestore(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB())
+	base, head := randomTree(5), randomTree(5)
+	baseRoot, headRoot := base.MerkleRoot(hFn), head.MerkleRoot(hFn)
+	block := *randomRoot()
+	if _, err := src.Put(1, base, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Put(2, head, hFn, WithParent(baseRoot), WithProvenance(block)); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetCanonical(headRoot, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetRef(HeadRef, headRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetRef(FinalizedRef, baseRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Pin(baseRoot); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := src.Backup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := buf.Bytes()
+
+	dst := New([prefixLen]byte{0xa, 0xb, 0xc}, newMemoryDB())
+	if m, err := dst.Restore(bytes.NewReader(backup)); err != nil {
+		t.Fatal(err)
+	} else if m != n {
+		t.Fatalf("restored %d records, backup has %d", m, n)
+	}
+	for _, check := range []func(db MerkleDB) (interface{}, error){
+		func(db MerkleDB) (interface{}, error) { return db.Anchors() },
+		func(db MerkleDB) (interface{}, error) { return db.Refs() },
+		func(db MerkleDB) (interface{}, error) { return db.Pins() },
+		func(db MerkleDB) (interface{}, error) { return db.Usage() },
+	} {
+		expected, err := check(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := check(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("restored %v, expected %v", got, expected)
+		}
+	}
+	got, err := dst.Get(RootGindex, headRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(head, got.Node, RootGindex, hFn, t)
+	if ancestry, err := dst.Ancestry(headRoot, 1); err != nil || len(ancestry) != 1 || ancestry[0].Root != baseRoot {
+		t.Fatalf("expected the parent to be restored, got %v, err: %v", ancestry, err)
+	}
+
+	// truncated and foreign streams are rejected
+	if _, err := New(testPrefix, newMemoryDB()).Restore(bytes.NewReader(backup[:len(backup)-1])); err == nil {
+		t.Fatal("expected an error for a truncated backup")
+	}
+	if _, err := New(testPrefix, newMemoryDB()).Restore(bytes.NewReader([]byte("not a backup"))); err == nil {
+		t.Fatal("expected an error for a foreign stream")
+	}
+}
+
+func TestMerkleDB_BackupBlobs(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB())
+	tree := randomTree(4)
+	root := tree.MerkleRoot(hFn)
+	if _, err := src.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	// larger than the records that are allocated up front
+	blob := bytes.Repeat([]byte{0xab}, 3*maxBackupRecordLen)
+	if err := src.PutBlob(root, blob); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := New(testPrefix, newMemoryDB())
+	if _, err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.GetBlob(root); err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("expected the blob to be restored, got %d bytes, err: %v", len(got), err)
+	}
+	if _, err := New(testPrefix, newMemoryDB()).Restore(bytes.NewReader(buf.Bytes()[:len(buf.Bytes())/2])); err == nil {
+		t.Fatal("expected an error for a backup truncated in the blob")
+	}
+}
+
+func TestMerkleDB_BackupSSZ(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB(), WithSSZStorage(SSZSubtree{Gindex: RootGindex, Type: testStateType}))
+	state := testState(t, 1, 10)
+	root := state.Backing().MerkleRoot(hFn)
+	if _, err := src.Put(1, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := src.GetSSZ(root, RootGindex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := New(testPrefix, newMemoryDB())
+	if _, err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.GetSSZ(root, RootGindex); err != nil || !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the SSZ encoding to be restored, got %+v, err: %v", got, err)
+	}
+}
+
+func TestBackupManifest(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB())
+	a, b := randomTree(4), randomTree(4)
+	for i, tree := range []Node{a, b} {
+		if _, err := src.Put(uint64(i), tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	n, err := src.Backup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := buf.Bytes()
+	m, err := ReadBackupManifest(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := src.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchors := map[Root]bool{a.MerkleRoot(hFn): true, b.MerkleRoot(hFn): true}
+	if m.Version != backupVersion || m.Records != uint64(n) || m.Nodes != uint64(usage.Nodes) || len(m.Anchors) != 2 ||
+		!anchors[m.Anchors[0]] || !anchors[m.Anchors[1]] {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	// a changed root in the value of the first record is a valid record, but does not match the content hash
+	tampered := append([]byte(nil), backup...)
+	keyLen := int(tampered[5])
+	valueLen := int(tampered[6+keyLen])
+	tampered[6+keyLen+valueLen] ^= 1
+	if _, err := ReadBackupManifest(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("expected the tampered backup to fail")
+	}
+	dst := New(testPrefix, newMemoryDB())
+	if _, err := dst.Restore(bytes.NewReader(tampered)); err == nil {
+		t.Fatal("expected the tampered backup to fail to restore")
+	}
+	if restored, err := dst.Anchors(); err != nil || len(restored) != 0 {
+		t.Fatalf("expected no anchors of a failed restore, got %v, err: %v", restored, err)
+	}
+
+	// backups of version 0 have no manifest, and are restored without it
+	manifestLen := 1 + len(m.encode())
+	old := append([]byte(nil), backup[:len(backup)-manifestLen]...)
+	old[4] = 0
+	if _, err := ReadBackupManifest(bytes.NewReader(old)); err == nil {
+		t.Fatal("expected no manifest of version 0")
+	}
+	if restored, err := dst.Restore(bytes.NewReader(old)); err != nil || restored != n {
+		t.Fatalf("expected %d records restored of version 0, got %d, err: %v", n, restored, err)
+	}
+}
@@ -0,0 +1,76 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// metaBlob stores opaque values by root next to the trees, see PutBlob
+const metaBlob byte = 'B'
+
+func (db *merkleDB) PutBlob(root Root, data []byte) error {
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	if err := db.checkWriteSize(metaBlob, data); err != nil {
+		return err
+	}
+	if db.vlog == nil {
+		return db.writeKey(db.metaKey(metaBlob, root[:]), data)
+	}
+	b := new(leveldb.Batch)
+	if err := db.putValue(b, metaBlob, root[:], data); err != nil {
+		return err
+	}
+	return db.write(b)
+}
+
+func (db *merkleDB) GetBlob(root Root) ([]byte, error) {
+	return db.getValue(metaBlob, root[:])
+}
+
+func (db *merkleDB) HasBlob(root Root) (bool, error) {
+	return db.hasValue(metaBlob, root[:])
+}
+
+// pruneBlobs deletes the blobs that are neither the root nor the provenance of an anchor, hidden or not
+func (db *merkleDB) pruneBlobs() error {
+	anchors, err := db.Anchors()
+	if err != nil {
+		return err
+	}
+	hidden, err := db.Hidden()
+	if err != nil {
+		return err
+	}
+	anchors = append(anchors, hidden...)
+	keep := make(map[Root]struct{}, 2*len(anchors))
+	for i := range anchors {
+		keep[anchors[i].Root] = struct{}{}
+		if anchors[i].Provenance != (Root{}) {
+			keep[anchors[i].Provenance] = struct{}{}
+		}
+	}
+	w := db.newDeleteWriter()
+	// the inline blobs, and the pointers to the blobs in the value log
+	scan := func(prefix []byte) error {
+		iter := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+		defer iter.Release()
+		for iter.Next() {
+			if _, ok := keep[toRoot(iter.Key()[len(prefix):])]; ok {
+				continue
+			}
+			if err := w.delete(iter.Key()); err != nil {
+				return err
+			}
+		}
+		return iter.Error()
+	}
+	if err := scan(db.metaKey(metaBlob, nil)); err != nil {
+		return err
+	}
+	if err := scan(db.pointerKey(metaBlob, nil)); err != nil {
+		return err
+	}
+	return w.flush()
+}
@@ -0,0 +1,59 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestMerkleDB_Blobs(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		var opts []Option
+		if deferred {
+			opts = append(opts, WithDeferredDeletes(0))
+		}
+		mdb := New(testPrefix, newMemoryDB(), opts...)
+		hFn := GetHashFn()
+		kept, expired := randomTree(3), randomTree(3)
+		keptBlock, expiredBlock := *randomRoot(), *randomRoot()
+		if _, err := mdb.Put(1, kept, hFn, WithProvenance(keptBlock)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mdb.Put(2, expired, hFn, WithProvenance(expiredBlock)); err != nil {
+			t.Fatal(err)
+		}
+		blobs := map[Root][]byte{
+			keptBlock:               {1, 2, 3},
+			expiredBlock:            {4, 5},
+			kept.MerkleRoot(hFn):    {6},
+			expired.MerkleRoot(hFn): {7},
+			*randomRoot():           {8},
+		}
+		for root, data := range blobs {
+			if err := mdb.PutBlob(root, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if data, err := mdb.GetBlob(keptBlock); err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+			t.Fatalf("unexpected blob: %x, %v", data, err)
+		}
+		if err := mdb.Prune([]Root{kept.MerkleRoot(hFn)}); err != nil {
+			t.Fatal(err)
+		}
+		// the blobs of the kept anchor stay, by root and by provenance
+		for root, data := range blobs {
+			got, err := mdb.GetBlob(root)
+			if root == keptBlock || root == kept.MerkleRoot(hFn) {
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("deferred=%v: expected blob %s to be kept: %v", deferred, root, err)
+				}
+			} else if err != leveldb.ErrNotFound {
+				t.Fatalf("deferred=%v: expected blob %s to be pruned, got %v", deferred, root, err)
+			}
+		}
+		if ok, err := mdb.HasBlob(keptBlock); err != nil || !ok {
+			t.Fatalf("expected the blob to be stored: %v", err)
+		}
+	}
+}
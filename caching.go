@@ -0,0 +1,200 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// CachingDB fronts a slow backend, e.g. a remote or cold database, with a fast local cache.
+// Node reads and proofs are served by the cache, which fetches missing nodes from the backend and keeps them.
+// Puts go to the backend and then to the cache. Anchors, refs and whole-database queries are answered by the backend.
+// The cache only grows by reads and writes, see Evict to shrink it.
+type CachingDB struct {
+	MerkleDB
+	cache *merkleDB
+}
+
+var _ MerkleDB = (*CachingDB)(nil)
+
+// NewCachingDB creates a cache in the given leveldb database, under the prefix, in front of the backend.
+// The options configure the cache, they should not enable expiry: the cache is shrunk by Evict.
+func NewCachingDB(backend MerkleDB, prefix [prefixLen]byte, cache *leveldb.DB, opts ...Option) *CachingDB {
+	opts = append(opts, WithNodeResolver(TreeResolver(backend)))
+	return &CachingDB{MerkleDB: backend, cache: New(prefix, cache, opts...).(*merkleDB)}
+}
+
+func (c *CachingDB) Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error) {
+	return c.cache.Get(gindex, key, opts...)
+}
+
+func (c *CachingDB) GetInto(gindex Gindex, key Root, dst *PairRecord) error {
+	return c.cache.GetInto(gindex, key, dst)
+}
+
+func (c *CachingDB) Has(gindex Gindex, key Root) (bool, error) {
+	if ok, err := c.cache.Has(gindex, key); err != nil || ok {
+		return ok, err
+	}
+	return c.MerkleDB.Has(gindex, key)
+}
+
+func (c *CachingDB) Walk(anchor Root, order WalkOrder, fn func(node StreamNode) error) error {
+	return c.cache.Walk(anchor, order, fn)
+}
+
+func (c *CachingDB) GetPath(anchor Root, typ view.TypeDef, path ...interface{}) (SlottedNode, error) {
+	return c.cache.GetPath(anchor, typ, path...)
+}
+
+func (c *CachingDB) Prove(anchor Root, target Gindex) (*MerkleProof, error) {
+	return c.cache.Prove(anchor, target)
+}
+
+func (c *CachingDB) ProveMulti(anchor Root, targets []Gindex) (*MultiProof, error) {
+	return c.cache.ProveMulti(anchor, targets)
+}
+
+func (c *CachingDB) ProveRange(anchor Root, base Gindex, depth uint8, start uint64, end uint64) (*MultiProof, error) {
+	return c.cache.ProveRange(anchor, base, depth, start, end)
+}
+
+// Sync syncs the backend, and then the cache
+func (c *CachingDB) Sync() error {
+	if err := c.MerkleDB.Sync(); err != nil {
+		return err
+	}
+	return c.cache.Sync()
+}
+
+// Preload fetches the missing nodes of the top of the tree from the backend into the cache
+func (c *CachingDB) Preload(anchor Root, depth uint8) (int, error) {
+	return c.cache.Preload(anchor, depth)
+}
+
+func (c *CachingDB) Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	report, err := c.MerkleDB.Put(slot, node, fn, opts...)
+	if err != nil || report.Replayed {
+		return report, err
+	}
+	// the commit and the idempotency key are for the backend batch, the cache writes its own
+	opts = append(opts, WithCommit(nil), WithIdempotencyKey(""))
+	_, err = c.cache.Put(slot, node, fn, opts...)
+	return report, err
+}
+
+// PutStream writes to the backend only: the stream may leave out nodes that the backend has, but the cache does not.
+// The cache fetches the nodes when they are read.
+func (c *CachingDB) PutStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	return c.MerkleDB.PutStream(slot, anchor, nodes, fn, opts...)
+}
+
+func (c *CachingDB) Transplant(srcGindex Gindex, srcRoot Root, dstGindex Gindex) (InsertReport, error) {
+	return c.MerkleDB.Transplant(srcGindex, srcRoot, dstGindex)
+}
+
+func (c *CachingDB) Delete(gindex Gindex, key Root) error {
+	if err := c.MerkleDB.Delete(gindex, key); err != nil {
+		return err
+	}
+	return c.cache.Delete(gindex, key)
+}
+
+// Hide hides the anchor in the backend, and in the cache: the cache may hold the tree without its anchor,
+// and then gets a hidden record of its own, so it does not serve the tree.
+func (c *CachingDB) Hide(root Root) error {
+	if err := c.MerkleDB.Hide(root); err != nil {
+		return err
+	}
+	if err := c.cache.Hide(root); err != leveldb.ErrNotFound {
+		return err
+	}
+	a := Anchor{Root: root}
+	return c.cache.writeKey(c.cache.metaKey(metaHidden, root[:]), a.encode())
+}
+
+func (c *CachingDB) Unhide(root Root) error {
+	if err := c.MerkleDB.Unhide(root); err != nil {
+		return err
+	}
+	if err := c.cache.Unhide(root); err != leveldb.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (c *CachingDB) Prune(liveRoots []Root) error {
+	if err := c.MerkleDB.Prune(liveRoots); err != nil {
+		return err
+	}
+	return c.cache.Prune(liveRoots)
+}
+
+func (c *CachingDB) Reorg(oldHead Root, newHead Root) (*ReorgReport, error) {
+	report, err := c.MerkleDB.Reorg(oldHead, newHead)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range report.Abandoned {
+		if err := c.cache.Delete(RootGindex, root); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// Evict removes the cached nodes and anchors that were stored at a slot before minSlot,
+// and returns the number of removed nodes. The backend is not changed.
+func (c *CachingDB) Evict(minSlot uint64) (int, error) {
+	defer c.cache.resetUsage()
+	defer c.cache.resetPrefetch()
+	defer c.cache.resetProofs()
+	c.cache.pruneLock.Lock()
+	defer c.cache.pruneLock.Unlock()
+	iter := c.cache.db.NewIterator(util.BytesPrefix(c.cache.prefix[:]), nil)
+	defer iter.Release()
+	w := c.cache.newDeleteWriter()
+	count := 0
+	for iter.Next() {
+		kind, isMeta := metaKind(iter.Key())
+		if isMeta && kind != metaAnchor {
+			continue
+		}
+		// both anchor records and node values have the slot after their first byte
+		v := iter.Value()
+		if len(v) < 1+8 || binary.LittleEndian.Uint64(v[1:1+8]) >= minSlot {
+			continue
+		}
+		if err := w.delete(iter.Key()); err != nil {
+			return count, err
+		}
+		if !isMeta {
+			count++
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return count, err
+	}
+	return count, w.flush()
+}
+
+// Stats are those of the backend, with the reads and cache hits of the cache
+func (c *CachingDB) Stats() Stats {
+	stats := c.MerkleDB.Stats()
+	cache := c.cache.Stats()
+	stats.Gets = cache.Gets
+	stats.CacheHits = cache.CacheHits
+	stats.CacheMisses = cache.CacheMisses
+	return stats
+}
+
+// Close closes the cache and the backend
+func (c *CachingDB) Close() error {
+	cacheErr := c.cache.Close()
+	if err := c.MerkleDB.Close(); err != nil {
+		return err
+	}
+	return cacheErr
+}
@@ -0,0 +1,65 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestCachingDB(t *testing.T) {
+	hFn := GetHashFn()
+	backend := New(testPrefix, newMemoryDB())
+	cold := randomTree(6)
+	coldRoot := cold.MerkleRoot(hFn)
+	if _, err := backend.Put(1, cold, hFn); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCachingDB(backend, [3]byte{9, 9, 9}, newMemoryDB())
+	defer c.Close()
+	// reads populate the cache
+	out, err := c.Get(RootGindex, coldRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(cold, out.Node, RootGindex, hFn, t)
+	if ok, _ := c.cache.Has(RootGindex, coldRoot); !ok {
+		t.Fatal("expected read node to be cached")
+	}
+	// puts write through
+	hot := randomTree(6)
+	hotRoot := hot.MerkleRoot(hFn)
+	if _, err := c.Put(100, hot, hFn); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []MerkleDB{backend, c.cache} {
+		if ok, _ := db.Has(RootGindex, hotRoot); !ok {
+			t.Fatal("expected put tree in backend and cache")
+		}
+	}
+	if anchors, err := c.Anchors(); err != nil || len(anchors) != 2 {
+		t.Fatalf("expected the anchors of the backend, got %d, err: %v", len(anchors), err)
+	}
+	// eviction by slot age only affects the cache
+	n, err := c.Evict(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("expected old nodes to be evicted")
+	}
+	if ok, _ := c.cache.Has(RootGindex, coldRoot); ok {
+		t.Fatal("expected old node to be evicted from the cache")
+	}
+	if ok, _ := c.cache.Has(RootGindex, hotRoot); !ok {
+		t.Fatal("expected recent node to stay cached")
+	}
+	if ok, err := c.Has(RootGindex, coldRoot); err != nil || !ok {
+		t.Fatal("expected evicted node to still be in the backend")
+	}
+	p, err := c.Prove(coldRoot, Gindex64(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Verify(coldRoot, hFn) {
+		t.Fatal("expected proof through the cache to verify")
+	}
+}
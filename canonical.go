@@ -0,0 +1,90 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"sort"
+)
+
+// Canonicality filters anchors by their canonical mark
+type Canonicality byte
+
+const (
+	// AnyCanonicality matches all anchors
+	AnyCanonicality Canonicality = iota
+	// CanonicalOnly matches the anchors that are marked canonical
+	CanonicalOnly
+	// NonCanonicalOnly matches the anchors that are not marked canonical
+	NonCanonicalOnly
+)
+
+func (c Canonicality) matches(a *Anchor) bool {
+	switch c {
+	case CanonicalOnly:
+		return a.Canonical
+	case NonCanonicalOnly:
+		return !a.Canonical
+	default:
+		return true
+	}
+}
+
+func (db *merkleDB) SetCanonical(root Root, canonical bool) error {
+	defer db.resetHead()
+	// a prune must not delete the anchor between the read and the write, the write would bring it back
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	a, err := db.GetAnchor(root)
+	if err != nil {
+		return err
+	}
+	a.Canonical = canonical
+	return db.writeKey(db.metaKey(metaAnchor, root[:]), a.encode())
+}
+
+func (db *merkleDB) FilterAnchors(c Canonicality) ([]Anchor, error) {
+	anchors, err := db.Anchors()
+	if err != nil {
+		return nil, err
+	}
+	out := anchors[:0]
+	for i := range anchors {
+		if c.matches(&anchors[i]) {
+			out = append(out, anchors[i])
+		}
+	}
+	return out, nil
+}
+
+func (db *merkleDB) CanonicalRange(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
+	anchors, err := db.FilterAnchors(CanonicalOnly)
+	if err != nil {
+		return nil, err
+	}
+	return db.anchorRange(anchors, startSlot, endSlot, gindex)
+}
+
+// anchorRange gets the nodes at the gindex in the trees of the anchors between startSlot and endSlot,
+// ordered by slot, then by the order of the anchors. The slots are those of the anchors.
+func (db *merkleDB) anchorRange(anchors []Anchor, startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return anchors[i].Slot < anchors[j].Slot
+	})
+	var out []SlottedNode
+	for i := range anchors {
+		a := &anchors[i]
+		if a.Slot < startSlot || a.Slot > endSlot {
+			continue
+		}
+		root, err := db.lookup(a.Root, gindex)
+		if err != nil {
+			return nil, err
+		}
+		n, err := db.Get(gindex, root)
+		if err != nil {
+			return nil, err
+		}
+		n.Slot = a.Slot
+		out = append(out, n)
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Canonical(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	canon := []Node{randomTree(5), randomTree(5)}
+	fork := randomTree(5)
+	for i, n := range []Node{canon[0], fork, canon[1]} {
+		if _, err := mdb.Put(uint64(1+i/2), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range canon {
+		if err := mdb.SetCanonical(n.MerkleRoot(hFn), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// putting a tree again keeps its mark
+	if _, err := mdb.Put(1, canon[0], hFn); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := mdb.GetAnchor(canon[0].MerkleRoot(hFn)); err != nil || !a.Canonical {
+		t.Fatalf("expected canonical anchor, err: %v", err)
+	}
+	if anchors, err := mdb.FilterAnchors(CanonicalOnly); err != nil || len(anchors) != 2 {
+		t.Fatalf("expected 2 canonical anchors, got %d, err: %v", len(anchors), err)
+	}
+	anchors, err := mdb.FilterAnchors(NonCanonicalOnly)
+	if err != nil || len(anchors) != 1 || anchors[0].Root != fork.MerkleRoot(hFn) {
+		t.Fatalf("expected only the fork to be non-canonical, err: %v", err)
+	}
+	if anchors, err := mdb.FilterAnchors(AnyCanonicality); err != nil || len(anchors) != 3 {
+		t.Fatalf("expected 3 anchors, got %d, err: %v", len(anchors), err)
+	}
+	// slot 1 has both a canonical tree and a fork
+	out, err := mdb.CanonicalRange(1, 1, RootGindex.Right())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := canon[0].Right()
+	if len(out) != 1 || out[0].Slot != 1 || out[0].Node.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatal("expected the right node of the canonical tree at slot 1")
+	}
+	if err := mdb.SetCanonical(canon[1].MerkleRoot(hFn), false); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := mdb.CanonicalRange(0, 10, RootGindex); err != nil || len(out) != 1 {
+		t.Fatalf("expected 1 canonical tree left, got %d, err: %v", len(out), err)
+	}
+	if err := mdb.SetCanonical(Root{1}, true); err == nil {
+		t.Fatal("expected unknown anchor error")
+	}
+
+	// marking an anchor while it is pruned does not bring it back
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for mdb.SetCanonical(fork.MerkleRoot(hFn), true) == nil {
+		}
+	}()
+	if err := mdb.Prune([]Root{canon[0].MerkleRoot(hFn), canon[1].MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if _, err := mdb.GetAnchor(fork.MerkleRoot(hFn)); err == nil {
+		t.Fatal("expected the pruned anchor to stay gone")
+	}
+}
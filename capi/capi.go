@@ -0,0 +1,254 @@
+// Package capi exports a C ABI of merkledb, for tooling in other languages, e.g. Python through ctypes or cffi.
+// Build the shared library with:
+//
+//	go build -buildmode=c-shared -o libmerkledb.so ./cmd/libmerkledb
+//
+// and include merkledb.h of this package, the header that go build writes has no exports of imported packages.
+// Databases are referred to by handles, roots are 32 bytes, gindices are uint64. Functions return 0 on success and -1 on failure, except where noted, with the error message
+// in *err, if err is not NULL. Memory that the library returns, error messages, answers and proofs, is freed with merkledb_free.
+//
+// Like the CLI, the shim knows no SSZ types: tooling with types builds its own library with a main package
+// that imports capi and registers its types with Register. Without types, merkledb_ingest puts trees
+// from their nodes, in the ingest frames of merkledb.Ingest, so the stock library writes databases too.
+package capi
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/protolambda/merkledb"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync"
+	"unsafe"
+)
+
+var (
+	lock    sync.Mutex
+	types   = make(map[string]view.TypeDef)
+	handles = make(map[int64]merkledb.MerkleDB)
+	next    int64
+)
+
+// Register makes the type available to merkledb_put_ssz under the name
+func Register(name string, typ view.TypeDef) {
+	lock.Lock()
+	defer lock.Unlock()
+	types[name] = typ
+}
+
+func open(path string, prefix [3]byte) (int64, error) {
+	ldb, err := leveldb.OpenFile(path, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return 0, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	next += 1
+	handles[next] = merkledb.New(prefix, ldb)
+	return next, nil
+}
+
+func lookup(h int64) (merkledb.MerkleDB, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	db, ok := handles[h]
+	if !ok {
+		return nil, fmt.Errorf("unknown handle %d", h)
+	}
+	return db, nil
+}
+
+func closeDB(h int64) error {
+	lock.Lock()
+	db, ok := handles[h]
+	delete(handles, h)
+	lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown handle %d", h)
+	}
+	return db.Close()
+}
+
+func putSSZ(h int64, typeName string, slot uint64, data []byte) (Root, error) {
+	db, err := lookup(h)
+	if err != nil {
+		return Root{}, err
+	}
+	lock.Lock()
+	typ, ok := types[typeName]
+	lock.Unlock()
+	if !ok {
+		return Root{}, fmt.Errorf("unknown type '%s'", typeName)
+	}
+	root, _, err := merkledb.ImportSSZ(db, slot, typ, bytes.NewReader(data), GetHashFn())
+	return root, err
+}
+
+// ingest puts the trees of the ingest frames, and returns the number of stored trees with the answer frames
+func ingest(h int64, data []byte) (int, []byte, error) {
+	db, err := lookup(h)
+	if err != nil {
+		return 0, nil, err
+	}
+	var answers bytes.Buffer
+	n, err := merkledb.Ingest(db, bytes.NewReader(data), &answers, GetHashFn())
+	return n, answers.Bytes(), err
+}
+
+func get(h int64, gindex uint64, key Root) (merkledb.PairRecord, error) {
+	db, err := lookup(h)
+	if err != nil {
+		return merkledb.PairRecord{}, err
+	}
+	if gindex == 0 {
+		return merkledb.PairRecord{}, errors.New("gindex 0")
+	}
+	var rec merkledb.PairRecord
+	err = db.GetInto(Gindex64(gindex), key, &rec)
+	return rec, err
+}
+
+// prove encodes the proof as the leaf, followed by the branch, bottom-up
+func prove(h int64, anchor Root, gindex uint64) ([]byte, error) {
+	db, err := lookup(h)
+	if err != nil {
+		return nil, err
+	}
+	if gindex == 0 {
+		return nil, errors.New("gindex 0")
+	}
+	p, err := db.Prove(anchor, Gindex64(gindex))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 32*(1+len(p.Branch)))
+	out = append(out, p.Leaf[:]...)
+	for _, r := range p.Branch {
+		out = append(out, r[:]...)
+	}
+	return out, nil
+}
+
+func setErr(out **C.char, err error) C.int {
+	if out != nil {
+		*out = C.CString(err.Error())
+	}
+	return -1
+}
+
+func cRoot(p *C.uint8_t) Root {
+	var out Root
+	copy(out[:], (*[32]byte)(unsafe.Pointer(p))[:])
+	return out
+}
+
+func setRoot(p *C.uint8_t, r Root) {
+	copy((*[32]byte)(unsafe.Pointer(p))[:], r[:])
+}
+
+// merkledb_open opens the leveldb database at the path, with the 3-byte key prefix.
+// It returns the handle of the database, or 0 on failure.
+//
+//export merkledb_open
+func merkledb_open(path *C.char, prefix *C.uint8_t, err **C.char) C.int64_t {
+	var p [3]byte
+	copy(p[:], (*[3]byte)(unsafe.Pointer(prefix))[:])
+	h, e := open(C.GoString(path), p)
+	if e != nil {
+		setErr(err, e)
+		return 0
+	}
+	return C.int64_t(h)
+}
+
+// merkledb_close closes the database of the handle
+//
+//export merkledb_close
+func merkledb_close(h C.int64_t, err **C.char) C.int {
+	if e := closeDB(int64(h)); e != nil {
+		return setErr(err, e)
+	}
+	return 0
+}
+
+// merkledb_put_ssz deserializes the SSZ data as the registered type, puts its tree at the slot,
+// and writes the root of the tree to root_out, 32 bytes.
+//
+//export merkledb_put_ssz
+func merkledb_put_ssz(h C.int64_t, typ *C.char, slot C.uint64_t, data *C.uint8_t, size C.size_t, rootOut *C.uint8_t, err **C.char) C.int {
+	if size > 1<<31-1 {
+		return setErr(err, fmt.Errorf("SSZ data of %d bytes is too large", size))
+	}
+	root, e := putSSZ(int64(h), C.GoString(typ), uint64(slot), C.GoBytes(unsafe.Pointer(data), C.int(size)))
+	if e != nil {
+		return setErr(err, e)
+	}
+	setRoot(rootOut, root)
+	return 0
+}
+
+// merkledb_ingest puts the trees of the ingest frames in the data, see merkledb.Ingest, without registered types.
+// The answer frames, a result or an error per tree, are written to *answers_out of *size_out bytes.
+// It returns the number of stored trees, or -1 if the framing of the data is broken, with the answers up to it.
+//
+//export merkledb_ingest
+func merkledb_ingest(h C.int64_t, data *C.uint8_t, size C.size_t, answersOut **C.uint8_t, sizeOut *C.size_t, err **C.char) C.int64_t {
+	if size > 1<<31-1 {
+		return C.int64_t(setErr(err, fmt.Errorf("ingest data of %d bytes is too large", size)))
+	}
+	n, answers, e := ingest(int64(h), C.GoBytes(unsafe.Pointer(data), C.int(size)))
+	*answersOut = (*C.uint8_t)(C.CBytes(answers))
+	*sizeOut = C.size_t(len(answers))
+	if e != nil {
+		return C.int64_t(setErr(err, e))
+	}
+	return C.int64_t(n)
+}
+
+// merkledb_get gets the node with the root key, 32 bytes, at the gindex, and writes its slot,
+// and for a pair the roots of its children to left_out and right_out, 32 bytes each.
+// It returns 1 for a pair, 0 for a leaf, and -1 on failure.
+//
+//export merkledb_get
+func merkledb_get(h C.int64_t, gindex C.uint64_t, key *C.uint8_t, slotOut *C.uint64_t, leftOut *C.uint8_t, rightOut *C.uint8_t, err **C.char) C.int {
+	rec, e := get(int64(h), uint64(gindex), cRoot(key))
+	if e != nil {
+		return setErr(err, e)
+	}
+	*slotOut = C.uint64_t(rec.Slot)
+	if !rec.Pair {
+		return 0
+	}
+	setRoot(leftOut, rec.Left)
+	setRoot(rightOut, rec.Right)
+	return 1
+}
+
+// merkledb_prove proves the node at the gindex in the tree of the anchor root, 32 bytes.
+// The proof is the leaf, followed by the branch bottom-up, 32 bytes per root, in *proof_out of *size_out bytes.
+//
+//export merkledb_prove
+func merkledb_prove(h C.int64_t, anchor *C.uint8_t, gindex C.uint64_t, proofOut **C.uint8_t, sizeOut *C.size_t, err **C.char) C.int {
+	proof, e := prove(int64(h), cRoot(anchor), uint64(gindex))
+	if e != nil {
+		return setErr(err, e)
+	}
+	*proofOut = (*C.uint8_t)(C.CBytes(proof))
+	*sizeOut = C.size_t(len(proof))
+	return 0
+}
+
+// merkledb_free frees memory returned by the library: error messages, answers and proofs
+//
+//export merkledb_free
+func merkledb_free(p unsafe.Pointer) {
+	C.free(p)
+}
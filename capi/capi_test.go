@@ -0,0 +1,115 @@
+package capi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/protolambda/merkledb"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"path/filepath"
+	"testing"
+)
+
+func TestShim(t *testing.T) {
+	typ := view.BasicListType(view.Uint64Type, 64)
+	Register("numbers", typ)
+	v := typ.New()
+	for i := uint64(0); i < 10; i++ {
+		if err := v.Append(view.Uint64View(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var data bytes.Buffer
+	if err := v.Serialize(codec.NewEncodingWriter(&data)); err != nil {
+		t.Fatal(err)
+	}
+	h, err := open(filepath.Join(t.TempDir(), "db"), [3]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := putSSZ(h, "unknown", 1, data.Bytes()); err == nil {
+		t.Fatal("expected an unknown type to fail")
+	}
+	root, err := putSSZ(h, "numbers", 1, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := v.HashTreeRoot(GetHashFn()); root != expected {
+		t.Fatalf("expected root %s, got %s", expected, root)
+	}
+	rec, err := get(h, 1, root)
+	if err != nil || !rec.Pair || rec.Slot != 1 {
+		t.Fatalf("unexpected root node: %+v, err: %v", rec, err)
+	}
+	// the length mix-in of the list
+	proof, err := prove(h, root, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof) != 2*32 || !bytes.Equal(proof[32:], rec.Left[:]) {
+		t.Fatalf("unexpected proof: %x", proof)
+	}
+	p := merkledb.MerkleProof{Gindex: Gindex64(3), Leaf: toRoot(proof[:32]), Branch: []Root{toRoot(proof[32:])}}
+	if !p.Verify(root, nil) {
+		t.Fatal("expected the proof to verify")
+	}
+	if err := closeDB(h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(h, 1, root); err == nil {
+		t.Fatal("expected a closed handle to fail")
+	}
+}
+
+func TestShim_Ingest(t *testing.T) {
+	hFn := GetHashFn()
+	left, right := Root{1}, Root{2}
+	tree := NewPairNode(&left, &right)
+	root := tree.MerkleRoot(hFn)
+	var data []byte
+	frame := func(payload []byte) {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+		data = append(append(data, size[:]...), payload...)
+	}
+	node := func(typ byte, gindex uint64, roots ...Root) []byte {
+		out := make([]byte, 1+8, 1+8+32*len(roots))
+		out[0] = typ
+		binary.LittleEndian.PutUint64(out[1:], gindex)
+		for _, r := range roots {
+			out = append(out, r[:]...)
+		}
+		return out
+	}
+	frame(node(merkledb.IngestTree, 5, root))
+	frame(node(merkledb.IngestPair, 1, root, left, right))
+	frame(node(merkledb.IngestLeaf, 2, left))
+	frame(node(merkledb.IngestLeaf, 3, right))
+	frame([]byte{merkledb.IngestEnd})
+
+	h, err := open(filepath.Join(t.TempDir(), "db"), [3]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(h)
+	n, answers, err := ingest(h, data)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 stored tree, got %d, err: %v", n, err)
+	}
+	if len(answers) != 4+1+32+8+8 || answers[4] != merkledb.IngestResult || toRoot(answers[5:37]) != root {
+		t.Fatalf("unexpected answers: %x", answers)
+	}
+	rec, err := get(h, 1, root)
+	if err != nil || !rec.Pair || rec.Slot != 5 || rec.Left != left {
+		t.Fatalf("unexpected root node: %+v, err: %v", rec, err)
+	}
+	if _, answers, err := ingest(h, data[:len(data)-1]); err == nil || len(answers) == 0 {
+		t.Fatalf("expected broken framing to fail with an answer, got %x, err: %v", answers, err)
+	}
+}
+
+func toRoot(b []byte) (out Root) {
+	copy(out[:], b)
+	return
+}
@@ -0,0 +1,33 @@
+/* C ABI of merkledb, see package capi. Link with the shared library of cmd/libmerkledb. */
+#ifndef MERKLEDB_H
+#define MERKLEDB_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Opens the leveldb database at the path, with the 3-byte key prefix. Returns the handle, or 0 on failure. */
+int64_t merkledb_open(char *path, uint8_t *prefix, char **err);
+/* Closes the database of the handle. */
+int merkledb_close(int64_t h, char **err);
+/* Puts the tree of the SSZ data of the registered type at the slot, and writes its 32-byte root to root_out.
+   The stock library registers no types, a library with types registers them with capi.Register in Go. */
+int merkledb_put_ssz(int64_t h, char *type, uint64_t slot, uint8_t *data, size_t size, uint8_t *root_out, char **err);
+/* Puts the trees of the ingest frames in the data, without registered types, see merkledb.Ingest for the frames.
+   Writes the answer frames to answers_out, freed with merkledb_free. Returns the number of stored trees, -1 on broken framing. */
+int64_t merkledb_ingest(int64_t h, uint8_t *data, size_t size, uint8_t **answers_out, size_t *size_out, char **err);
+/* Gets the node with the 32-byte key at the gindex. Returns 1 for a pair, with its children, 0 for a leaf, -1 on failure. */
+int merkledb_get(int64_t h, uint64_t gindex, uint8_t *key, uint64_t *slot_out, uint8_t *left_out, uint8_t *right_out, char **err);
+/* Proves the node at the gindex in the tree of the 32-byte anchor root: the leaf, then the branch bottom-up. */
+int merkledb_prove(int64_t h, uint8_t *anchor, uint64_t gindex, uint8_t **proof_out, size_t *size_out, char **err);
+/* Frees memory returned by the library: error messages, answers and proofs. */
+void merkledb_free(void *p);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
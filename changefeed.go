@@ -0,0 +1,156 @@
+package merkledb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"sync"
+)
+
+// ErrLagging ends a subscription that did not receive the changes as fast as they were committed
+var ErrLagging = errors.New("subscriber is lagging behind")
+
+// maxChangeLen bounds the batch length read from a change stream, to not allocate for corrupt lengths
+const maxChangeLen = 1 << 30
+
+// Change is a batch that was committed to the leveldb, with its sequence number in the changefeed
+type Change struct {
+	Seq uint64
+	// Batch is the leveldb batch encoding, see leveldb.Batch.Dump, with the full keys including the prefix.
+	// The values in the value log are inline, in place of the pointers to them, see WithValueLog.
+	Batch []byte
+}
+
+// Changefeed streams the committed batches of the merkledbs that use it, in commit order, to subscribers.
+// A follower applies the changes to its own leveldb, after restoring a backup, to serve as a read replica.
+// Commits are serialized while the feed is used, to publish them in order.
+type Changefeed struct {
+	lock sync.Mutex
+	seq  uint64
+	subs map[*Subscription]struct{}
+}
+
+func NewChangefeed() *Changefeed {
+	return &Changefeed{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the changes of a feed, from the moment it subscribed
+type Subscription struct {
+	feed    *Changefeed
+	changes chan Change
+	err     error
+}
+
+// Subscribe buffers up to the given number of changes for the subscriber.
+// A subscriber with a full buffer is dropped with ErrLagging, commits never wait for subscribers.
+func (f *Changefeed) Subscribe(buffer int) *Subscription {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	s := &Subscription{feed: f, changes: make(chan Change, buffer)}
+	f.subs[s] = struct{}{}
+	return s
+}
+
+// Changes delivers the changes in commit order, and is closed when the subscription ends
+func (s *Subscription) Changes() <-chan Change {
+	return s.changes
+}
+
+// Err is why the subscription ended, after the changes are closed: ErrLagging, the error of a change that
+// could not be published, or nil if it was closed
+func (s *Subscription) Err() error {
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+	s.feed.drop(s, nil)
+}
+
+func (f *Changefeed) drop(s *Subscription, err error) {
+	if _, ok := f.subs[s]; !ok {
+		return
+	}
+	delete(f.subs, s)
+	s.err = err
+	close(s.changes)
+}
+
+// commit commits the batch, and publishes the encoding of it that the dump returns.
+// The subscriptions end with the error of the dump if it fails.
+func (f *Changefeed) commit(b *leveldb.Batch, commit CommitFn, dump func(b *leveldb.Batch) ([]byte, error)) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := commit(b); err != nil {
+		return err
+	}
+	f.seq++
+	if len(f.subs) == 0 {
+		return nil
+	}
+	data, err := dump(b)
+	if err != nil {
+		// the batch is committed, the subscribers miss the change and can not follow anymore
+		for s := range f.subs {
+			f.drop(s, fmt.Errorf("failed to publish change %d: %w", f.seq, err))
+		}
+		return nil
+	}
+	change := Change{Seq: f.seq, Batch: append([]byte(nil), data...)}
+	for s := range f.subs {
+		select {
+		case s.changes <- change:
+		default:
+			f.drop(s, ErrLagging)
+		}
+	}
+	return nil
+}
+
+// ApplyChange writes the batch of the change to the leveldb of a follower
+func ApplyChange(db *leveldb.DB, c Change) error {
+	b := new(leveldb.Batch)
+	if err := b.Load(c.Batch); err != nil {
+		return fmt.Errorf("corrupt batch of change %d: %v", c.Seq, err)
+	}
+	return db.Write(b, nil)
+}
+
+// WriteChange encodes the change to a stream, as the uvarint sequence number and batch length, and the batch
+func WriteChange(w io.Writer, c Change) error {
+	var buf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], c.Seq)
+	n += binary.PutUvarint(buf[n:], uint64(len(c.Batch)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(c.Batch)
+	return err
+}
+
+// ReadChange decodes a change that was written with WriteChange. It returns io.EOF at the end of the stream.
+func ReadChange(r *bufio.Reader) (Change, error) {
+	seq, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Change{}, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to read length of change %d: %v", seq, err)
+	}
+	if size > maxChangeLen {
+		return Change{}, fmt.Errorf("change %d of %d bytes is too large", seq, size)
+	}
+	c := Change{Seq: seq, Batch: make([]byte, size)}
+	if _, err := io.ReadFull(r, c.Batch); err != nil {
+		return Change{}, fmt.Errorf("failed to read change %d: %v", seq, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,79 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"testing"
+)
+
+func TestChangefeed(t *testing.T) {
+	hFn := GetHashFn()
+	feed := NewChangefeed()
+	leader := New(testPrefix, newMemoryDB(), WithChangefeed(feed)).(*merkleDB)
+	sub := feed.Subscribe(100)
+	lagging := feed.Subscribe(0)
+
+	foo, bar := fullTree(4), fullTree(3)
+	if _, err := leader.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.Put(2, bar, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.SetRef("head", bar.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Prune([]Root{bar.MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+	if _, ok := <-lagging.Changes(); ok {
+		t.Fatal("expected the lagging subscription to be closed")
+	}
+	if lagging.Err() != ErrLagging || sub.Err() != nil {
+		t.Fatalf("unexpected subscription errors: %v, %v", lagging.Err(), sub.Err())
+	}
+
+	// the changes are streamed to a follower
+	var stream bytes.Buffer
+	var seq uint64
+	for c := range sub.Changes() {
+		if c.Seq != seq+1 {
+			t.Fatalf("expected change %d, got %d", seq+1, c.Seq)
+		}
+		seq = c.Seq
+		if err := WriteChange(&stream, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ldb := newMemoryDB()
+	r := bufio.NewReader(&stream)
+	for {
+		c, err := ReadChange(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyChange(ldb, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	follower := New(testPrefix, ldb).(*merkleDB)
+	if a, b := countKeys(t, leader), countKeys(t, follower); a != b {
+		t.Fatalf("expected the follower to have the %d keys of the leader, got %d", a, b)
+	}
+	out, err := follower.Get(RootGindex, bar.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(bar, out.Node, RootGindex, hFn, t)
+	if root, err := follower.GetRef("head"); err != nil || root != bar.MerkleRoot(hFn) {
+		t.Fatalf("expected the ref, got %s, err: %v", root, err)
+	}
+	if ok, err := follower.Has(RootGindex, foo.MerkleRoot(hFn)); err != nil || ok {
+		t.Fatalf("expected the pruned tree to be gone, ok: %v, err: %v", ok, err)
+	}
+}
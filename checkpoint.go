@@ -0,0 +1,109 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync/atomic"
+)
+
+const metaPruneCheckpoint byte = 'c'
+
+const pruneCheckpointVersion = 0
+
+// PruneCheckpoint is the progress of a prune, written with every chunk of deletes.
+// A prune that is interrupted leaves it behind, and the next Prune with the same live roots
+// marks again and continues the sweep after the last key, instead of scanning from the start.
+// A put in between clears the last key: the next prune scans from the start again.
+type PruneCheckpoint struct {
+	LiveRoots []Root
+	// LastKey is the last deleted key
+	LastKey []byte
+}
+
+func (c *PruneCheckpoint) encode() []byte {
+	out := make([]byte, 1+4+32*len(c.LiveRoots)+len(c.LastKey))
+	out[0] = pruneCheckpointVersion
+	binary.LittleEndian.PutUint32(out[1:1+4], uint32(len(c.LiveRoots)))
+	for i := range c.LiveRoots {
+		copy(out[1+4+32*i:], c.LiveRoots[i][:])
+	}
+	copy(out[1+4+32*len(c.LiveRoots):], c.LastKey)
+	return out
+}
+
+func (c *PruneCheckpoint) decode(v []byte) error {
+	if len(v) < 1+4 {
+		return fmt.Errorf("corrupt prune checkpoint, too short: '%x'", v)
+	}
+	if v[0] != pruneCheckpointVersion {
+		return fmt.Errorf("prune checkpoint has unknown record version: %d", v[0])
+	}
+	n := binary.LittleEndian.Uint32(v[1 : 1+4])
+	if uint64(len(v)) < 1+4+32*uint64(n) {
+		return fmt.Errorf("corrupt prune checkpoint, missing live roots: '%x'", v)
+	}
+	c.LiveRoots = make([]Root, n)
+	for i := range c.LiveRoots {
+		copy(c.LiveRoots[i][:], v[1+4+32*i:])
+	}
+	c.LastKey = append([]byte(nil), v[1+4+32*n:]...)
+	return nil
+}
+
+// sameRoots is true if the checkpoint is of a prune of the same set of live roots
+func (c *PruneCheckpoint) sameRoots(liveRoots []Root) bool {
+	set := make(map[Root]struct{}, len(c.LiveRoots))
+	for _, root := range c.LiveRoots {
+		set[root] = struct{}{}
+	}
+	other := make(map[Root]struct{}, len(liveRoots))
+	for _, root := range liveRoots {
+		if _, ok := set[root]; !ok {
+			return false
+		}
+		other[root] = struct{}{}
+	}
+	return len(other) == len(set)
+}
+
+func (db *merkleDB) PruneCheckpoint() (*PruneCheckpoint, error) {
+	v, err := db.getMeta(metaPruneCheckpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := new(PruneCheckpoint)
+	if err := c.decode(v); err != nil {
+		return nil, corruptMeta(metaPruneCheckpoint, nil, v, err)
+	}
+	return c, nil
+}
+
+// invalidateCheckpoint clears the last key of a stored prune checkpoint in the batch of a put, so the resumed prune
+// scans all keys again: the anchor of the put sorts before the last key, and would be kept without its new nodes.
+func (db *merkleDB) invalidateCheckpoint(b *leveldb.Batch) error {
+	if atomic.LoadInt32(&db.checkpointed) == 0 {
+		return nil
+	}
+	c, err := db.PruneCheckpoint()
+	if err == leveldb.ErrNotFound || (err == nil && c.LastKey == nil) {
+		atomic.StoreInt32(&db.checkpointed, 0)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	c.LastKey = nil
+	b.Put(db.metaKey(metaPruneCheckpoint, nil), c.encode())
+	return nil
+}
+
+func (db *merkleDB) ResumePrune() (bool, error) {
+	c, err := db.PruneCheckpoint()
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, db.Prune(c.LiveRoots)
+}
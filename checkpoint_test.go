@@ -0,0 +1,154 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestMerkleDB_ResumePrune(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithDeleteBatchSize(10)).(*merkleDB)
+	live := randomTree(5)
+	liveRoot := live.MerkleRoot(hFn)
+	for i, n := range []Node{live, randomTree(5), randomTree(5)} {
+		if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resumed, err := mdb.ResumePrune(); err != nil || resumed {
+		t.Fatalf("expected no prune to resume, got %v, err: %v", resumed, err)
+	}
+	plan, err := mdb.PrunePlan([]Root{liveRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leave a checkpoint behind, as if a prune stopped halfway through the unreachable nodes
+	var unreachable [][]byte
+	marked, err := mdb.mark([]Root{liveRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.unmarked(marked, nil, func(key []byte, value []byte) error {
+		unreachable = append(unreachable, append([]byte(nil), key...))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	half := len(unreachable) / 2
+	c := PruneCheckpoint{LiveRoots: []Root{liveRoot}, LastKey: unreachable[half]}
+	if err := mdb.db.Put(mdb.metaKey(metaPruneCheckpoint, nil), c.encode(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mdb.PruneCheckpoint(); err != nil {
+		t.Fatal(err)
+	} else if !got.sameRoots(c.LiveRoots) || string(got.LastKey) != string(c.LastKey) {
+		t.Fatalf("unexpected checkpoint: %v", got)
+	}
+
+	if resumed, err := mdb.ResumePrune(); err != nil || !resumed {
+		t.Fatalf("expected the prune to resume, got %v, err: %v", resumed, err)
+	}
+	// the keys up to the checkpoint were not scanned again
+	for i, key := range unreachable {
+		ok, err := mdb.db.Has(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (i <= half) {
+			t.Fatalf("key %d of %d: expected to be kept %v, got %v", i, len(unreachable), i <= half, ok)
+		}
+	}
+	if _, err := mdb.PruneCheckpoint(); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the checkpoint to be removed, got %v", err)
+	}
+	// a new prune scans everything again
+	if err := mdb.Prune([]Root{liveRoot}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, mdb); n != marked.len() {
+		t.Fatalf("expected %d keys, got %d", marked.len(), n)
+	}
+	if len(unreachable) != plan.Nodes+len(plan.Anchors) {
+		t.Fatalf("expected the plan to match the unreachable keys")
+	}
+}
+
+func TestMerkleDB_ResumePruneAfterPut(t *testing.T) {
+	hFn := GetHashFn()
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb).(*merkleDB)
+	live := randomTree(5)
+	liveRoot := live.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, live, hFn); err != nil {
+		t.Fatal(err)
+	}
+	// a checkpoint of a prune that stopped right after the anchors, found when the DB is opened again
+	c := PruneCheckpoint{LiveRoots: []Root{liveRoot}, LastKey: mdb.metaKey(metaAnchor+1, nil)}
+	if err := ldb.Put(mdb.metaKey(metaPruneCheckpoint, nil), c.encode(), nil); err != nil {
+		t.Fatal(err)
+	}
+	mdb = New(testPrefix, ldb).(*merkleDB)
+	later := randomTree(5)
+	laterRoot := later.MerkleRoot(hFn)
+	if _, err := mdb.Put(2, later, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mdb.PruneCheckpoint(); err != nil || got.LastKey != nil || !got.sameRoots(c.LiveRoots) {
+		t.Fatalf("expected the put to clear the last key, got %v, err: %v", got, err)
+	}
+	if resumed, err := mdb.ResumePrune(); err != nil || !resumed {
+		t.Fatalf("expected the prune to resume, got %v, err: %v", resumed, err)
+	}
+	// the anchor of the later put goes with its nodes, it is not left dangling
+	if _, err := mdb.GetAnchor(laterRoot); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the later anchor to be pruned, got %v", err)
+	}
+	if _, err := mdb.Get(RootGindex, liveRoot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerkleDB_ResumePruneAfterRestore(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB())
+	restored := randomTree(5)
+	restoredRoot := restored.MerkleRoot(hFn)
+	if _, err := src.Put(2, restored, hFn); err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if _, err := src.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb).(*merkleDB)
+	live := randomTree(5)
+	liveRoot := live.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, live, hFn); err != nil {
+		t.Fatal(err)
+	}
+	c := PruneCheckpoint{LiveRoots: []Root{liveRoot}, LastKey: mdb.metaKey(metaAnchor+1, nil)}
+	if err := ldb.Put(mdb.metaKey(metaPruneCheckpoint, nil), c.encode(), nil); err != nil {
+		t.Fatal(err)
+	}
+	mdb = New(testPrefix, ldb).(*merkleDB)
+	if _, err := mdb.Restore(bytes.NewReader(backup.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mdb.PruneCheckpoint(); err != nil || got.LastKey != nil {
+		t.Fatalf("expected the restore to clear the last key, got %v, err: %v", got, err)
+	}
+	if resumed, err := mdb.ResumePrune(); err != nil || !resumed {
+		t.Fatalf("expected the prune to resume, got %v, err: %v", resumed, err)
+	}
+	// the restored anchor goes with its nodes, it is not left dangling
+	if _, err := mdb.GetAnchor(restoredRoot); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the restored anchor to be pruned, got %v", err)
+	}
+	if _, err := mdb.Get(RootGindex, liveRoot); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,189 @@
+package merkledb
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+)
+
+// DefaultChunkSize is the size of the chunks of ExportChunks if none is given
+const DefaultChunkSize = 1 << 20
+
+// ChunkManifest describes a Backup stream that is split into fixed-size chunks, to distribute it in parts,
+// e.g. as the pieces of a torrent or with HTTP range requests. Every chunk is ChunkSize bytes, except the last.
+type ChunkManifest struct {
+	ChunkSize uint64 `json:"chunk_size"`
+	Size      uint64 `json:"size"`
+	// Chunks are the SHA-256 hashes of the chunks
+	Chunks []Root `json:"chunks"`
+	// Root commits to the chunks, to publish as the identity of the snapshot:
+	// the SHA-256 merkle root of the chunk hashes, mixed in with the size
+	Root Root `json:"root"`
+}
+
+// chunkRoot computes the root of the manifest from its chunk hashes and size
+func (m *ChunkManifest) chunkRoot() Root {
+	hFn := GetHashFn()
+	count := uint64(len(m.Chunks))
+	return hFn.Mixin(Merkleize(hFn, count, count, func(i uint64) Root {
+		return m.Chunks[i]
+	}), m.Size)
+}
+
+// Verify checks that the manifest is consistent: the number of chunks fits the size, and the root commits to the chunks.
+// Compare the root with a trusted one to trust the manifest.
+func (m *ChunkManifest) Verify() error {
+	if m.ChunkSize == 0 {
+		return errors.New("manifest has no chunk size")
+	}
+	if expected := (m.Size + m.ChunkSize - 1) / m.ChunkSize; uint64(len(m.Chunks)) != expected {
+		return fmt.Errorf("manifest of %d bytes has %d chunks, expected %d", m.Size, len(m.Chunks), expected)
+	}
+	if root := m.chunkRoot(); root != m.Root {
+		return fmt.Errorf("manifest root %s does not match its chunks: %s", m.Root, root)
+	}
+	return nil
+}
+
+// chunkLen is the expected size of the chunk at the index
+func (m *ChunkManifest) chunkLen(i int) uint64 {
+	if start := uint64(i) * m.ChunkSize; m.Size-start < m.ChunkSize {
+		return m.Size - start
+	}
+	return m.ChunkSize
+}
+
+// VerifyChunk checks a chunk against its hash in the manifest, independently of the other chunks
+func (m *ChunkManifest) VerifyChunk(i int, chunk []byte) error {
+	if i < 0 || i >= len(m.Chunks) {
+		return fmt.Errorf("chunk %d out of range, manifest has %d chunks", i, len(m.Chunks))
+	}
+	if size := m.chunkLen(i); uint64(len(chunk)) != size {
+		return fmt.Errorf("chunk %d has %d bytes, expected %d", i, len(chunk), size)
+	}
+	if h := Root(sha256.Sum256(chunk)); h != m.Chunks[i] {
+		return fmt.Errorf("chunk %d has hash %s, expected %s", i, h, m.Chunks[i])
+	}
+	return nil
+}
+
+type chunkWriter struct {
+	m   *ChunkManifest
+	buf []byte
+	fn  func(i int, chunk []byte) error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	i := len(w.m.Chunks)
+	w.m.Chunks = append(w.m.Chunks, sha256.Sum256(w.buf))
+	w.m.Size += uint64(len(w.buf))
+	err := w.fn(i, w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// ExportChunks writes a Backup of the DB in chunks of the size, DefaultChunkSize if 0, and returns their manifest.
+// The chunk is only valid during the call of fn, which has to copy it to keep it.
+func ExportChunks(db TreeReader, chunkSize int, fn func(i int, chunk []byte) error) (*ChunkManifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	m := &ChunkManifest{ChunkSize: uint64(chunkSize)}
+	w := &chunkWriter{m: m, buf: make([]byte, 0, chunkSize), fn: fn}
+	if _, err := db.Backup(w); err != nil {
+		return nil, err
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	m.Root = m.chunkRoot()
+	return m, nil
+}
+
+type fetchedChunk struct {
+	data []byte
+	err  error
+}
+
+// ImportChunks restores the backup of the chunks of the manifest, see Restore. The chunks are fetched and verified
+// by the given number of workers in parallel, 1 if less, and restored in order; at most the number of workers
+// of chunks are held in memory. The manifest is verified first, a chunk that fails to verify stops the import.
+func ImportChunks(db TreeWriter, m *ChunkManifest, workers int, fetch func(i int) ([]byte, error)) (int, error) {
+	if err := m.Verify(); err != nil {
+		return 0, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]chan fetchedChunk, len(m.Chunks))
+	for i := range results {
+		results[i] = make(chan fetchedChunk, 1)
+	}
+	// a slot is taken per fetched chunk, and released when the chunk is restored
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := range results {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int) {
+				data, err := fetch(i)
+				if err == nil {
+					err = m.VerifyChunk(i, data)
+				} else {
+					err = fmt.Errorf("failed to fetch chunk %d: %w", i, err)
+				}
+				results[i] <- fetchedChunk{data: data, err: err}
+			}(i)
+		}
+	}()
+	pr, pw := io.Pipe()
+	go func() {
+		for i := range results {
+			var c fetchedChunk
+			select {
+			case c = <-results[i]:
+			case <-done:
+				return
+			}
+			if c.err != nil {
+				_ = pw.CloseWithError(c.err)
+				return
+			}
+			if _, err := pw.Write(c.data); err != nil {
+				return
+			}
+			<-slots
+		}
+		_ = pw.Close()
+	}()
+	n, err := db.Restore(pr)
+	// stops the chunks if the restore failed first
+	_ = pr.CloseWithError(errors.New("restore ended"))
+	return n, err
+}
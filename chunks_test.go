@@ -0,0 +1,109 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestExportImportChunks(t *testing.T) {
+	hFn := GetHashFn()
+	src := New(testPrefix, newMemoryDB())
+	for i := 0; i < 3; i++ {
+		if _, err := src.Put(uint64(i), randomTree(5), hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lock sync.Mutex
+	chunks := make(map[int][]byte)
+	m, err := ExportChunks(src, 512, func(i int, chunk []byte) error {
+		chunks[i] = append([]byte(nil), chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Chunks) < 4 || len(chunks) != len(m.Chunks) || uint64(len(chunks[len(chunks)-1])) > m.ChunkSize {
+		t.Fatalf("unexpected chunks: %d of %d bytes", len(m.Chunks), m.Size)
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ChunkManifest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, m) {
+		t.Fatal("expected the same manifest after decoding")
+	}
+
+	fetched := make(map[int]int)
+	fetch := func(i int) ([]byte, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		fetched[i] += 1
+		return chunks[i], nil
+	}
+	dst := New(testPrefix, newMemoryDB())
+	if _, err := ImportChunks(dst, m, 4, fetch); err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != len(m.Chunks) {
+		t.Fatalf("expected every chunk to be fetched once, got %v", fetched)
+	}
+	expected, err := src.Anchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.Anchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("restored %v, expected %v", got, expected)
+	}
+
+	// a chunk is verified by itself, before it is restored
+	bad := append([]byte(nil), chunks[1]...)
+	bad[0] ^= 1
+	if err := m.VerifyChunk(1, bad); err == nil {
+		t.Fatal("expected a changed chunk to fail")
+	}
+	if err := m.VerifyChunk(1, chunks[1][:10]); err == nil {
+		t.Fatal("expected a short chunk to fail")
+	}
+	empty := New(testPrefix, newMemoryDB())
+	if _, err := ImportChunks(empty, m, 2, func(i int) ([]byte, error) {
+		if i == 1 {
+			return bad, nil
+		}
+		return chunks[i], nil
+	}); err == nil {
+		t.Fatal("expected a changed chunk to fail the import")
+	}
+	failing := errors.New("unavailable")
+	if _, err := ImportChunks(empty, m, 2, func(i int) ([]byte, error) {
+		return nil, failing
+	}); err == nil {
+		t.Fatal("expected a failed fetch to fail the import")
+	}
+	if anchors, err := empty.Anchors(); err != nil || len(anchors) != 0 {
+		t.Fatalf("expected no anchors of a failed import, got %v, err: %v", anchors, err)
+	}
+
+	decoded.Chunks[0][0] ^= 1
+	if err := decoded.Verify(); err == nil {
+		t.Fatal("expected a changed chunk hash to fail the root")
+	}
+	decoded.Chunks = decoded.Chunks[1:]
+	if _, err := ImportChunks(empty, &decoded, 2, fetch); err == nil {
+		t.Fatal("expected a manifest with missing chunks to fail")
+	}
+}
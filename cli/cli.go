@@ -0,0 +1,351 @@
+// Package cli implements the merkledb command-line tool.
+//
+// The SSZ types that the tool can work with are not part of merkledb:
+// tooling that knows the types (e.g. beacon states of each fork) runs the tool with its own type registry.
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/protolambda/merkledb"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// Types maps type names, as used on the command line, to SSZ types
+type Types map[string]view.TypeDef
+
+func (t Types) names() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+const usage = `usage: merkledb <command> [flags]
+
+commands:
+  backup    write the trees, anchors, refs and pins of a prefix to a file
+  decode    decode a raw node key and value
+  dump      print the decoded records of a prefix
+  import    import a SSZ file into the database
+  ingest    put the trees of a stream of node records, from stdin, a file or a unix socket
+  reprefix  move all keys of one prefix to another prefix
+  restore   write the records of a backup file into the database
+`
+
+// Run the tool with the given arguments, excluding the program name.
+func Run(args []string, types Types, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "backup":
+		return runBackup(args[1:], out)
+	case "restore":
+		return runRestore(args[1:], out)
+	case "decode":
+		return runDecode(args[1:], out)
+	case "dump":
+		return runDump(args[1:], out)
+	case "import":
+		return runImport(args[1:], types, out)
+	case "ingest":
+		return runIngest(args[1:], out)
+	case "reprefix":
+		return runReprefix(args[1:], out)
+	default:
+		return fmt.Errorf("unknown command '%s'\n%s", args[0], usage)
+	}
+}
+
+func parsePrefix(v string) (out [3]byte, err error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+	if err != nil {
+		return out, fmt.Errorf("bad prefix: %v", err)
+	}
+	if len(b) != len(out) {
+		return out, fmt.Errorf("prefix must be %d bytes, got %d", len(out), len(b))
+	}
+	copy(out[:], b)
+	return out, nil
+}
+
+func runImport(args []string, types Types, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	prefixHex := flags.String("prefix", "000000", "hex-encoded 3-byte key prefix")
+	typeName := flags.String("type", "", "name of the SSZ type of the input")
+	slot := flags.Uint64("slot", 0, "slot to store the tree at")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || flags.NArg() != 1 {
+		return errors.New("usage: merkledb import -db <path> -type <name> [-prefix <hex>] [-slot <slot>] <file.ssz>")
+	}
+	typ, ok := types[*typeName]
+	if !ok {
+		return fmt.Errorf("unknown type '%s', known types: %s", *typeName, types.names())
+	}
+	prefix, err := parsePrefix(*prefixHex)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	db := merkledb.New(prefix, ldb)
+	defer db.Close()
+	root, report, err := merkledb.ImportSSZ(db, *slot, typ, f, GetHashFn())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "imported %s: %d new nodes, %d reused, %d bytes\n", root, report.NewNodes, report.ReusedNodes, report.BytesWritten)
+	return err
+}
+
+// runIngest answers the trees of stdin or the file on out, and those of a socket on their connection.
+// A socket is served until the tool is interrupted.
+func runIngest(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	prefixHex := flags.String("prefix", "000000", "hex-encoded 3-byte key prefix")
+	socket := flags.String("socket", "", "path of a unix socket to accept producers on, instead of reading a stream")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || flags.NArg() > 1 || (*socket != "" && flags.NArg() != 0) {
+		return errors.New("usage: merkledb ingest -db <path> [-prefix <hex>] [-socket <path> | <file>]")
+	}
+	prefix, err := parsePrefix(*prefixHex)
+	if err != nil {
+		return err
+	}
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	db := merkledb.New(prefix, ldb)
+	defer db.Close()
+	if *socket != "" {
+		l, err := net.Listen("unix", *socket)
+		if err != nil {
+			return err
+		}
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		go func() {
+			<-interrupt
+			_ = l.Close()
+		}()
+		return merkledb.ServeIngest(l, db, GetHashFn())
+	}
+	in := io.Reader(os.Stdin)
+	if flags.NArg() == 1 && flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	_, err = merkledb.Ingest(db, in, out, GetHashFn())
+	return err
+}
+
+func runReprefix(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("reprefix", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	fromHex := flags.String("from", "", "hex-encoded 3-byte prefix to move keys from")
+	toHex := flags.String("to", "", "hex-encoded 3-byte prefix to move keys to")
+	batchSize := flags.Int("batch", merkledb.DefaultReprefixBatchSize, "number of keys to move per batch")
+	resume := flags.Bool("resume", false, "continue an interrupted move, allowing existing keys under the destination prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || *fromHex == "" || *toHex == "" || flags.NArg() != 0 {
+		return errors.New("usage: merkledb reprefix -db <path> -from <hex> -to <hex> [-batch <size>] [-resume]")
+	}
+	from, err := parsePrefix(*fromHex)
+	if err != nil {
+		return err
+	}
+	to, err := parsePrefix(*toHex)
+	if err != nil {
+		return err
+	}
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	moved, err := merkledb.Reprefix(ldb, from, to, merkledb.ReprefixOptions{
+		BatchSize: *batchSize,
+		Progress: func(moved int) {
+			_, _ = fmt.Fprintf(out, "moved %d keys\n", moved)
+		},
+		Resume: *resume,
+	})
+	if err != nil {
+		return fmt.Errorf("reprefix stopped after %d keys: %v", moved, err)
+	}
+	_, err = fmt.Fprintf(out, "done, moved %d keys from %x to %x\n", moved, from, to)
+	return err
+}
+
+func runDump(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	prefixHex := flags.String("prefix", "000000", "hex-encoded 3-byte key prefix")
+	gindex := flags.Uint64("gindex", 0, "only dump the nodes at this gindex, all records if 0")
+	limit := flags.Int("limit", 0, "maximum number of records to dump, unbounded if 0")
+	raw := flags.Bool("raw", false, "add the hex-encoded key and value to every record")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || flags.NArg() != 0 {
+		return errors.New("usage: merkledb dump -db <path> [-prefix <hex>] [-gindex <gindex>] [-limit <n>] [-raw]")
+	}
+	prefix, err := parsePrefix(*prefixHex)
+	if err != nil {
+		return err
+	}
+	opts := merkledb.DumpOptions{Limit: *limit, Raw: *raw}
+	if *gindex != 0 {
+		opts.Gindex = Gindex64(*gindex)
+	}
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	_, err = merkledb.Dump(ldb, prefix, out, opts)
+	return err
+}
+
+func runDecode(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("decode", flag.ContinueOnError)
+	keyHex := flags.String("key", "", "hex-encoded node key")
+	valueHex := flags.String("value", "", "hex-encoded node value, optional")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyHex == "" || flags.NArg() != 0 {
+		return errors.New("usage: merkledb decode -key <hex> [-value <hex>]")
+	}
+	key, err := hex.DecodeString(strings.TrimPrefix(*keyHex, "0x"))
+	if err != nil {
+		return fmt.Errorf("bad key: %v", err)
+	}
+	k, err := merkledb.ParseNodeKey(key)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "prefix=%x gindex=%d bits=%d root=%s\n", k.Prefix, k.Gindex, k.BitLen, k.Root); err != nil {
+		return err
+	}
+	if *valueHex == "" {
+		return nil
+	}
+	value, err := hex.DecodeString(strings.TrimPrefix(*valueHex, "0x"))
+	if err != nil {
+		return fmt.Errorf("bad value: %v", err)
+	}
+	rec, err := merkledb.ParseNodeValue(value)
+	if err != nil {
+		return err
+	}
+	if rec.Pair {
+		_, err = fmt.Fprintf(out, "type=pair slot=%d left=%s right=%s\n", rec.Slot, rec.Left, rec.Right)
+	} else {
+		_, err = fmt.Fprintf(out, "type=leaf slot=%d\n", rec.Slot)
+	}
+	return err
+}
+
+func runBackup(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	prefixHex := flags.String("prefix", "000000", "hex-encoded 3-byte key prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || flags.NArg() != 1 {
+		return errors.New("usage: merkledb backup -db <path> [-prefix <hex>] <file>")
+	}
+	prefix, err := parsePrefix(*prefixHex)
+	if err != nil {
+		return err
+	}
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	db := merkledb.New(prefix, ldb)
+	defer db.Close()
+	f, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	n, err := db.Backup(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "backed up %d records\n", n)
+	return err
+}
+
+func runRestore(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dbPath := flags.String("db", "", "path of the leveldb database")
+	prefixHex := flags.String("prefix", "000000", "hex-encoded 3-byte key prefix to restore under")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dbPath == "" || flags.NArg() != 1 {
+		return errors.New("usage: merkledb restore -db <path> [-prefix <hex>] <file>")
+	}
+	prefix, err := parsePrefix(*prefixHex)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ldb, err := leveldb.OpenFile(*dbPath, merkledb.RecommendedLevelDBOptions())
+	if err != nil {
+		return err
+	}
+	db := merkledb.New(prefix, ldb)
+	defer db.Close()
+	n, err := db.Restore(f)
+	if err != nil {
+		return fmt.Errorf("restore stopped after %d records: %v", n, err)
+	}
+	_, err = fmt.Fprintf(out, "restored %d records\n", n)
+	return err
+}
@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/protolambda/merkledb"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeNumbers(t *testing.T, dir string) (Types, string) {
+	typ := view.BasicListType(view.Uint64Type, 64)
+	v := typ.New()
+	for i := uint64(0); i < 10; i++ {
+		if err := v.Append(view.Uint64View(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var data bytes.Buffer
+	if err := v.Serialize(codec.NewEncodingWriter(&data)); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "list.ssz")
+	if err := os.WriteFile(input, data.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Types{"numbers": typ}, input
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	types, input := writeNumbers(t, dir)
+	var out bytes.Buffer
+	args := []string{"import", "-db", filepath.Join(dir, "db"), "-type", "numbers", "-slot", "3", input}
+	if err := Run(args, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "imported ") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	if err := Run([]string{"import", "-db", filepath.Join(dir, "db"), "-type", "other", input}, types, &out); err == nil {
+		t.Fatal("expected unknown type error")
+	}
+}
+
+func TestReprefix(t *testing.T) {
+	dir := t.TempDir()
+	types, input := writeNumbers(t, dir)
+	dbPath := filepath.Join(dir, "db")
+	var out bytes.Buffer
+	if err := Run([]string{"import", "-db", dbPath, "-type", "numbers", input}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Run([]string{"reprefix", "-db", dbPath, "-from", "000000", "-to", "0a0b0c"}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "done, moved ") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	if err := Run([]string{"import", "-db", dbPath, "-prefix", "0a0b0c", "-type", "numbers", input}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), " 0 reused") {
+		t.Fatalf("expected moved nodes to be reused: %s", out.String())
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	types, input := writeNumbers(t, dir)
+	dbPath := filepath.Join(dir, "db")
+	var out bytes.Buffer
+	if err := Run([]string{"import", "-db", dbPath, "-type", "numbers", input}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Run([]string{"dump", "-db", dbPath, "-gindex", "1", "-raw"}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 1 ||
+		!strings.HasPrefix(lines[0], "node gindex=1 bits=1 ") || !strings.Contains(lines[0], " key=") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"dump", "-db", dbPath}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "anchor root=") {
+		t.Fatalf("expected the anchor in the dump: %s", out.String())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var out bytes.Buffer
+	key := "000000" + "0100" + "80" + strings.Repeat("ab", 32)
+	value := "00" + "0500000000000000"
+	if err := Run([]string{"decode", "-key", key, "-value", value}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "prefix=000000 gindex=1 bits=1 root=0x" + strings.Repeat("ab", 32) + "\ntype=leaf slot=5\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	if err := Run([]string{"decode", "-key", "000000"}, nil, &out); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	types, input := writeNumbers(t, dir)
+	dbPath := filepath.Join(dir, "db")
+	backup := filepath.Join(dir, "backup")
+	var out bytes.Buffer
+	if err := Run([]string{"import", "-db", dbPath, "-type", "numbers", input}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Run([]string{"backup", "-db", dbPath, backup}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	backedUp := out.String()
+	out.Reset()
+	restored := filepath.Join(dir, "restored")
+	if err := Run([]string{"restore", "-db", restored, "-prefix", "0a0b0c", backup}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimPrefix(backedUp, "backed up ") != strings.TrimPrefix(out.String(), "restored ") {
+		t.Fatalf("expected all records to be restored: %s, %s", backedUp, out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"import", "-db", restored, "-prefix", "0a0b0c", "-type", "numbers", input}, types, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), " 0 reused") {
+		t.Fatalf("expected the restored nodes to be reused: %s", out.String())
+	}
+}
+
+func TestIngest(t *testing.T) {
+	dir := t.TempDir()
+	left, right := Root{1}, Root{2}
+	root := GetHashFn()(left, right)
+	var in bytes.Buffer
+	frame := func(kind byte, gindex uint64, roots ...Root) {
+		payload := []byte{kind}
+		if kind == merkledb.IngestTree {
+			payload = append(payload, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(payload[1:], 5)
+		} else if kind != merkledb.IngestEnd {
+			payload = append(payload, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(payload[1:], gindex)
+		}
+		for _, r := range roots {
+			payload = append(payload, r[:]...)
+		}
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+		in.Write(append(size[:], payload...))
+	}
+	frame(merkledb.IngestTree, 0, root)
+	frame(merkledb.IngestPair, 1, root, left, right)
+	frame(merkledb.IngestLeaf, 2, left)
+	frame(merkledb.IngestLeaf, 3, right)
+	frame(merkledb.IngestEnd, 0)
+	input := filepath.Join(dir, "trees")
+	if err := os.WriteFile(input, in.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Run([]string{"ingest", "-db", filepath.Join(dir, "db"), input}, Types{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	answer := out.Bytes()
+	if len(answer) != 4+1+32+8+8 || answer[4] != merkledb.IngestResult || !bytes.Equal(answer[5:37], root[:]) {
+		t.Fatalf("unexpected answer: %x", answer)
+	}
+	if nodes := binary.LittleEndian.Uint64(answer[37:45]); nodes != 3 {
+		t.Fatalf("expected 3 new nodes, got %d", nodes)
+	}
+}
@@ -0,0 +1,12 @@
+// Command libmerkledb is the stock C shared library of merkledb, see package capi:
+//
+//	go build -buildmode=c-shared -o libmerkledb.so ./cmd/libmerkledb
+package main
+
+import (
+	_ "github.com/protolambda/merkledb/capi"
+)
+
+// the stock library knows no SSZ types, it puts trees from their nodes with merkledb_ingest.
+// Tooling with types of its own builds a library that registers them, for merkledb_put_ssz.
+func main() {}
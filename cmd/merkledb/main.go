@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	"github.com/protolambda/merkledb/cli"
+	"os"
+)
+
+func main() {
+	// the stock tool knows no SSZ types, tooling with types of its own can call cli.Run with them.
+	if err := cli.Run(os.Args[1:], cli.Types{}, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package merkledb
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"math/bits"
+	"sort"
+)
+
+// ExtractColumn gets the root of the node at the gindex in the tree of every anchor, in the order of the anchors,
+// e.g. the chunk with the balance of a validator across many slots. Basic values are packed in the chunks.
+// The trees are walked down together, a level at a time: the nodes of a level are read once, in key order,
+// so the reads of nodes that the trees share are not repeated.
+func ExtractColumn(db TreeReader, anchors []Root, gindex Gindex) ([]Root, error) {
+	target, err := gindexValue(gindex)
+	if err != nil {
+		return nil, err
+	}
+	depth := uint32(bits.Len64(target)) - 1
+	column := append([]Root(nil), anchors...)
+	var rec PairRecord
+	for d := uint32(0); d <= depth; d++ {
+		g := target >> (depth - d)
+		unique := make([]Root, 0, len(column))
+		next := make(map[Root]Root, len(column))
+		for _, root := range column {
+			if _, ok := next[root]; !ok {
+				next[root] = Root{}
+				unique = append(unique, root)
+			}
+		}
+		sort.Slice(unique, func(i, j int) bool {
+			return bytes.Compare(unique[i][:], unique[j][:]) < 0
+		})
+		for _, root := range unique {
+			if err := db.GetInto(Gindex64(g), root, &rec); err != nil {
+				return nil, fmt.Errorf("failed to get node at gindex %d with root %s: %w", g, root, err)
+			}
+			if d == depth {
+				continue
+			}
+			if !rec.Pair {
+				return nil, fmt.Errorf("node at gindex %d with root %s is a leaf: %w", g, root, leafError(&rec, Gindex64(g), root))
+			}
+			if (target>>(depth-d-1))&1 == 0 {
+				next[root] = rec.Left
+			} else {
+				next[root] = rec.Right
+			}
+		}
+		if d == depth {
+			break
+		}
+		for i, root := range column {
+			column[i] = next[root]
+		}
+	}
+	return column, nil
+}
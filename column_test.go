@@ -0,0 +1,63 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+type recordCountingReader struct {
+	TreeReader
+	reads int
+}
+
+func (r *recordCountingReader) GetInto(gindex Gindex, key Root, dst *PairRecord) error {
+	r.reads += 1
+	return r.TreeReader.GetInto(gindex, key, dst)
+}
+
+func TestExtractColumn(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	var anchors []Root
+	for slot := uint64(1); slot <= 3; slot++ {
+		state := testState(t, slot, 10)
+		if _, err := mdb.Put(slot, state.Backing(), hFn); err != nil {
+			t.Fatal(err)
+		}
+		anchors = append(anchors, state.HashTreeRoot(hFn))
+	}
+	// the balance of validator 5 is the second value of the second chunk
+	g, _, err := ResolvePath(testStateType, "balances", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	column, err := ExtractColumn(mdb, anchors, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(column) != len(anchors) {
+		t.Fatalf("expected %d values, got %d", len(anchors), len(column))
+	}
+	for i, chunk := range column {
+		if v := binary.LittleEndian.Uint64(chunk[8:16]); v != 31_000_000_005 {
+			t.Fatalf("unexpected balance of anchor %d: %d", i, v)
+		}
+	}
+
+	// the nodes of a repeated anchor are read once
+	r := &recordCountingReader{TreeReader: mdb}
+	depth := int(g.Depth())
+	if _, err := ExtractColumn(r, []Root{anchors[0], anchors[0], anchors[0]}, g); err != nil {
+		t.Fatal(err)
+	}
+	if r.reads != depth+1 {
+		t.Fatalf("expected %d reads, got %d", depth+1, r.reads)
+	}
+
+	// the genesis time is a leaf: navigating below it fails
+	if _, err := ExtractColumn(mdb, anchors, Gindex64(8)); !errors.Is(err, NavigationError) {
+		t.Fatalf("expected a navigation error, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/protolambda/merkledb/arrowfile"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"io"
+)
+
+// LeafColumn is a column of ExportColumns: a value in the chunk at the gindex of every exported tree
+type LeafColumn struct {
+	// Name is the header of the column
+	Name   string
+	Gindex Gindex
+	// Offset and Size are the bytes of the value within the chunk. Values of 1, 2, 4 and 8 bytes
+	// are exported as unsigned integers, other values as fixed-size binary.
+	Offset uint64
+	Size   uint64
+}
+
+// PathColumn resolves the path into a column of the value at the path, see ResolvePath.
+// Packed basic elements get the bytes of the element within the chunk. Bits are not supported.
+func PathColumn(name string, typ view.TypeDef, path ...interface{}) (LeafColumn, error) {
+	g, elem, err := ResolvePath(typ, path...)
+	if err != nil {
+		return LeafColumn{}, err
+	}
+	col := LeafColumn{Name: name, Gindex: g, Size: 32}
+	if len(path) > 0 {
+		parent := typ
+		if len(path) > 1 {
+			if _, parent, err = ResolvePath(typ, path[:len(path)-1]...); err != nil {
+				return LeafColumn{}, err
+			}
+		}
+		switch parent.(type) {
+		case *view.BasicListTypeDef, *view.BasicVectorTypeDef:
+			index, err := pathIndex(path[len(path)-1])
+			if err != nil {
+				return LeafColumn{}, err
+			}
+			col.Size = elem.TypeByteLength()
+			col.Offset = (index % basicElemsPerChunk(elem)) * col.Size
+			return col, nil
+		case *view.BitListTypeDef, *view.BitVectorTypeDef:
+			return LeafColumn{}, fmt.Errorf("column %s: bits are not supported", name)
+		}
+	}
+	if _, ok := elem.(view.BasicTypeDef); ok {
+		col.Size = elem.TypeByteLength()
+	}
+	return col, nil
+}
+
+func (c *LeafColumn) value(chunk *Root) ([]byte, error) {
+	if c.Size == 0 || c.Offset+c.Size > 32 {
+		return nil, fmt.Errorf("column %s: %d bytes at offset %d do not fit in a chunk", c.Name, c.Size, c.Offset)
+	}
+	return chunk[c.Offset : c.Offset+c.Size], nil
+}
+
+// ExportColumns writes the histories of the columns across the trees of the anchors as an Arrow IPC file,
+// one row per anchor, in the order of the anchors, with the anchor root and slot first. The columns are read
+// with ExtractColumn. The file is read by pyarrow, polars, DuckDB and the Arrow libraries, which convert it to Parquet.
+// It returns the number of written rows.
+func ExportColumns(db TreeReader, w io.Writer, anchors []Root, columns []LeafColumn) (int, error) {
+	out := make([]arrowfile.Column, 2+len(columns))
+	out[0] = arrowfile.Column{Name: "anchor", Width: 32, Values: make([]byte, 0, 32*len(anchors))}
+	out[1] = arrowfile.Column{Name: "slot", Width: 8, Uint: true, Values: make([]byte, 0, 8*len(anchors))}
+	for i := range columns {
+		c := &columns[i]
+		column, err := ExtractColumn(db, anchors, c.Gindex)
+		if err != nil {
+			return 0, fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		col := arrowfile.Column{Name: c.Name, Width: int(c.Size), Values: make([]byte, 0, int(c.Size)*len(anchors))}
+		col.Uint = c.Size == 1 || c.Size == 2 || c.Size == 4 || c.Size == 8
+		for j := range column {
+			v, err := c.value(&column[j])
+			if err != nil {
+				return 0, err
+			}
+			col.Values = append(col.Values, v...)
+		}
+		out[2+i] = col
+	}
+	var slot [8]byte
+	for _, root := range anchors {
+		a, err := db.GetAnchor(root)
+		if err != nil {
+			return 0, fmt.Errorf("anchor %s: %w", root, err)
+		}
+		out[0].Values = append(out[0].Values, root[:]...)
+		binary.LittleEndian.PutUint64(slot[:], a.Slot)
+		out[1].Values = append(out[1].Values, slot[:]...)
+	}
+	if err := arrowfile.Write(w, len(anchors), out); err != nil {
+		return 0, err
+	}
+	return len(anchors), nil
+}
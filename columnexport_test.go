@@ -0,0 +1,73 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/protolambda/merkledb/arrowfile"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestExportColumns(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	var anchors []Root
+	for slot := uint64(10); slot < 13; slot++ {
+		state := testState(t, slot, 8)
+		if _, err := mdb.Put(slot, state.Backing(), hFn); err != nil {
+			t.Fatal(err)
+		}
+		anchors = append(anchors, state.HashTreeRoot(hFn))
+	}
+	var columns []LeafColumn
+	for _, path := range [][]interface{}{
+		{"slot"},
+		{"balances", 5},
+		{"validators", 3, "effective_balance"},
+		{"validators", 3, "pubkey"},
+	} {
+		c, err := PathColumn(fmt.Sprint(path...), testStateType, path...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, c)
+	}
+	if c := columns[1]; c.Offset != 8 || c.Size != 8 {
+		t.Fatalf("unexpected packed column: %+v", c)
+	}
+	var out bytes.Buffer
+	n, err := ExportColumns(mdb, &out, anchors, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(anchors) {
+		t.Fatalf("expected %d rows, got %d", len(anchors), n)
+	}
+	rows, read, err := arrowfile.Read(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != len(anchors) || len(read) != 2+len(columns) || read[0].Name != "anchor" || read[1].Name != "slot" || read[3].Name != columns[1].Name {
+		t.Fatalf("unexpected schema: %d rows, %+v", rows, read)
+	}
+	if !read[1].Uint || !read[3].Uint || read[5].Uint || read[5].Width != 32 {
+		t.Fatalf("expected integer columns, and a binary pubkey column: %+v", read)
+	}
+	uint64At := func(c arrowfile.Column, i int) uint64 {
+		return binary.LittleEndian.Uint64(c.Values[8*i:])
+	}
+	for i := range anchors {
+		slot := uint64(10 + i)
+		if !bytes.Equal(read[0].Values[32*i:32*(i+1)], anchors[i][:]) || uint64At(read[1], i) != slot || uint64At(read[2], i) != slot {
+			t.Fatalf("unexpected anchor or slot in row %d", i)
+		}
+		if uint64At(read[3], i) != 31000000005 || uint64At(read[4], i) != 32000000003 {
+			t.Fatalf("unexpected values in row %d", i)
+		}
+	}
+
+	if _, err := PathColumn("slashed", testStateType, "validators", 0, "slashed"); err != nil {
+		t.Fatalf("a bool field is a basic value: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// CompletenessReport lists the nodes of a tree that are referenced by a stored parent, but not stored themselves.
+// These are either pruned, or lost to corruption.
+type CompletenessReport struct {
+	// Stored is the number of nodes of the tree that are stored
+	Stored int
+	// Missing nodes, as referenced by their parent
+	Missing []NodeRef
+	// Summarized nodes are stored as summaries by a partial put: their root is known, their subtree is not stored
+	Summarized []NodeRef
+}
+
+// Complete is true if no nodes are missing or summarized
+func (r *CompletenessReport) Complete() bool {
+	return len(r.Missing) == 0 && len(r.Summarized) == 0
+}
+
+func (db *merkleDB) Completeness(anchor Root) (report *CompletenessReport, err error) {
+	err = db.consistent(func(view *merkleDB) error {
+		report, err = view.completeness(anchor)
+		return err
+	})
+	return report, err
+}
+
+func (db *merkleDB) completeness(anchor Root) (*CompletenessReport, error) {
+	report := new(CompletenessReport)
+	stack := []NodeRef{{Gindex: RootGindex, Root: anchor}}
+	var rec PairRecord
+	for len(stack) > 0 {
+		ref := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err := db.getLocal(ref.Gindex, ref.Root, &rec); err == leveldb.ErrNotFound {
+			report.Missing = append(report.Missing, ref)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if rec.Summary {
+			report.Summarized = append(report.Summarized, ref)
+			continue
+		}
+		report.Stored += 1
+		if rec.Pair {
+			stack = append(stack,
+				NodeRef{Gindex: ref.Gindex.Right(), Root: rec.Right},
+				NodeRef{Gindex: ref.Gindex.Left(), Root: rec.Left})
+		}
+	}
+	return report, nil
+}
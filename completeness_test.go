@@ -0,0 +1,42 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Completeness(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := randomTree(10)
+	anchor := foo.MerkleRoot(hFn)
+	report, err := mdb.Put(1, foo, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := mdb.Completeness(anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Complete() || c.Stored != report.NewNodes {
+		t.Fatalf("expected complete tree of %d nodes: %+v", report.NewNodes, c)
+	}
+	n, gi := randomNode(foo, RootGindex, 5)
+	if gi == RootGindex {
+		left, _ := foo.Left()
+		n, gi = left, LeftGindex
+	}
+	if err := mdb.Delete(gi, n.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	c, err = mdb.Completeness(anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Complete() || len(c.Missing) != 1 {
+		t.Fatalf("expected 1 missing node: %+v", c)
+	}
+	if c.Missing[0].Gindex != gi || c.Missing[0].Root != n.MerkleRoot(hFn) {
+		t.Fatalf("unexpected missing node: %v", c.Missing[0])
+	}
+}
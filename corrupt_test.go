@@ -0,0 +1,239 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corruptTestDB fills a DB with every kind of record that reads decode
+func corruptTestDB(t *testing.T) (*leveldb.DB, []Option, []Root, []Node) {
+	opts := []Option{WithAuditLog(), WithIndex(testBalancesIndex), WithDeferredDeletes(0),
+		WithSSZStorage(SSZSubtree{Gindex: RootGindex, Type: testStateType})}
+	ldb := newMemoryDB()
+	mdb, err := Open(testPrefix, ldb, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hFn := GetHashFn()
+	var roots []Root
+	var trees []Node
+	for i := uint64(1); i <= 3; i++ {
+		state := testState(t, i, 4+i)
+		var putOpts []PutOption
+		if i > 1 {
+			putOpts = append(putOpts, WithParent(roots[i-2]), WithProvenance(*randomRoot()))
+		}
+		putOpts = append(putOpts, WithTags("t"), WithIdempotencyKey(fmt.Sprintf("put-%d", i)))
+		if _, err := mdb.Put(i, state.Backing(), hFn, putOpts...); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, state.HashTreeRoot(hFn))
+		trees = append(trees, state.Backing())
+	}
+	for _, err := range []error{
+		mdb.SetCanonical(roots[1], true),
+		mdb.SetRef(HeadRef, roots[1]),
+		mdb.Pin(roots[1]),
+		mdb.Hide(roots[2]),
+		mdb.PutBlob(roots[1], []byte("blob")),
+		mdb.Prune([]Root{roots[1]}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ldb, opts, roots, trees
+}
+
+// corruptChecks are the reads and writes that decode stored records
+func corruptChecks(mdb MerkleDB, ldb *leveldb.DB, roots []Root, trees []Node) map[string]func() {
+	hFn := GetHashFn()
+	checks := map[string]func(){
+		"Anchors":         func() { mdb.Anchors() },
+		"Refs":            func() { mdb.Refs() },
+		"GetRef":          func() { mdb.GetRef(HeadRef) },
+		"Pins":            func() { mdb.Pins() },
+		"Hidden":          func() { mdb.Hidden() },
+		"Repairs":         func() { mdb.Repairs() },
+		"AuditLog":        func() { mdb.AuditLog(0, 0) },
+		"ExportAuditLog":  func() { mdb.ExportAuditLog(ioutil.Discard) },
+		"IdempotentPut":   func() { mdb.IdempotentPut("put-2") },
+		"Backup":          func() { mdb.Backup(ioutil.Discard) },
+		"Usage":           func() { mdb.Usage() },
+		"Health":          func() { mdb.Health() },
+		"PrunePlan":       func() { mdb.PrunePlan(roots[:1]) },
+		"FindOrphans":     func() { mdb.FindOrphans() },
+		"CheckSlots":      func() { mdb.CheckSlots() },
+		"PruneCheckpoint": func() { mdb.PruneCheckpoint() },
+		"FilterAnchors":   func() { mdb.FilterAnchors(CanonicalOnly) },
+		"Range":           func() { mdb.Range(0, 10, Gindex64(5)) },
+		"GetAllAtSlot":    func() { mdb.GetAllAtSlot(2, RootGindex) },
+		"CanonicalRange":  func() { mdb.CanonicalRange(0, 10, RootGindex) },
+		"TaggedAnchors":   func() { mdb.TaggedAnchors("t") },
+		"TaggedRange":     func() { mdb.TaggedRange(0, 10, RootGindex, "t") },
+		"Dump":            func() { Dump(ldb, testPrefix, ioutil.Discard, DumpOptions{}) },
+		"ReadMetadata":    func() { ReadMetadata(ldb, testPrefix) },
+		"ReadLease":       func() { ReadLease(ldb, testPrefix) },
+		"Open":            func() { Open(testPrefix, ldb) },
+		"Expire":          func() { mdb.Expire() },
+		"Reclaim":         func() { mdb.Reclaim() },
+		"Prune":           func() { mdb.Prune(roots[1:2]) },
+		"ForgetKeys":      func() { mdb.ForgetIdempotencyKeys(time.Now()) },
+	}
+	for i, root := range roots {
+		root := root
+		for name, fn := range map[string]func(){
+			"Get":               func() { mdb.Get(RootGindex, root) },
+			"Walk":              func() { mdb.Walk(root, DepthFirst, func(StreamNode) error { return nil }) },
+			"Completeness":      func() { mdb.Completeness(root) },
+			"Prove":             func() { mdb.Prove(root, Gindex64(5)) },
+			"ProveMulti":        func() { mdb.ProveMulti(root, []Gindex{Gindex64(5), Gindex64(28)}) },
+			"ProveRange":        func() { mdb.ProveRange(root, Gindex64(14), 8, 0, 4) },
+			"Preload":           func() { mdb.Preload(root, 4) },
+			"GetAnchor":         func() { mdb.GetAnchor(root) },
+			"GetBlob":           func() { mdb.GetBlob(root) },
+			"GetSSZ":            func() { mdb.GetSSZ(root, RootGindex) },
+			"IndexLookup":       func() { mdb.IndexLookup(root, "balances", Uint64Key(7)) },
+			"IndexRange":        func() { mdb.IndexRange(root, "balances", nil, nil) },
+			"Ancestry":          func() { mdb.Ancestry(root, 10) },
+			"ProvenanceAnchors": func() { mdb.ProvenanceAnchors(root) },
+			"Tags":              func() { mdb.Tags(root) },
+			"Put":               func() { mdb.Put(uint64(i+1), trees[i], hFn) },
+		} {
+			checks[fmt.Sprintf("%s(%d)", name, i)] = fn
+		}
+	}
+	return checks
+}
+
+// corruptValues are the truncated and changed versions of a stored value
+func corruptValues(v []byte) [][]byte {
+	out := [][]byte{nil, append(append([]byte(nil), v...), 0)}
+	if len(v) > 0 {
+		flipped := append([]byte(nil), v...)
+		flipped[0] ^= 0xff
+		out = append(out, v[:1], v[:len(v)/2], v[:len(v)-1], flipped)
+	}
+	return out
+}
+
+// corruptKeys are the truncated and changed versions of a stored key, under the same prefix and metadata kind
+func corruptKeys(k []byte) [][]byte {
+	var out [][]byte
+	min := prefixLen
+	if _, ok := metaKind(k); ok {
+		min = metaKeyLen
+	}
+	for _, n := range []int{min, min + 1, (min + len(k)) / 2, len(k) - 1} {
+		if n >= min && n < len(k) {
+			out = append(out, k[:n:n])
+		}
+	}
+	for _, i := range []int{min, min + 1, len(k) - 33, len(k) - 1} {
+		if i >= min && i < len(k) {
+			changed := append([]byte(nil), k...)
+			changed[i] ^= 0xff
+			out = append(out, changed)
+		}
+	}
+	return out
+}
+
+func TestCorruptRecordsDoNotPanic(t *testing.T) {
+	src, opts, roots, trees := corruptTestDB(t)
+	var keys, values [][]byte
+	iter := src.NewIterator(nil, nil)
+	for iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+		values = append(values, append([]byte(nil), iter.Value()...))
+	}
+	iter.Release()
+	type corruption struct{ key, value []byte }
+	for i, key := range keys {
+		// the node records all decode the same, a sample of them is enough
+		if _, meta := metaKind(key); !meta && i%16 != 0 {
+			continue
+		}
+		var corruptions []corruption
+		for _, v := range corruptValues(values[i]) {
+			corruptions = append(corruptions, corruption{key, v})
+		}
+		for _, k := range corruptKeys(key) {
+			corruptions = append(corruptions, corruption{k, values[i]})
+		}
+		for _, c := range corruptions {
+			ldb := newMemoryDB()
+			b := new(leveldb.Batch)
+			for j := range keys {
+				if j != i {
+					b.Put(keys[j], values[j])
+				}
+			}
+			b.Put(c.key, c.value)
+			if err := ldb.Write(b, nil); err != nil {
+				t.Fatal(err)
+			}
+			mdb := New(testPrefix, ldb, opts...)
+			for name, fn := range corruptChecks(mdb, ldb, roots, trees) {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s panics on %s as '%x' '%x': %v", name, dumpRecord(key, values[i]), c.key, c.value, r)
+						}
+					}()
+					fn()
+				}()
+			}
+			mdb.Close()
+		}
+	}
+}
+
+func TestCorruptValueError(t *testing.T) {
+	ldb, opts, roots, _ := corruptTestDB(t)
+	mdb := New(testPrefix, ldb, opts...).(*merkleDB)
+	for _, kind := range []byte{metaAnchor, metaTags} {
+		if err := ldb.Put(mdb.metaKey(kind, roots[1][:]), []byte{0xff}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ldb.Put(mdb.metaKey(metaRef, []byte(HeadRef)), []byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, fn := range map[string]func() error{
+		"GetAnchor": func() error { _, err := mdb.GetAnchor(roots[1]); return err },
+		"Anchors":   func() error { _, err := mdb.Anchors(); return err },
+		"Tags":      func() error { _, err := mdb.Tags(roots[1]); return err },
+		"GetRef":    func() error { _, err := mdb.GetRef(HeadRef); return err },
+	} {
+		err := fn()
+		var corrupt *CorruptValueError
+		if !errors.Is(err, ErrCorruptValue) || !errors.As(err, &corrupt) || corrupt.Kind == 0 {
+			t.Fatalf("%s: expected a corrupt metadata record, got %v", name, err)
+		}
+	}
+
+	// a pair at the deepest 64 bit gindex cannot have children
+	deep := Gindex64(1<<63 | 5)
+	var buf [maxKeyLen]byte
+	k, err := mdb.buildKey(&buf, deep, roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Put(k, encodeValue(&PairRecord{Pair: true}), nil); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *CorruptValueError
+	if _, err := mdb.Get(deep, roots[0]); !errors.As(err, &corrupt) || corrupt.Gindex != deep || corrupt.Root != roots[0] {
+		t.Fatalf("expected a corrupt node, got %v", err)
+	}
+	if !strings.Contains(corrupt.Error(), "at gindex") {
+		t.Fatalf("expected the gindex in the error: %v", corrupt)
+	}
+}
@@ -2,29 +2,264 @@ package merkledb
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"github.com/protolambda/merkledb/lite"
 	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
 	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// InsertReport describes what a Put added to the DB
+type InsertReport struct {
+	// NewNodes is the number of nodes that were written
+	NewNodes int
+	// ReusedNodes is the number of subtrees that were already stored, and shared instead of written
+	ReusedNodes int
+	// BytesWritten is the size of the write batch
+	BytesWritten int
+	// MaxDepth is the depth of the deepest node that was written
+	MaxDepth uint32
+	// Replayed is true if the idempotency key of the put was used before, and nothing was written
+	Replayed bool
+	// Keys is the number of puts and deletes in the write batch, of nodes and metadata
+	Keys int
+	// HashTime is the time spent hashing the tree, for nodes that were not hashed before the put
+	HashTime time.Duration
+	// WriteTime is the time spent writing the batch
+	WriteTime time.Duration
+}
+
+// NodeRef identifies a stored node
+type NodeRef struct {
+	Gindex Gindex
+	Root   Root
+}
+
 type SlottedNode struct {
 	Slot uint64
 	Node Node
 }
 
-type MerkleDB interface {
-	// Put a node and its subtree in the DB
-	Put(slot uint64, node Node, fn HashFn) error
-	// Get a node from the DB
-	Get(gindex Gindex, key Root) (SlottedNode, error)
+// PairRecord is the decoded value of a stored node.
+type PairRecord = lite.PairRecord
+
+// TreeReader is the read-only capability of a MerkleDB
+type TreeReader interface {
+	// Get a node from the DB. Pair nodes are virtual nodes, unless the options ask for another LoadStrategy.
+	Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error)
+	// Get the stored record of a node into dst, without allocating a Node
+	GetInto(gindex Gindex, key Root, dst *PairRecord) error
 	// Has the node or not
 	Has(gindex Gindex, key Root) (bool, error)
-	// Delete the node at (gindex, key), does not remove any subtree
-	Delete(gindex Gindex, key Root) error
-	// Range retrieval of slotted values from the DB, between startSlot and endSlot, at the given gindex.
-	// There may be multiple nodes per slot.
+	// Range retrieval of slotted values from the DB, between startSlot and endSlot (both inclusive), at the given gindex.
+	// There may be multiple nodes per slot. Nodes are ordered by slot, then by root.
+	// The nodes are read with a single iterator, which is consistent by itself.
 	Range(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error)
+	// GetAllAtSlot retrieves every node stored for the slot at the given gindex, e.g. competing fork states.
+	GetAllAtSlot(slot uint64, gindex Gindex) ([]SlottedNode, error)
+	// Walk visits every node of the stored tree of the anchor, in the given order.
+	// The walk reads from a snapshot, concurrent writes do not show up in it.
+	Walk(anchor Root, order WalkOrder, fn func(node StreamNode) error) error
+	// Completeness checks if every node reachable from the anchor is stored, in a snapshot of the DB
+	Completeness(anchor Root) (*CompletenessReport, error)
+	// GetPath gets the node at the path of field names and indices, in the tree of the anchor, typed with the given type.
+	// See ResolvePath.
+	GetPath(anchor Root, typ view.TypeDef, path ...interface{}) (SlottedNode, error)
+	// Prove the node at the target gindex, in the tree of the given anchor root
+	Prove(anchor Root, target Gindex) (*MerkleProof, error)
+	// ProveMulti proves the nodes at the target gindices, in the tree of the given anchor root, in one multiproof
+	ProveMulti(anchor Root, targets []Gindex) (*MultiProof, error)
+	// ProveRange proves the contiguous range of nodes [start, end) at the depth below the base gindex, in one multiproof
+	ProveRange(anchor Root, base Gindex, depth uint8, start uint64, end uint64) (*MultiProof, error)
+	// Preload reads the nodes of the tree of the anchor down to the depth, e.g. of a new head ahead of its proofs,
+	// so they are served from the caches: the leveldb block cache, and the cache of a CachingDB.
+	// It returns the number of nodes that were read.
+	Preload(anchor Root, depth uint8) (int, error)
+	// Anchors lists the roots of all trees that were Put in the DB, ordered by root
+	Anchors() ([]Anchor, error)
+	// GetAnchor gets the anchor record of the tree with the given root
+	GetAnchor(root Root) (Anchor, error)
+	// GetRef gets the anchor root of a named reference
+	GetRef(name string) (Root, error)
+	// Refs lists all named references
+	Refs() (map[string]Root, error)
+	// Pins lists the pinned roots, ordered by root
+	Pins() ([]Root, error)
+	// Hidden lists the anchors that were hidden with Hide, ordered by root
+	Hidden() ([]Anchor, error)
+	// Repairs lists the nodes with corrupt values that could not be recovered, see WithRecovery
+	Repairs() ([]NodeRef, error)
+	// AuditLog lists up to limit records of the audit log, starting at the sequence number from. Unbounded if limit is 0.
+	AuditLog(from uint64, limit int) ([]AuditRecord, error)
+	// ExportAuditLog writes the audit log as JSON, one record per line, from a snapshot, and returns the number of records
+	ExportAuditLog(w io.Writer) (int, error)
+	// GetBlob gets the blob stored with PutBlob, leveldb.ErrNotFound if there is none
+	GetBlob(root Root) ([]byte, error)
+	// HasBlob checks if a blob is stored for the root
+	HasBlob(root Root) (bool, error)
+	// GetSSZ gets the SSZ encoding of the subtree at the gindex of the anchor, stored by a put with WithSSZStorage,
+	// leveldb.ErrNotFound if there is none
+	GetSSZ(anchor Root, gindex Gindex) (SSZRecord, error)
+	// IndexLookup lists the entries of the key in the index, of the nodes in the tree of the anchor, see WithIndex
+	IndexLookup(anchor Root, name string, key []byte) ([]IndexEntry, error)
+	// IndexRange lists the entries with keys in the range [start, end) in the index, of the nodes in the tree
+	// of the anchor, ordered by key and position. The range is unbounded on the side of a nil key.
+	IndexRange(anchor Root, name string, start []byte, end []byte) ([]IndexEntry, error)
+	// WatchGindex delivers a LeafChange for every put tree with a different node at the gindex than the previous
+	// tree: the parent of the put, see WithParent, or else the canonical anchor with the highest slot, if that is before
+	// the put. It buffers up to the given number of changes; a watch with a full buffer ends with ErrLagging,
+	// puts never wait for watches. The changes are delivered after the put is committed.
+	WatchGindex(gindex Gindex, buffer int) *GindexWatch
+	// IdempotentPut gets the put that claimed the idempotency key, see WithIdempotencyKey
+	IdempotentPut(key string) (IdempotentPut, error)
+	// Backup writes all nodes, anchors, named references, pins, blobs and SSZ encodings to a stream, from a snapshot,
+	// and returns the number of written records. The records end with a BackupManifest. See Restore.
+	// The values in the value log are written inline.
+	Backup(w io.Writer) (int, error)
+	// Usage counts the stored nodes, and the bytes of the nodes and anchors
+	Usage() (Usage, error)
+	// Stats gets the counters of the operations since the MerkleDB was created
+	Stats() Stats
+	// Health runs cheap probes of the handle, the reads and a sample of the nodes, see HealthStatus
+	Health() HealthStatus
+	// PrunePlan computes what Prune would remove with the same live roots, without deleting anything.
+	// With deferred deletes, it is what the prune and the reclaims after it remove together.
+	PrunePlan(liveRoots []Root) (*PruneReport, error)
+	// FindOrphans lists the stored nodes that are not reachable from any anchor or named reference
+	FindOrphans() (*OrphanReport, error)
+	// CheckSlots flags the nodes whose recorded slot is newer than the anchor that references them,
+	// which points at bugs in the slot bookkeeping of the caller.
+	CheckSlots() (*SlotReport, error)
+	// PruneCheckpoint gets the progress of an interrupted prune, leveldb.ErrNotFound if there is none
+	PruneCheckpoint() (*PruneCheckpoint, error)
+	// Ancestry follows the parent links of the anchor, and returns up to n ancestors, nearest first.
+	// It stops early at an anchor without parent, or with a parent that is not stored.
+	Ancestry(root Root, n int) ([]Anchor, error)
+	// FilterAnchors lists the anchors of the given canonicality, ordered by root
+	FilterAnchors(c Canonicality) ([]Anchor, error)
+	// ProvenanceAnchors lists the anchors of the trees with the given provenance, ordered by root. See WithProvenance.
+	ProvenanceAnchors(provenance Root) ([]Anchor, error)
+	// CanonicalRange retrieves the nodes at the given gindex in the trees of the canonical anchors
+	// between startSlot and endSlot (both inclusive), ordered by slot, then by anchor root. The slots are those of the anchors.
+	CanonicalRange(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error)
+	// Tags lists the tags of the anchor with the given root, sorted. See Tag.
+	Tags(root Root) ([]string, error)
+	// TaggedAnchors lists the anchors with the given tag, ordered by root
+	TaggedAnchors(tag string) ([]Anchor, error)
+	// TaggedRange is like CanonicalRange, for the trees of the anchors with the given tag
+	TaggedRange(startSlot uint64, endSlot uint64, gindex Gindex, tag string) ([]SlottedNode, error)
+	// History lists the leaves at a gindex of interest in [startSlot, endSlot], by slot, see WithCompactHistory
+	History(gindex Gindex, startSlot uint64, endSlot uint64) ([]HistoryEntry, error)
+	// ProveHistory proves the leaf at a gindex of interest in the tree of the historical anchor at the slot,
+	// reconstructed from the nearest full anchor, if the anchor itself is not stored. See WithCompactHistory.
+	ProveHistory(gindex Gindex, slot uint64, anchor Root, fn HashFn) (*MerkleProof, error)
+}
+
+// TreeWriter is the write capability of a MerkleDB
+type TreeWriter interface {
+	// Put a node and its subtree in the DB. A nil HashFn defaults to ConcurrentHashFn.
+	Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error)
+	// PutStream puts the tree of the anchor from a stream of nodes, validating every node against its parent.
+	// Nodes that are already stored may be left out of the stream, together with their subtrees.
+	PutStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts ...PutOption) (InsertReport, error)
+	// PutTop puts the nodes of the tree up to maxDepth, the root is at depth 0. The nodes at maxDepth are stored
+	// as summaries: their roots are provable, but their subtrees are not stored, and reads below them fail with ErrSummarized.
+	// Later puts do not reuse summaries or the pairs above them, a full Put of the tree stores the whole subtree.
+	PutTop(slot uint64, node Node, fn HashFn, maxDepth uint32, opts ...PutOption) (InsertReport, error)
+	// PutSubtrees puts the subtrees at the gindices, and the nodes on the paths from the root to them.
+	// The siblings of those paths are stored as summaries, like the boundary of PutTop.
+	PutSubtrees(slot uint64, node Node, fn HashFn, gindices []Gindex, opts ...PutOption) (InsertReport, error)
+	// PutCompact stores only the leaves at the gindices of interest of the tree, without any nodes or anchor,
+	// see WithCompactHistory. Full puts and PutStream record the same leaves along with the tree.
+	PutCompact(slot uint64, node Node, fn HashFn) (InsertReport, error)
+	// TrimHistory deletes the compact history before the slot, and returns the number of deleted entries
+	TrimHistory(before uint64) (int, error)
+	// Transplant copies the stored subtree at (srcGindex, srcRoot) to dstGindex, keeping the slots of the nodes.
+	// A tree that is Put later can then reuse the subtree at its new position.
+	// Until then the copy is not reachable from any anchor, and a Prune removes it.
+	Transplant(srcGindex Gindex, srcRoot Root, dstGindex Gindex) (InsertReport, error)
+	// Delete the node at (gindex, key), does not remove any subtree.
+	// Deleting a root node also deletes its anchor record.
+	// With deferred deletes, the node is tombstoned instead, and Reclaim deletes only the node too.
+	Delete(gindex Gindex, key Root) error
+	// Prune all nodes that are not reachable from any of the live anchor roots.
+	// A prune that was interrupted continues where it stopped, if the live roots are the same. See PruneCheckpoint.
+	Prune(liveRoots []Root) error
+	// ResumePrune continues an interrupted prune with its live roots, and returns false if there was none
+	ResumePrune() (bool, error)
+	// Reorg finds the common ancestor of the two heads through the parent links, see WithParent,
+	// and prunes the anchors of the old branch after it, with the nodes that no other anchor uses.
+	// The head reference is moved to the new head if it named the old head.
+	Reorg(oldHead Root, newHead Root) (*ReorgReport, error)
+	// SetRef names an anchor root. Named anchors are exempt from expiry.
+	SetRef(name string, root Root) error
+	// DeleteRef removes a named reference, the anchor itself is kept
+	DeleteRef(name string) error
+	// Pin exempts the tree with the given root from pruning, expiry, quotas and reorgs, regardless of its slot.
+	// E.g. for the genesis and weak-subjectivity states. The tree does not have to be stored yet.
+	Pin(root Root) error
+	// Unpin makes the pinned tree subject to pruning again
+	Unpin(root Root) error
+	// Hide soft-deletes the anchor with the given root: it is left out of Anchors and Range at the root,
+	// reads of its tree fail with ErrHidden, and it cannot be put again. Unlike a deleted anchor,
+	// its tree is kept by prunes, reorgs and expiry, as if pinned, until Unhide restores it.
+	Hide(root Root) error
+	// Unhide restores the hidden anchor with the given root, with its metadata
+	Unhide(root Root) error
+	// Tag attaches the tags, arbitrary non-empty strings, to the stored anchor with the given root,
+	// e.g. "checkpoint" or "experiment-42", to filter anchors by purpose. See also WithTags.
+	// Tags are kept when the tree is put again, and deleted with the anchor.
+	Tag(root Root, tags ...string) error
+	// Untag removes the tags from the anchor with the given root
+	Untag(root Root, tags ...string) error
+	// PruneTagged prunes the anchors with the given tag, and keeps all others, and returns how many were pruned.
+	// Named and pinned anchors are kept, like by Expire.
+	PruneTagged(tag string) (int, error)
+	// Restore writes the records of a Backup stream, which may be of a DB with another prefix.
+	// The restored DB has the anchors, with their branch metadata, the named references and the pins of the backup.
+	// Records are validated, and checked against the manifest of the backup. Nodes are written in batches,
+	// a failed restore leaves the nodes before the failure; the anchors, references and pins are written
+	// after the manifest verified.
+	Restore(r io.Reader) (int, error)
+	// Merge adds the nodes, anchors, named references and pins of the source, e.g. another shard or a partial replica.
+	// Records that are stored in both are resolved with the policies of the options; stored nodes with a corrupt
+	// value are replaced. The source is read from a snapshot, and merged in batches:
+	// a failed merge leaves the records before the failure.
+	Merge(src TreeReader, opts MergeOptions) (*MergeReport, error)
+	// SetCanonical marks the anchor with the given root as canonical or not.
+	// Anchors are not canonical until marked, putting the same tree again keeps the mark.
+	SetCanonical(root Root, canonical bool) error
+	// Expire prunes the anchors that outlived the configured retention, and returns how many there were
+	Expire() (int, error)
+	// Reclaim deletes the nodes that were tombstoned by Delete, and the roots that were tombstoned by Prune
+	// with the nodes below them, that are not reachable from any anchor. It returns the number of deleted nodes.
+	Reclaim() (int, error)
+	// RebuildIndex adds the entries of all stored nodes that the index covers, and returns the number of entries
+	RebuildIndex(name string) (int, error)
+	// PutBlob stores an opaque value under the root, e.g. the SSZ encoding of the block with that root.
+	// Blobs are pruned with the trees: a prune keeps the blobs of the roots and provenances of the kept anchors,
+	// a blob must be put after the tree it belongs to. Blobs are not included in backups.
+	PutBlob(root Root, data []byte) error
+	// ForgetIdempotencyKeys deletes the idempotency keys of the puts before the given time,
+	// puts with those keys are written again. It returns the number of forgotten keys.
+	ForgetIdempotencyKeys(before time.Time) (int, error)
+	// Sync makes the writes that returned before it durable: the value files are synced, their directory entries
+	// were synced when they were created, and the leveldb journal is synced with a synced write. Writes are not synced by default, a crash may lose the last ones; after Sync returns,
+	// a crash keeps every put that was accepted before it. Puts with a custom Commit are synced by their commit.
+	Sync() error
+}
+
+type MerkleDB interface {
+	TreeReader
+	TreeWriter
+	// Snapshot takes a consistent read-only view of the DB, for multiple reads that must see the same trees
+	Snapshot() (*Snapshot, error)
+	// Close stops any background work and closes the underlying leveldb
+	Close() error
 }
 
 // DB format
@@ -36,24 +271,182 @@ type MerkleDB interface {
 //
 // Pair node:
 // bytes(prefix) ++ uint16(gindex_bitlen) ++ bytes(gindex_leftbitaligned) ++ bytes32(self) -> uint8(1) ++ uint64(slot) ++ bytes32(left) ++ bytes32(right)
+//
+// Metadata records have a zero gindex bit length, which no node key can have:
+// bytes(prefix) ++ uint16(0) ++ uint8(kind) ++ bytes(id) -> bytes(record)
+//
+// Anchor, kind 'a', one per tree that was Put:
+// ... ++ bytes32(root) -> uint8(version) ++ uint64(slot) ++ uint64(inserted_at_unix_nano) ++ uint8(flags) ++ bytes32(parent)
+// Flags: bit 0 is set if the anchor is canonical.
+//
+// Named reference, kind 'r':
+// ... ++ bytes(name) -> bytes32(root)
+//
+// Tombstone, kind 't', a node that is marked for deferred deletion:
+// ... ++ uint16(gindex_bitlen) ++ bytes(gindex_leftbitaligned) ++ bytes32(self) -> empty
 
-const prefixLen = 3
-const gindexLenByteLen = 2
-const maxGindexByteLen = 32
+const prefixLen = lite.PrefixLen
+const gindexLenByteLen = lite.GindexLenByteLen
+const maxGindexByteLen = lite.MaxGindexByteLen
+const maxKeyLen = lite.MaxKeyLen
+
+// Key buffers are pooled: virtual node loads hit buildKey for every child, and the key never outlives the DB call.
+var keyPool = sync.Pool{
+	New: func() interface{} {
+		return new([maxKeyLen]byte)
+	},
+}
 
 type merkleDB struct {
 	prefix [prefixLen]byte
 	db     *leveldb.DB
+	// r serves the reads, the database itself or a snapshot of it
+	r    reader
+	opts Options
+	// Puts share the lock, prunes are exclusive: a prune must not sweep nodes that a concurrent put builds on.
+	pruneLock sync.RWMutex
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	// usage is the storage in use, nil if it has to be counted again
+	usage *Usage
+	// reserved is the usage of the puts that are being written, see reserveQuota
+	reserved  Usage
+	usageLock sync.Mutex
+	// snap is the snapshot of a read-only view, and base the merkledb it was taken of. Both nil if not a view.
+	snap *snapshotReader
+	base *merkleDB
+	// counters are shared with the views
+	counters *counters
+	// auditSeq is the sequence number of the last audit record
+	auditSeq uint64
+	// prefetch reads ahead of sequential reads, see WithPrefetch. Nil if disabled.
+	prefetch *prefetcher
+	// lease is the lease of the prefix that the merkledb holds, see WithLease. Nil if none.
+	lease       *Lease
+	releaseOnce sync.Once
+	// leaseLost is set to 1 when another writer took over the lease, writes fail with ErrLeaseLost from then on
+	leaseLost int32
+	// idempotencyLock serializes the puts with an idempotency key, and forgetting the keys
+	idempotencyLock sync.Mutex
+	// watches are the open watches of gindices, see WatchGindex
+	watches   map[*GindexWatch]struct{}
+	watchLock sync.Mutex
+	// head is the canonical anchor with the highest slot, nil if none, that the watches compare puts without
+	// a parent with. It is searched again if headKnown is false, see resetHead.
+	head      *Anchor
+	headKnown bool
+	// vlog stores large values in files, see WithValueLog. Nil if disabled, shared with the views.
+	vlog *valueLog
+	// proofs caches the proofs of Prove, see WithProofCache. Nil if disabled, and for views.
+	proofs *proofCache
+	// collision is the result of the prefix check of New, see WithPrefixCheck
+	collision error
+	// invalid is the error of the options of New, which Open fails with, see checkOptions and compareMetadata.
+	// A failure to continue the audit log is kept here too, see initAudit.
+	invalid error
+	// checkpointed is 1 while a prune checkpoint with a last key may be stored, see invalidateCheckpoint
+	checkpointed int32
 }
 
 // Wrap the database with a binary-tree merkle interface.
-func New(prefix [prefixLen]byte, db *leveldb.DB) MerkleDB {
-	return &merkleDB{prefix, db}
+func New(prefix [prefixLen]byte, db *leveldb.DB, opts ...Option) MerkleDB {
+	mdb := &merkleDB{prefix: prefix, db: db, r: db, closing: make(chan struct{}), counters: new(counters)}
+	for _, opt := range opts {
+		opt(&mdb.opts)
+	}
+	if mdb.opts.Prefetch > 0 {
+		mdb.prefetch = newPrefetcher(mdb, mdb.opts.Prefetch)
+	}
+	if mdb.opts.ValueLog.Dir != "" {
+		mdb.vlog = newValueLog(prefix, mdb.opts.ValueLog)
+	}
+	if mdb.opts.ProofCacheSize > 0 {
+		mdb.proofs = newProofCache(mdb.opts.ProofCacheSize)
+	}
+	if mdb.invalid = checkOptions(&mdb.opts); mdb.invalid == nil {
+		mdb.invalid = mdb.compareMetadata()
+	}
+	if mdb.invalid != nil && mdb.opts.OnBackgroundError != nil {
+		mdb.opts.OnBackgroundError(mdb.invalid)
+	}
+	mdb.checkPrefix()
+	if ok, err := db.Has(mdb.metaKey(metaPruneCheckpoint, nil), nil); err == nil && ok {
+		mdb.checkpointed = 1
+	}
+	if mdb.opts.AuditLog {
+		if err := mdb.initAudit(); err != nil {
+			err = fmt.Errorf("failed to continue the audit log: %w", err)
+			if mdb.invalid == nil {
+				mdb.invalid = err
+			}
+			if mdb.opts.OnBackgroundError != nil {
+				mdb.opts.OnBackgroundError(err)
+			}
+		}
+	}
+	if mdb.opts.SweepInterval > 0 {
+		mdb.wg.Add(1)
+		go mdb.sweepLoop()
+	}
+	return mdb
+}
+
+func (db *merkleDB) Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	if db.opts.OnSlowQuery == nil {
+		return db.putTree(slot, node, fn, opts)
+	}
+	start := time.Now()
+	report, err := db.putTree(slot, node, fn, opts)
+	q := SlowQuery{Op: "put", Gindex: RootGindex, Slot: slot, Nodes: report.NewNodes, Err: err}
+	// the root is cached by a written tree, a failed put may not have one, e.g. of a cyclic tree
+	if err == nil {
+		q.Root = node.MerkleRoot(hashFnOrDefault(fn))
+	}
+	db.logSlow(start, q)
+	return report, err
+}
+
+// putTree puts the tree, and puts it again after making room for it if it exceeds the quota
+func (db *merkleDB) putTree(slot uint64, node Node, fn HashFn, opts []PutOption) (InsertReport, error) {
+	release, replayed, err := db.claim(opts)
+	if err != nil || replayed {
+		return InsertReport{Replayed: replayed}, err
+	}
+	defer release()
+	fn = hashFnOrDefault(fn)
+	report, err := db.put(slot, node, fn, opts)
+	if err == ErrQuotaExceeded && db.opts.Quota.PruneOnExceed {
+		// the full tree is known, it can be put again after making room for it
+		if err := db.makeRoom(Usage{Nodes: report.NewNodes, Bytes: report.BytesWritten}); err != nil {
+			return InsertReport{}, err
+		}
+		report, err = db.put(slot, node, fn, opts)
+	}
+	if err == nil {
+		db.notifyWatches(node.MerkleRoot(fn), slot, opts)
+	}
+	return report, err
 }
 
-func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
-	// if we are just putting a single node, then we don't need the batch
+func (db *merkleDB) put(slot uint64, node Node, fn HashFn, opts []PutOption) (InsertReport, error) {
+	rootOf := func(node Node) Root {
+		return node.MerkleRoot(fn)
+	}
+	if memo := applyPutOptions(opts).RootMemo; memo != nil {
+		rootOf = func(node Node) Root {
+			return memo.Root(node, fn)
+		}
+	}
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	partial := applyPutOptions(opts).partial
+	// if we are just putting a single node, then we don't need to traverse anything
 	if node.IsLeaf() {
+		_, summary := node.(*summaryNode)
+		if summary && !partial {
+			return InsertReport{}, ErrSummaryNode
+		}
 		var key [prefixLen + gindexLenByteLen + 1 + 32]byte
 		// prefix
 		copy(key[0:prefixLen], db.prefix[:])
@@ -62,40 +455,67 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 		key[prefixLen+1] = 0
 		// gindex
 		key[prefixLen+gindexLenByteLen] = 1 << 7
+		start := time.Now()
 		root := node.MerkleRoot(fn)
+		hashTime := time.Since(start)
 		copy(key[prefixLen+gindexLenByteLen+1:], root[:])
 
-		var val [9]byte
-		val[0] = 0
-		binary.LittleEndian.PutUint64(val[1:], slot)
-		return db.db.Put(key[:], val[:], nil)
+		var val [1 + 8]byte
+		b := new(leveldb.Batch)
+		b.Put(key[:], appendValue(val[:0], &PairRecord{Slot: slot, Summary: summary}))
+		if err := db.putAnchor(b, root, slot, opts); err != nil {
+			return InsertReport{}, err
+		}
+		if err := db.putSSZ(b, root, node, opts); err != nil {
+			return InsertReport{}, err
+		}
+		if err := db.putHistory(b, root, slot, node, fn, opts); err != nil {
+			return InsertReport{}, err
+		}
+		report := InsertReport{NewNodes: 1, BytesWritten: len(b.Dump()), HashTime: hashTime}
+		err := db.writePut(b, &report, opts)
+		return report, err
 	} else {
 		b := new(leveldb.Batch)
-		var keyScratch [prefixLen + gindexLenByteLen + maxGindexByteLen + 32]byte
+		var keyScratch [maxKeyLen]byte
 		copy(keyScratch[0:prefixLen], db.prefix[:])
+		var report InsertReport
+		dedup := db.opts.Dedup
+		if dedup == nil {
+			dedup = AlwaysProbe
+		}
+		if applyPutOptions(opts).Fresh {
+			dedup = freshProbe{dedup}
+		}
 
-		var add func(gindexBitIndex uint32, node Node) error
-		add = func(gindexBitIndex uint32, node Node) error {
-			if gindexBitIndex >= maxGindexByteLen*8 {
-				return errors.New("gindex too large")
+		maxDepth := db.maxDepth()
+		// inPartial is true below a partial pair, the only place for a summary
+		var add func(gindexBitIndex uint32, node Node, inPartial bool) error
+		add = func(gindexBitIndex uint32, node Node, inPartial bool) error {
+			if gindexBitIndex > maxDepth {
+				return &DepthError{Depth: gindexBitIndex, Max: maxDepth}
+			}
+			report.NewNodes += 1
+			if gindexBitIndex > report.MaxDepth {
+				report.MaxDepth = gindexBitIndex
 			}
 
 			if node.IsLeaf() {
+				// summaries are only stored by partial puts, they are never reused
+				_, summary := node.(*summaryNode)
+				if summary && (!partial || !inPartial) {
+					return ErrSummaryNode
+				}
 				max := prefixLen + gindexLenByteLen + (1 + uint16(gindexBitIndex>>3)) + 32
 				// update to the current gindex bit length
 				binary.LittleEndian.PutUint16(keyScratch[prefixLen:prefixLen+gindexLenByteLen], uint16(gindexBitIndex+1))
 
-				var val [9]byte
-				val[0] = 0
-				binary.LittleEndian.PutUint64(val[1:], slot)
-
+				var val [1 + 8]byte
 				// Note that the key scratchpad is already prepared by the caller, no work left to do.
-				b.Put(keyScratch[:max], val[:])
+				b.Put(keyScratch[:max], appendValue(val[:0], &PairRecord{Slot: slot, Summary: summary}))
+				dedup.Stored(keyScratch[:max])
 				return nil
 			} else {
-				var val [1 + 8 + 32 + 32]byte
-				val[0] = 1
-				binary.LittleEndian.PutUint64(val[1:1+8], slot)
 				left, err := node.Left()
 				if err != nil {
 					return err
@@ -104,10 +524,12 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 				if err != nil {
 					return err
 				}
-				leftRoot := left.MerkleRoot(fn)
-				rightRoot := right.MerkleRoot(fn)
-				copy(val[1+8:1+8+32], leftRoot[:])
-				copy(val[1+8+32:1+8+32+32], rightRoot[:])
+				leftRoot := rootOf(left)
+				rightRoot := rootOf(right)
+				var val [1 + 8 + 32 + 32]byte
+				// the pairs above the summaries of a partial put do not have their whole subtree stored
+				_, partialPair := node.(*partialPair)
+				rec := PairRecord{Slot: slot, Pair: true, Left: leftRoot, Right: rightRoot, Partial: partialPair}
 
 				// update to the current gindex bit length
 				binary.LittleEndian.PutUint16(keyScratch[prefixLen:prefixLen+gindexLenByteLen], uint16(gindexBitIndex+1))
@@ -115,10 +537,13 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 				max := prefixLen + gindexLenByteLen + (1 + uint16(gindexBitIndex>>3)) + 32
 
 				// insert the pair node
-				b.Put(keyScratch[:max], val[:])
+				b.Put(keyScratch[:max], appendValue(val[:0], &rec))
+				dedup.Stored(keyScratch[:max])
 
 				// going deeper
 				gindexBitIndex += 1
+				// the existence checks need the bit length of the children
+				binary.LittleEndian.PutUint16(keyScratch[prefixLen:prefixLen+gindexLenByteLen], uint16(gindexBitIndex+1))
 				lastGindexByteIndex := prefixLen + gindexLenByteLen + uint16(gindexBitIndex>>3)
 				max = lastGindexByteIndex + 1 + 32
 
@@ -131,14 +556,18 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 				max += 32
 
 				// check if the key exists already. If it does, we don't need to insert it again
-				if exists, err := db.db.Has(keyScratch[:max], nil); err != nil {
+				if exists, err := db.probe(dedup, keyScratch[:max], gindexBitIndex); err != nil {
 					return err
-				} else if !exists {
-					if err := add(gindexBitIndex, left); err != nil {
-						return fmt.Errorf("failed to add left node to batch: %v", err)
+				} else if exists {
+					report.ReusedNodes += 1
+				} else {
+					if err := add(gindexBitIndex, left, partialPair); err != nil {
+						return fmt.Errorf("failed to add left node to batch: %w", err)
 					}
 				}
 
+				// the left subtree may have changed the bit length
+				binary.LittleEndian.PutUint16(keyScratch[prefixLen:prefixLen+gindexLenByteLen], uint16(gindexBitIndex+1))
 				// Set current bit to one, to identify the right node
 				keyScratch[lastGindexByteIndex] |= currentBit
 				// Reset trailing bits zero
@@ -149,11 +578,13 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 				max += 32
 
 				// check if the key exists already. If it does, we don't need to insert it again
-				if exists, err := db.db.Has(keyScratch[:max], nil); err != nil {
+				if exists, err := db.probe(dedup, keyScratch[:max], gindexBitIndex); err != nil {
 					return err
-				} else if !exists {
-					if err := add(gindexBitIndex, right); err != nil {
-						return fmt.Errorf("failed to add right node to batch: %v", err)
+				} else if exists {
+					report.ReusedNodes += 1
+				} else {
+					if err := add(gindexBitIndex, right, partialPair); err != nil {
+						return fmt.Errorf("failed to add right node to batch: %w", err)
 					}
 				}
 
@@ -165,93 +596,270 @@ func (db *merkleDB) Put(slot uint64, node Node, fn HashFn) error {
 		keyScratch[prefixLen+1] = 0
 		// gindex: root node == 1 (left aligned)
 		keyScratch[prefixLen+gindexLenByteLen] = 1 << 7
-		root := node.MerkleRoot(fn)
+		// the nodes cache their roots: all hashing happens here, the traversal below reuses the roots
+		start := time.Now()
+		root, err := boundedRoot(node, rootOf, fn, 0, maxDepth)
+		if err != nil {
+			return InsertReport{}, err
+		}
+		report.HashTime = time.Since(start)
 		max := prefixLen + gindexLenByteLen + 1 + 32
 		copy(keyScratch[prefixLen+gindexLenByteLen+1:max], root[:])
-		if err := add(0, node); err != nil {
-			return fmt.Errorf("failed to add anchor pair node: %v", err)
+		if err := add(0, node, false); err != nil {
+			return InsertReport{}, fmt.Errorf("failed to add anchor pair node: %w", err)
+		}
+		if err := db.putAnchor(b, root, slot, opts); err != nil {
+			return InsertReport{}, err
+		}
+		if err := db.putSSZ(b, root, node, opts); err != nil {
+			return InsertReport{}, err
+		}
+		if err := db.putHistory(b, root, slot, node, fn, opts); err != nil {
+			return InsertReport{}, err
 		}
+		report.BytesWritten = len(b.Dump())
 
-		return db.db.Write(b, nil)
+		err = db.writePut(b, &report, opts)
+		return report, err
 	}
 }
 
-func (db *merkleDB) buildKey(gindex Gindex, key Root) []byte {
-	data, bitLen := gindex.LeftAlignedBigEndian()
-	size := prefixLen + gindexLenByteLen + uint64(len(data)) + 32
-	keyData := make([]byte, size, size)
-	copy(keyData[0:prefixLen], db.prefix[:])
-	binary.LittleEndian.PutUint16(keyData[prefixLen:prefixLen+gindexLenByteLen], uint16(bitLen))
-	copy(keyData[prefixLen+gindexLenByteLen:prefixLen+gindexLenByteLen+len(data)], data)
-	copy(keyData[prefixLen+gindexLenByteLen+uint64(len(data)):], key[:])
-	return keyData
+// probe checks if the node key is stored, if the strategy asks for it
+func (db *merkleDB) probe(dedup DedupStrategy, key []byte, depth uint32) (bool, error) {
+	if !dedup.Probe(key, depth) {
+		return false, nil
+	}
+	return db.storedFully(key)
+}
+
+// storedFully is true if the node of the key is stored with its subtree: summaries and partial pairs are not,
+// a put writes them again, with the subtree below them.
+func (db *merkleDB) storedFully(key []byte) (bool, error) {
+	v, err := db.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return lite.Complete(v), nil
+}
+
+// buildKey writes the key into the given buffer, and returns the used part of it.
+func (db *merkleDB) buildKey(dst *[maxKeyLen]byte, gindex Gindex, key Root) ([]byte, error) {
+	return lite.BuildKey(dst, db.prefix, gindex, key)
+}
+
+// writePut writes the batch of a put, within the quota, or hands it to the commit of the put
+func (db *merkleDB) writePut(b *leveldb.Batch, report *InsertReport, opts []PutOption) error {
+	if err := db.indexBatch(b); err != nil {
+		return err
+	}
+	if err := db.invalidateCheckpoint(b); err != nil {
+		return err
+	}
+	// the index entries and the checkpoint count as written too
+	report.BytesWritten = len(b.Dump())
+	release, err := db.reserveQuota(report)
+	if err != nil {
+		return err
+	}
+	commit := applyPutOptions(opts).Commit
+	if commit == nil {
+		commit = func(b *leveldb.Batch) error {
+			return db.db.Write(b, nil)
+		}
+	}
+	report.Keys = b.Len()
+	start := time.Now()
+	if err := db.commit(b, commit); err != nil {
+		release(false)
+		return err
+	}
+	release(true)
+	report.WriteTime = time.Since(start)
+	atomic.AddUint64(&db.counters.puts, 1)
+	if db.opts.OnPut != nil {
+		db.opts.OnPut(*report)
+	}
+	return nil
 }
 
-func (db *merkleDB) Get(gindex Gindex, key Root) (SlottedNode, error) {
-	out, err := db.db.Get(db.buildKey(gindex, key), nil)
+func (db *merkleDB) Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error) {
+	var rec PairRecord
+	if err := db.GetInto(gindex, key, &rec); err != nil {
+		return SlottedNode{}, err
+	}
+	node, err := loadNode(db, db, db.opts.MaxDepth, gindex, key, &rec, applyGetOptions(opts))
 	if err != nil {
 		return SlottedNode{}, err
 	}
-	if len(out) < 1+8 {
-		return SlottedNode{}, fmt.Errorf("key '%x' has corrupt value, too short: '%x'", key, out)
+	return SlottedNode{Slot: rec.Slot, Node: node}, nil
+}
+
+func (db *merkleDB) GetInto(gindex Gindex, key Root, dst *PairRecord) error {
+	if db.opts.OnSlowQuery == nil {
+		return db.getInto(gindex, key, dst)
+	}
+	start := time.Now()
+	err := db.getInto(gindex, key, dst)
+	db.logSlow(start, SlowQuery{Op: "get", Gindex: gindex, Root: key, Err: err})
+	return err
+}
+
+func (db *merkleDB) getInto(gindex Gindex, key Root, dst *PairRecord) error {
+	atomic.AddUint64(&db.counters.gets, 1)
+	// every read of a tree starts at its root
+	if gindex.IsRoot() {
+		if hidden, err := db.isHidden(key); err != nil {
+			return err
+		} else if hidden {
+			return ErrHidden
+		}
 	}
-	typ := out[0]
-	if typ == 0 {
-		slot := binary.LittleEndian.Uint64(out[1 : 1+8])
-		return SlottedNode{Slot: slot, Node: &key}, nil
-	} else if typ == 1 {
-		if len(out) != 1+8+32+32 {
-			return SlottedNode{}, fmt.Errorf("key '%x' has corrupt pair value, invalid length: '%x'", key, out)
+	if db.prefetch != nil {
+		if db.prefetch.take(gindex, key, dst) {
+			atomic.AddUint64(&db.counters.prefetchHits, 1)
+			return nil
 		}
-		slot := binary.LittleEndian.Uint64(out[1 : 1+8])
-		var left, right Root
-		copy(left[:], out[1+8:1+8+32])
-		copy(right[:], out[1+8+32:1+8+32+32])
-		node := NewVirtualNode(db, gindex, key, left, right)
-		return SlottedNode{Slot: slot, Node: node}, nil
-	} else {
-		return SlottedNode{}, fmt.Errorf("key '%x' has corrupt value, unrecognized typ: '%x'", key, out)
 	}
+	err := db.getLocal(gindex, key, dst)
+	if err == nil && db.prefetch != nil {
+		db.prefetch.read(gindex, *dst)
+	}
+	if err == leveldb.ErrNotFound && db.opts.Resolver != nil {
+		atomic.AddUint64(&db.counters.misses, 1)
+		return db.resolve(gindex, key, dst)
+	}
+	if err == nil && db.opts.Resolver != nil {
+		atomic.AddUint64(&db.counters.hits, 1)
+	}
+	if corrupt, ok := err.(*CorruptValueError); ok && db.opts.Recover {
+		return db.recover(corrupt, dst)
+	}
+	return err
 }
 
-func (db *merkleDB) Has(gindex Gindex, key Root) (bool, error) {
-	return db.db.Has(db.buildKey(gindex, key), nil)
+// getLocal gets the record of a node without falling back to the resolver
+func (db *merkleDB) getLocal(gindex Gindex, key Root, dst *PairRecord) error {
+	buf := keyPool.Get().(*[maxKeyLen]byte)
+	defer keyPool.Put(buf)
+	k, err := db.buildKey(buf, gindex, key)
+	if err != nil {
+		return err
+	}
+	out, err := db.r.Get(k, nil)
+	if err != nil {
+		return err
+	}
+	if err := parseValue(out, dst); err != nil {
+		return &CorruptValueError{Gindex: gindex, Root: key, Value: out, Err: err}
+	}
+	// the gindices of the children of a pair at the deepest 64 bit gindex overflow, no such pair is ever stored
+	if g, ok := gindex.(Gindex64); ok && dst.Pair && g.Depth() >= 63 {
+		return &CorruptValueError{Gindex: gindex, Root: key, Value: out, Err: errGindexTooDeep}
+	}
+	return nil
 }
 
-func (db *merkleDB) Delete(gindex Gindex, key Root) error {
-	return db.db.Delete(db.buildKey(gindex, key), nil)
+func (db *merkleDB) Has(gindex Gindex, key Root) (bool, error) {
+	buf := keyPool.Get().(*[maxKeyLen]byte)
+	defer keyPool.Put(buf)
+	k, err := db.buildKey(buf, gindex, key)
+	if err != nil {
+		return false, err
+	}
+	return db.r.Has(k, nil)
 }
 
-func (db *merkleDB) Range(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
-	panic("implement me")
+func (db *merkleDB) Delete(gindex Gindex, key Root) error {
+	defer db.resetUsage()
+	defer db.resetPrefetch()
+	defer db.resetProofs()
+	buf := keyPool.Get().(*[maxKeyLen]byte)
+	defer keyPool.Put(buf)
+	k, err := db.buildKey(buf, gindex, key)
+	if err != nil {
+		return err
+	}
+	b := new(leveldb.Batch)
+	if db.opts.DeferredDeletes {
+		b.Put(db.tombstoneKey(k), []byte{tombstoneNode})
+	} else {
+		b.Delete(k)
+	}
+	if gindex.IsRoot() {
+		b.Delete(db.metaKey(metaAnchor, key[:]))
+		b.Delete(db.metaKey(metaHidden, key[:]))
+		b.Delete(db.metaKey(metaTags, key[:]))
+		if err := db.deleteSSZ(b, key); err != nil {
+			return err
+		}
+	}
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return err
+	}
+	db.audit(b, AuditRecord{Op: AuditDelete, Root: key, Gindex: g})
+	return db.write(b)
 }
 
 func (db *merkleDB) Close() error {
+	db.stop()
 	return db.db.Close()
 }
 
+// stop ends the background work and the watches, and releases the lease if any, without closing the underlying leveldb
+func (db *merkleDB) stop() {
+	db.closeOnce.Do(func() {
+		close(db.closing)
+	})
+	db.wg.Wait()
+	db.releaseLease()
+	db.closeWatches()
+	if db.vlog != nil && db.base == nil {
+		if err := db.vlog.close(); err != nil && db.opts.OnBackgroundError != nil {
+			db.opts.OnBackgroundError(err)
+		}
+	}
+}
+
 var _ MerkleDB = (*merkleDB)(nil)
 
 type VirtualNode interface {
 	Node
 	Detach() error
+	// LeftRoot is the root of the left child, known without loading the child
+	LeftRoot() Root
+	// RightRoot is the root of the right child, known without loading the child
+	RightRoot() Root
+	// Slot the node was stored at
+	Slot() uint64
+}
+
+// nodeGetter is what a virtual node reads its children from
+type nodeGetter interface {
+	Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error)
 }
 
 type virtualNode struct {
-	db         MerkleDB
-	gindex     Gindex
+	db     nodeGetter
+	gindex Gindex
+	// maxDepth bounds the targets of Getter and Setter, the deepest a key can hold if 0
+	maxDepth   uint32
 	self       Root
+	slot       uint64
 	left       Root
 	right      Root
 	cacheLeft  Node
 	cacheRight Node
 }
 
-func NewVirtualNode(db MerkleDB, gindex Gindex, key Root, left Root, right Root) VirtualNode {
+func NewVirtualNode(db TreeReader, gindex Gindex, key Root, left Root, right Root, slot uint64) VirtualNode {
 	return &virtualNode{
 		db:         db,
 		gindex:     gindex,
 		self:       key,
+		slot:       slot,
 		left:       left,
 		right:      right,
 		cacheLeft:  nil,
@@ -273,6 +881,9 @@ func (v virtualNode) Left() (Node, error) {
 	if v.cacheLeft != nil {
 		return v.cacheLeft, nil
 	}
+	if v.db == nil {
+		return nil, ErrUnbound
+	}
 	slotted, err := v.db.Get(v.gindex.Left(), v.left)
 	if err != nil {
 		return nil, err
@@ -289,6 +900,9 @@ func (v virtualNode) Right() (Node, error) {
 	if v.cacheRight != nil {
 		return v.cacheRight, nil
 	}
+	if v.db == nil {
+		return nil, ErrUnbound
+	}
 	slotted, err := v.db.Get(v.gindex.Right(), v.right)
 	if err != nil {
 		return nil, err
@@ -301,6 +915,18 @@ func (v virtualNode) Right() (Node, error) {
 	return slotted.Node, nil
 }
 
+func (v virtualNode) LeftRoot() Root {
+	return v.left
+}
+
+func (v virtualNode) RightRoot() Root {
+	return v.right
+}
+
+func (v virtualNode) Slot() uint64 {
+	return v.slot
+}
+
 func (v virtualNode) IsLeaf() bool {
 	return false
 }
@@ -325,18 +951,21 @@ func (v virtualNode) Getter(target Gindex) (Node, error) {
 	if target.IsRoot() {
 		return v, nil
 	}
+	if err := checkDepth(v.gindex, target, v.maxDepth); err != nil {
+		return nil, err
+	}
 	if target.IsLeft() {
 		left, err := v.Left()
 		if err != nil {
-			return nil, err
+			return nil, summarized(err, v.gindex.Left(), v.left)
 		}
-		return left.Getter(target.Left())
+		return left.Getter(target.Subtree())
 	} else {
 		right, err := v.Right()
 		if err != nil {
-			return nil, err
+			return nil, summarized(err, v.gindex.Right(), v.right)
 		}
-		return right.Getter(target.Right())
+		return right.Getter(target.Subtree())
 	}
 }
 
@@ -344,6 +973,9 @@ func (v virtualNode) Setter(target Gindex, expand bool) (Link, error) {
 	if target.IsRoot() {
 		return Identity, nil
 	}
+	if err := checkDepth(v.gindex, target, v.maxDepth); err != nil {
+		return nil, err
+	}
 	if target.IsClose() {
 		if target.IsLeft() {
 			return v.RebindLeft, nil
@@ -351,20 +983,43 @@ func (v virtualNode) Setter(target Gindex, expand bool) (Link, error) {
 			return v.RebindRight, nil
 		}
 	}
-	// TODO: maybe make this depth 1 part lazy-loading as well?
-	if target.IsLeft() {
-		left, err := v.Left()
-		if err != nil {
-			return nil, err
+	// deeper paths are resolved lazily: nothing is loaded until the link is used,
+	// navigation errors are returned by the link.
+	return func(value Node) (Node, error) {
+		var link Link
+		if target.IsLeft() {
+			left, err := v.expandableChild(v.Left, v.left, expand)
+			if err != nil {
+				return nil, err
+			}
+			if link, err = DeeperSetter(v.RebindLeft, left, target, expand); err != nil {
+				return nil, err
+			}
+		} else {
+			right, err := v.expandableChild(v.Right, v.right, expand)
+			if err != nil {
+				return nil, err
+			}
+			if link, err = DeeperSetter(v.RebindRight, right, target, expand); err != nil {
+				return nil, err
+			}
 		}
-		return DeeperSetter(v.RebindLeft, left, target, expand)
-	} else {
-		right, err := v.Right()
-		if err != nil {
-			return nil, err
+		return link(value)
+	}, nil
+}
+
+// expandableChild loads a child. When expanding, a child that is not stored but has the root of a zero subtree
+// is returned as zero node, which expands like it does in an in-memory tree.
+func (v virtualNode) expandableChild(load func() (Node, error), root Root, expand bool) (Node, error) {
+	child, err := load()
+	if err == leveldb.ErrNotFound && expand {
+		for i := range ZeroHashes {
+			if ZeroHashes[i] == root {
+				return ZeroNode(uint32(i)), nil
+			}
 		}
-		return DeeperSetter(v.RebindRight, right, target, expand)
 	}
+	return child, err
 }
 
 func (v virtualNode) SummarizeInto(target Gindex, h HashFn) (SummaryLink, error) {
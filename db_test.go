@@ -1,8 +1,10 @@
 package merkledb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	. "github.com/protolambda/ztyp/tree"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -96,7 +98,7 @@ func TestMerkleDB_Put(t *testing.T) {
 	foo := randomRoot()
 	fooHex := toHex(foo[:])
 	slot := randomSlot()
-	err := mdb.Put(slot, foo, Hash)
+	_, err := mdb.Put(slot, foo, Hash)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,7 +121,7 @@ func TestMerkleDB_PutNested(t *testing.T) {
 	_ = foo.MerkleRoot(hFn)
 
 	slot := randomSlot()
-	err := mdb.Put(slot, foo, hFn)
+	_, err := mdb.Put(slot, foo, hFn)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -213,7 +215,7 @@ func TestVirtualNode(t *testing.T) {
 	_ = foo.MerkleRoot(hFn)
 
 	slot := randomSlot()
-	err := mdb.Put(slot, foo, hFn)
+	_, err := mdb.Put(slot, foo, hFn)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -229,3 +231,354 @@ func TestVirtualNode(t *testing.T) {
 	}
 	compareNodes(n, out.Node, gi, hFn, t)
 }
+
+func TestVirtualNode_Roots(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	foo := NewPairNode(randomTree(4), randomTree(4))
+	hFn := GetHashFn()
+	if _, err := mdb.Put(42, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, ok := out.Node.(VirtualNode)
+	if !ok {
+		t.Fatal("expected a virtual node")
+	}
+	if v.LeftRoot() != foo.LeftChild.MerkleRoot(hFn) || v.RightRoot() != foo.RightChild.MerkleRoot(hFn) {
+		t.Fatal("unexpected child roots")
+	}
+	if v.Slot() != 42 {
+		t.Fatalf("unexpected slot %d", v.Slot())
+	}
+}
+
+type countingReader struct {
+	TreeReader
+	gets int
+}
+
+func (r *countingReader) Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error) {
+	r.gets++
+	return r.TreeReader.Get(gindex, key, opts...)
+}
+
+func TestVirtualNode_LazySetter(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	foo := fullTree(5)
+	hFn := GetHashFn()
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := mdb.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := rec.Node.(VirtualNode)
+	reader := &countingReader{TreeReader: mdb}
+	lazy := NewVirtualNode(reader, RootGindex, v.MerkleRoot(hFn), v.LeftRoot(), v.RightRoot(), v.Slot())
+	target := Gindex64(0b110101)
+	link, err := lazy.Setter(target, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.gets != 0 {
+		t.Fatalf("expected no reads before the link is used, got %d", reader.gets)
+	}
+	value := randomRoot()
+	out, err := link(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.gets == 0 {
+		t.Fatal("expected reads when the link is used")
+	}
+	expectedLink, err := foo.Setter(target, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := expectedLink(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatal("lazy setter produced a different tree")
+	}
+	// navigation errors surface when the link is used
+	link, err = lazy.Setter(Gindex64(0b1101011), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := link(value); err == nil {
+		t.Fatal("expected navigation error")
+	}
+}
+
+func TestVirtualNode_SetterExpand(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	zero := SubtreeFillToDepth(&ZeroHashes[0], 4)
+	foo := NewPairNode(zero, randomTree(3))
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	// the zero subtree is not stored anymore
+	if err := mdb.Delete(RootGindex.Left(), zero.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Gindex64(0b101101)
+	value := randomRoot()
+	link, err := out.Node.Setter(target, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := link(value); err != leveldb.ErrNotFound {
+		t.Fatalf("expected not found error without expand, got %v", err)
+	}
+	link, err = out.Node.Setter(target, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := link(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLink, err := NewPairNode(ZeroNode(4), foo.RightChild).Setter(target, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := expectedLink(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatal("expanded setter produced a different tree")
+	}
+}
+
+func BenchmarkMerkleDB_Get(b *testing.B) {
+	db := newMemoryDB()
+	mdb := New(testPrefix, db)
+	foo := randomTree(10)
+	hFn := GetHashFn()
+	if _, err := mdb.Put(randomSlot(), foo, hFn); err != nil {
+		b.Fatal(err)
+	}
+	n, gi := randomNode(foo, RootGindex, 6)
+	root := n.MerkleRoot(hFn)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mdb.Get(gi, root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleDB_GetInto(t *testing.T) {
+	db := newMemoryDB()
+	mdb := New(testPrefix, db)
+	foo := randomTree(10)
+	hFn := GetHashFn()
+	slot := randomSlot()
+	if _, err := mdb.Put(slot, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	n, gi := randomNode(foo, RootGindex, 6)
+	var rec PairRecord
+	if err := mdb.GetInto(gi, n.MerkleRoot(hFn), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Slot != slot {
+		t.Fatalf("different slot: %d <> %d", rec.Slot, slot)
+	}
+	if rec.Pair == n.IsLeaf() {
+		t.Fatalf("expected pair: %v, got pair: %v", !n.IsLeaf(), rec.Pair)
+	}
+	if rec.Pair {
+		left, _ := n.Left()
+		right, _ := n.Right()
+		if rec.Left != left.MerkleRoot(hFn) || rec.Right != right.MerkleRoot(hFn) {
+			t.Fatal("pair record children do not match")
+		}
+	}
+}
+
+func BenchmarkMerkleDB_GetInto(b *testing.B) {
+	db := newMemoryDB()
+	mdb := New(testPrefix, db)
+	foo := randomTree(10)
+	hFn := GetHashFn()
+	if _, err := mdb.Put(randomSlot(), foo, hFn); err != nil {
+		b.Fatal(err)
+	}
+	n, gi := randomNode(foo, RootGindex, 6)
+	root := n.MerkleRoot(hFn)
+	var rec PairRecord
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := mdb.GetInto(gi, root, &rec); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleDB_PutReport(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	a := NewPairNode(NewPairNode(randomRoot(), randomRoot()), randomRoot())
+	report, err := mdb.Put(1, a, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NewNodes != 5 || report.ReusedNodes != 0 || report.MaxDepth != 2 || report.BytesWritten == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	// share the left subtree
+	aLeft, _ := a.Left()
+	b := NewPairNode(aLeft, randomRoot())
+	report, err = mdb.Put(2, b, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NewNodes != 2 || report.ReusedNodes != 1 || report.MaxDepth != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestMerkleDB_PutCommit(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb)
+	hFn := GetHashFn()
+	node := randomTree(5)
+	root := node.MerkleRoot(hFn)
+	appKey := []byte("app/block/1")
+	if _, err := mdb.Put(1, node, hFn, WithCommit(func(b *leveldb.Batch) error {
+		b.Put(appKey, root[:])
+		return ldb.Write(b, nil)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ldb.Get(appKey, nil); err != nil || !bytes.Equal(v, root[:]) {
+		t.Fatalf("expected the application key to be written, got %x, err: %v", v, err)
+	}
+	if _, err := mdb.GetAnchor(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed commit fails the put, and nothing is written
+	other := randomTree(5)
+	failed := errors.New("external store unavailable")
+	if _, err := mdb.Put(2, other, hFn, WithCommit(func(b *leveldb.Batch) error {
+		return failed
+	})); err != failed {
+		t.Fatalf("expected the commit error, got %v", err)
+	}
+	if ok, err := mdb.Has(RootGindex, other.MerkleRoot(hFn)); err != nil || ok {
+		t.Fatalf("expected the tree to not be written, ok: %v, err: %v", ok, err)
+	}
+}
+
+func TestMerkleDB_PutFresh(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(4)
+	anchor := foo.MerkleRoot(hFn)
+	if report, err := mdb.Put(1, foo, hFn, WithFresh()); err != nil || report.NewNodes != 31 {
+		t.Fatalf("expected all nodes to be written, got %d, err: %v", report.NewNodes, err)
+	}
+	out, err := mdb.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+	// without checks the stored nodes are written again
+	if report, err := mdb.Put(2, foo, hFn, WithFresh()); err != nil || report.ReusedNodes != 0 || report.NewNodes != 31 {
+		t.Fatalf("expected no reused nodes, got %+v, err: %v", report, err)
+	}
+	if out, err := mdb.Get(RootGindex, anchor); err != nil || out.Slot != 2 {
+		t.Fatalf("expected the slot of the last put, got %d, err: %v", out.Slot, err)
+	}
+
+	// streams skip the checks of the received nodes too
+	nodes := collectStream(t, TreeSource(foo, hFn))
+	if report, err := mdb.PutStream(3, anchor, &nodes, hFn, WithFresh()); err != nil || report.ReusedNodes != 0 || report.NewNodes != 31 {
+		t.Fatalf("expected no reused nodes, got %+v, err: %v", report, err)
+	}
+}
+
+func BenchmarkMerkleDB_Put(b *testing.B) {
+	benchmarkPut(b)
+}
+
+func BenchmarkMerkleDB_PutFresh(b *testing.B) {
+	benchmarkPut(b, WithFresh())
+}
+
+func benchmarkPut(b *testing.B, opts ...PutOption) {
+	hFn := GetHashFn()
+	trees := make([]Node, b.N)
+	for i := range trees {
+		trees[i] = randomTree(10)
+		trees[i].MerkleRoot(hFn)
+	}
+	mdb := New(testPrefix, newMemoryDB())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mdb.Put(uint64(i), trees[i], hFn, opts...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleDB_PutMetrics(t *testing.T) {
+	var reports []InsertReport
+	mdb := New(testPrefix, newMemoryDB(), WithPutHook(func(report InsertReport) {
+		reports = append(reports, report)
+	}))
+	hFn := GetHashFn()
+	node := fullTree(4)
+	report, err := mdb.Put(1, node, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 31 nodes, the anchor, and the delete of the trimmed fields of the anchor
+	if report.Keys != 33 {
+		t.Fatalf("expected 33 keys, got %d", report.Keys)
+	}
+	if report.HashTime <= 0 || report.WriteTime <= 0 {
+		t.Fatalf("expected the hashing and writing to be timed: %+v", report)
+	}
+	if report.BytesWritten == 0 {
+		t.Fatal("expected the batch size")
+	}
+	stream, err := mdb.PutStream(2, node.MerkleRoot(hFn), TreeSource(node, hFn), hFn, WithFresh())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Keys != 33 || stream.HashTime <= 0 {
+		t.Fatalf("unexpected stream report: %+v", stream)
+	}
+	if len(reports) != 2 || reports[0] != report || reports[1] != stream {
+		t.Fatalf("expected the hook to get the reports: %+v", reports)
+	}
+
+	// failed puts are not reported
+	failed := func(b *leveldb.Batch) error {
+		return errors.New("failed")
+	}
+	if _, err := mdb.Put(3, randomTree(2), hFn, WithCommit(failed)); err == nil {
+		t.Fatal("expected the put to fail")
+	}
+	if len(reports) != 2 {
+		t.Fatal("expected no report of a failed put")
+	}
+}
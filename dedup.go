@@ -0,0 +1,110 @@
+package merkledb
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DedupStrategy decides for every child of a pair that Put writes if it checks whether the child is stored already.
+// A stored child is reused with its subtree. A child that is not checked is written, with its subtree,
+// which rewrites the nodes that are stored already with the slot of the Put.
+// The strategy is used by concurrent puts.
+type DedupStrategy interface {
+	// Probe is true if Put checks the child with the node key at the depth, the root is at depth 0
+	Probe(key []byte, depth uint32) bool
+	// Stored is called with the key of every node that Put adds to its batch
+	Stored(key []byte)
+}
+
+type alwaysProbe struct{}
+
+func (alwaysProbe) Probe(key []byte, depth uint32) bool { return true }
+func (alwaysProbe) Stored(key []byte)                   {}
+
+type neverProbe struct{}
+
+func (neverProbe) Probe(key []byte, depth uint32) bool { return false }
+func (neverProbe) Stored(key []byte)                   {}
+
+type probeAboveDepth uint32
+
+func (d probeAboveDepth) Probe(key []byte, depth uint32) bool { return depth <= uint32(d) }
+func (probeAboveDepth) Stored(key []byte)                     {}
+
+// freshProbe checks no child, and keeps the configured strategy informed of the put nodes
+type freshProbe struct {
+	DedupStrategy
+}
+
+func (freshProbe) Probe(key []byte, depth uint32) bool { return false }
+
+// AlwaysProbe checks every child, and writes no node that is stored already. The default.
+var AlwaysProbe DedupStrategy = alwaysProbe{}
+
+// NeverProbe checks no child, and writes every node, e.g. for a backfill into an empty archive.
+var NeverProbe DedupStrategy = neverProbe{}
+
+// ProbeAboveDepth checks the children up to the given depth, and writes all nodes below it.
+// Subtrees that did not change between puts are reused as a whole near the root, and the many
+// existence checks of small deep subtrees are avoided.
+func ProbeAboveDepth(depth uint32) DedupStrategy {
+	return probeAboveDepth(depth)
+}
+
+// BloomProbe checks only the children that may have been put before through the same strategy, by an in-memory
+// bloom filter of the keys of the put nodes. Other children are written without check. Stored nodes that the filter
+// does not know are rewritten once. That suits a head-following process, which puts trees that share most nodes
+// with the trees it put before.
+type BloomProbe struct {
+	lock   sync.RWMutex
+	bits   []uint64
+	hashes uint32
+}
+
+// NewBloomProbe creates a bloom filter of the given number of bits, rounded up to a multiple of 64,
+// with the given number of hash functions per key.
+func NewBloomProbe(bits uint64, hashes uint32) *BloomProbe {
+	if hashes == 0 {
+		hashes = 1
+	}
+	words := (bits + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &BloomProbe{bits: make([]uint64, words), hashes: hashes}
+}
+
+// positions derives the two hashes of double hashing
+func (p *BloomProbe) positions(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	a := h.Sum64()
+	// the second hash is derived from the first, it must be odd to reach all bits
+	b := (a>>33 | a<<31) | 1
+	return a, b
+}
+
+func (p *BloomProbe) Probe(key []byte, depth uint32) bool {
+	a, b := p.positions(key)
+	n := uint64(len(p.bits)) * 64
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for i := uint32(0); i < p.hashes; i++ {
+		bit := (a + uint64(i)*b) % n
+		if p.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *BloomProbe) Stored(key []byte) {
+	a, b := p.positions(key)
+	n := uint64(len(p.bits)) * 64
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i := uint32(0); i < p.hashes; i++ {
+		bit := (a + uint64(i)*b) % n
+		p.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
@@ -0,0 +1,65 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Dedup(t *testing.T) {
+	hFn := GetHashFn()
+	base := fullTree(3).(*PairNode)
+	// shares the left subtree of the base at depth 1, and a subtree of its right at depth 2
+	next := NewPairNode(base.LeftChild, NewPairNode(base.RightChild.(*PairNode).LeftChild, fullTree(2)))
+	for _, c := range []struct {
+		name   string
+		dedup  DedupStrategy
+		reused int
+	}{
+		{"always", AlwaysProbe, 2},
+		{"default", nil, 2},
+		{"never", NeverProbe, 0},
+		{"depth 1", ProbeAboveDepth(1), 1},
+		{"depth 2", ProbeAboveDepth(2), 2},
+		// the filter does not know the base, which was put without it
+		{"bloom", NewBloomProbe(1<<12, 3), 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ldb := newMemoryDB()
+			if _, err := New(testPrefix, ldb).Put(1, base, hFn); err != nil {
+				t.Fatal(err)
+			}
+			mdb := New(testPrefix, ldb, WithDedup(c.dedup))
+			report, err := mdb.Put(2, next, hFn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.ReusedNodes != c.reused {
+				t.Fatalf("expected %d reused nodes, got %d", c.reused, report.ReusedNodes)
+			}
+			anchor := next.MerkleRoot(hFn)
+			out, err := mdb.Get(RootGindex, anchor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareNodes(next, out.Node, RootGindex, hFn, t)
+		})
+	}
+}
+
+func TestBloomProbe(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithDedup(NewBloomProbe(1<<12, 3)))
+	base := fullTree(3).(*PairNode)
+	if report, err := mdb.Put(1, base, hFn); err != nil || report.NewNodes != 15 {
+		t.Fatalf("expected all nodes to be new, got %d, err: %v", report.NewNodes, err)
+	}
+	// the filter knows the nodes it put, and finds them stored
+	next := NewPairNode(base.LeftChild, fullTree(2))
+	report, err := mdb.Put(2, next, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReusedNodes != 1 || report.NewNodes != 8 {
+		t.Fatalf("expected the left subtree to be reused, got %d reused and %d new", report.ReusedNodes, report.NewNodes)
+	}
+}
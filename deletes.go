@@ -0,0 +1,68 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync/atomic"
+)
+
+// DefaultDeleteBatchSize is the number of deletions per write batch of Prune and Reclaim, if not configured
+const DefaultDeleteBatchSize = 10_000
+
+// deleteWriter writes deletions in chunks of at most the configured batch size,
+// and compacts the key range of each written chunk if configured.
+// Keys are expected in sorted order, to keep every chunk a narrow key range for the LSM.
+type deleteWriter struct {
+	db    *merkleDB
+	b     *leveldb.Batch
+	first []byte
+	last  []byte
+	// deletes is the number of deletions in the batch, which may hold a checkpoint as well
+	deletes int
+	// checkpoint adds a record of the progress to every chunk, with the last deleted key, if not nil
+	checkpoint func(b *leveldb.Batch, last []byte)
+}
+
+func (db *merkleDB) newDeleteWriter() *deleteWriter {
+	return &deleteWriter{db: db, b: new(leveldb.Batch)}
+}
+
+func (w *deleteWriter) delete(key []byte) error {
+	if w.b.Len() == 0 {
+		w.first = append(w.first[:0], key...)
+	}
+	w.last = append(w.last[:0], key...)
+	w.b.Delete(key)
+	w.deletes++
+	size := w.db.opts.DeleteBatchSize
+	if size <= 0 {
+		size = DefaultDeleteBatchSize
+	}
+	if w.b.Len() >= size {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *deleteWriter) flush() error {
+	if w.b.Len() == 0 {
+		return nil
+	}
+	if w.checkpoint != nil {
+		w.checkpoint(w.b, w.last)
+	}
+	if err := w.db.write(w.b); err != nil {
+		return err
+	}
+	atomic.AddUint64(&w.db.counters.deletedKeys, uint64(w.deletes))
+	w.b.Reset()
+	w.deletes = 0
+	if w.db.opts.CompactDeletes {
+		// the range limit is exclusive, the last key is included by extending it
+		limit := append(w.last, 0)
+		if err := w.db.db.CompactRange(util.Range{Start: w.first, Limit: limit}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_PruneChunked(t *testing.T) {
+	hFn := GetHashFn()
+	a := randomTree(8)
+	aLeft, _ := a.Left()
+	b := NewPairNode(aLeft, randomTree(6))
+	c := randomTree(7)
+	var counts []int
+	for _, opts := range [][]Option{
+		nil,
+		{WithDeleteBatchSize(3)},
+		{WithDeleteBatchSize(1), WithCompactDeletes()},
+		{WithDeleteBatchSize(2), WithCompactDeletes(), WithDeferredDeletes(0)},
+	} {
+		mdb := New(testPrefix, newMemoryDB(), opts...).(*merkleDB)
+		for i, n := range []Node{a, b, c} {
+			if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := mdb.Prune([]Root{b.MerkleRoot(hFn)}); err != nil {
+			t.Fatal(err)
+		}
+		if mdb.opts.DeferredDeletes {
+			if _, err := mdb.Reclaim(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out, err := mdb.Get(RootGindex, b.MerkleRoot(hFn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareNodes(b, out.Node, RootGindex, hFn, t)
+		for _, n := range []Node{a, c} {
+			if ok, err := mdb.Has(RootGindex, n.MerkleRoot(hFn)); err != nil {
+				t.Fatal(err)
+			} else if ok {
+				t.Fatal("expected pruned tree to be gone")
+			}
+		}
+		counts = append(counts, countKeys(t, mdb))
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] != counts[0] {
+			t.Fatalf("chunked prune %d left %d keys, expected %d", i, counts[i], counts[0])
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpOptions configures Dump
+type DumpOptions struct {
+	// Gindex limits the dump to the nodes at the position of the gindex, if not nil. Metadata is left out then.
+	Gindex Gindex
+	// Limit is the maximum number of dumped records. Unbounded if 0.
+	Limit int
+	// Raw adds the hex-encoded key and value to every record
+	Raw bool
+}
+
+// Dump prints every record under the prefix, one line per record, in key order.
+// Node records show the gindex, its bit length, the root, the node type, the slot, and the children of pairs.
+// Anchors, refs, pins, tombstones, repair marks, trimmed trees, blobs, SSZ encodings, value pointers, index entries, idempotency keys, audit records, the lease and the metadata block are decoded too, other metadata is printed as hex.
+// Records that cannot be decoded are printed as corrupt, and the dump continues.
+// It returns the number of dumped records.
+func Dump(db *leveldb.DB, prefix [prefixLen]byte, w io.Writer, opts DumpOptions) (int, error) {
+	scan := prefix[:]
+	if opts.Gindex != nil {
+		data, bitLen := opts.Gindex.LeftAlignedBigEndian()
+		if len(data) > maxGindexByteLen {
+			return 0, errGindexTooDeep
+		}
+		scan = make([]byte, prefixLen+gindexLenByteLen+len(data))
+		copy(scan, prefix[:])
+		binary.LittleEndian.PutUint16(scan[prefixLen:], uint16(bitLen))
+		copy(scan[prefixLen+gindexLenByteLen:], data)
+	}
+	iter := db.NewIterator(util.BytesPrefix(scan), nil)
+	defer iter.Release()
+	n := 0
+	for iter.Next() {
+		if opts.Limit > 0 && n >= opts.Limit {
+			break
+		}
+		line := dumpRecord(iter.Key(), iter.Value())
+		if opts.Raw {
+			line += fmt.Sprintf(" key=%x value=%x", iter.Key(), iter.Value())
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return n, err
+		}
+		n += 1
+	}
+	return n, iter.Error()
+}
+
+func dumpRecord(key []byte, value []byte) string {
+	if kind, ok := metaKind(key); ok {
+		return dumpMeta(kind, key[metaKeyLen:], value)
+	}
+	k, err := ParseNodeKey(key)
+	if err != nil {
+		return fmt.Sprintf("corrupt node: %v", err)
+	}
+	rec, err := ParseNodeValue(value)
+	if err != nil {
+		return fmt.Sprintf("corrupt node gindex=%d bits=%d root=%s: %v", k.Gindex, k.BitLen, k.Root, err)
+	}
+	if rec.Pair {
+		typ := "pair"
+		if rec.Partial {
+			typ = "partial"
+		}
+		return fmt.Sprintf("node gindex=%d bits=%d root=%s type=%s slot=%d left=%s right=%s",
+			k.Gindex, k.BitLen, k.Root, typ, rec.Slot, rec.Left, rec.Right)
+	}
+	if rec.Summary {
+		return fmt.Sprintf("node gindex=%d bits=%d root=%s type=summary slot=%d", k.Gindex, k.BitLen, k.Root, rec.Slot)
+	}
+	return fmt.Sprintf("node gindex=%d bits=%d root=%s type=leaf slot=%d", k.Gindex, k.BitLen, k.Root, rec.Slot)
+}
+
+func dumpMeta(kind byte, id []byte, value []byte) string {
+	switch kind {
+	case metaAnchor, metaHidden:
+		if len(id) != 32 {
+			return fmt.Sprintf("corrupt anchor: root of %d bytes", len(id))
+		}
+		var a Anchor
+		if err := a.decode(toRoot(id), value); err != nil {
+			return fmt.Sprintf("corrupt anchor: %v", err)
+		}
+		inserted := "none"
+		if !a.InsertedAt.IsZero() {
+			inserted = a.InsertedAt.UTC().Format(time.RFC3339Nano)
+		}
+		label := "anchor"
+		if kind == metaHidden {
+			label = "hidden anchor"
+		}
+		return fmt.Sprintf("%s root=%s slot=%d inserted=%s canonical=%v parent=%s provenance=%s", label,
+			a.Root, a.Slot, inserted, a.Canonical, a.Parent, a.Provenance)
+	case metaRef:
+		if len(value) != 32 {
+			return fmt.Sprintf("corrupt ref name=%s: root of %d bytes", strconv.Quote(string(id)), len(value))
+		}
+		return fmt.Sprintf("ref name=%s root=%s", strconv.Quote(string(id)), toRoot(value))
+	case metaPin:
+		if len(id) != 32 {
+			return fmt.Sprintf("corrupt pin: root of %d bytes", len(id))
+		}
+		return fmt.Sprintf("pin root=%s", toRoot(id))
+	case metaTags:
+		if len(id) != 32 {
+			return fmt.Sprintf("corrupt tags: root of %d bytes", len(id))
+		}
+		tags, err := decodeTags(value)
+		if err != nil {
+			return fmt.Sprintf("corrupt tags root=%s: %v", toRoot(id), err)
+		}
+		quoted := make([]string, len(tags))
+		for i, tag := range tags {
+			quoted[i] = strconv.Quote(tag)
+		}
+		return fmt.Sprintf("tags root=%s tags=%s", toRoot(id), strings.Join(quoted, ","))
+	case metaTombstone:
+		return dumpNodeMeta("tombstone", id)
+	case metaRepair:
+		return dumpNodeMeta("repair", id)
+	case metaTrimmed:
+		if len(id) != 32 || len(value) < 1 || (len(value)-1)%8 != 0 {
+			return fmt.Sprintf("corrupt trimmed: id of %d bytes, value of %d bytes", len(id), len(value))
+		}
+		fields := make([]string, 0, (len(value)-1)/8)
+		for i := 1; i < len(value); i += 8 {
+			fields = append(fields, strconv.FormatUint(binary.LittleEndian.Uint64(value[i:]), 10))
+		}
+		return fmt.Sprintf("trimmed root=%s fields=%s", toRoot(id), strings.Join(fields, ","))
+	case metaPrefix:
+		var m PrefixMetadata
+		if err := m.decode(value); err != nil {
+			return fmt.Sprintf("corrupt metadata: %v", err)
+		}
+		return fmt.Sprintf("metadata created=%s schema=%d hash=%s layout=%d deferred=%v profile=%s ttl=%s ttl_slots=%d refs_only=%v",
+			m.Created.UTC().Format(time.RFC3339Nano), m.SchemaVersion, m.HashName, m.KeyLayout, m.DeferredDeletes,
+			strconv.Quote(string(m.Profile)), m.TTL, m.TTLSlots, m.RetainRefsOnly)
+	case metaBlob:
+		if len(id) != 32 {
+			return fmt.Sprintf("corrupt blob: root of %d bytes", len(id))
+		}
+		return fmt.Sprintf("blob root=%s size=%d", toRoot(id), len(value))
+	case metaSSZ:
+		if len(id) != 32+8 {
+			return fmt.Sprintf("corrupt ssz: id of %d bytes", len(id))
+		}
+		var r SSZRecord
+		if err := r.decode(value); err != nil {
+			return fmt.Sprintf("corrupt ssz: %v", err)
+		}
+		return fmt.Sprintf("ssz anchor=%s gindex=%d type=%s size=%d",
+			toRoot(id[:32]), binary.BigEndian.Uint64(id[32:]), strconv.Quote(r.Type), len(r.Data))
+	case metaValuePointer:
+		var p valuePointer
+		if len(id) < 1 || p.decode(value) != nil {
+			return fmt.Sprintf("corrupt value pointer: id of %d bytes, value of %d bytes", len(id), len(value))
+		}
+		return fmt.Sprintf("value pointer kind=%c id=%x file=%d offset=%d size=%d", id[0], id[1:], p.file, p.offset, p.size)
+	case metaIndex:
+		if len(id) < 1 || len(id) < 1+int(id[0])+indexEntryTail {
+			return fmt.Sprintf("corrupt index entry: id of %d bytes", len(id))
+		}
+		tail := id[len(id)-indexEntryTail:]
+		return fmt.Sprintf("index name=%s key=%x position=%d gindex=%d root=%s", strconv.Quote(string(id[1:1+id[0]])),
+			id[1+id[0]:len(id)-indexEntryTail], binary.BigEndian.Uint64(tail[:8]), binary.BigEndian.Uint64(tail[8:16]), toRoot(tail[16:]))
+	case metaHistory:
+		if len(id) != historyIDLen || len(value) != 32 {
+			return fmt.Sprintf("corrupt history entry: id of %d bytes, leaf of %d bytes", len(id), len(value))
+		}
+		return fmt.Sprintf("history gindex=%d slot=%d anchor=%s leaf=%s", binary.BigEndian.Uint64(id[:8]),
+			binary.BigEndian.Uint64(id[8:16]), toRoot(id[16:]), toRoot(value))
+	case metaLease:
+		var l Lease
+		if err := l.decode(value); err != nil {
+			return fmt.Sprintf("corrupt lease: %v", err)
+		}
+		return fmt.Sprintf("lease owner=%x pid=%d host=%s acquired=%s heartbeat=%s", l.Owner, l.PID, strconv.Quote(l.Host),
+			l.Acquired.UTC().Format(time.RFC3339Nano), l.Heartbeat.UTC().Format(time.RFC3339Nano))
+	case metaIdempotency:
+		var p IdempotentPut
+		if err := p.decode(string(id), value); err != nil {
+			return fmt.Sprintf("corrupt idempotency key: %v", err)
+		}
+		return fmt.Sprintf("idempotency key=%s root=%s slot=%d at=%s",
+			strconv.Quote(p.Key), p.Root, p.Slot, p.At.UTC().Format(time.RFC3339Nano))
+	case metaAudit:
+		if len(id) != 8 {
+			return fmt.Sprintf("corrupt audit: id of %d bytes", len(id))
+		}
+		var r AuditRecord
+		if err := r.decode(binary.BigEndian.Uint64(id), value); err != nil {
+			return fmt.Sprintf("corrupt audit: %v", err)
+		}
+		return fmt.Sprintf("audit seq=%d op=%s time=%s slot=%d root=%s gindex=%d count=%d",
+			r.Seq, r.Op, r.Time.UTC().Format(time.RFC3339Nano), r.Slot, r.Root, r.Gindex, r.Count)
+	default:
+		return fmt.Sprintf("meta kind=%s id=%x value=%x", strconv.QuoteRune(rune(kind)), id, value)
+	}
+}
+
+// dumpNodeMeta prints metadata with a node key, without prefix, as id
+func dumpNodeMeta(name string, id []byte) string {
+	if len(id) < gindexLenByteLen+32 {
+		return fmt.Sprintf("corrupt %s: key too short", name)
+	}
+	bitLen := uint32(binary.LittleEndian.Uint16(id[:gindexLenByteLen]))
+	gindex, err := lite.GindexFromKey(id[gindexLenByteLen:len(id)-32], bitLen)
+	if err != nil {
+		return fmt.Sprintf("corrupt %s: %v", name, err)
+	}
+	return fmt.Sprintf("%s gindex=%d bits=%d root=%s", name, gindex, bitLen, toRoot(id[len(id)-32:]))
+}
+
+func toRoot(b []byte) (out Root) {
+	copy(out[:], b)
+	return
+}
@@ -0,0 +1,73 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb)
+	left, right := randomRoot(), randomRoot()
+	node := NewPairNode(left, right)
+	hFn := GetHashFn()
+	root := node.MerkleRoot(hFn)
+	if _, err := mdb.Put(3, node, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SetRef(HeadRef, root); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	n, err := Dump(ldb, testPrefix, &out, DumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if n != 5 || len(lines) != 5 {
+		t.Fatalf("expected 5 records, got %d: %s", n, out.String())
+	}
+	// metadata sorts before the nodes, the anchor before the ref
+	expected := []string{
+		"anchor root=" + root.String() + " slot=3 inserted=none canonical=false parent=",
+		"ref name=\"head\" root=" + root.String(),
+		"node gindex=1 bits=1 root=" + root.String() + " type=pair slot=3 left=" + left.String(),
+		"node gindex=2 bits=2 ",
+		"node gindex=3 bits=2 ",
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("line %d: expected prefix %q, got %q", i, prefix, lines[i])
+		}
+	}
+
+	out.Reset()
+	if n, err := Dump(ldb, testPrefix, &out, DumpOptions{Gindex: LeftGindex, Raw: true}); err != nil {
+		t.Fatal(err)
+	} else if n != 1 || !strings.Contains(out.String(), " type=leaf slot=3 key=") {
+		t.Fatalf("unexpected dump of the left node: %s", out.String())
+	}
+	out.Reset()
+	if n, err := Dump(ldb, testPrefix, &out, DumpOptions{Limit: 2}); err != nil || n != 2 {
+		t.Fatalf("expected the limit to be respected, got %d, err: %v", n, err)
+	}
+
+	// corrupt records do not stop the dump
+	var buf [maxKeyLen]byte
+	k, err := mdb.(*merkleDB).buildKey(&buf, RightGindex, *right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Put(k, []byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if n, err := Dump(ldb, testPrefix, &out, DumpOptions{}); err != nil || n != 5 {
+		t.Fatalf("expected 5 records, got %d, err: %v", n, err)
+	}
+	if !strings.Contains(out.String(), "corrupt node gindex=3 bits=2 ") {
+		t.Fatalf("expected the corrupt node in the dump: %s", out.String())
+	}
+}
@@ -0,0 +1,140 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"io"
+)
+
+// e2store entry types, as used in era files
+var (
+	e2Version         = [2]byte{0x65, 0x32}
+	e2CompressedBlock = [2]byte{0x01, 0x00}
+	e2CompressedState = [2]byte{0x02, 0x00}
+	e2SlotIndex       = [2]byte{0x69, 0x32}
+)
+
+const e2HeaderLen = 8
+
+// EraOptions configures how an era file is ingested
+type EraOptions struct {
+	// StateType is the SSZ type of the era state, e.g. the beacon state of the fork of the era
+	StateType view.TypeDef
+	// OnBlock is called with the SSZ of every signed block in the era. Blocks are skipped if nil.
+	OnBlock func(ssz []byte) error
+	// MaxEntrySize bounds the decompressed size of an entry. Defaults to the max size of the state type.
+	MaxEntrySize uint64
+}
+
+// EraReport describes what was ingested from an era file
+type EraReport struct {
+	StateRoot Root
+	StateSlot uint64
+	Blocks    int
+	Insert    InsertReport
+}
+
+func readE2Entry(r io.Reader) (typ [2]byte, data []byte, err error) {
+	var header [e2HeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return typ, nil, err
+	}
+	copy(typ[:], header[0:2])
+	length := binary.LittleEndian.Uint32(header[2:6])
+	if header[6] != 0 || header[7] != 0 {
+		return typ, nil, errors.New("e2store entry has non-zero reserved bytes")
+	}
+	data = make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return typ, nil, fmt.Errorf("truncated e2store entry: %v", err)
+	}
+	return typ, data, nil
+}
+
+func decompressE2(data []byte, max uint64) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(snappy.NewReader(bytes.NewReader(data)), readLimit(max)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(out)) > max {
+		return nil, fmt.Errorf("decompressed entry is larger than %d bytes", max)
+	}
+	return out, nil
+}
+
+// IngestEra reads an era file (e2store format), and Puts its state, deduplicated against the trees that are already stored.
+// The state is stored at the slot of the state slot index, the last entry of the era file.
+func IngestEra(db TreeWriter, r io.Reader, opts EraOptions, fn HashFn) (*EraReport, error) {
+	if opts.StateType == nil {
+		return nil, errors.New("no state type")
+	}
+	max := opts.MaxEntrySize
+	if max == 0 {
+		max = opts.StateType.MaxByteLength()
+	}
+	typ, _, err := readE2Entry(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version entry: %v", err)
+	}
+	if typ != e2Version {
+		return nil, fmt.Errorf("not an era file, first entry has type %x", typ)
+	}
+	report := new(EraReport)
+	var state Node
+	var lastIndex []byte
+	for {
+		typ, data, err := readE2Entry(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case e2CompressedBlock:
+			report.Blocks += 1
+			if opts.OnBlock == nil {
+				continue
+			}
+			block, err := decompressE2(data, max)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %v", report.Blocks-1, err)
+			}
+			if err := opts.OnBlock(block); err != nil {
+				return nil, fmt.Errorf("block %d: %v", report.Blocks-1, err)
+			}
+		case e2CompressedState:
+			if state != nil {
+				return nil, errors.New("era file has more than one state")
+			}
+			raw, err := decompressE2(data, max)
+			if err != nil {
+				return nil, fmt.Errorf("state: %v", err)
+			}
+			v, err := opts.StateType.Deserialize(codecReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("failed to deserialize state: %v", err)
+			}
+			state = v.Backing()
+		case e2SlotIndex:
+			lastIndex = data
+		}
+	}
+	if state == nil {
+		return nil, errors.New("era file has no state")
+	}
+	// the state slot index has a single offset: starting-slot ++ offset ++ count
+	if len(lastIndex) != 8*3 || binary.LittleEndian.Uint64(lastIndex[16:24]) != 1 {
+		return nil, errors.New("era file does not end with a state slot index")
+	}
+	report.StateSlot = binary.LittleEndian.Uint64(lastIndex[0:8])
+	report.StateRoot = state.MerkleRoot(fn)
+	if report.Insert, err = db.Put(report.StateSlot, state, fn); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
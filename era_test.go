@@ -0,0 +1,90 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/golang/snappy"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"math"
+	"testing"
+)
+
+func writeE2Entry(t *testing.T, w *bytes.Buffer, typ [2]byte, data []byte) {
+	var header [e2HeaderLen]byte
+	copy(header[0:2], typ[:])
+	binary.LittleEndian.PutUint32(header[2:6], uint32(len(data)))
+	w.Write(header[:])
+	w.Write(data)
+}
+
+func compressE2(t *testing.T, data []byte) []byte {
+	var out bytes.Buffer
+	w := snappy.NewBufferedWriter(&out)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestIngestEra(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 8192, 12)
+	var stateSSZ bytes.Buffer
+	if err := state.Serialize(codec.NewEncodingWriter(&stateSSZ)); err != nil {
+		t.Fatal(err)
+	}
+	var era bytes.Buffer
+	writeE2Entry(t, &era, e2Version, nil)
+	writeE2Entry(t, &era, e2CompressedBlock, compressE2(t, []byte("block 0")))
+	writeE2Entry(t, &era, e2CompressedBlock, compressE2(t, []byte("block 1")))
+	writeE2Entry(t, &era, e2CompressedState, compressE2(t, stateSSZ.Bytes()))
+	var index [24]byte
+	binary.LittleEndian.PutUint64(index[0:8], 8192)
+	binary.LittleEndian.PutUint64(index[16:24], 1)
+	writeE2Entry(t, &era, e2SlotIndex, index[:])
+
+	var blocks []string
+	report, err := IngestEra(mdb, &era, EraOptions{
+		StateType: testStateType,
+		OnBlock: func(ssz []byte) error {
+			blocks = append(blocks, string(ssz))
+			return nil
+		},
+		MaxEntrySize: 1 << 20,
+	}, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.StateSlot != 8192 || report.StateRoot != state.HashTreeRoot(hFn) || report.Blocks != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(blocks) != 2 || blocks[1] != "block 1" {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	out, err := mdb.Get(RootGindex, report.StateRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Slot != 8192 {
+		t.Fatalf("unexpected slot: %d", out.Slot)
+	}
+}
+
+func TestDecompressE2_LargeLimit(t *testing.T) {
+	data := []byte("an entry of a type with a maximum length over math.MaxInt64")
+	out, err := decompressE2(compressE2(t, data), math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("unexpected entry: %q", out)
+	}
+	if _, err := decompressE2(compressE2(t, data), uint64(len(data)-1)); err == nil {
+		t.Fatal("expected an entry over the maximum to fail")
+	}
+}
@@ -0,0 +1,116 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"time"
+)
+
+func (db *merkleDB) expired(a *Anchor, now time.Time, head uint64) bool {
+	if db.opts.RetainRefsOnly {
+		return true
+	}
+	if db.opts.TTL > 0 && !a.InsertedAt.IsZero() && now.Sub(a.InsertedAt) > db.opts.TTL {
+		return true
+	}
+	if db.opts.TTLSlots > 0 && head-a.Slot > db.opts.TTLSlots {
+		return true
+	}
+	return false
+}
+
+func (db *merkleDB) Expire() (int, error) {
+	if db.opts.TTL == 0 && db.opts.TTLSlots == 0 && !db.opts.RetainRefsOnly {
+		return 0, nil
+	}
+	// expired trees with fields that are still retained, and the trees that change by this expiry
+	partial := make(map[Root][]uint64)
+	var changed []Root
+	// the anchors are listed by the prune, a concurrent put either completes before, or waits for it
+	if err := db.prune(func() ([]Root, map[Root][]uint64, error) {
+		anchors, err := db.Anchors()
+		if err != nil {
+			return nil, nil, err
+		}
+		retained, err := db.retained()
+		if err != nil {
+			return nil, nil, err
+		}
+		var head uint64
+		for i := range anchors {
+			if anchors[i].Slot > head {
+				head = anchors[i].Slot
+			}
+		}
+		var now time.Time
+		if db.opts.Clock != nil {
+			now = db.opts.Clock()
+		}
+		live := make([]Root, 0, len(anchors))
+		for i := range anchors {
+			root := anchors[i].Root
+			// named and pinned anchors never expire
+			if _, ok := retained[root]; ok || !db.expired(&anchors[i], now, head) {
+				live = append(live, root)
+				continue
+			}
+			fields, err := db.fields(anchors[i].Slot, head)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(fields) > 0 && db.opts.DeferredDeletes {
+				live = append(live, root)
+				continue
+			}
+			if len(fields) > 0 {
+				partial[root] = fields
+				// a tree that was trimmed to the same fields before does not change
+				if prev, err := db.trimmed(root); err != nil {
+					return nil, nil, err
+				} else if sameFields(prev, fields) {
+					continue
+				}
+			}
+			changed = append(changed, root)
+		}
+		if len(changed) == 0 {
+			return nil, nil, errNoPrune
+		}
+		return live, partial, nil
+	}); err != nil {
+		return 0, err
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	b := new(leveldb.Batch)
+	for _, root := range changed {
+		if fields, ok := partial[root]; ok {
+			b.Put(db.metaKey(metaTrimmed, root[:]), encodeTrimmed(fields))
+		} else {
+			b.Delete(db.metaKey(metaTrimmed, root[:]))
+		}
+	}
+	return len(changed), db.write(b)
+}
+
+func (db *merkleDB) sweepLoop() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.opts.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := db.Expire(); err != nil && db.opts.OnBackgroundError != nil {
+				db.opts.OnBackgroundError(err)
+			}
+			if db.opts.DeferredDeletes {
+				if _, err := db.Reclaim(); err != nil && db.opts.OnBackgroundError != nil {
+					db.opts.OnBackgroundError(err)
+				}
+			}
+		case <-db.closing:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+	"time"
+)
+
+func TestMerkleDB_ExpireTTL(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	mdb := New(testPrefix, newMemoryDB(), WithClock(func() time.Time {
+		return now
+	}), WithTTL(time.Hour, 0))
+	hFn := GetHashFn()
+	old := randomTree(6)
+	if _, err := mdb.Put(1, old, hFn); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	recent := randomTree(6)
+	if _, err := mdb.Put(2, recent, hFn); err != nil {
+		t.Fatal(err)
+	}
+	n, err := mdb.Expire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 expired anchor, got %d", n)
+	}
+	if ok, _ := mdb.Has(RootGindex, old.MerkleRoot(hFn)); ok {
+		t.Fatal("expected old tree to be expired")
+	}
+	if ok, _ := mdb.Has(RootGindex, recent.MerkleRoot(hFn)); !ok {
+		t.Fatal("expected recent tree to be kept")
+	}
+}
+
+func TestMerkleDB_ExpireSlotsBackground(t *testing.T) {
+	errs := make(chan error, 1)
+	mdb := New(testPrefix, newMemoryDB(), WithTTLSlots(10, time.Millisecond), WithBackgroundErrors(func(err error) {
+		errs <- err
+	}))
+	defer mdb.Close()
+	hFn := GetHashFn()
+	old := randomTree(6)
+	if _, err := mdb.Put(100, old, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(120, randomTree(6), hFn); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case <-deadline:
+			t.Fatal("old tree was not expired in time")
+		case <-time.After(5 * time.Millisecond):
+		}
+		if ok, err := mdb.Has(RootGindex, old.MerkleRoot(hFn)); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+	}
+}
+
+func TestMerkleDB_PruneLiveSetLocked(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB()).(*merkleDB)
+	hFn := GetHashFn()
+	tree := randomTree(4)
+	put := make(chan error, 1)
+	// the live set of a prune, like the anchors listed by Expire, is computed while puts wait for the prune
+	if err := mdb.prune(func() ([]Root, map[Root][]uint64, error) {
+		go func() {
+			_, err := mdb.Put(1, tree, hFn)
+			put <- err
+		}()
+		select {
+		case err := <-put:
+			t.Fatalf("expected the put to wait for the prune, got %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return nil, nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-put; err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mdb.Has(RootGindex, tree.MerkleRoot(hFn)); err != nil || !ok {
+		t.Fatalf("expected the put after the prune to be kept, got %v, err: %v", ok, err)
+	}
+}
+
+func TestSweepInterval(t *testing.T) {
+	for _, c := range []struct {
+		opts     []Option
+		expected time.Duration
+	}{
+		{[]Option{WithTTLSlots(10, time.Second), WithDeferredDeletes(time.Minute)}, time.Second},
+		{[]Option{WithDeferredDeletes(time.Minute), WithTTL(time.Hour, time.Second)}, time.Second},
+		{[]Option{WithTTL(time.Hour, time.Second), WithDeferredDeletes(0)}, time.Second},
+		{[]Option{WithDeferredDeletes(0)}, 0},
+		{[]Option{WithDeferredDeletes(time.Second), WithProfile(ProfilePruned)}, time.Second},
+		{[]Option{WithProfile(ProfileMinimal)}, DefaultSweepInterval},
+	} {
+		var o Options
+		for _, opt := range c.opts {
+			opt(&o)
+		}
+		if o.SweepInterval != c.expected {
+			t.Fatalf("expected a sweep interval of %s, got %s", c.expected, o.SweepInterval)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package merkledb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/protolambda/merkledb/lite"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"io"
+)
+
+// The Fuzz functions are entry points for go-fuzz style fuzzers, of the decoders that are fed untrusted bytes.
+// They return 1 if the input decoded, to prioritize it, and 0 otherwise. They panic on a bug:
+// a decoder that panics, an error that does not wrap ErrMalformed, or a decoded value that does not encode back.
+
+// FuzzParseKey fuzzes ParseNodeKey
+func FuzzParseKey(data []byte) int {
+	key, err := ParseNodeKey(data)
+	if err != nil {
+		checkFuzzErr(err)
+		return 0
+	}
+	var buf [maxKeyLen]byte
+	out, err := lite.BuildKey(&buf, key.Prefix, key.Gindex, key.Root)
+	if err != nil {
+		panic(fmt.Sprintf("decoded key '%x' does not encode: %v", data, err))
+	}
+	if !bytes.Equal(out, data) {
+		panic(fmt.Sprintf("decoded key '%x' does not encode back: '%x'", data, out))
+	}
+	return 1
+}
+
+// FuzzParseValue fuzzes ParseNodeValue
+func FuzzParseValue(data []byte) int {
+	rec, err := ParseNodeValue(data)
+	if err != nil {
+		checkFuzzErr(err)
+		return 0
+	}
+	if out := appendValue(nil, &rec); !bytes.Equal(out, data) {
+		panic(fmt.Sprintf("decoded value '%x' does not encode back: '%x'", data, out))
+	}
+	return 1
+}
+
+// FuzzImport fuzzes Restore, of a backup into an empty in-memory DB, and reads the restored anchors
+func FuzzImport(data []byte) int {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		panic(err)
+	}
+	mdb := New([prefixLen]byte{}, ldb)
+	defer mdb.Close()
+	if _, err := mdb.Restore(bytes.NewReader(data)); err != nil {
+		// the input is complete, it can only be malformed or truncated
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			checkFuzzErr(err)
+		}
+		return 0
+	}
+	anchors, err := mdb.Anchors()
+	if err != nil {
+		panic(fmt.Sprintf("restored anchors do not read back: %v", err))
+	}
+	for _, a := range anchors {
+		// the trees of a valid backup are not guaranteed to be complete, only to decode
+		if _, err := mdb.Completeness(a.Root); err != nil {
+			panic(fmt.Sprintf("restored tree %s does not read back: %v", a.Root, err))
+		}
+	}
+	return 1
+}
+
+func checkFuzzErr(err error) {
+	if !errors.Is(err, ErrMalformed) {
+		panic(fmt.Sprintf("error does not wrap ErrMalformed: %v", err))
+	}
+}
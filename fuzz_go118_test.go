@@ -0,0 +1,52 @@
+//go:build go1.18
+// +build go1.18
+
+package merkledb
+
+import (
+	"bytes"
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func FuzzNodeKey(f *testing.F) {
+	var buf [maxKeyLen]byte
+	key, err := lite.BuildKey(&buf, testPrefix, Gindex64(12345), *randomRoot())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(append([]byte(nil), key...))
+	f.Add(metaKeyOf(testPrefix, metaAnchor, make([]byte, 32)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzParseKey(data)
+	})
+}
+
+func FuzzNodeValue(f *testing.F) {
+	f.Add(appendValue(nil, &PairRecord{Slot: 3}))
+	f.Add(appendValue(nil, &PairRecord{Slot: 4, Pair: true, Left: *randomRoot(), Right: *randomRoot()}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzParseValue(data)
+	})
+}
+
+func FuzzRestore(f *testing.F) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	tree := randomTree(3)
+	if _, err := mdb.Put(1, tree, hFn, WithTags("a")); err != nil {
+		f.Fatal(err)
+	}
+	if err := mdb.SetRef(HeadRef, tree.MerkleRoot(hFn)); err != nil {
+		f.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if _, err := mdb.Backup(&backup); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(backup.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		FuzzImport(data)
+	})
+}
@@ -0,0 +1,45 @@
+package merkledb
+
+import (
+	"bytes"
+	"errors"
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestFuzzEntryPoints(t *testing.T) {
+	var buf [maxKeyLen]byte
+	key, err := lite.BuildKey(&buf, testPrefix, Gindex64(6), *randomRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FuzzParseKey(key) != 1 || FuzzParseKey(key[:len(key)-1]) != 0 {
+		t.Fatal("unexpected result of the key fuzzer")
+	}
+	leaf := appendValue(nil, &PairRecord{Slot: 3})
+	if FuzzParseValue(leaf) != 1 || FuzzParseValue(append(leaf, 0)) != 0 {
+		t.Fatal("expected trailing bytes of a leaf value to fail")
+	}
+	if _, err := ParseNodeValue([]byte{4, 0, 0, 0, 0, 0, 0, 0, 0}); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected a malformed error, got %v", err)
+	}
+
+	mdb := New(testPrefix, newMemoryDB())
+	if _, err := mdb.Put(1, randomTree(3), GetHashFn()); err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if _, err := mdb.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	data := backup.Bytes()
+	if FuzzImport(data) != 1 || FuzzImport(data[:len(data)-1]) != 0 {
+		t.Fatal("unexpected result of the import fuzzer")
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := New(testPrefix, newMemoryDB()).Restore(bytes.NewReader(corrupt)); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected a malformed error, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// ListLengthGindex is the position of the length mix-in of a list, relative to the list root.
+const ListLengthGindex = RightGindex
+
+// ListContentsGindex is the position of the contents subtree of a list, relative to the list root.
+const ListContentsGindex = LeftGindex
+
+var errGindexTooDeep = errors.New("gindex too deep, only up to 63 bits deep gindices are supported")
+
+// DepthIndexGindex builds the gindex of the node at the given depth and index (starting at 0, from the left).
+func DepthIndexGindex(depth uint8, index uint64) (Gindex, error) {
+	return ToGindex64(index, depth)
+}
+
+// GindexDepthIndex converts a gindex back into the depth and index (starting at 0, from the left).
+func GindexDepthIndex(gindex Gindex) (depth uint32, index uint64, err error) {
+	iter, depth := gindex.BitIter()
+	if depth >= 64 {
+		return 0, 0, errGindexTooDeep
+	}
+	for {
+		right, ok := iter.Next()
+		if !ok {
+			break
+		}
+		index <<= 1
+		if right {
+			index |= 1
+		}
+	}
+	return depth, index, nil
+}
+
+// ConcatGindices joins the gindices of a path, each relative to the node of the previous, into one gindex.
+func ConcatGindices(gindices ...Gindex) (Gindex, error) {
+	out := uint64(1)
+	total := uint32(0)
+	for _, g := range gindices {
+		depth, index, err := GindexDepthIndex(g)
+		if err != nil {
+			return nil, err
+		}
+		total += depth
+		if total >= 64 {
+			return nil, errGindexTooDeep
+		}
+		out = out<<depth | index
+	}
+	return Gindex64(out), nil
+}
+
+// ContainerFieldGindex is the gindex of a field within a container of fieldCount fields.
+func ContainerFieldGindex(fieldCount uint64, fieldIndex uint64) (Gindex, error) {
+	if fieldIndex >= fieldCount {
+		return nil, fmt.Errorf("field index %d out of range, container has %d fields", fieldIndex, fieldCount)
+	}
+	return ToGindex64(fieldIndex, CoverDepth(fieldCount))
+}
+
+// VectorElementGindex is the gindex of the chunk holding the element, within a vector of the given length.
+// For vectors of basic types, multiple elements are packed per chunk. Use 1 for vectors of composite types.
+func VectorElementGindex(length uint64, elemsPerChunk uint64, index uint64) (Gindex, error) {
+	if index >= length {
+		return nil, fmt.Errorf("element index %d out of range, vector has length %d", index, length)
+	}
+	if elemsPerChunk == 0 {
+		return nil, errors.New("need at least 1 element per chunk")
+	}
+	chunks := (length + elemsPerChunk - 1) / elemsPerChunk
+	return ToGindex64(index/elemsPerChunk, CoverDepth(chunks))
+}
+
+// ListElementGindex is the gindex of the chunk holding the element, within a list of the given limit.
+// For lists of basic types, multiple elements are packed per chunk. Use 1 for lists of composite types.
+func ListElementGindex(limit uint64, elemsPerChunk uint64, index uint64) (Gindex, error) {
+	if index >= limit {
+		return nil, fmt.Errorf("element index %d out of range, list has limit %d", index, limit)
+	}
+	if elemsPerChunk == 0 {
+		return nil, errors.New("need at least 1 element per chunk")
+	}
+	chunks := (limit + elemsPerChunk - 1) / elemsPerChunk
+	elem, err := ToGindex64(index/elemsPerChunk, CoverDepth(chunks))
+	if err != nil {
+		return nil, err
+	}
+	return ConcatGindices(ListContentsGindex, elem)
+}
@@ -0,0 +1,72 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestGindexDepthIndex(t *testing.T) {
+	for _, c := range []struct {
+		depth uint8
+		index uint64
+	}{{0, 0}, {1, 1}, {3, 5}, {40, 123456}, {63, 1<<63 - 1}} {
+		g, err := DepthIndexGindex(c.depth, c.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		depth, index, err := GindexDepthIndex(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depth != uint32(c.depth) || index != c.index {
+			t.Fatalf("expected (%d, %d), got (%d, %d)", c.depth, c.index, depth, index)
+		}
+	}
+}
+
+func TestListElementGindex(t *testing.T) {
+	// a list of 2**40 composite elements: contents at 2, then 40 levels deep
+	g, err := ListElementGindex(1<<40, 1, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != Gindex64(2<<40|1234) {
+		t.Fatalf("unexpected gindex: %d", g)
+	}
+	// uint64 elements, 4 per chunk
+	g, err = ListElementGindex(1<<40, 4, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != Gindex64(2<<38|(1234/4)) {
+		t.Fatalf("unexpected gindex: %d", g)
+	}
+	if _, err := ListElementGindex(10, 1, 10); err == nil {
+		t.Fatal("expected out of range error")
+	}
+}
+
+func TestConcatGindices(t *testing.T) {
+	// field 11 of a container with 21 fields, then element 7 of a list with limit 2**40 in that field
+	field, err := ContainerFieldGindex(21, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field != Gindex64(32+11) {
+		t.Fatalf("unexpected field gindex: %d", field)
+	}
+	elem, err := ListElementGindex(1<<40, 1, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ConcatGindices(field, elem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != Gindex64(((32+11)<<41)|(2<<40|7)&(1<<41-1)) {
+		t.Fatalf("unexpected gindex: %d", g)
+	}
+	if _, err := ConcatGindices(elem, elem); err == nil {
+		t.Fatal("expected too deep error")
+	}
+}
@@ -3,6 +3,7 @@ module github.com/protolambda/merkledb
 go 1.16
 
 require (
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db
 	github.com/protolambda/ztyp v0.1.9
 	github.com/syndtr/goleveldb v1.0.0
 )
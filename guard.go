@@ -0,0 +1,67 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// maxKeyDepth is the depth of the deepest node that a key can hold
+const maxKeyDepth = maxGindexByteLen*8 - 1
+
+// DepthError is returned for a node deeper than the maximum depth, see WithMaxDepth.
+// An in-memory tree with a cycle fails with it too, instead of recursing without end.
+type DepthError struct {
+	Depth uint32
+	Max   uint32
+}
+
+func (e *DepthError) Error() string {
+	return fmt.Sprintf("node at depth %d is deeper than the maximum depth %d", e.Depth, e.Max)
+}
+
+// maxDepth is the configured maximum depth, which is never more than a key can hold
+func (db *merkleDB) maxDepth() uint32 {
+	return limitDepth(db.opts.MaxDepth)
+}
+
+func limitDepth(max uint32) uint32 {
+	if max == 0 || max > maxKeyDepth {
+		return maxKeyDepth
+	}
+	return max
+}
+
+// boundedRoot computes the root of the node at the depth, and fails for pairs below the maximum depth.
+// Pairs cache their root, like with MerkleRoot; other nodes are hashed with rootOf.
+func boundedRoot(node Node, rootOf func(Node) Root, fn HashFn, depth uint32, max uint32) (Root, error) {
+	if depth > max {
+		return Root{}, &DepthError{Depth: depth, Max: max}
+	}
+	pair, ok := node.(*PairNode)
+	if !ok || pair.Value != (Root{}) {
+		return rootOf(node), nil
+	}
+	left, err := boundedRoot(pair.LeftChild, rootOf, fn, depth+1, max)
+	if err != nil {
+		return Root{}, err
+	}
+	right, err := boundedRoot(pair.RightChild, rootOf, fn, depth+1, max)
+	if err != nil {
+		return Root{}, err
+	}
+	pair.Value = fn(left, right)
+	return pair.Value, nil
+}
+
+// checkDepth fails if the target below the node at the gindex is deeper than the maximum depth.
+// The gindex of the node is not known for decoded nodes, only the depth of the target counts then.
+func checkDepth(gindex Gindex, target Gindex, max uint32) error {
+	depth := target.Depth()
+	if gindex != nil {
+		depth += gindex.Depth()
+	}
+	if max = limitDepth(max); depth > max {
+		return &DepthError{Depth: depth, Max: max}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_PutCyclic(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	cyclic := &PairNode{RightChild: randomRoot()}
+	cyclic.LeftChild = cyclic
+	_, err := mdb.Put(1, cyclic, nil)
+	var depthErr *DepthError
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("expected a depth error, got %v", err)
+	}
+	if depthErr.Max != maxKeyDepth {
+		t.Fatalf("expected the key limit, got %d", depthErr.Max)
+	}
+}
+
+func TestMerkleDB_MaxDepth(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithMaxDepth(3))
+	hFn := GetHashFn()
+	var depthErr *DepthError
+	if _, err := mdb.Put(1, fullTree(4), hFn); !errors.As(err, &depthErr) {
+		t.Fatalf("expected a depth error, got %v", err)
+	} else if depthErr.Depth != 4 || depthErr.Max != 3 {
+		t.Fatalf("unexpected error: %v", depthErr)
+	}
+	deep := fullTree(4)
+	if _, err := mdb.PutStream(1, deep.MerkleRoot(hFn), TreeSource(deep, hFn), hFn); !errors.As(err, &depthErr) {
+		t.Fatalf("expected a depth error of the stream, got %v", err)
+	}
+
+	tree := fullTree(3)
+	if _, err := mdb.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mdb.Get(RootGindex, tree.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := got.Node.Getter(Gindex64(8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := got.Node.Getter(Gindex64(16)); !errors.As(err, &depthErr) {
+		t.Fatalf("expected a depth error of the getter, got %v", err)
+	}
+	if _, err := got.Node.Setter(Gindex64(16), true); !errors.As(err, &depthErr) {
+		t.Fatalf("expected a depth error of the setter, got %v", err)
+	}
+	// the depth counts from the root of the tree, not from the node
+	left, err := mdb.Get(RootGindex.Left(), got.Node.(VirtualNode).LeftRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := left.Node.Getter(Gindex64(8)); !errors.As(err, &depthErr) || depthErr.Depth != 4 {
+		t.Fatalf("expected a depth error at depth 4, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package merkledb
+
+import (
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// ConcurrentHashFn is a HashFn that is safe for concurrent use: every call borrows a hasher from a pool.
+// Put, PutStream, TreeSource and proof verification use it when they are given a nil HashFn.
+func ConcurrentHashFn(a Root, b Root) Root {
+	return lite.ConcurrentHashFn(a, b)
+}
+
+func hashFnOrDefault(fn HashFn) HashFn {
+	if fn == nil {
+		return ConcurrentHashFn
+	}
+	return fn
+}
@@ -0,0 +1,46 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentHashFn(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	roots := make([]Root, 8)
+	for i := range roots {
+		n := randomTree(6)
+		if _, err := mdb.Put(uint64(i), n, ConcurrentHashFn); err != nil {
+			t.Fatal(err)
+		}
+		roots[i] = n.MerkleRoot(GetHashFn())
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(roots))
+	for i := range roots {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// a nil HashFn hashes with the shared pool
+			if _, err := mdb.Put(uint64(i), randomTree(6), nil); err != nil {
+				errs <- err
+				return
+			}
+			p, err := mdb.Prove(roots[i], Gindex64(2))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !p.Verify(roots[i], nil) {
+				errs <- errors.New("expected proof to verify")
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package merkledb
+
+import (
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"math/rand"
+	"time"
+)
+
+// metaHealth is the kind of the sentinel key that Health reads. It is never written.
+const metaHealth byte = 'h'
+
+// HealthSamples is the number of random nodes that Health verifies
+const HealthSamples = 8
+
+// Probe is the outcome of one of the checks of Health
+type Probe struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Error describes the failure, empty if OK
+	Error string `json:"error,omitempty"`
+}
+
+// HealthStatus is the outcome of Health
+type HealthStatus struct {
+	Healthy bool          `json:"healthy"`
+	Probes  []Probe       `json:"probes"`
+	Took    time.Duration `json:"took"`
+}
+
+// Health runs a few cheap probes, for readiness and liveness checks:
+//   - open: the handle is not closed
+//   - read: a sentinel key can be read
+//   - nodes: HealthSamples nodes at random positions in the key space decode, and the pairs hash to their roots
+//
+// It does not return an error: every failure is recorded in the status of its probe.
+func (db *merkleDB) Health() HealthStatus {
+	start := time.Now()
+	probes := []Probe{
+		probe("open", db.probeOpen),
+		probe("read", db.probeRead),
+		probe("nodes", db.probeNodes),
+	}
+	status := HealthStatus{Healthy: true, Probes: probes}
+	for _, p := range probes {
+		status.Healthy = status.Healthy && p.OK
+	}
+	status.Took = time.Since(start)
+	return status
+}
+
+func probe(name string, fn func() error) Probe {
+	if err := fn(); err != nil {
+		return Probe{Name: name, Error: err.Error()}
+	}
+	return Probe{Name: name, OK: true}
+}
+
+func (db *merkleDB) probeOpen() error {
+	select {
+	case <-db.closing:
+		return leveldb.ErrClosed
+	default:
+		return nil
+	}
+}
+
+func (db *merkleDB) probeRead() error {
+	if _, err := db.r.Get(db.metaKey(metaHealth, nil), nil); err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (db *merkleDB) probeNodes() error {
+	iter := db.r.NewIterator(nil, nil)
+	defer iter.Release()
+	var rec PairRecord
+	for i := 0; i < HealthSamples; i++ {
+		// seek to a random position in the node keys, which follow the metadata of the prefix
+		seek := make([]byte, prefixLen+gindexLenByteLen+8)
+		copy(seek, db.prefix[:])
+		rand.Read(seek[prefixLen:])
+		if seek[prefixLen] == 0 && seek[prefixLen+1] == 0 {
+			seek[prefixLen] = 1
+		}
+		if !iter.Seek(seek) || !db.isNodeKey(iter.Key()) {
+			// wrap around to the first node, the smallest bit length bytes after those of the metadata
+			first := make([]byte, prefixLen+gindexLenByteLen)
+			copy(first, db.prefix[:])
+			first[prefixLen+1] = 1
+			if !iter.Seek(first) || !db.isNodeKey(iter.Key()) {
+				break
+			}
+		}
+		k, err := ParseNodeKey(iter.Key())
+		if err != nil {
+			return err
+		}
+		if err := parseValue(iter.Value(), &rec); err != nil {
+			return fmt.Errorf("node gindex=%d root=%s: %v", k.Gindex, k.Root, err)
+		}
+		if rec.Pair && ConcurrentHashFn(rec.Left, rec.Right) != k.Root {
+			return fmt.Errorf("node gindex=%d root=%s: children do not hash to the root", k.Gindex, k.Root)
+		}
+	}
+	return iter.Error()
+}
+
+// isNodeKey is true for the node keys of the prefix
+func (db *merkleDB) isNodeKey(key []byte) bool {
+	if len(key) < prefixLen || string(key[:prefixLen]) != string(db.prefix[:]) {
+		return false
+	}
+	_, meta := metaKind(key)
+	return !meta
+}
@@ -0,0 +1,54 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strings"
+	"testing"
+)
+
+func TestMerkleDB_Health(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb)
+	if status := mdb.Health(); !status.Healthy || len(status.Probes) != 3 {
+		t.Fatalf("expected an empty db to be healthy: %+v", status)
+	}
+	if _, err := mdb.Put(1, fullTree(1), nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := mdb.Health(); !status.Healthy {
+		t.Fatalf("expected healthy: %+v", status)
+	}
+
+	// with every node corrupt, any sample fails
+	iter := ldb.NewIterator(util.BytesPrefix(testPrefix[:]), nil)
+	var keys [][]byte
+	for iter.Next() {
+		if _, meta := metaKind(iter.Key()); !meta {
+			keys = append(keys, append([]byte(nil), iter.Key()...))
+		}
+	}
+	iter.Release()
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(keys))
+	}
+	for _, k := range keys {
+		if err := ldb.Put(k, []byte{1, 2}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status := mdb.Health()
+	if status.Healthy || !status.Probes[0].OK || !status.Probes[1].OK || status.Probes[2].OK {
+		t.Fatalf("expected only the nodes probe to fail: %+v", status)
+	}
+
+	if err := mdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status = mdb.Health()
+	if status.Healthy || status.Probes[0].OK || status.Probes[1].OK {
+		t.Fatalf("expected a closed db to be unhealthy: %+v", status)
+	}
+	if !strings.Contains(status.Probes[0].Error, "closed") {
+		t.Fatalf("unexpected error: %s", status.Probes[0].Error)
+	}
+}
@@ -0,0 +1,95 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// metaHidden stores the anchor records of hidden trees, in place of their anchor records, see Hide
+const metaHidden byte = 'H'
+
+// ErrHidden is returned by reads of the tree of a hidden anchor, and by puts of a hidden tree
+var ErrHidden = errors.New("anchor is hidden")
+
+func (db *merkleDB) Hide(root Root) error {
+	// a prune must not sweep the tree between its live roots and the hidden record
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	// the cached proofs of the anchor would still be served, also those of proofs that are running now
+	defer db.dropProofs(root)
+	return db.moveAnchor(root, metaAnchor, metaHidden, AuditHide)
+}
+
+func (db *merkleDB) Unhide(root Root) error {
+	defer db.resetHead()
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	return db.moveAnchor(root, metaHidden, metaAnchor, AuditUnhide)
+}
+
+// moveAnchor moves the anchor record of the root from one kind to the other
+func (db *merkleDB) moveAnchor(root Root, from byte, to byte, op AuditOp) error {
+	v, err := db.db.Get(db.metaKey(from, root[:]), nil)
+	if err != nil {
+		return err
+	}
+	if err := db.checkValue(from, root[:], v); err != nil {
+		return err
+	}
+	var a Anchor
+	if err := a.decode(root, v); err != nil {
+		return corruptMeta(from, root[:], v, err)
+	}
+	b := new(leveldb.Batch)
+	b.Put(db.metaKey(to, root[:]), v)
+	b.Delete(db.metaKey(from, root[:]))
+	db.audit(b, AuditRecord{Op: op, Root: root, Slot: a.Slot})
+	return db.write(b)
+}
+
+func (db *merkleDB) Hidden() ([]Anchor, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaHidden, nil)), nil)
+	defer iter.Release()
+	var out []Anchor
+	for iter.Next() {
+		if err := db.checkValue(metaHidden, iter.Key()[metaKeyLen:], iter.Value()); err != nil {
+			return nil, err
+		}
+		var a Anchor
+		if err := a.decode(toRoot(iter.Key()[metaKeyLen:]), iter.Value()); err != nil {
+			return nil, corruptMeta(metaHidden, iter.Key()[metaKeyLen:], iter.Value(), err)
+		}
+		out = append(out, a)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *merkleDB) isHidden(root Root) (bool, error) {
+	return db.r.Has(db.metaKey(metaHidden, root[:]), nil)
+}
+
+// anchored is true if the root has an anchor, hidden or not
+func (db *merkleDB) anchored(root Root) (bool, error) {
+	if ok, err := db.r.Has(db.metaKey(metaAnchor, root[:]), nil); err != nil || ok {
+		return ok, err
+	}
+	return db.isHidden(root)
+}
+
+// hiddenRoots are the roots of the hidden anchors, which prunes keep like pinned anchors
+func (db *merkleDB) hiddenRoots() ([]Root, error) {
+	hidden, err := db.Hidden()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Root, len(hidden))
+	for i := range hidden {
+		out[i] = hidden[i].Root
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Hide(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	a, b := randomTree(5), randomTree(5)
+	rootA, rootB := a.MerkleRoot(hFn), b.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Hide(rootA); err != nil {
+		t.Fatal(err)
+	}
+	expectAnchors(t, mdb, rootB)
+	if hidden, err := mdb.Hidden(); err != nil || len(hidden) != 1 || hidden[0].Root != rootA || hidden[0].Slot != 1 {
+		t.Fatalf("unexpected hidden anchors: %v, err: %v", hidden, err)
+	}
+	if _, err := mdb.GetAnchor(rootA); err == nil {
+		t.Fatal("expected no anchor of the hidden tree")
+	}
+	if _, err := mdb.Get(RootGindex, rootA); err != ErrHidden {
+		t.Fatalf("expected ErrHidden, got %v", err)
+	}
+	if _, err := mdb.Prove(rootA, Gindex64(2)); err == nil {
+		t.Fatal("expected no proof in the hidden tree")
+	}
+	if nodes, err := mdb.Range(0, 10, RootGindex); err != nil || len(nodes) != 1 || nodes[0].Node.MerkleRoot(hFn) != rootB {
+		t.Fatalf("unexpected roots in range: %v, err: %v", nodes, err)
+	}
+	if _, err := mdb.Put(3, a, hFn); err != ErrHidden {
+		t.Fatalf("expected a put of the hidden tree to fail, got %v", err)
+	}
+	if err := mdb.Hide(rootA); err == nil {
+		t.Fatal("expected hiding a hidden anchor to fail")
+	}
+
+	// the hidden tree is kept by prunes
+	if err := mdb.Prune([]Root{rootB}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Unhide(rootA); err != nil {
+		t.Fatal(err)
+	}
+	expectAnchors(t, mdb, rootA, rootB)
+	if anchor, err := mdb.GetAnchor(rootA); err != nil || anchor.Slot != 1 {
+		t.Fatalf("expected the anchor to be restored, got %v, err: %v", anchor, err)
+	}
+	if report, err := mdb.Completeness(rootA); err != nil || !report.Complete() {
+		t.Fatalf("expected the restored tree to be complete, err: %v", err)
+	}
+	if _, err := mdb.Prove(rootA, Gindex64(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Unhide(rootA); err == nil {
+		t.Fatal("expected unhiding a visible anchor to fail")
+	}
+}
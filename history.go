@@ -0,0 +1,290 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// metaHistory stores the leaves at the gindices of interest by gindex, slot and anchor, see WithCompactHistory
+const metaHistory byte = 'C'
+
+const historyIDLen = 8 + 8 + 32
+
+// ErrNoHistory is returned by PutCompact if no gindices of interest are configured, see WithCompactHistory
+var ErrNoHistory = errors.New("no compact history gindices configured")
+
+// ErrHistoryUnprovable is returned by ProveHistory when no stored anchor reconstructs the proof of the historical one
+var ErrHistoryUnprovable = errors.New("no full anchor reconstructs the proof")
+
+// historyCandidates is the number of full anchors, nearest by slot first, that ProveHistory tries
+const historyCandidates = 8
+
+// HistoryEntry is the leaf at a gindex of interest, in the tree of the anchor at the slot
+type HistoryEntry struct {
+	Slot   uint64
+	Anchor Root
+	Leaf   Root
+}
+
+func historyID(g uint64, slot uint64, anchor Root) []byte {
+	id := make([]byte, historyIDLen)
+	binary.BigEndian.PutUint64(id[:8], g)
+	binary.BigEndian.PutUint64(id[8:16], slot)
+	copy(id[16:], anchor[:])
+	return id
+}
+
+// putHistory adds the leaves at the gindices of interest of the put tree to the batch of the put
+func (db *merkleDB) putHistory(b *leveldb.Batch, root Root, slot uint64, node Node, fn HashFn, opts []PutOption) error {
+	return db.recordHistory(b, root, slot, opts, func(g uint64) (Root, error) {
+		leaf, err := node.Getter(Gindex64(g))
+		if err != nil {
+			return Root{}, err
+		}
+		return leaf.MerkleRoot(fn), nil
+	})
+}
+
+// streamHistory adds the leaves at the gindices of interest of a streamed tree to the batch of the put.
+// The paths are the received nodes on the paths to the gindices, by gindex: the stream leaves out the stored nodes.
+func (db *merkleDB) streamHistory(b *leveldb.Batch, root Root, slot uint64, paths map[uint64]StreamNode, opts []PutOption) error {
+	return db.recordHistory(b, root, slot, opts, func(g uint64) (Root, error) {
+		node, at := root, uint64(1)
+		var rec PairRecord
+		for d := bits.Len64(g) - 2; d >= 0; d-- {
+			if n, ok := paths[at]; ok {
+				rec = PairRecord{Pair: n.Pair, Left: n.Left, Right: n.Right}
+			} else if err := db.getLocal(Gindex64(at), node, &rec); err != nil {
+				return Root{}, err
+			}
+			if !rec.Pair {
+				return Root{}, leafError(&rec, Gindex64(at), node)
+			}
+			if (g>>uint(d))&1 == 1 {
+				node, at = rec.Right, at<<1|1
+			} else {
+				node, at = rec.Left, at<<1
+			}
+		}
+		return node, nil
+	})
+}
+
+// recordHistory adds the leaves at the gindices of interest to the batch of a put, with the leafAt of the put tree.
+// A tree that does not reach a gindex has no leaf to record there, e.g. a smaller tree than the gindices are meant for.
+func (db *merkleDB) recordHistory(b *leveldb.Batch, root Root, slot uint64, opts []PutOption, leafAt func(g uint64) (Root, error)) error {
+	if len(db.opts.HistoryGindices) == 0 || applyPutOptions(opts).partial {
+		return nil
+	}
+	for _, gindex := range db.opts.HistoryGindices {
+		g, err := gindexValue(gindex)
+		if err != nil {
+			return err
+		}
+		leafRoot, err := leafAt(g)
+		if errors.Is(err, NavigationError) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to find the history leaf at gindex %d: %w", g, err)
+		}
+		b.Put(db.metaKey(metaHistory, historyID(g, slot, root)), leafRoot[:])
+	}
+	return nil
+}
+
+func (db *merkleDB) PutCompact(slot uint64, node Node, fn HashFn) (InsertReport, error) {
+	if len(db.opts.HistoryGindices) == 0 {
+		return InsertReport{}, ErrNoHistory
+	}
+	fn = hashFnOrDefault(fn)
+	start := time.Now()
+	root := node.MerkleRoot(fn)
+	report := InsertReport{HashTime: time.Since(start)}
+	b := new(leveldb.Batch)
+	if err := db.putHistory(b, root, slot, node, fn, nil); err != nil {
+		return InsertReport{}, err
+	}
+	report.BytesWritten = len(b.Dump())
+	report.Keys = b.Len()
+	start = time.Now()
+	if err := db.write(b); err != nil {
+		return InsertReport{}, err
+	}
+	report.WriteTime = time.Since(start)
+	return report, nil
+}
+
+func (db *merkleDB) History(gindex Gindex, startSlot uint64, endSlot uint64) ([]HistoryEntry, error) {
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return nil, err
+	}
+	scan := util.BytesPrefix(db.metaKey(metaHistory, historyID(g, 0, Root{})[:8]))
+	scan.Start = db.metaKey(metaHistory, historyID(g, startSlot, Root{})[:16])
+	iter := db.r.NewIterator(scan, nil)
+	defer iter.Release()
+	var out []HistoryEntry
+	for iter.Next() {
+		id := iter.Key()[metaKeyLen:]
+		if len(id) != historyIDLen {
+			return nil, corruptMeta(metaHistory, id, iter.Value(), errors.New("corrupt history key"))
+		}
+		if err := db.checkValue(metaHistory, id, iter.Value()); err != nil {
+			return nil, err
+		}
+		e := HistoryEntry{Slot: binary.BigEndian.Uint64(id[8:16]), Anchor: toRoot(id[16:]), Leaf: toRoot(iter.Value())}
+		if e.Slot > endSlot {
+			break
+		}
+		out = append(out, e)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *merkleDB) ProveHistory(gindex Gindex, slot uint64, anchor Root, fn HashFn) (*MerkleProof, error) {
+	fn = hashFnOrDefault(fn)
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := db.getMeta(metaHistory, historyID(g, slot, anchor))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.GetAnchor(anchor); err == nil {
+		return db.Prove(anchor, gindex)
+	} else if err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	// the leaves of all gindices of interest of the historical tree, the other nodes are those of a full anchor
+	targets := []uint64{g}
+	leaves := map[uint64]Root{g: toRoot(leaf)}
+	for _, h := range db.opts.HistoryGindices {
+		v, err := gindexValue(h)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := leaves[v]; ok {
+			continue
+		}
+		leaf, err := db.getMeta(metaHistory, historyID(v, slot, anchor))
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		targets = append(targets, v)
+		leaves[v] = toRoot(leaf)
+	}
+	anchors, err := db.Anchors()
+	if err != nil {
+		return nil, err
+	}
+	distance := func(a *Anchor) uint64 {
+		if a.Slot > slot {
+			return a.Slot - slot
+		}
+		return slot - a.Slot
+	}
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return distance(&anchors[i]) < distance(&anchors[j])
+	})
+	if len(anchors) > historyCandidates {
+		anchors = anchors[:historyCandidates]
+	}
+	gindices := make([]Gindex, len(targets))
+	for i, v := range targets {
+		gindices[i] = Gindex64(v)
+	}
+	for i := range anchors {
+		mp, err := db.ProveMulti(anchors[i].Root, gindices)
+		if err != nil {
+			// e.g. a trimmed tree, without the nodes of the gindices
+			continue
+		}
+		for j, v := range targets {
+			mp.Leaves[j] = leaves[v]
+		}
+		if p := historyBranch(mp, targets, g, fn); p != nil && p.Verify(anchor, fn) {
+			return p, nil
+		}
+	}
+	return nil, ErrHistoryUnprovable
+}
+
+// historyBranch is the proof of the target in the tree of the multiproof, nil if the multiproof does not cover it
+func historyBranch(mp *MultiProof, targets []uint64, target uint64, fn HashFn) *MerkleProof {
+	objects := make(map[uint64]Root, 2*len(mp.Helpers))
+	for i, g := range targets {
+		objects[g] = mp.Leaves[i]
+	}
+	for i, g := range helperValues(targets) {
+		if i >= len(mp.Helpers) {
+			return nil
+		}
+		objects[g] = mp.Helpers[i]
+	}
+	keys := make([]uint64, 0, len(objects))
+	for g := range objects {
+		keys = append(keys, g)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] > keys[j]
+	})
+	// the parents are hashed bottom-up, like MultiProof.Verify
+	for pos := 0; pos < len(keys); pos++ {
+		g := keys[pos]
+		if _, ok := objects[g>>1]; ok || g <= 1 {
+			continue
+		}
+		left, okL := objects[g&^1]
+		right, okR := objects[g|1]
+		if okL && okR {
+			objects[g>>1] = fn(left, right)
+			keys = append(keys, g>>1)
+		}
+	}
+	p := &MerkleProof{Gindex: Gindex64(target), Leaf: objects[target]}
+	for g := target; g > 1; g >>= 1 {
+		sibling, ok := objects[g^1]
+		if !ok {
+			return nil
+		}
+		p.Branch = append(p.Branch, sibling)
+	}
+	return p
+}
+
+func (db *merkleDB) TrimHistory(before uint64) (int, error) {
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaHistory, nil)), nil)
+	defer iter.Release()
+	w := db.newDeleteWriter()
+	n := 0
+	for iter.Next() {
+		id := iter.Key()[metaKeyLen:]
+		if len(id) != historyIDLen {
+			return n, corruptMeta(metaHistory, id, iter.Value(), errors.New("corrupt history key"))
+		}
+		if binary.BigEndian.Uint64(id[8:16]) >= before {
+			continue
+		}
+		if err := w.delete(iter.Key()); err != nil {
+			return n, err
+		}
+		n += 1
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	return n, w.flush()
+}
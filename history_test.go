@@ -0,0 +1,135 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestCompactHistory(t *testing.T) {
+	// two leaves of interest, like the last justified and finalized checkpoint of a validator
+	gindices := []Gindex{Gindex64(17), Gindex64(29)}
+	mdb := New(testPrefix, newMemoryDB(), WithCompactHistory(gindices...))
+	hFn := GetHashFn()
+	if _, err := New(testPrefix, newMemoryDB()).PutCompact(1, fullTree(4), hFn); err != ErrNoHistory {
+		t.Fatalf("expected ErrNoHistory, got %v", err)
+	}
+	full := fullTree(4)
+	if _, err := mdb.Put(1, full, hFn); err != nil {
+		t.Fatal(err)
+	}
+	with := func(tree Node, g uint64, leaf Node) Node {
+		setter, err := tree.Setter(Gindex64(g), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := setter(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	tree := full
+	anchors := []Root{full.MerkleRoot(hFn)}
+	for slot := uint64(2); slot <= 4; slot++ {
+		tree = with(with(tree, 17, randomRoot()), 29, randomRoot())
+		report, err := mdb.PutCompact(slot, tree, hFn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Keys != 2 {
+			t.Fatalf("expected 2 history entries, got %d keys", report.Keys)
+		}
+		anchors = append(anchors, tree.MerkleRoot(hFn))
+	}
+	// only the leaves are stored, not the trees
+	if _, err := mdb.GetAnchor(anchors[2]); err == nil {
+		t.Fatal("expected no anchor of a compact put")
+	}
+
+	entries, err := mdb.History(Gindex64(29), 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Slot != 2 || entries[1].Anchor != anchors[2] {
+		t.Fatalf("unexpected history: %+v", entries)
+	}
+	leaf, err := tree.Getter(Gindex64(29))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all, err := mdb.History(Gindex64(29), 0, 10); err != nil || len(all) != 4 || all[3].Leaf != leaf.MerkleRoot(hFn) {
+		t.Fatalf("unexpected history: %+v, %v", all, err)
+	}
+
+	for slot := uint64(1); slot <= 4; slot++ {
+		anchor := anchors[slot-1]
+		for _, g := range gindices {
+			p, err := mdb.ProveHistory(g, slot, anchor, hFn)
+			if err != nil {
+				t.Fatalf("slot %d: %v", slot, err)
+			}
+			if !p.Verify(anchor, hFn) {
+				t.Fatalf("proof of slot %d does not verify", slot)
+			}
+		}
+	}
+	if _, err := mdb.ProveHistory(Gindex64(17), 5, anchors[3], hFn); err == nil {
+		t.Fatal("expected no history at slot 5")
+	}
+
+	// a tree that changed outside of the leaves of interest is not provable from the full anchor
+	other := with(tree, 20, randomRoot())
+	if _, err := mdb.PutCompact(5, other, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.ProveHistory(Gindex64(17), 5, other.MerkleRoot(hFn), hFn); err != ErrHistoryUnprovable {
+		t.Fatalf("expected ErrHistoryUnprovable, got %v", err)
+	}
+
+	n, err := mdb.TrimHistory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 trimmed entries, got %d", n)
+	}
+	if entries, err := mdb.History(Gindex64(17), 0, 10); err != nil || len(entries) != 3 || entries[0].Slot != 3 {
+		t.Fatalf("unexpected history after the trim: %+v, %v", entries, err)
+	}
+
+	// a streamed tree records its leaves too, also of the subtrees that the stream leaves out
+	streamed := with(full, 29, randomRoot())
+	var nodes sliceSource
+	// the left subtree, with gindex 17, is stored with the full tree
+	for _, n := range collectStream(t, TreeSource(streamed, hFn)) {
+		if g, err := gindexValue(n.Gindex); err != nil || !within(g, 2) {
+			nodes = append(nodes, n)
+		}
+	}
+	if _, err := mdb.PutStream(6, streamed.MerkleRoot(hFn), &nodes, hFn); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range gindices {
+		leaf, err := streamed.Getter(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries, err := mdb.History(g, 6, 6); err != nil || len(entries) != 1 || entries[0].Leaf != leaf.MerkleRoot(hFn) {
+			t.Fatalf("unexpected history of the streamed tree: %+v, %v", entries, err)
+		}
+	}
+	// a tree that does not reach a gindex records only the others
+	small := NewPairNode(fullTree(3), randomRoot())
+	if report, err := mdb.Put(7, small, hFn); err != nil || report.NewNodes != 17 {
+		t.Fatalf("expected the small tree to be put, got %+v, err: %v", report, err)
+	}
+	if entries, err := mdb.History(Gindex64(17), 7, 7); err != nil || len(entries) != 1 {
+		t.Fatalf("expected the reached gindex to be recorded, got %+v, %v", entries, err)
+	}
+	if entries, err := mdb.History(Gindex64(29), 7, 7); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no leaf of the gindex below a leaf, got %+v, %v", entries, err)
+	}
+	if _, err := New(testPrefix, newMemoryDB(), WithCompactHistory(Gindex64(40))).Put(1, fullTree(3), hFn); err != nil {
+		t.Fatalf("expected the put of a tree below the gindex to pass, got %v", err)
+	}
+}
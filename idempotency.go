@@ -0,0 +1,127 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"time"
+)
+
+// metaIdempotency records the puts by idempotency key, see WithIdempotencyKey
+const metaIdempotency byte = 'i'
+
+const idempotencyVersion = 0
+
+const idempotencyRecordLen = 1 + 32 + 8 + 8
+
+// IdempotentPut is the put that claimed an idempotency key
+type IdempotentPut struct {
+	Key  string
+	Root Root
+	Slot uint64
+	// At is the time of the put, from the Clock, or the system time if none
+	At time.Time
+}
+
+func (p *IdempotentPut) encode() []byte {
+	out := make([]byte, idempotencyRecordLen)
+	out[0] = idempotencyVersion
+	copy(out[1:33], p.Root[:])
+	binary.LittleEndian.PutUint64(out[33:41], p.Slot)
+	binary.LittleEndian.PutUint64(out[41:49], uint64(p.At.UnixNano()))
+	return out
+}
+
+func (p *IdempotentPut) decode(key string, v []byte) error {
+	if len(v) < idempotencyRecordLen {
+		return fmt.Errorf("idempotency key %q has corrupt record, too short: '%x'", key, v)
+	}
+	if v[0] != idempotencyVersion {
+		return fmt.Errorf("idempotency key %q has unknown record version: %d", key, v[0])
+	}
+	*p = IdempotentPut{
+		Key:  key,
+		Root: toRoot(v[1:33]),
+		Slot: binary.LittleEndian.Uint64(v[33:41]),
+		At:   time.Unix(0, int64(binary.LittleEndian.Uint64(v[41:49]))),
+	}
+	return nil
+}
+
+func (db *merkleDB) now() time.Time {
+	if db.opts.Clock != nil {
+		return db.opts.Clock()
+	}
+	return time.Now()
+}
+
+// claim holds the idempotency key of the put until release is called, and reports if the key was claimed already.
+// Puts without a key are not held.
+func (db *merkleDB) claim(opts []PutOption) (release func(), replayed bool, err error) {
+	key := applyPutOptions(opts).IdempotencyKey
+	if key == "" {
+		return func() {}, false, nil
+	}
+	db.idempotencyLock.Lock()
+	if _, err := db.db.Get(db.metaKey(metaIdempotency, []byte(key)), nil); err == nil {
+		db.idempotencyLock.Unlock()
+		return nil, true, nil
+	} else if err != leveldb.ErrNotFound {
+		db.idempotencyLock.Unlock()
+		return nil, false, err
+	}
+	return db.idempotencyLock.Unlock, false, nil
+}
+
+// putIdempotencyKey adds the record of the idempotency key of the put, if any, to its batch
+func (db *merkleDB) putIdempotencyKey(b *leveldb.Batch, root Root, slot uint64, opts []PutOption) {
+	key := applyPutOptions(opts).IdempotencyKey
+	if key == "" {
+		return
+	}
+	p := IdempotentPut{Key: key, Root: root, Slot: slot, At: db.now()}
+	b.Put(db.metaKey(metaIdempotency, []byte(key)), p.encode())
+}
+
+func (db *merkleDB) IdempotentPut(key string) (IdempotentPut, error) {
+	v, err := db.getMeta(metaIdempotency, []byte(key))
+	if err != nil {
+		return IdempotentPut{}, err
+	}
+	var p IdempotentPut
+	if err := p.decode(key, v); err != nil {
+		return IdempotentPut{}, corruptMeta(metaIdempotency, []byte(key), v, err)
+	}
+	return p, nil
+}
+
+func (db *merkleDB) ForgetIdempotencyKeys(before time.Time) (int, error) {
+	db.idempotencyLock.Lock()
+	defer db.idempotencyLock.Unlock()
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaIdempotency, nil)), nil)
+	defer iter.Release()
+	w := db.newDeleteWriter()
+	n := 0
+	for iter.Next() {
+		if err := db.checkValue(metaIdempotency, iter.Key()[metaKeyLen:], iter.Value()); err != nil {
+			return n, err
+		}
+		var p IdempotentPut
+		if err := p.decode(string(iter.Key()[metaKeyLen:]), iter.Value()); err != nil {
+			return n, corruptMeta(metaIdempotency, iter.Key()[metaKeyLen:], iter.Value(), err)
+		}
+		if !p.At.Before(before) {
+			continue
+		}
+		if err := w.delete(iter.Key()); err != nil {
+			return n, err
+		}
+		n += 1
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	return n, w.flush()
+}
@@ -0,0 +1,98 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+	"time"
+)
+
+func TestMerkleDB_PutIdempotencyKey(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	mdb := New(testPrefix, newMemoryDB(), WithClock(func() time.Time {
+		return now
+	}))
+	hFn := GetHashFn()
+	a := randomTree(4)
+	report, err := mdb.Put(1, a, hFn, WithIdempotencyKey("msg-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Replayed || report.NewNodes == 0 {
+		t.Fatalf("expected the first put to be written: %+v", report)
+	}
+	p, err := mdb.IdempotentPut("msg-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Root != a.MerkleRoot(hFn) || p.Slot != 1 || !p.At.Equal(now) {
+		t.Fatalf("unexpected record: %+v", p)
+	}
+
+	// a replay of the message is a no-op, even if the tree differs
+	b := randomTree(4)
+	report, err = mdb.Put(2, b, hFn, WithIdempotencyKey("msg-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Replayed || report.NewNodes != 0 {
+		t.Fatalf("expected a replay: %+v", report)
+	}
+	if ok, _ := mdb.Has(RootGindex, b.MerkleRoot(hFn)); ok {
+		t.Fatal("expected the replayed put not to be written")
+	}
+	if report, err := mdb.PutStream(2, b.MerkleRoot(hFn), TreeSource(b, hFn), hFn, WithIdempotencyKey("msg-1")); err != nil {
+		t.Fatal(err)
+	} else if !report.Replayed {
+		t.Fatalf("expected a replayed stream: %+v", report)
+	}
+
+	// other keys, and puts without a key, are written
+	if report, err := mdb.Put(2, b, hFn, WithIdempotencyKey("msg-2")); err != nil {
+		t.Fatal(err)
+	} else if report.Replayed {
+		t.Fatal("expected a new key to be written")
+	}
+	if report, err := mdb.Put(3, randomTree(2), hFn); err != nil {
+		t.Fatal(err)
+	} else if report.Replayed {
+		t.Fatal("expected a put without key to be written")
+	}
+
+	now = now.Add(time.Hour)
+	n, err := mdb.ForgetIdempotencyKeys(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 forgotten keys, got %d", n)
+	}
+	if _, err := mdb.IdempotentPut("msg-1"); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the key to be forgotten: %v", err)
+	}
+	if report, err := mdb.Put(2, b, hFn, WithIdempotencyKey("msg-1")); err != nil {
+		t.Fatal(err)
+	} else if report.Replayed {
+		t.Fatal("expected a forgotten key to be written again")
+	}
+	if n, err := mdb.ForgetIdempotencyKeys(now); err != nil || n != 0 {
+		t.Fatalf("expected the new key to be kept: %d, %v", n, err)
+	}
+}
+
+func TestMerkleDB_PutIdempotencyKeyFailed(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	node := randomTree(3)
+	failed := func(b *leveldb.Batch) error {
+		return leveldb.ErrClosed
+	}
+	if _, err := mdb.Put(1, node, nil, WithIdempotencyKey("k"), WithCommit(failed)); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	// the key is only claimed by a written put
+	if report, err := mdb.Put(1, node, nil, WithIdempotencyKey("k")); err != nil {
+		t.Fatal(err)
+	} else if report.Replayed {
+		t.Fatal("expected the retry to be written")
+	}
+}
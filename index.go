@@ -0,0 +1,337 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"math/bits"
+)
+
+// metaIndex stores the entries of the leaf indexes, see WithIndex
+const metaIndex byte = 'I'
+
+// indexEntryTail is the length of the position, gindex and root after the key of an entry
+const indexEntryTail = 8 + 8 + 32
+
+// ErrUnknownIndex is returned for queries of an index that is not declared with WithIndex
+var ErrUnknownIndex = errors.New("unknown index")
+
+// IndexEntry is a key of a leaf in an index, with the position it is found at, e.g. a validator index
+type IndexEntry struct {
+	Key      []byte
+	Position uint64
+}
+
+// LeafIndex declares an index on the nodes at the gindex of the Field in every element of a list or vector:
+// the elements are the nodes at the Depth below the Base gindex. The Field is RootGindex if the elements
+// are the indexed nodes themselves, e.g. packed chunks. The indexed nodes are typically leaves, whose root is their value.
+type LeafIndex struct {
+	// Name identifies the index in queries
+	Name  string
+	Base  Gindex
+	Depth uint8
+	Field Gindex
+	// Keys returns the entries of the node of the element at the position. The keys of an index
+	// should be of the same length, to order them by value in range queries.
+	Keys func(element uint64, root Root) []IndexEntry
+}
+
+// Uint64Index indexes the packed uint64 values of a basic list or vector by value, e.g. balances,
+// the elements are the chunks of 4 values at the depth below the base.
+func Uint64Index(name string, base Gindex, depth uint8) LeafIndex {
+	return LeafIndex{Name: name, Base: base, Depth: depth, Field: RootGindex,
+		Keys: func(element uint64, root Root) []IndexEntry {
+			out := make([]IndexEntry, 4)
+			for i := range out {
+				out[i] = IndexEntry{Key: Uint64Key(binary.LittleEndian.Uint64(root[8*i:])), Position: element*4 + uint64(i)}
+			}
+			return out
+		},
+	}
+}
+
+// RootIndex indexes the elements by the root of the node at the field, e.g. validators by the root of the pubkey.
+func RootIndex(name string, base Gindex, depth uint8, field Gindex) LeafIndex {
+	return LeafIndex{Name: name, Base: base, Depth: depth, Field: field,
+		Keys: func(element uint64, root Root) []IndexEntry {
+			return []IndexEntry{{Key: append([]byte(nil), root[:]...), Position: element}}
+		},
+	}
+}
+
+// Uint64Key is the key of a value in a Uint64Index, big-endian to order keys by value
+func Uint64Key(v uint64) []byte {
+	var out [8]byte
+	binary.BigEndian.PutUint64(out[:], v)
+	return out[:]
+}
+
+// element returns the position of the element that holds the node at the gindex, or false if it is not indexed
+func (idx *LeafIndex) element(g uint64) (uint64, bool, error) {
+	base, err := gindexValue(idx.Base)
+	if err != nil {
+		return 0, false, err
+	}
+	field, err := gindexValue(idx.Field)
+	if err != nil {
+		return 0, false, err
+	}
+	baseDepth, fieldDepth := uint32(bits.Len64(base))-1, uint32(bits.Len64(field))-1
+	if uint32(bits.Len64(g))-1 != baseDepth+uint32(idx.Depth)+fieldDepth {
+		return 0, false, nil
+	}
+	if g>>(uint32(idx.Depth)+fieldDepth) != base || g&(1<<fieldDepth-1) != field&(1<<fieldDepth-1) {
+		return 0, false, nil
+	}
+	return (g >> fieldDepth) & (1<<idx.Depth - 1), true, nil
+}
+
+// maxIndexName is the maximum length of the name of an index, the keys of the entries prefix it with its length in a byte
+const maxIndexName = 255
+
+// check fails if the entries of the index cannot be stored
+func (idx *LeafIndex) check() error {
+	if len(idx.Name) > maxIndexName {
+		return fmt.Errorf("index name of %d bytes, at most %d are supported", len(idx.Name), maxIndexName)
+	}
+	return nil
+}
+
+func (db *merkleDB) index(name string) (*LeafIndex, error) {
+	for i := range db.opts.Indexes {
+		if db.opts.Indexes[i].Name == name {
+			return &db.opts.Indexes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+}
+
+func (db *merkleDB) indexKeyPrefix(name string, key []byte) []byte {
+	id := make([]byte, 0, 1+len(name)+len(key))
+	id = append(id, byte(len(name)))
+	id = append(id, name...)
+	id = append(id, key...)
+	return db.metaKey(metaIndex, id)
+}
+
+func (db *merkleDB) indexKey(name string, e IndexEntry, g uint64, root Root) []byte {
+	k := db.indexKeyPrefix(name, e.Key)
+	var tail [indexEntryTail]byte
+	binary.BigEndian.PutUint64(tail[:8], e.Position)
+	binary.BigEndian.PutUint64(tail[8:16], g)
+	copy(tail[16:], root[:])
+	return append(k, tail[:]...)
+}
+
+// indexNode adds the entries of the node to the batch, for every index that covers the gindex
+func (db *merkleDB) indexNode(b *leveldb.Batch, g uint64, root Root) error {
+	for i := range db.opts.Indexes {
+		idx := &db.opts.Indexes[i]
+		if err := idx.check(); err != nil {
+			return err
+		}
+		element, ok, err := idx.element(g)
+		if err != nil {
+			return fmt.Errorf("index %s: %v", idx.Name, err)
+		}
+		if !ok {
+			continue
+		}
+		for _, e := range idx.Keys(element, root) {
+			b.Put(db.indexKey(idx.Name, e, g, root), nil)
+		}
+	}
+	return nil
+}
+
+type writtenNodes []NodeKey
+
+func (w *writtenNodes) Put(key []byte, value []byte) {
+	if k, err := ParseNodeKey(key); err == nil {
+		*w = append(*w, k)
+	}
+}
+
+func (w *writtenNodes) Delete(key []byte) {}
+
+// indexBatch adds the entries of the nodes that the batch of a put writes
+func (db *merkleDB) indexBatch(b *leveldb.Batch) error {
+	if len(db.opts.Indexes) == 0 {
+		return nil
+	}
+	var nodes writtenNodes
+	if err := b.Replay(&nodes); err != nil {
+		return err
+	}
+	for _, k := range nodes {
+		// the indexes cover nodes with 64 bit gindices only, deeper nodes are not indexed
+		if k.Gindex.Depth() >= 64 {
+			continue
+		}
+		g, err := gindexValue(k.Gindex)
+		if err != nil {
+			return err
+		}
+		if err := db.indexNode(b, g, k.Root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *merkleDB) RebuildIndex(name string) (int, error) {
+	idx, err := db.index(name)
+	if err != nil {
+		return 0, err
+	}
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	base, err := gindexValue(idx.Base)
+	if err != nil {
+		return 0, err
+	}
+	field, err := gindexValue(idx.Field)
+	if err != nil {
+		return 0, err
+	}
+	// the indexed nodes are all of the same bit length, and their keys are adjacent
+	bitLen := uint32(bits.Len64(base)) + uint32(idx.Depth) + uint32(bits.Len64(field)) - 1
+	if bitLen > 64 {
+		return 0, errGindexTooDeep
+	}
+	scan := make([]byte, prefixLen+gindexLenByteLen)
+	copy(scan, db.prefix[:])
+	binary.LittleEndian.PutUint16(scan[prefixLen:], uint16(bitLen))
+	iter := db.db.NewIterator(util.BytesPrefix(scan), nil)
+	defer iter.Release()
+	n := 0
+	b := new(leveldb.Batch)
+	for iter.Next() {
+		k, err := ParseNodeKey(iter.Key())
+		if err != nil {
+			return n, err
+		}
+		g, err := gindexValue(k.Gindex)
+		if err != nil {
+			return n, err
+		}
+		element, ok, err := idx.element(g)
+		if err != nil {
+			return n, err
+		} else if !ok {
+			continue
+		}
+		for _, e := range idx.Keys(element, k.Root) {
+			b.Put(db.indexKey(idx.Name, e, g, k.Root), nil)
+			n += 1
+		}
+		if b.Len() >= DefaultDeleteBatchSize {
+			if err := db.write(b); err != nil {
+				return n, err
+			}
+			b.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	if b.Len() > 0 {
+		if err := db.write(b); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (db *merkleDB) IndexLookup(anchor Root, name string, key []byte) ([]IndexEntry, error) {
+	return db.queryIndex(anchor, name, util.BytesPrefix(db.indexKeyPrefix(name, key)))
+}
+
+func (db *merkleDB) IndexRange(anchor Root, name string, start []byte, end []byte) ([]IndexEntry, error) {
+	r := util.BytesPrefix(db.indexKeyPrefix(name, nil))
+	if start != nil {
+		r.Start = db.indexKeyPrefix(name, start)
+	}
+	if end != nil {
+		r.Limit = db.indexKeyPrefix(name, end)
+	}
+	return db.queryIndex(anchor, name, r)
+}
+
+// queryIndex lists the entries in the range that are of nodes in the tree of the anchor.
+// The entries of nodes of other trees are skipped, each indexed gindex is looked up once.
+func (db *merkleDB) queryIndex(anchor Root, name string, r *util.Range) ([]IndexEntry, error) {
+	if _, err := db.index(name); err != nil {
+		return nil, err
+	}
+	if _, err := db.GetAnchor(anchor); err != nil {
+		return nil, err
+	}
+	var out []IndexEntry
+	err := db.consistent(func(view *merkleDB) error {
+		roots := make(map[uint64]Root)
+		iter := view.r.NewIterator(r, nil)
+		defer iter.Release()
+		keyStart := metaKeyLen + 1 + len(name)
+		for iter.Next() {
+			k := iter.Key()
+			if len(k) < keyStart+indexEntryTail || binary.BigEndian.Uint64(k[len(k)-indexEntryTail+8:]) == 0 {
+				return corruptMeta(metaIndex, k[metaKeyLen:], iter.Value(), fmt.Errorf("corrupt index entry: '%x'", k))
+			}
+			tail := k[len(k)-indexEntryTail:]
+			g := binary.BigEndian.Uint64(tail[8:16])
+			root, ok := roots[g]
+			if !ok {
+				var err error
+				root, err = view.lookup(anchor, Gindex64(g))
+				if err != nil && !skippedLookup(err) {
+					return err
+				}
+				roots[g] = root
+			}
+			if root != toRoot(tail[16:]) {
+				continue
+			}
+			out = append(out, IndexEntry{
+				Key:      append([]byte(nil), k[keyStart:len(k)-indexEntryTail]...),
+				Position: binary.BigEndian.Uint64(tail[:8]),
+			})
+		}
+		return iter.Error()
+	})
+	return out, err
+}
+
+// pruneIndexes deletes the entries of nodes that are no longer stored
+func (db *merkleDB) pruneIndexes() error {
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaIndex, nil)), nil)
+	defer iter.Release()
+	w := db.newDeleteWriter()
+	buf := keyPool.Get().(*[maxKeyLen]byte)
+	defer keyPool.Put(buf)
+	for iter.Next() {
+		if len(iter.Key()) < metaKeyLen+1+indexEntryTail || binary.BigEndian.Uint64(iter.Key()[len(iter.Key())-indexEntryTail+8:]) == 0 {
+			return corruptMeta(metaIndex, iter.Key()[metaKeyLen:], iter.Value(), fmt.Errorf("corrupt index entry: '%x'", iter.Key()))
+		}
+		tail := iter.Key()[len(iter.Key())-indexEntryTail:]
+		node, err := db.buildKey(buf, Gindex64(binary.BigEndian.Uint64(tail[8:16])), toRoot(tail[16:]))
+		if err != nil {
+			return err
+		}
+		if has, err := db.db.Has(node, nil); err != nil {
+			return err
+		} else if has {
+			continue
+		}
+		if err := w.delete(iter.Key()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return w.flush()
+}
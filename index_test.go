@@ -0,0 +1,183 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"testing"
+)
+
+// the balances are 256 chunks below gindex 14, the validators 1024 elements below gindex 12, with the pubkey at field gindex 4
+var (
+	testBalancesIndex = Uint64Index("balances", Gindex64(14), 8)
+	testPubkeyIndex   = RootIndex("pubkey", Gindex64(12), 10, Gindex64(4))
+)
+
+func countIndexEntries(t *testing.T, mdb *merkleDB) int {
+	iter := mdb.db.NewIterator(util.BytesPrefix(mdb.metaKey(metaIndex, nil)), nil)
+	defer iter.Release()
+	n := 0
+	for iter.Next() {
+		n += 1
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMerkleDB_Index(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithIndex(testBalancesIndex), WithIndex(testPubkeyIndex)).(*merkleDB)
+	hFn := GetHashFn()
+	first := testState(t, 1, 8)
+	if _, err := mdb.Put(1, first.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	firstRoot := first.HashTreeRoot(hFn)
+
+	vals, err := first.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vals.(*view.ComplexListView).Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := v.(*view.ContainerView).Get(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err := mdb.IndexLookup(firstRoot, "pubkey", pubkey.(*view.RootView)[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Position != 3 {
+		t.Fatalf("unexpected pubkey hits: %+v", hits)
+	}
+
+	// the second state lowers the balance of validator 5
+	second := testState(t, 2, 8)
+	bals, err := second.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bals.(*view.BasicListView).Set(5, view.Uint64View(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, second.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	secondRoot := second.HashTreeRoot(hFn)
+	before := countIndexEntries(t, mdb)
+
+	rich := func(anchor Root) []uint64 {
+		hits, err := mdb.IndexRange(anchor, "balances", Uint64Key(31_000_000_005), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []uint64
+		for _, h := range hits {
+			out = append(out, h.Position)
+		}
+		return out
+	}
+	if got := rich(firstRoot); len(got) != 3 || got[0] != 5 || got[1] != 6 || got[2] != 7 {
+		t.Fatalf("unexpected balances of the first state: %v", got)
+	}
+	if got := rich(secondRoot); len(got) != 2 || got[0] != 6 || got[1] != 7 {
+		t.Fatalf("unexpected balances of the second state: %v", got)
+	}
+	if hits, err := mdb.IndexLookup(secondRoot, "balances", Uint64Key(1)); err != nil || len(hits) != 1 || hits[0].Position != 5 {
+		t.Fatalf("unexpected lowered balance hits: %+v, %v", hits, err)
+	}
+
+	// the entries of the pruned nodes are deleted
+	if err := mdb.Prune([]Root{secondRoot}); err != nil {
+		t.Fatal(err)
+	}
+	if after := countIndexEntries(t, mdb); after >= before {
+		t.Fatalf("expected entries to be pruned, %d before, %d after", before, after)
+	}
+	if got := rich(secondRoot); len(got) != 2 {
+		t.Fatalf("unexpected balances after the prune: %v", got)
+	}
+	if _, err := mdb.IndexLookup(firstRoot, "balances", Uint64Key(1)); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the pruned anchor to be missing, got %v", err)
+	}
+	if _, err := mdb.IndexLookup(secondRoot, "unknown", nil); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("expected an unknown index, got %v", err)
+	}
+}
+
+func TestMerkleDB_RebuildIndex(t *testing.T) {
+	ldb := newMemoryDB()
+	hFn := GetHashFn()
+	state := testState(t, 1, 8)
+	if _, err := New(testPrefix, ldb).Put(1, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	root := state.HashTreeRoot(hFn)
+	mdb := New(testPrefix, ldb, WithIndex(testBalancesIndex))
+	key := Uint64Key(31_000_000_002)
+	if hits, err := mdb.IndexLookup(root, "balances", key); err != nil || len(hits) != 0 {
+		t.Fatalf("expected no hits before the rebuild: %+v, %v", hits, err)
+	}
+	n, err := mdb.RebuildIndex("balances")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 2 chunks of 8 balances, the zero chunks after them are stored as the roots of zero subtrees
+	if n != 8 {
+		t.Fatalf("unexpected number of entries: %d", n)
+	}
+	if hits, err := mdb.IndexLookup(root, "balances", key); err != nil || len(hits) != 1 || hits[0].Position != 2 {
+		t.Fatalf("unexpected hits after the rebuild: %+v, %v", hits, err)
+	}
+}
+
+func TestMerkleDB_IndexLimits(t *testing.T) {
+	hFn := GetHashFn()
+	// nodes deeper than 63 bits are not indexed, the put does not fail
+	var deep Node = randomRoot()
+	for i := 0; i < 70; i++ {
+		deep = NewPairNode(deep, randomRoot())
+	}
+	mdb := New(testPrefix, newMemoryDB(), WithIndex(RootIndex("deep", Gindex64(2), 8, RootGindex))).(*merkleDB)
+	if _, err := mdb.Put(1, deep, hFn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries are part of the written bytes
+	state := testState(t, 1, 8)
+	plainDB := New(testPrefix, newMemoryDB())
+	plain, err := plainDB.Put(1, state.Backing(), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed := New(testPrefix, newMemoryDB(), WithIndex(testBalancesIndex)).(*merkleDB)
+	report, err := indexed.Put(1, state.Backing(), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countIndexEntries(t, indexed); n == 0 || report.BytesWritten <= plain.BytesWritten {
+		t.Fatalf("expected the %d entries in the written bytes, got %d, %d without index", n, report.BytesWritten, plain.BytesWritten)
+	}
+	plainUsage, err := plainDB.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := indexed.Usage(); err != nil || u.Nodes != plainUsage.Nodes || u.Bytes <= plainUsage.Bytes {
+		t.Fatalf("expected the usage to count the entries, got %+v, %+v without index, err: %v", u, plainUsage, err)
+	}
+
+	// the name is prefixed by its length in a byte
+	long := RootIndex(string(make([]byte, 256)), Gindex64(2), 8, RootGindex)
+	if _, err := Open(testPrefix, newMemoryDB(), WithIndex(long)); err == nil {
+		t.Fatal("expected a long index name to be rejected")
+	}
+	if _, err := New(testPrefix, newMemoryDB(), WithIndex(long)).Put(1, state.Backing(), hFn); err == nil {
+		t.Fatal("expected the put with a long index name to fail")
+	}
+}
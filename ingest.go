@@ -0,0 +1,210 @@
+package merkledb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"net"
+)
+
+// Ingest frames are a uint32 little-endian length, followed by the payload: a frame type byte and its fields.
+// A tree is a tree frame, its node frames, every parent before its children, and an end frame, see PutStream.
+// Every ingested tree is answered with a result frame, or an error frame.
+const (
+	// IngestTree starts a tree: uint64(slot) ++ bytes32(anchor root)
+	IngestTree byte = 't'
+	// IngestLeaf is a leaf node: uint64(gindex) ++ bytes32(root)
+	IngestLeaf byte = 'l'
+	// IngestPair is a pair node: uint64(gindex) ++ bytes32(root) ++ bytes32(left) ++ bytes32(right)
+	IngestPair byte = 'p'
+	// IngestEnd ends the nodes of the tree, without fields
+	IngestEnd byte = 'e'
+	// IngestResult answers a stored tree: bytes32(anchor root) ++ uint64(new nodes) ++ uint64(reused nodes)
+	IngestResult byte = 'o'
+	// IngestError answers a tree that was not stored, or a broken stream: the UTF-8 error message
+	IngestError byte = 'x'
+)
+
+const (
+	ingestTreeLen = 1 + 8 + 32
+	ingestLeafLen = 1 + 8 + 32
+	ingestPairLen = 1 + 8 + 32 + 32 + 32
+)
+
+// ingestReader reads the frames of an ingest stream
+type ingestReader struct {
+	r   *bufio.Reader
+	buf [ingestPairLen]byte
+}
+
+// next reads the next frame, the payload is only valid until the next call. It returns io.EOF at the end
+// of the stream between frames, and io.ErrUnexpectedEOF within a frame.
+func (r *ingestReader) next() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n == 0 || n > ingestPairLen {
+		return nil, fmt.Errorf("ingest frame of %d bytes", n)
+	}
+	payload := r.buf[:n]
+	if _, err := io.ReadFull(r.r, payload); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// ingestSource streams the node frames of a tree to PutStream
+type ingestSource struct {
+	r *ingestReader
+	// done is set at the end frame of the tree
+	done bool
+	// broken is the error of a stream that cannot be read any further
+	broken error
+}
+
+func (s *ingestSource) Next() (StreamNode, error) {
+	if s.done {
+		return StreamNode{}, io.EOF
+	}
+	if s.broken != nil {
+		return StreamNode{}, s.broken
+	}
+	frame, err := s.r.next()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.broken = err
+		return StreamNode{}, err
+	}
+	var n StreamNode
+	switch {
+	case frame[0] == IngestEnd && len(frame) == 1:
+		s.done = true
+		return StreamNode{}, io.EOF
+	case frame[0] == IngestLeaf && len(frame) == ingestLeafLen:
+	case frame[0] == IngestPair && len(frame) == ingestPairLen:
+		n.Pair = true
+		n.Left = toRoot(frame[41:73])
+		n.Right = toRoot(frame[73:105])
+	default:
+		s.broken = fmt.Errorf("unexpected ingest frame '%c' of %d bytes", frame[0], len(frame))
+		return StreamNode{}, s.broken
+	}
+	g := binary.LittleEndian.Uint64(frame[1:9])
+	if g == 0 {
+		s.broken = errors.New("ingest node with gindex 0")
+		return StreamNode{}, s.broken
+	}
+	n.Gindex = Gindex64(g)
+	n.Root = toRoot(frame[9:41])
+	return n, nil
+}
+
+// drain skips the remaining nodes of a tree that failed to put
+func (s *ingestSource) drain() error {
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			return nil
+		} else if s.broken != nil {
+			return s.broken
+		}
+	}
+}
+
+func writeIngestFrame(w *bufio.Writer, payload []byte) error {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Ingest puts the trees of a stream of length-prefixed frames, e.g. from a unix socket or a pipe of another process,
+// so producers in other languages can stream trees into the DB without Go. The frames are described at IngestTree.
+// Every tree is put with PutStream, and validated like it. A tree that fails is answered with an error frame,
+// and the stream continues with the next tree; a stream with broken framing ends with an error frame.
+// The answers are written to w, which may be nil for a one-way pipe. Ingest returns the number of stored trees,
+// at the end of the stream between trees.
+func Ingest(db TreeWriter, r io.Reader, w io.Writer, fn HashFn, opts ...PutOption) (int, error) {
+	ir := &ingestReader{r: bufio.NewReader(r)}
+	var bw *bufio.Writer
+	if w != nil {
+		bw = bufio.NewWriter(w)
+	}
+	answer := func(payload []byte) error {
+		if bw == nil {
+			return nil
+		}
+		return writeIngestFrame(bw, payload)
+	}
+	fail := func(count int, err error) (int, error) {
+		_ = answer(append([]byte{IngestError}, err.Error()...))
+		return count, err
+	}
+	count := 0
+	for {
+		frame, err := ir.next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return fail(count, err)
+		}
+		if frame[0] != IngestTree || len(frame) != ingestTreeLen {
+			return fail(count, fmt.Errorf("expected an ingest tree frame, got '%c' of %d bytes", frame[0], len(frame)))
+		}
+		slot := binary.LittleEndian.Uint64(frame[1:9])
+		anchor := toRoot(frame[9:41])
+		src := &ingestSource{r: ir}
+		report, err := db.PutStream(slot, anchor, src, fn, opts...)
+		if src.broken != nil {
+			return fail(count, src.broken)
+		}
+		if err != nil {
+			if err := src.drain(); err != nil {
+				return fail(count, err)
+			}
+			if err := answer(append([]byte{IngestError}, fmt.Sprintf("tree %s: %v", anchor, err)...)); err != nil {
+				return count, err
+			}
+			continue
+		}
+		count += 1
+		out := make([]byte, 1+32+8+8)
+		out[0] = IngestResult
+		copy(out[1:33], anchor[:])
+		binary.LittleEndian.PutUint64(out[33:41], uint64(report.NewNodes))
+		binary.LittleEndian.PutUint64(out[41:49], uint64(report.ReusedNodes))
+		if err := answer(out); err != nil {
+			return count, err
+		}
+	}
+}
+
+// ServeIngest accepts connections on the listener, e.g. a unix socket, and runs Ingest on each, answering on
+// the connection. It returns nil when the listener is closed, connections that are still open finish their stream.
+func ServeIngest(l net.Listener, db TreeWriter, fn HashFn, opts ...PutOption) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = Ingest(db, conn, conn, fn, opts...)
+		}()
+	}
+}
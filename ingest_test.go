@@ -0,0 +1,142 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// writeIngestTree writes the frames of the tree, as a producer in another language would
+func writeIngestTree(t *testing.T, w io.Writer, slot uint64, node Node) {
+	hFn := GetHashFn()
+	frame := func(payload []byte) {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+		if _, err := w.Write(append(size[:], payload...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := node.MerkleRoot(hFn)
+	header := make([]byte, 1+8, ingestTreeLen)
+	header[0] = IngestTree
+	binary.LittleEndian.PutUint64(header[1:], slot)
+	frame(append(header, root[:]...))
+	src := TreeSource(node, hFn)
+	for {
+		n, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		g, _ := gindexValue(n.Gindex)
+		payload := make([]byte, 1+8, ingestPairLen)
+		payload[0] = IngestLeaf
+		if n.Pair {
+			payload[0] = IngestPair
+		}
+		binary.LittleEndian.PutUint64(payload[1:], g)
+		payload = append(payload, n.Root[:]...)
+		if n.Pair {
+			payload = append(append(payload, n.Left[:]...), n.Right[:]...)
+		}
+		frame(payload)
+	}
+	frame([]byte{IngestEnd})
+}
+
+func readIngestAnswers(t *testing.T, r io.Reader) [][]byte {
+	var out [][]byte
+	br := bufio.NewReader(r)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err == io.EOF {
+			return out
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, payload)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	a, b := randomTree(5), randomTree(5)
+	var in bytes.Buffer
+	writeIngestTree(t, &in, 1, a)
+	// a tree with a node that does not match its parent is answered with an error, the next tree is still stored
+	var bad bytes.Buffer
+	writeIngestTree(t, &bad, 2, randomTree(3))
+	corrupt := bad.Bytes()
+	corrupt[4+ingestTreeLen+4+9] ^= 1
+	in.Write(corrupt)
+	writeIngestTree(t, &in, 3, b)
+	var out bytes.Buffer
+	n, err := Ingest(mdb, &in, &out, hFn)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 ingested trees, got %d, err: %v", n, err)
+	}
+	answers := readIngestAnswers(t, &out)
+	if len(answers) != 3 || answers[0][0] != IngestResult || answers[1][0] != IngestError || answers[2][0] != IngestResult {
+		t.Fatalf("unexpected answers: %q", answers)
+	}
+	if root := toRoot(answers[2][1:33]); root != b.MerkleRoot(hFn) {
+		t.Fatalf("unexpected answered root: %s", root)
+	}
+	expectAnchors(t, mdb, a.MerkleRoot(hFn), b.MerkleRoot(hFn))
+
+	// a stream that ends within a tree is broken
+	var cut bytes.Buffer
+	writeIngestTree(t, &cut, 4, randomTree(3))
+	out.Reset()
+	if _, err := Ingest(mdb, bytes.NewReader(cut.Bytes()[:cut.Len()-5]), &out, hFn); err == nil {
+		t.Fatal("expected a cut stream to fail")
+	}
+	if answers := readIngestAnswers(t, &out); len(answers) != 1 || answers[0][0] != IngestError {
+		t.Fatalf("expected an error answer, got %q", answers)
+	}
+}
+
+func TestServeIngest(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "ingest.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeIngest(l, mdb, hFn)
+	}()
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := randomTree(6)
+	writeIngestTree(t, conn, 7, tree)
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	answers := readIngestAnswers(t, conn)
+	_ = conn.Close()
+	if len(answers) != 1 || answers[0][0] != IngestResult {
+		t.Fatalf("unexpected answers: %q", answers)
+	}
+	if a, err := mdb.GetAnchor(tree.MerkleRoot(hFn)); err != nil || a.Slot != 7 {
+		t.Fatalf("expected the ingested anchor, got %v, err: %v", a, err)
+	}
+	_ = l.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
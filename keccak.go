@@ -0,0 +1,96 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	. "github.com/protolambda/ztyp/tree"
+	"math/bits"
+)
+
+// keccakRate is the block size of Keccak-256 in bytes
+const keccakRate = 136
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// keccakRotations and keccakLanes are the rotation offsets and lane order of the rho and pi steps
+var keccakRotations = [24]int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44}
+var keccakLanes = [24]int{10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1}
+
+func keccakF(a *[25]uint64) {
+	var c [5]uint64
+	for round := 0; round < 24; round++ {
+		// theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+		// rho and pi
+		t := a[1]
+		for i := 0; i < 24; i++ {
+			j := keccakLanes[i]
+			t, a[j] = a[j], bits.RotateLeft64(t, keccakRotations[i])
+		}
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				c[x] = a[y+x]
+			}
+			for x := 0; x < 5; x++ {
+				a[y+x] = c[x] ^ (^c[(x+1)%5] & c[(x+2)%5])
+			}
+		}
+		// iota
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// Keccak256 is the legacy Keccak-256 hash of Ethereum, with the original padding, of the concatenated data
+func Keccak256(data ...[]byte) (out Root) {
+	var state [25]uint64
+	var block [keccakRate]byte
+	n := 0
+	absorb := func() {
+		for i := 0; i < keccakRate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(block[8*i:])
+		}
+		keccakF(&state)
+		n = 0
+	}
+	for _, d := range data {
+		for len(d) > 0 {
+			c := copy(block[n:], d)
+			n += c
+			d = d[c:]
+			if n == keccakRate {
+				absorb()
+			}
+		}
+	}
+	for i := n; i < keccakRate; i++ {
+		block[i] = 0
+	}
+	block[n] ^= 0x01
+	block[keccakRate-1] ^= 0x80
+	absorb()
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(out[8*i:], state[i])
+	}
+	return
+}
+
+// KeccakHashFn is a HashFn that hashes the pairs of a tree with Keccak256, e.g. trees imported with ImportMPTWitness.
+// It is safe for concurrent use. Trees must be built from explicit leaves with it: the zero hashes of ztyp are SHA-256.
+func KeccakHashFn(a Root, b Root) Root {
+	return Keccak256(a[:], b[:])
+}
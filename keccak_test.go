@@ -0,0 +1,55 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	long := bytes.Repeat([]byte("abcdefghij"), 30) // spans multiple blocks
+	for _, c := range []struct {
+		data     [][]byte
+		expected string
+	}{
+		{nil, "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{[][]byte{[]byte("hello world")}, "47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad"},
+		{[][]byte{[]byte("hello"), []byte(" "), []byte("world")}, "47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad"},
+	} {
+		out := Keccak256(c.data...)
+		if got := hex.EncodeToString(out[:]); got != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, got)
+		}
+	}
+	// a block boundary must not change the hash of concatenated parts
+	whole := Keccak256(long)
+	split := Keccak256(long[:keccakRate], long[keccakRate:200], long[200:])
+	if whole != split {
+		t.Fatal("expected the same hash of the split input")
+	}
+	if whole == Keccak256(long[:keccakRate]) {
+		t.Fatal("expected the second block to change the hash")
+	}
+
+	// known answers around the block size, where the padding fills a block or starts a new one,
+	// of the bytes i*7+3, from a reference implementation that matches SHA3-256 with the SHA3 padding
+	for _, c := range []struct {
+		size     int
+		expected string
+	}{
+		{135, "00ef96af9cf4b24c7f269d922294444a197d0a33638c2e56634c57e892103a8f"},
+		{136, "742061bcad767ed4c4f5883b1dcb1aad11afdcc140dc469d953759b127b9f9ed"},
+		{137, "e3371f61e770abf254c34239c3b0099ad90594507415bc81dd0a10b9692bbf2a"},
+		{272, "ac141fd7b0a0ffcd2e967254d508da3ec616596493c36fa304425647d90e6de5"},
+		{1000, "80cdc8dd52cbb3dbaea8f383209893fa2bb52efbd5aedbb4b26dcfe307fcdc9b"},
+	} {
+		data := make([]byte, c.size)
+		for i := range data {
+			data[i] = byte(i*7 + 3)
+		}
+		out := Keccak256(data)
+		if got := hex.EncodeToString(out[:]); got != c.expected {
+			t.Fatalf("%d bytes: expected %s, got %s", c.size, c.expected, got)
+		}
+	}
+}
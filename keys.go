@@ -0,0 +1,31 @@
+package merkledb
+
+import (
+	"fmt"
+	"github.com/protolambda/merkledb/lite"
+)
+
+// ErrMalformed is wrapped by the errors of the decoders of untrusted input: node keys and values, and backups.
+var ErrMalformed = lite.ErrMalformed
+
+// NodeKey is the decoded key of a stored node
+type NodeKey = lite.NodeKey
+
+// ParseNodeKey decodes the raw leveldb key of a node: the prefix, the bit length and
+// the left-aligned bits of the gindex, and the node root.
+// Metadata keys, like anchor records, are not node keys and return an error.
+func ParseNodeKey(key []byte) (NodeKey, error) {
+	if _, ok := metaKind(key); ok {
+		return NodeKey{}, fmt.Errorf("%w: metadata key, not a node key", ErrMalformed)
+	}
+	return lite.ParseKey(key)
+}
+
+// ParseNodeValue decodes the raw leveldb value of a node: the slot, and the children if it is a pair.
+func ParseNodeValue(value []byte) (PairRecord, error) {
+	var out PairRecord
+	if err := parseValue(value, &out); err != nil {
+		return PairRecord{}, err
+	}
+	return out, nil
+}
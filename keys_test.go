@@ -0,0 +1,75 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestParseNode(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb)
+	hFn := GetHashFn()
+	node := randomTree(6)
+	if _, err := mdb.Put(7, node, hFn); err != nil {
+		t.Fatal(err)
+	}
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+	nodes := 0
+	for iter.Next() {
+		k, err := ParseNodeKey(iter.Key())
+		if _, isMeta := metaKind(iter.Key()); isMeta {
+			if err == nil {
+				t.Fatalf("expected an error for metadata key '%x'", iter.Key())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes += 1
+		if k.Prefix != testPrefix {
+			t.Fatalf("unexpected prefix: %x", k.Prefix)
+		}
+		if _, bitLen := k.Gindex.LeftAlignedBigEndian(); uint32(bitLen) != k.BitLen {
+			t.Fatalf("gindex %d does not match bit length %d", k.Gindex, k.BitLen)
+		}
+		rec, err := ParseNodeValue(iter.Value())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Slot != 7 {
+			t.Fatalf("unexpected slot: %d", rec.Slot)
+		}
+		// the parsed records match the stored tree
+		expected, err := node.Getter(k.Gindex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected.MerkleRoot(hFn) != k.Root {
+			t.Fatalf("unexpected root at gindex %d", k.Gindex)
+		}
+		if rec.Pair != !expected.IsLeaf() {
+			t.Fatalf("unexpected node type at gindex %d", k.Gindex)
+		}
+		if rec.Pair {
+			left, _ := expected.Left()
+			right, _ := expected.Right()
+			if rec.Left != left.MerkleRoot(hFn) || rec.Right != right.MerkleRoot(hFn) {
+				t.Fatalf("unexpected children at gindex %d", k.Gindex)
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if nodes == 0 {
+		t.Fatal("expected nodes")
+	}
+	if _, err := ParseNodeKey([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+	if _, err := ParseNodeValue([]byte{4, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("expected an error for an unknown value type")
+	}
+}
@@ -0,0 +1,178 @@
+package merkledb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metaLease is the lease of the writer of the prefix, see WithLease
+const metaLease byte = 'L'
+
+const leaseVersion = 0
+
+// ErrLeaseLost is reported to the background errors when another writer took over the lease of the prefix,
+// after the heartbeat of this one stalled. From then on, every write of this merkledb fails with it.
+var ErrLeaseLost = errors.New("lease of the prefix was lost")
+
+// leaseLock serializes the changes of leases. Another process cannot open the leveldb while it is open,
+// competing writers of a prefix share the leveldb in one process.
+var leaseLock sync.Mutex
+
+// Lease is the record of the writer that holds a prefix
+type Lease struct {
+	// Owner identifies the merkledb that holds the lease
+	Owner [16]byte
+	PID   int
+	Host  string
+	// Acquired is when the lease was taken, and Heartbeat when it was last renewed
+	Acquired  time.Time
+	Heartbeat time.Time
+}
+
+func (l *Lease) encode() []byte {
+	out := make([]byte, 1+16+4+8+8+1, 1+16+4+8+8+1+len(l.Host))
+	out[0] = leaseVersion
+	copy(out[1:17], l.Owner[:])
+	binary.LittleEndian.PutUint32(out[17:21], uint32(l.PID))
+	binary.LittleEndian.PutUint64(out[21:29], uint64(l.Acquired.UnixNano()))
+	binary.LittleEndian.PutUint64(out[29:37], uint64(l.Heartbeat.UnixNano()))
+	out[37] = byte(len(l.Host))
+	return append(out, l.Host...)
+}
+
+func (l *Lease) decode(v []byte) error {
+	if len(v) < 38 {
+		return fmt.Errorf("corrupt lease, too short: '%x'", v)
+	}
+	if v[0] != leaseVersion {
+		return fmt.Errorf("unknown lease version: %d", v[0])
+	}
+	if len(v) != 38+int(v[37]) {
+		return fmt.Errorf("corrupt lease, host of %d bytes: '%x'", len(v)-38, v)
+	}
+	*l = Lease{
+		PID:       int(binary.LittleEndian.Uint32(v[17:21])),
+		Acquired:  time.Unix(0, int64(binary.LittleEndian.Uint64(v[21:29]))),
+		Heartbeat: time.Unix(0, int64(binary.LittleEndian.Uint64(v[29:37]))),
+		Host:      string(v[38:]),
+	}
+	copy(l.Owner[:], v[1:17])
+	return nil
+}
+
+// LeaseError is returned by Open when another writer holds the lease of the prefix
+type LeaseError struct {
+	Holder Lease
+	// Expires is when the lease expires, if the holder stops renewing it
+	Expires time.Time
+}
+
+func (e *LeaseError) Error() string {
+	return fmt.Sprintf("prefix is held by pid %d on %q since %s, lease expires at %s",
+		e.Holder.PID, e.Holder.Host, e.Holder.Acquired.UTC().Format(time.RFC3339), e.Expires.UTC().Format(time.RFC3339))
+}
+
+// ReadLease reads the lease of the prefix, leveldb.ErrNotFound if no writer holds it
+func ReadLease(db *leveldb.DB, prefix [prefixLen]byte) (*Lease, error) {
+	v, err := db.Get(metaKeyOf(prefix, metaLease, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValueSize(metaLease, nil, v, 0); err != nil {
+		return nil, err
+	}
+	var l Lease
+	if err := l.decode(v); err != nil {
+		return nil, corruptMeta(metaLease, nil, v, err)
+	}
+	return &l, nil
+}
+
+// acquireLease takes the lease of the prefix, unless another writer holds it and renewed it within the TTL
+func (db *merkleDB) acquireLease() error {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	now := db.now()
+	if held, err := ReadLease(db.db, db.prefix); err == nil {
+		if expires := held.Heartbeat.Add(db.opts.LeaseTTL); now.Before(expires) {
+			return &LeaseError{Holder: *held, Expires: expires}
+		}
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+	l := Lease{PID: os.Getpid(), Acquired: now, Heartbeat: now}
+	if _, err := rand.Read(l.Owner[:]); err != nil {
+		return err
+	}
+	if host, err := os.Hostname(); err == nil && len(host) <= 255 {
+		l.Host = host
+	}
+	if err := db.writeKey(db.metaKey(metaLease, nil), l.encode()); err != nil {
+		return err
+	}
+	db.lease = &l
+	db.wg.Add(1)
+	go db.heartbeatLoop()
+	return nil
+}
+
+// renewLease writes the heartbeat of the lease, and fails with ErrLeaseLost if another writer holds it now
+func (db *merkleDB) renewLease() error {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	held, err := ReadLease(db.db, db.prefix)
+	if err == leveldb.ErrNotFound || (err == nil && !bytes.Equal(held.Owner[:], db.lease.Owner[:])) {
+		atomic.StoreInt32(&db.leaseLost, 1)
+		return ErrLeaseLost
+	} else if err != nil {
+		return err
+	}
+	l := *db.lease
+	l.Heartbeat = db.now()
+	return db.writeKey(db.metaKey(metaLease, nil), l.encode())
+}
+
+func (db *merkleDB) heartbeatLoop() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(db.opts.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.renewLease(); err != nil {
+				if db.opts.OnBackgroundError != nil {
+					db.opts.OnBackgroundError(err)
+				}
+				if err == ErrLeaseLost {
+					return
+				}
+			}
+		case <-db.closing:
+			return
+		}
+	}
+}
+
+// releaseLease deletes the lease, if this merkledb still holds it
+func (db *merkleDB) releaseLease() {
+	if db.lease == nil {
+		return
+	}
+	db.releaseOnce.Do(func() {
+		leaseLock.Lock()
+		defer leaseLock.Unlock()
+		if held, err := ReadLease(db.db, db.prefix); err == nil && bytes.Equal(held.Owner[:], db.lease.Owner[:]) {
+			if err := db.deleteKey(db.metaKey(metaLease, nil)); err != nil && db.opts.OnBackgroundError != nil {
+				db.opts.OnBackgroundError(err)
+			}
+		}
+	})
+}
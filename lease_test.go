@@ -0,0 +1,102 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOpen_Lease(t *testing.T) {
+	ldb := newMemoryDB()
+	now := time.Unix(1600000000, 0)
+	clock := WithClock(func() time.Time {
+		return now
+	})
+	first, err := Open(testPrefix, ldb, clock, WithLease(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ReadLease(ldb, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.PID != os.Getpid() || !l.Acquired.Equal(now) {
+		t.Fatalf("unexpected lease: %+v", l)
+	}
+
+	_, err = Open(testPrefix, ldb, clock, WithLease(time.Hour))
+	var leaseErr *LeaseError
+	if !errors.As(err, &leaseErr) {
+		t.Fatalf("expected a lease error, got %v", err)
+	}
+	if leaseErr.Holder.Owner != l.Owner || !leaseErr.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected holder: %+v", leaseErr)
+	}
+	// other prefixes are not held
+	other, err := Open([prefixLen]byte{9}, ldb, clock, WithLease(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.(*merkleDB).stop()
+
+	// renewing extends the lease
+	now = now.Add(50 * time.Minute)
+	if err := first.(*merkleDB).renewLease(); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(50 * time.Minute)
+	if _, err := Open(testPrefix, ldb, clock, WithLease(time.Hour)); !errors.As(err, &leaseErr) {
+		t.Fatalf("expected the renewed lease to be held, got %v", err)
+	}
+
+	// closing releases the lease
+	first.(*merkleDB).stop()
+	if _, err := ReadLease(ldb, testPrefix); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the lease to be released, got %v", err)
+	}
+	second, err := Open(testPrefix, ldb, clock, WithLease(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.(*merkleDB).stop()
+}
+
+func TestOpen_LeaseExpired(t *testing.T) {
+	ldb := newMemoryDB()
+	now := time.Unix(1600000000, 0)
+	clock := WithClock(func() time.Time {
+		return now
+	})
+	stalled, err := Open(testPrefix, ldb, clock, WithLease(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without heartbeat the lease expires, and can be taken over
+	now = now.Add(2 * time.Hour)
+	next, err := Open(testPrefix, ldb, clock, WithLease(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stalled.(*merkleDB).renewLease(); err != ErrLeaseLost {
+		t.Fatalf("expected the lease to be lost, got %v", err)
+	}
+	// the stalled writer does not write anymore
+	if _, err := stalled.Put(1, fullTree(2), nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the put to fail with ErrLeaseLost, got %v", err)
+	}
+	if err := stalled.Delete(Gindex64(1), *randomRoot()); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the delete to fail with ErrLeaseLost, got %v", err)
+	}
+	if _, err := next.Put(1, fullTree(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	// the stalled writer does not release the lease of the next one
+	stalled.(*merkleDB).stop()
+	if l, err := ReadLease(ldb, testPrefix); err != nil || l.Owner != next.(*merkleDB).lease.Owner {
+		t.Fatalf("expected the next writer to hold the lease: %v", err)
+	}
+	next.(*merkleDB).stop()
+}
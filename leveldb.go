@@ -0,0 +1,90 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Leveldb defaults for the merkledb workload: keys are content-addressed and read at random,
+// so lookups rely on the block cache and bloom filters much more than on locality.
+const (
+	DefaultBlockCacheSize      = 256 * opt.MiB
+	DefaultBloomFilterBits     = 10
+	DefaultWriteBuffer         = 64 * opt.MiB
+	DefaultCompactionTableSize = 8 * opt.MiB
+)
+
+// LevelDBOptions are the leveldb settings that matter for a merkledb.
+// Zero values are replaced by the defaults.
+type LevelDBOptions struct {
+	// BlockCacheSize is the capacity of the block cache, in bytes.
+	BlockCacheSize int
+	// BloomFilterBits is the number of bloom filter bits per key. A negative value disables the filter.
+	BloomFilterBits int
+	// WriteBuffer is the size of the memtable, in bytes.
+	WriteBuffer int
+	// CompactionTableSize is the size of the tables created by compaction, in bytes.
+	CompactionTableSize int
+}
+
+// WithLevelDB configures the leveldb settings used when merkledb opens the leveldb database itself.
+func WithLevelDB(ldbOpts LevelDBOptions) Option {
+	return func(o *Options) {
+		o.LevelDB = ldbOpts
+	}
+}
+
+// Options converts the settings to leveldb options, defaulting the unset values.
+func (l LevelDBOptions) Options() *opt.Options {
+	out := &opt.Options{
+		BlockCacheCapacity:  DefaultBlockCacheSize,
+		WriteBuffer:         DefaultWriteBuffer,
+		CompactionTableSize: DefaultCompactionTableSize,
+		Filter:              filter.NewBloomFilter(DefaultBloomFilterBits),
+	}
+	if l.BlockCacheSize > 0 {
+		out.BlockCacheCapacity = l.BlockCacheSize
+	}
+	if l.WriteBuffer > 0 {
+		out.WriteBuffer = l.WriteBuffer
+	}
+	if l.CompactionTableSize > 0 {
+		out.CompactionTableSize = l.CompactionTableSize
+	}
+	if l.BloomFilterBits > 0 {
+		out.Filter = filter.NewBloomFilter(l.BloomFilterBits)
+	} else if l.BloomFilterBits < 0 {
+		out.Filter = nil
+	}
+	return out
+}
+
+// RecommendedLevelDBOptions returns the leveldb options for the LevelDB settings of the given merkledb options,
+// to open the leveldb database with before passing it to New.
+func RecommendedLevelDBOptions(opts ...Option) *opt.Options {
+	var o Options
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return o.LevelDB.Options()
+}
+
+// DefaultPrefix is the key prefix of a merkledb opened with OpenFile
+var DefaultPrefix = [prefixLen]byte{}
+
+// OpenFile opens, or creates, the leveldb database at the given path, with the LevelDB settings of the options,
+// and returns the merkledb stored in it under DefaultPrefix, validated like with Open.
+// Closing the merkledb closes the leveldb database.
+func OpenFile(path string, opts ...Option) (MerkleDB, error) {
+	ldb, err := leveldb.OpenFile(path, RecommendedLevelDBOptions(opts...))
+	if err != nil {
+		return nil, err
+	}
+	mdb, err := Open(DefaultPrefix, ldb, opts...)
+	if err != nil {
+		_ = ldb.Close()
+		return nil, err
+	}
+	return mdb, nil
+}
@@ -0,0 +1,61 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecommendedLevelDBOptions(t *testing.T) {
+	o := RecommendedLevelDBOptions()
+	if o.BlockCacheCapacity != DefaultBlockCacheSize || o.WriteBuffer != DefaultWriteBuffer ||
+		o.CompactionTableSize != DefaultCompactionTableSize || o.Filter == nil {
+		t.Fatalf("unexpected defaults: %+v", o)
+	}
+	o = RecommendedLevelDBOptions(WithLevelDB(LevelDBOptions{BlockCacheSize: 1 << 20, BloomFilterBits: -1}))
+	if o.BlockCacheCapacity != 1<<20 || o.Filter != nil || o.WriteBuffer != DefaultWriteBuffer {
+		t.Fatalf("unexpected options: %+v", o)
+	}
+	ldb, err := leveldb.Open(storage.NewMemStorage(), RecommendedLevelDBOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdb := New(testPrefix, ldb)
+	defer mdb.Close()
+	hFn := GetHashFn()
+	foo := randomTree(6)
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mdb.Has(RootGindex, foo.MerkleRoot(hFn)); err != nil || !ok {
+		t.Fatalf("expected tree to be stored, err: %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	hFn := GetHashFn()
+	foo := randomTree(6)
+	mdb, err := OpenFile(path, WithLevelDB(LevelDBOptions{BlockCacheSize: 1 << 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mdb, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close()
+	out, err := mdb.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+}
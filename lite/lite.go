@@ -0,0 +1,162 @@
+// Package lite is the read path of merkledb without leveldb: the key and value encoding of the stored nodes,
+// proof verification, and the traversal of stored trees over a pluggable fetch function, see Tree.
+// It compiles to wasm/js, so browsers can verify and traverse trees that were exported from a merkledb,
+// e.g. served as key-value records over HTTP.
+package lite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// ErrMalformed is wrapped by the errors of the decoders of keys and values, for input that is not a valid encoding.
+// The decoders never panic, they are safe for untrusted input.
+var ErrMalformed = errors.New("malformed input")
+
+// PrefixLen is the length of the key prefix of a merkledb
+const PrefixLen = 3
+
+// GindexLenByteLen is the length of the gindex bit length in a key
+const GindexLenByteLen = 2
+
+// MaxGindexByteLen is the length of the longest gindex in a key
+const MaxGindexByteLen = 32
+
+// MaxKeyLen is the length of the longest node key
+const MaxKeyLen = PrefixLen + GindexLenByteLen + MaxGindexByteLen + 32
+
+// PairRecord is the decoded value of a stored node.
+type PairRecord struct {
+	Slot uint64
+	// Pair is false if the node is stored as a single root, without children.
+	Pair  bool
+	Left  Root
+	Right Root
+	// Summary is true for a node that is not a pair, whose subtree is not stored: only its root is known.
+	Summary bool
+	// Partial is true for a pair with summaries in its subtree, so the subtree is only stored in part.
+	Partial bool
+}
+
+// Complete is true if the stored value is of a node with its whole subtree, as far as the node itself tells:
+// a leaf, or a pair that is not partial. Stored summaries and partial pairs are not reused by puts.
+func Complete(value []byte) bool {
+	return len(value) > 0 && value[0] <= 1
+}
+
+// NodeKey is the decoded key of a stored node
+type NodeKey struct {
+	Prefix [PrefixLen]byte
+	Gindex Gindex
+	// BitLen is the bit length of the gindex, i.e. its depth + 1
+	BitLen uint32
+	Root   Root
+}
+
+// BuildKey encodes the key of the node with the root at the gindex into dst:
+// the prefix, the bit length and the left-aligned bits of the gindex, and the root.
+func BuildKey(dst *[MaxKeyLen]byte, prefix [PrefixLen]byte, gindex Gindex, root Root) ([]byte, error) {
+	data, bitLen := gindex.LeftAlignedBigEndian()
+	if len(data) > MaxGindexByteLen {
+		return nil, errors.New("gindex too large")
+	}
+	size := PrefixLen + GindexLenByteLen + len(data) + 32
+	copy(dst[0:PrefixLen], prefix[:])
+	binary.LittleEndian.PutUint16(dst[PrefixLen:PrefixLen+GindexLenByteLen], uint16(bitLen))
+	copy(dst[PrefixLen+GindexLenByteLen:PrefixLen+GindexLenByteLen+len(data)], data)
+	copy(dst[PrefixLen+GindexLenByteLen+len(data):size], root[:])
+	return dst[:size], nil
+}
+
+// GindexFromKey converts the left-aligned gindex bits of a key back into a Gindex
+func GindexFromKey(data []byte, bitLen uint32) (Gindex, error) {
+	if bitLen == 0 || bitLen > 64 || uint32(len(data)) != (bitLen+7)/8 {
+		return nil, fmt.Errorf("%w: cannot convert %d bit gindex '%x'", ErrMalformed, bitLen, data)
+	}
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	// the gindex starts with its leading 1 bit, and is padded with zero bits
+	pad := uint32(len(data))*8 - bitLen
+	if v&(1<<pad-1) != 0 || (v>>pad)>>(bitLen-1) != 1 {
+		return nil, fmt.Errorf("%w: non-canonical %d bit gindex '%x'", ErrMalformed, bitLen, data)
+	}
+	return Gindex64(v >> pad), nil
+}
+
+// ParseKey decodes the key of a node, see BuildKey. Metadata keys have a zero bit length, and fail to parse.
+func ParseKey(key []byte) (NodeKey, error) {
+	if len(key) < PrefixLen+GindexLenByteLen+1+32 {
+		return NodeKey{}, fmt.Errorf("%w: key too short: '%x'", ErrMalformed, key)
+	}
+	var out NodeKey
+	copy(out.Prefix[:], key[:PrefixLen])
+	out.BitLen = uint32(binary.LittleEndian.Uint16(key[PrefixLen : PrefixLen+GindexLenByteLen]))
+	gindex, err := GindexFromKey(key[PrefixLen+GindexLenByteLen:len(key)-32], out.BitLen)
+	if err != nil {
+		return NodeKey{}, err
+	}
+	out.Gindex = gindex
+	copy(out.Root[:], key[len(key)-32:])
+	return out, nil
+}
+
+// AppendValue appends the value of the node record to dst: uint8(0) ++ uint64(slot) for a leaf,
+// uint8(1) ++ uint64(slot) ++ bytes32(left) ++ bytes32(right) for a pair.
+// A summary is stored like a leaf with type 2, a partial pair like a pair with type 3.
+func AppendValue(dst []byte, rec *PairRecord) []byte {
+	var slot [8]byte
+	binary.LittleEndian.PutUint64(slot[:], rec.Slot)
+	if !rec.Pair {
+		if rec.Summary {
+			dst = append(dst, 2)
+		} else {
+			dst = append(dst, 0)
+		}
+		return append(dst, slot[:]...)
+	}
+	if rec.Partial {
+		dst = append(dst, 3)
+	} else {
+		dst = append(dst, 1)
+	}
+	dst = append(dst, slot[:]...)
+	dst = append(dst, rec.Left[:]...)
+	return append(dst, rec.Right[:]...)
+}
+
+// ParseValue decodes the value of a node into dst, see AppendValue
+func ParseValue(value []byte, dst *PairRecord) error {
+	if len(value) < 1+8 {
+		return fmt.Errorf("%w: corrupt value, too short: '%x'", ErrMalformed, value)
+	}
+	typ := value[0]
+	if typ == 0 || typ == 2 {
+		if len(value) != 1+8 {
+			return fmt.Errorf("%w: corrupt leaf value, invalid length: '%x'", ErrMalformed, value)
+		}
+		dst.Slot = binary.LittleEndian.Uint64(value[1 : 1+8])
+		dst.Pair = false
+		dst.Left = Root{}
+		dst.Right = Root{}
+		dst.Summary = typ == 2
+		dst.Partial = false
+		return nil
+	} else if typ == 1 || typ == 3 {
+		if len(value) != 1+8+32+32 {
+			return fmt.Errorf("%w: corrupt pair value, invalid length: '%x'", ErrMalformed, value)
+		}
+		dst.Slot = binary.LittleEndian.Uint64(value[1 : 1+8])
+		dst.Pair = true
+		dst.Summary = false
+		dst.Partial = typ == 3
+		copy(dst.Left[:], value[1+8:1+8+32])
+		copy(dst.Right[:], value[1+8+32:1+8+32+32])
+		return nil
+	} else {
+		return fmt.Errorf("%w: corrupt value, unrecognized typ: '%x'", ErrMalformed, value)
+	}
+}
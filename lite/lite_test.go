@@ -0,0 +1,65 @@
+package lite
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	prefix := [PrefixLen]byte{1, 2, 3}
+	root := Root{0xaa, 0xbb}
+	for _, g := range []uint64{1, 2, 3, 5, 255, 256, 1<<40 | 12345, 1<<63 | 1} {
+		var buf [MaxKeyLen]byte
+		key, err := BuildKey(&buf, prefix, Gindex64(g), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := ParseKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := GindexValue(k.Gindex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.Prefix != prefix || k.Root != root || v != g {
+			t.Fatalf("gindex %d: unexpected key %+v", g, k)
+		}
+	}
+	if _, err := ParseKey(make([]byte, PrefixLen+GindexLenByteLen+32)); err == nil {
+		t.Fatal("expected a short key to fail")
+	}
+	// a 14 bit gindex without its leading bit, and one with non-zero padding
+	for _, data := range [][]byte{{0x30, 0x30}, {0xc0, 0xc1}} {
+		if _, err := GindexFromKey(data, 14); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("expected '%x' to be malformed, got %v", data, err)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	for _, rec := range []PairRecord{
+		{Slot: 7},
+		{Slot: 1 << 40, Pair: true, Left: Root{1}, Right: Root{2}},
+		{Slot: 8, Summary: true},
+		{Slot: 9, Pair: true, Partial: true, Left: Root{3}, Right: Root{4}},
+	} {
+		var out PairRecord
+		if err := ParseValue(AppendValue(nil, &rec), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != rec {
+			t.Fatalf("expected %+v, got %+v", rec, out)
+		}
+	}
+	if err := ParseValue([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0}, new(PairRecord)); err == nil {
+		t.Fatal("expected a pair without children to fail")
+	}
+	if err := ParseValue([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, new(PairRecord)); !errors.Is(err, ErrMalformed) {
+		t.Fatal("expected a leaf with trailing bytes to fail")
+	}
+	if err := ParseValue([]byte{4, 0, 0, 0, 0, 0, 0, 0, 0}, new(PairRecord)); err == nil {
+		t.Fatal("expected an unknown type to fail")
+	}
+}
@@ -0,0 +1,331 @@
+package lite
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// a HashFn from GetHashFn reuses its hash state between calls, and cannot be shared between goroutines
+var hasherPool = sync.Pool{New: func() interface{} {
+	return GetHashFn()
+}}
+
+// ConcurrentHashFn is a HashFn that is safe for concurrent use: every call borrows a hasher from a pool.
+// Proof verification and Tree use it when they are given a nil HashFn.
+func ConcurrentHashFn(a Root, b Root) Root {
+	h := hasherPool.Get().(HashFn)
+	out := h(a, b)
+	hasherPool.Put(h)
+	return out
+}
+
+func hashFnOrDefault(fn HashFn) HashFn {
+	if fn == nil {
+		return ConcurrentHashFn
+	}
+	return fn
+}
+
+// RecordGetter gets the record of the node with the root at the gindex into dst
+type RecordGetter func(gindex Gindex, root Root, dst *PairRecord) error
+
+// MerkleProof proves a Leaf at a Gindex.
+// The Branch is ordered bottom-up: the sibling of the leaf first, the sibling just below the anchor last.
+type MerkleProof struct {
+	Gindex Gindex
+	Leaf   Root
+	Branch []Root
+}
+
+// Verify the proof against the given anchor root
+func (p *MerkleProof) Verify(anchor Root, fn HashFn) bool {
+	fn = hashFnOrDefault(fn)
+	iter, depth := p.Gindex.BitIter()
+	if uint32(len(p.Branch)) != depth {
+		return false
+	}
+	rights := make([]bool, 0, depth)
+	for {
+		right, ok := iter.Next()
+		if !ok {
+			break
+		}
+		rights = append(rights, right)
+	}
+	node := p.Leaf
+	for i, sibling := range p.Branch {
+		if rights[len(rights)-1-i] {
+			node = fn(sibling, node)
+		} else {
+			node = fn(node, sibling)
+		}
+	}
+	return node == anchor
+}
+
+// Prove the node at the target gindex in the tree of the anchor, with the records of the nodes on its path
+func Prove(get RecordGetter, anchor Root, target Gindex) (*MerkleProof, error) {
+	iter, depth := target.BitIter()
+	branch := make([]Root, depth)
+	var rec PairRecord
+	var gindex Gindex = RootGindex
+	node := anchor
+	if err := get(gindex, node, &rec); err != nil {
+		return nil, err
+	}
+	for i := int(depth) - 1; i >= 0; i-- {
+		right, _ := iter.Next()
+		if !rec.Pair {
+			return nil, NavigationError
+		}
+		if right {
+			branch[i] = rec.Left
+			node = rec.Right
+			gindex = gindex.Right()
+		} else {
+			branch[i] = rec.Right
+			node = rec.Left
+			gindex = gindex.Left()
+		}
+		// the leaf itself does not have to be loaded, its root is known from the parent
+		if i > 0 {
+			if err := get(gindex, node, &rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &MerkleProof{Gindex: target, Leaf: node, Branch: branch}, nil
+}
+
+// MultiProof proves multiple leaves of the same tree at once, sharing the branch nodes between them.
+// The Helpers are the roots of the nodes at HelperGindices(Gindices), in the same order.
+type MultiProof struct {
+	Gindices []Gindex
+	Leaves   []Root
+	Helpers  []Root
+}
+
+// GindexValue is the gindex as integer, of gindices up to 63 bits deep
+func GindexValue(g Gindex) (uint64, error) {
+	iter, depth := g.BitIter()
+	if depth >= 64 {
+		return 0, errors.New("gindex too deep, only up to 63 bits deep gindices are supported")
+	}
+	out := uint64(1)
+	for {
+		right, ok := iter.Next()
+		if !ok {
+			return out, nil
+		}
+		out <<= 1
+		if right {
+			out |= 1
+		}
+	}
+}
+
+// GindexValues are the gindices as integers
+func GindexValues(gindices []Gindex) ([]uint64, error) {
+	out := make([]uint64, len(gindices))
+	for i, g := range gindices {
+		v, err := GindexValue(g)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// HelperValues is HelperGindices of gindices as integers
+func HelperValues(targets []uint64) []uint64 {
+	paths := make(map[uint64]struct{})
+	for _, g := range targets {
+		for ; g > 1; g >>= 1 {
+			paths[g] = struct{}{}
+		}
+	}
+	helpers := make(map[uint64]struct{})
+	for _, g := range targets {
+		for ; g > 1; g >>= 1 {
+			if _, ok := paths[g^1]; !ok {
+				helpers[g^1] = struct{}{}
+			}
+		}
+	}
+	out := make([]uint64, 0, len(helpers))
+	for g := range helpers {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] > out[j]
+	})
+	return out
+}
+
+// HelperGindices returns the gindices of the nodes that are needed, next to the given leaves,
+// to verify a multiproof of the leaves. The helpers are ordered by descending gindex, like SSZ multiproofs.
+func HelperGindices(gindices []Gindex) ([]Gindex, error) {
+	targets, err := GindexValues(gindices)
+	if err != nil {
+		return nil, err
+	}
+	helpers := HelperValues(targets)
+	out := make([]Gindex, len(helpers))
+	for i, g := range helpers {
+		out[i] = Gindex64(g)
+	}
+	return out, nil
+}
+
+// Verify the multiproof against the given anchor root
+func (p *MultiProof) Verify(anchor Root, fn HashFn) bool {
+	fn = hashFnOrDefault(fn)
+	if len(p.Gindices) != len(p.Leaves) {
+		return false
+	}
+	targets, err := GindexValues(p.Gindices)
+	if err != nil {
+		return false
+	}
+	helpers := HelperValues(targets)
+	if len(helpers) != len(p.Helpers) {
+		return false
+	}
+	objects := make(map[uint64]Root, len(targets)+len(helpers))
+	for i, g := range targets {
+		objects[g] = p.Leaves[i]
+	}
+	for i, g := range helpers {
+		objects[g] = p.Helpers[i]
+	}
+	keys := make([]uint64, 0, len(objects))
+	for g := range objects {
+		keys = append(keys, g)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] > keys[j]
+	})
+	// parents are appended as their children are hashed, and are always smaller than the keys before them
+	for pos := 0; pos < len(keys); pos++ {
+		g := keys[pos]
+		if g <= 1 {
+			continue
+		}
+		if _, ok := objects[g>>1]; ok {
+			continue
+		}
+		left, okL := objects[g&^1]
+		right, okR := objects[g|1]
+		if !okL || !okR {
+			continue
+		}
+		objects[g>>1] = fn(left, right)
+		keys = append(keys, g>>1)
+	}
+	root, ok := objects[1]
+	return ok && root == anchor
+}
+
+// ProofCheck is a multiproof to verify against the root of its anchor, see VerifyMultiproofBatch
+type ProofCheck struct {
+	Anchor Root
+	Proof  *MultiProof
+}
+
+// VerifyMultiproofBatch verifies many independent multiproofs against their anchors, e.g. incoming witnesses
+// before they are ingested. The proofs are verified by the given number of workers in parallel, 1 if less,
+// that each hash with a HashFn of their own from newHashFn, GetHashFn if nil. It returns if each proof verified,
+// in the order of the checks. A nil proof does not verify.
+func VerifyMultiproofBatch(checks []ProofCheck, workers int, newHashFn func() HashFn) []bool {
+	if newHashFn == nil {
+		newHashFn = GetHashFn
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(checks) {
+		workers = len(checks)
+	}
+	out := make([]bool, len(checks))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			fn := newHashFn()
+			for i := int(atomic.AddInt64(&next, 1)); i < len(checks); i = int(atomic.AddInt64(&next, 1)) {
+				out[i] = checks[i].Proof != nil && checks[i].Proof.Verify(checks[i].Anchor, fn)
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// ProveMulti proves the nodes at the gindices in the tree of the anchor in one multiproof,
+// with the records of the nodes on their paths. Every node is read at most once.
+func ProveMulti(get RecordGetter, anchor Root, gindices []Gindex) (*MultiProof, error) {
+	if len(gindices) == 0 {
+		return nil, errors.New("no gindices to prove")
+	}
+	targets, err := GindexValues(gindices)
+	if err != nil {
+		return nil, err
+	}
+	helpers := HelperValues(targets)
+	needed := make(map[uint64]Root, len(targets)+len(helpers))
+	for _, g := range targets {
+		needed[g] = Root{}
+	}
+	for _, g := range helpers {
+		needed[g] = Root{}
+	}
+	// the ancestors of needed nodes are loaded, every node at most once
+	below := make(map[uint64]struct{})
+	for g := range needed {
+		for g >>= 1; g >= 1; g >>= 1 {
+			below[g] = struct{}{}
+		}
+	}
+	var rec PairRecord
+	var visit func(g uint64, root Root) error
+	visit = func(g uint64, root Root) error {
+		if _, ok := needed[g]; ok {
+			needed[g] = root
+		}
+		if _, ok := below[g]; !ok {
+			return nil
+		}
+		if err := get(Gindex64(g), root, &rec); err != nil {
+			return err
+		}
+		if !rec.Pair {
+			return NavigationError
+		}
+		left, right := rec.Left, rec.Right
+		if err := visit(g<<1, left); err != nil {
+			return err
+		}
+		return visit(g<<1|1, right)
+	}
+	if err := visit(1, anchor); err != nil {
+		return nil, err
+	}
+	out := &MultiProof{
+		Gindices: gindices,
+		Leaves:   make([]Root, len(targets)),
+		Helpers:  make([]Root, len(helpers)),
+	}
+	for i, g := range targets {
+		out.Leaves[i] = needed[g]
+	}
+	for i, g := range helpers {
+		out.Helpers[i] = needed[g]
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package lite
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestHelperGindices(t *testing.T) {
+	helpers, err := HelperGindices([]Gindex{Gindex64(8), Gindex64(9), Gindex64(14)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []uint64
+	for _, g := range helpers {
+		v, _ := GindexValue(g)
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[0] != 15 || got[1] != 6 || got[2] != 5 {
+		t.Fatalf("unexpected helpers: %v", got)
+	}
+	if _, err := GindexValue(Gindex64(1 << 63)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	hFn := GetHashFn()
+	tree := fullTree(3)
+	anchor := tree.MerkleRoot(hFn)
+	records := make(map[string][]byte)
+	storeTree(t, records, RootGindex, tree)
+	lt := &Tree{Prefix: testPrefix, Fetch: mapFetch(records)}
+	p, err := lt.Prove(anchor, Gindex64(13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Branch[1][0] ^= 1
+	if p.Verify(anchor, hFn) {
+		t.Fatal("expected a tampered branch to fail")
+	}
+	mp, err := lt.ProveMulti(anchor, []Gindex{Gindex64(13), Gindex64(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp.Leaves[0][0] ^= 1
+	if mp.Verify(anchor, hFn) {
+		t.Fatal("expected a tampered leaf to fail")
+	}
+}
+
+func TestVerifyMultiproofBatch(t *testing.T) {
+	hFn := GetHashFn()
+	records := make(map[string][]byte)
+	lt := &Tree{Prefix: testPrefix, Fetch: mapFetch(records)}
+	var checks []ProofCheck
+	for i := 0; i < 20; i++ {
+		tree := fullTree(4)
+		anchor := tree.MerkleRoot(hFn)
+		storeTree(t, records, RootGindex, tree)
+		p, err := lt.ProveMulti(anchor, []Gindex{Gindex64(16 + uint64(i)%16), Gindex64(5)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks = append(checks, ProofCheck{Anchor: anchor, Proof: p})
+	}
+	checks[3].Proof.Leaves[0][0] ^= 1
+	checks[7].Anchor = Root{1}
+	checks[11].Proof = nil
+	for _, workers := range []int{0, 1, 4, 64} {
+		out := VerifyMultiproofBatch(checks, workers, nil)
+		if len(out) != len(checks) {
+			t.Fatalf("expected %d results, got %d", len(checks), len(out))
+		}
+		for i, ok := range out {
+			if ok != (i != 3 && i != 7 && i != 11) {
+				t.Fatalf("%d workers: unexpected result of proof %d: %v", workers, i, ok)
+			}
+		}
+	}
+	if out := VerifyMultiproofBatch(nil, 4, nil); len(out) != 0 {
+		t.Fatalf("expected no results: %v", out)
+	}
+}
@@ -0,0 +1,207 @@
+package lite
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// ErrNotFound is returned by a Fetch for a key that it has no value of
+var ErrNotFound = errors.New("not found")
+
+// Fetch gets the raw value of the raw key of a stored node, e.g. from a static file server,
+// an exported backup loaded into memory, or a key-value store of the browser. It returns ErrNotFound for missing keys.
+type Fetch func(key []byte) ([]byte, error)
+
+// Tree reads the stored trees of a merkledb through a Fetch. Every fetched pair is verified against the root
+// it was fetched by, so the fetch does not have to be trusted: the trees are as trusted as their anchor roots.
+type Tree struct {
+	Prefix [PrefixLen]byte
+	Fetch  Fetch
+	// HashFn verifies the fetched pairs, ConcurrentHashFn if nil
+	HashFn HashFn
+}
+
+// GetInto gets the record of the node with the root at the gindex into dst, and verifies it if it is a pair.
+// It has the signature of a RecordGetter.
+func (t *Tree) GetInto(gindex Gindex, root Root, dst *PairRecord) error {
+	var buf [MaxKeyLen]byte
+	key, err := BuildKey(&buf, t.Prefix, gindex, root)
+	if err != nil {
+		return err
+	}
+	value, err := t.Fetch(key)
+	if err != nil {
+		return err
+	}
+	if err := ParseValue(value, dst); err != nil {
+		return fmt.Errorf("node %s: %v", root, err)
+	}
+	if dst.Pair && hashFnOrDefault(t.HashFn)(dst.Left, dst.Right) != root {
+		return fmt.Errorf("node %s does not match the hash of its children", root)
+	}
+	return nil
+}
+
+// Lookup follows the path of the gindex from the anchor, and returns the root of the node at the gindex.
+// Only the nodes above it are fetched.
+func (t *Tree) Lookup(anchor Root, gindex Gindex) (Root, error) {
+	p, err := Prove(t.GetInto, anchor, gindex)
+	if err != nil {
+		return Root{}, err
+	}
+	return p.Leaf, nil
+}
+
+// Prove the node at the target gindex, in the tree of the anchor
+func (t *Tree) Prove(anchor Root, target Gindex) (*MerkleProof, error) {
+	return Prove(t.GetInto, anchor, target)
+}
+
+// ProveMulti proves the nodes at the gindices in the tree of the anchor, in one multiproof
+func (t *Tree) ProveMulti(anchor Root, gindices []Gindex) (*MultiProof, error) {
+	return ProveMulti(t.GetInto, anchor, gindices)
+}
+
+// Node gets the tree of the anchor as a ztyp Node, which fetches its nodes as they are traversed,
+// e.g. to view it with a ztyp type. Reads of nodes that are not stored fail with the error of the fetch.
+func (t *Tree) Node(anchor Root) (Node, error) {
+	return t.node(RootGindex, anchor)
+}
+
+func (t *Tree) node(gindex Gindex, root Root) (Node, error) {
+	var rec PairRecord
+	if err := t.GetInto(gindex, root, &rec); err != nil {
+		return nil, err
+	}
+	if !rec.Pair {
+		return &root, nil
+	}
+	return &lazyNode{tree: t, gindex: gindex, self: root, left: rec.Left, right: rec.Right}, nil
+}
+
+// lazyNode is a stored pair, of which the children are fetched once, when they are first read
+type lazyNode struct {
+	tree        *Tree
+	gindex      Gindex
+	self        Root
+	left, right Root
+	leftNode    Node
+	rightNode   Node
+}
+
+func (n *lazyNode) Left() (Node, error) {
+	if n.leftNode == nil {
+		child, err := n.tree.node(n.gindex.Left(), n.left)
+		if err != nil {
+			return nil, err
+		}
+		n.leftNode = child
+	}
+	return n.leftNode, nil
+}
+
+func (n *lazyNode) Right() (Node, error) {
+	if n.rightNode == nil {
+		child, err := n.tree.node(n.gindex.Right(), n.right)
+		if err != nil {
+			return nil, err
+		}
+		n.rightNode = child
+	}
+	return n.rightNode, nil
+}
+
+func (n *lazyNode) IsLeaf() bool {
+	return false
+}
+
+func (n *lazyNode) RebindLeft(left Node) (Node, error) {
+	right, err := n.Right()
+	if err != nil {
+		return nil, err
+	}
+	return NewPairNode(left, right), nil
+}
+
+func (n *lazyNode) RebindRight(right Node) (Node, error) {
+	left, err := n.Left()
+	if err != nil {
+		return nil, err
+	}
+	return NewPairNode(left, right), nil
+}
+
+func (n *lazyNode) Getter(target Gindex) (Node, error) {
+	if target.IsRoot() {
+		return n, nil
+	}
+	var child Node
+	var err error
+	if target.IsLeft() {
+		child, err = n.Left()
+	} else {
+		child, err = n.Right()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return child.Getter(target.Subtree())
+}
+
+func (n *lazyNode) Setter(target Gindex, expand bool) (Link, error) {
+	if target.IsRoot() {
+		return Identity, nil
+	}
+	if target.IsClose() {
+		if target.IsLeft() {
+			return n.RebindLeft, nil
+		}
+		return n.RebindRight, nil
+	}
+	if target.IsLeft() {
+		left, err := n.Left()
+		if err != nil {
+			return nil, err
+		}
+		return DeeperSetter(n.RebindLeft, left, target, expand)
+	}
+	right, err := n.Right()
+	if err != nil {
+		return nil, err
+	}
+	return DeeperSetter(n.RebindRight, right, target, expand)
+}
+
+func (n *lazyNode) SummarizeInto(target Gindex, h HashFn) (SummaryLink, error) {
+	return SummaryInto(n, target, h)
+}
+
+func (n *lazyNode) MerkleRoot(HashFn) Root {
+	return n.self
+}
+
+var _ Node = (*lazyNode)(nil)
+
+// Walk visits the stored nodes of the tree of the anchor, depth-first, parents before their children,
+// and skips the subtrees that fn returns false for. Nodes that are not stored are skipped.
+func (t *Tree) Walk(anchor Root, fn func(gindex Gindex, root Root, rec *PairRecord) (bool, error)) error {
+	var visit func(gindex Gindex, root Root) error
+	visit = func(gindex Gindex, root Root) error {
+		var rec PairRecord
+		if err := t.GetInto(gindex, root, &rec); errors.Is(err, ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		deeper, err := fn(gindex, root, &rec)
+		if err != nil || !deeper || !rec.Pair {
+			return err
+		}
+		if err := visit(gindex.Left(), rec.Left); err != nil {
+			return err
+		}
+		return visit(gindex.Right(), rec.Right)
+	}
+	return visit(RootGindex, anchor)
+}
@@ -0,0 +1,106 @@
+package lite
+
+import (
+	"crypto/rand"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+var testPrefix = [PrefixLen]byte{0xde, 0xad, 0x01}
+
+// storeTree writes the records of the tree like merkledb stores them, as a browser would receive them
+func storeTree(t *testing.T, records map[string][]byte, gindex Gindex, node Node) {
+	hFn := GetHashFn()
+	var buf [MaxKeyLen]byte
+	key, err := BuildKey(&buf, testPrefix, gindex, node.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := PairRecord{Slot: 3}
+	if !node.IsLeaf() {
+		left, _ := node.Left()
+		right, _ := node.Right()
+		rec.Pair, rec.Left, rec.Right = true, left.MerkleRoot(hFn), right.MerkleRoot(hFn)
+		storeTree(t, records, gindex.Left(), left)
+		storeTree(t, records, gindex.Right(), right)
+	}
+	records[string(key)] = AppendValue(nil, &rec)
+}
+
+func fullTree(depth int) Node {
+	if depth == 0 {
+		var r Root
+		_, _ = rand.Read(r[:])
+		return &r
+	}
+	return NewPairNode(fullTree(depth-1), fullTree(depth-1))
+}
+
+func mapFetch(records map[string][]byte) Fetch {
+	return func(key []byte) ([]byte, error) {
+		v, ok := records[string(key)]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+}
+
+func TestTree(t *testing.T) {
+	hFn := GetHashFn()
+	tree := fullTree(4)
+	anchor := tree.MerkleRoot(hFn)
+	records := make(map[string][]byte)
+	storeTree(t, records, RootGindex, tree)
+	lt := &Tree{Prefix: testPrefix, Fetch: mapFetch(records)}
+
+	expected, err := tree.Getter(Gindex64(1<<4 | 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, err := lt.Lookup(anchor, Gindex64(1<<4|9)); err != nil || root != expected.MerkleRoot(hFn) {
+		t.Fatalf("unexpected lookup: %s, err: %v", root, err)
+	}
+	node, err := lt.Node(anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := node.Getter(Gindex64(1<<4 | 9))
+	if err != nil || leaf.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatalf("unexpected traversed leaf, err: %v", err)
+	}
+	p, err := lt.Prove(anchor, Gindex64(1<<3|2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Verify(anchor, hFn) {
+		t.Fatal("expected the proof to verify")
+	}
+	mp, err := lt.ProveMulti(anchor, []Gindex{Gindex64(1<<4 | 1), Gindex64(1<<2 | 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mp.Verify(anchor, hFn) {
+		t.Fatal("expected the multiproof to verify")
+	}
+	count := 0
+	if err := lt.Walk(anchor, func(gindex Gindex, root Root, rec *PairRecord) (bool, error) {
+		count++
+		return gindex.Depth() < 2, nil
+	}); err != nil || count != 7 {
+		t.Fatalf("expected 7 walked nodes, got %d, err: %v", count, err)
+	}
+
+	// a fetch that serves another child is caught by the hash of the parent
+	left, _ := tree.Left()
+	var buf [MaxKeyLen]byte
+	key, _ := BuildKey(&buf, testPrefix, RootGindex, anchor)
+	forged := PairRecord{Slot: 3, Pair: true, Left: left.MerkleRoot(hFn), Right: Root{1}}
+	records[string(key)] = AppendValue(nil, &forged)
+	if _, err := lt.Lookup(anchor, Gindex64(3)); err == nil {
+		t.Fatal("expected a forged node to fail")
+	}
+	if _, err := lt.Node(Root{2}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
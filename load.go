@@ -0,0 +1,114 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// LoadStrategy is how Get returns a stored pair node
+type LoadStrategy byte
+
+const (
+	// LoadLazy returns a virtual node, that loads its children when they are read
+	LoadLazy LoadStrategy = iota
+	// LoadEager loads the subtree up to a depth into memory, with virtual nodes below that depth
+	LoadEager
+	// LoadSummary returns only the root of the node, as a summary leaf that Put rejects, e.g. for proofs that need no children
+	LoadSummary
+)
+
+func (s LoadStrategy) String() string {
+	switch s {
+	case LoadLazy:
+		return "lazy"
+	case LoadEager:
+		return "eager"
+	case LoadSummary:
+		return "summary"
+	default:
+		return fmt.Sprintf("LoadStrategy(%d)", byte(s))
+	}
+}
+
+// GetOptions configures a Get
+type GetOptions struct {
+	// Load is how a pair node is returned, lazily by default
+	Load LoadStrategy
+	// Depth is how deep below the node LoadEager reads: pairs above it are in memory, pairs at it are virtual
+	Depth uint32
+}
+
+type GetOption func(o *GetOptions)
+
+// WithEagerLoad reads the subtree of a pair node up to the given depth below it, all at once,
+// e.g. to export the subtree without a read per node later. The pairs at the depth are virtual nodes.
+// If a node of the subtree is not stored, the Get fails.
+func WithEagerLoad(depth uint32) GetOption {
+	return func(o *GetOptions) {
+		o.Load = LoadEager
+		o.Depth = depth
+	}
+}
+
+// WithSummaryLoad returns a pair node as its root only, without reading or referencing its children.
+// The summary cannot be put again, Put fails with ErrSummaryNode.
+func WithSummaryLoad() GetOption {
+	return func(o *GetOptions) {
+		o.Load = LoadSummary
+	}
+}
+
+func applyGetOptions(opts []GetOption) (out GetOptions) {
+	for _, o := range opts {
+		o(&out)
+	}
+	return
+}
+
+// recordReader reads the stored records of nodes
+type recordReader interface {
+	GetInto(gindex Gindex, key Root, dst *PairRecord) error
+}
+
+// loadNode returns the node of the record as the options ask for. Virtual nodes load their children from the getter,
+// and bound their targets by the maximum depth.
+func loadNode(r recordReader, getter nodeGetter, maxDepth uint32, gindex Gindex, key Root, rec *PairRecord, opts GetOptions) (Node, error) {
+	if !rec.Pair {
+		return leafNode(gindex, key, rec), nil
+	}
+	switch opts.Load {
+	case LoadSummary:
+		return &summaryNode{gindex: gindex, root: key}, nil
+	case LoadEager:
+		return loadEager(r, getter, maxDepth, gindex, key, rec, opts.Depth)
+	default:
+		return &virtualNode{db: getter, gindex: gindex, maxDepth: maxDepth, self: key, slot: rec.Slot, left: rec.Left, right: rec.Right}, nil
+	}
+}
+
+func loadEager(r recordReader, getter nodeGetter, maxDepth uint32, gindex Gindex, key Root, rec *PairRecord, depth uint32) (Node, error) {
+	if !rec.Pair {
+		return leafNode(gindex, key, rec), nil
+	}
+	if depth == 0 {
+		return &virtualNode{db: getter, gindex: gindex, maxDepth: maxDepth, self: key, slot: rec.Slot, left: rec.Left, right: rec.Right}, nil
+	}
+	left, right := rec.Left, rec.Right
+	var child PairRecord
+	if err := r.GetInto(gindex.Left(), left, &child); err != nil {
+		return nil, summarized(err, gindex.Left(), left)
+	}
+	leftNode, err := loadEager(r, getter, maxDepth, gindex.Left(), left, &child, depth-1)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.GetInto(gindex.Right(), right, &child); err != nil {
+		return nil, summarized(err, gindex.Right(), right)
+	}
+	rightNode, err := loadEager(r, getter, maxDepth, gindex.Right(), right, &child, depth-1)
+	if err != nil {
+		return nil, err
+	}
+	// the root is known, it is not hashed again
+	return &PairNode{Value: key, LeftChild: leftNode, RightChild: rightNode}, nil
+}
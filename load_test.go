@@ -0,0 +1,86 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_GetLoadStrategy(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	tree := fullTree(4)
+	root := tree.MerkleRoot(hFn)
+	if _, err := mdb.Put(3, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+
+	lazy, err := mdb.Get(RootGindex, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lazy.Node.(VirtualNode); !ok {
+		t.Fatalf("expected a virtual node by default, got %T", lazy.Node)
+	}
+
+	summary, err := mdb.Get(RootGindex, root, WithSummaryLoad())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := summary.Node.(*summaryNode); !ok || summary.Node.MerkleRoot(hFn) != root || summary.Slot != 3 {
+		t.Fatalf("expected the root only: %+v", summary)
+	}
+	if _, err := New(testPrefix, newMemoryDB()).Put(4, summary.Node, hFn); !errors.Is(err, ErrSummaryNode) {
+		t.Fatalf("expected ErrSummaryNode, got %v", err)
+	}
+
+	eager, err := mdb.Get(RootGindex, root, WithEagerLoad(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, ok := eager.Node.(*PairNode)
+	if !ok {
+		t.Fatalf("expected an in-memory pair, got %T", eager.Node)
+	}
+	if pair.MerkleRoot(hFn) != root {
+		t.Fatal("unexpected root")
+	}
+	// the pairs above the depth are in memory, the pairs at the depth are virtual
+	for g := uint64(2); g < 8; g++ {
+		n, err := pair.Getter(Gindex64(g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, virtual := n.(VirtualNode); virtual != (g >= 4) {
+			t.Fatalf("node %d: unexpected %T", g, n)
+		}
+	}
+	compareNodes(tree, eager.Node, RootGindex, hFn, t)
+
+	// loading the full tree leaves no virtual nodes
+	full, err := mdb.Get(RootGindex, root, WithEagerLoad(^uint32(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := full.Node.Getter(Gindex64(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := leaf.(*Root); !ok {
+		t.Fatalf("expected a leaf, got %T", leaf)
+	}
+
+	// a missing node fails the eager load, not the lazy one
+	left := tree.(*PairNode).LeftChild.(*PairNode).LeftChild.MerkleRoot(hFn)
+	if err := mdb.Delete(Gindex64(4), left); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Get(RootGindex, root, WithEagerLoad(3)); err == nil {
+		t.Fatal("expected the eager load to fail")
+	} else if _, ok := err.(ErrSummarized); !ok {
+		t.Fatalf("expected a summarized node error, got %v", err)
+	}
+	if _, err := mdb.Get(RootGindex, root); err != nil {
+		t.Fatal(err)
+	}
+}
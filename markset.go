@@ -0,0 +1,88 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"os"
+)
+
+// markSet is the set of keys that a prune keeps. Up to the configured number of keys are kept in memory,
+// the rest are spilled to a temporary leveldb on disk, so that marking billions of nodes does not need
+// tens of GB of memory. Lookups of spilled keys go through a bloom filter first.
+type markSet struct {
+	mem   map[string]struct{}
+	limit int
+	dir   string
+	spill *leveldb.DB
+	// spillDir is the temporary directory of the spill database, removed on close
+	spillDir string
+	spilled  int
+}
+
+func (db *merkleDB) newMarkSet() *markSet {
+	return &markSet{mem: make(map[string]struct{}), limit: db.opts.MarkMemoryLimit, dir: db.opts.MarkSpillDir}
+}
+
+func (s *markSet) has(key []byte) (bool, error) {
+	if _, ok := s.mem[string(key)]; ok {
+		return true, nil
+	}
+	if s.spill == nil {
+		return false, nil
+	}
+	return s.spill.Has(key, nil)
+}
+
+// add a key, which must not be in the set yet
+func (s *markSet) add(key []byte) error {
+	s.mem[string(key)] = struct{}{}
+	if s.limit > 0 && len(s.mem) >= s.limit {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush moves the keys in memory to the spill database
+func (s *markSet) flush() error {
+	if s.spill == nil {
+		dir, err := os.MkdirTemp(s.dir, "merkledb-mark-")
+		if err != nil {
+			return err
+		}
+		s.spillDir = dir
+		s.spill, err = leveldb.OpenFile(dir, &opt.Options{
+			Filter: filter.NewBloomFilter(10),
+			// the set is rebuilt by the next prune if lost
+			NoSync: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	b := new(leveldb.Batch)
+	for k := range s.mem {
+		b.Put([]byte(k), nil)
+	}
+	if err := s.spill.Write(b, nil); err != nil {
+		return err
+	}
+	s.spilled += len(s.mem)
+	s.mem = make(map[string]struct{})
+	return nil
+}
+
+func (s *markSet) len() int {
+	return len(s.mem) + s.spilled
+}
+
+// close removes the spilled keys, if any
+func (s *markSet) close() error {
+	if s.spill == nil {
+		return nil
+	}
+	if err := s.spill.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.spillDir)
+}
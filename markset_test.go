@@ -0,0 +1,69 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"os"
+	"testing"
+)
+
+func TestMerkleDB_PruneMarkSpill(t *testing.T) {
+	hFn := GetHashFn()
+	dir := t.TempDir()
+	spilling := New(testPrefix, newMemoryDB(), WithMarkSpill(5, dir)).(*merkleDB)
+	inMemory := New(testPrefix, newMemoryDB()).(*merkleDB)
+	shared := randomTree(5)
+	trees := []Node{NewPairNode(shared, randomTree(4)), NewPairNode(randomTree(4), shared), randomTree(6)}
+	var roots []Root
+	for i, n := range trees {
+		for _, mdb := range []*merkleDB{spilling, inMemory} {
+			if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		roots = append(roots, n.MerkleRoot(hFn))
+	}
+	marked, err := spilling.mark(roots[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked.spilled == 0 {
+		t.Fatal("expected marked keys to be spilled")
+	}
+	expected, err := inMemory.mark(roots[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked.len() != expected.len() {
+		t.Fatalf("expected %d marked keys, got %d", expected.len(), marked.len())
+	}
+	for k := range expected.mem {
+		if ok, err := marked.has([]byte(k)); err != nil || !ok {
+			t.Fatalf("expected key '%x' to be marked, err: %v", k, err)
+		}
+	}
+	if err := marked.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mdb := range []*merkleDB{spilling, inMemory} {
+		if err := mdb.Prune(roots[:2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, b := countKeys(t, spilling), countKeys(t, inMemory); a != b || a != expected.len() {
+		t.Fatalf("expected %d keys after the prune, got %d with spilling, %d without", expected.len(), a, b)
+	}
+	for _, root := range roots[:2] {
+		if report, err := spilling.Completeness(root); err != nil || !report.Complete() {
+			t.Fatalf("expected the live tree to be complete, err: %v", err)
+		}
+	}
+	// the spill databases are removed after use
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected the spill directory to be empty, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,63 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"reflect"
+	"sync"
+)
+
+// RootMemo caches the merkle roots of nodes by node identity, for the duration of an ingestion session.
+// ztyp pair nodes already cache their own root; the memo serves Node implementations that hash on every call,
+// so subtrees that are shared between the trees of a session are hashed only once. See WithRootMemo.
+// A RootMemo is safe for concurrent use.
+type RootMemo struct {
+	mu    sync.Mutex
+	roots map[Node]Root
+}
+
+func NewRootMemo() *RootMemo {
+	return &RootMemo{roots: make(map[Node]Root)}
+}
+
+// Root returns the merkle root of the node, hashing it only if it was not seen before
+func (m *RootMemo) Root(node Node, fn HashFn) Root {
+	switch n := node.(type) {
+	case *Root:
+		return *n
+	case *PairNode:
+		// hash the children through the memo, the pair caches its own root
+		if n.Value == (Root{}) {
+			n.Value = fn(m.Root(n.LeftChild, fn), m.Root(n.RightChild, fn))
+		}
+		return n.Value
+	}
+	// only nodes with an identity can be remembered
+	if !reflect.TypeOf(node).Comparable() {
+		return node.MerkleRoot(fn)
+	}
+	m.mu.Lock()
+	root, ok := m.roots[node]
+	m.mu.Unlock()
+	if ok {
+		return root
+	}
+	root = node.MerkleRoot(fn)
+	m.mu.Lock()
+	m.roots[node] = root
+	m.mu.Unlock()
+	return root
+}
+
+// Len returns the number of remembered roots
+func (m *RootMemo) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.roots)
+}
+
+// Reset forgets all remembered roots, e.g. at the end of a session
+func (m *RootMemo) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roots = make(map[Node]Root)
+}
@@ -0,0 +1,46 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+// hashingNode hashes its children on every call, like a Node implementation without a root cache
+type hashingNode struct {
+	PairNode
+	hashed *int
+}
+
+func (n *hashingNode) MerkleRoot(h HashFn) Root {
+	*n.hashed += 1
+	return h(n.LeftChild.MerkleRoot(h), n.RightChild.MerkleRoot(h))
+}
+
+func TestRootMemo(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	hashed := 0
+	shared := &hashingNode{PairNode: PairNode{LeftChild: randomRoot(), RightChild: randomRoot()}, hashed: &hashed}
+	memo := NewRootMemo()
+	for i := 0; i < 3; i++ {
+		n := NewPairNode(shared, randomTree(3))
+		if _, err := mdb.Put(uint64(i), n, hFn, WithRootMemo(memo)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hashed != 1 {
+		t.Fatalf("expected the shared node to be hashed once, got %d", hashed)
+	}
+	out, err := mdb.Get(RootGindex.Left(), shared.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(shared, out.Node, RootGindex.Left(), hFn, t)
+	if memo.Len() != 1 {
+		t.Fatalf("expected only the shared node to be remembered, got %d", memo.Len())
+	}
+	memo.Reset()
+	if memo.Len() != 0 {
+		t.Fatal("expected empty memo after reset")
+	}
+}
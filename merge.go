@@ -0,0 +1,181 @@
+package merkledb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+)
+
+// ErrMergeConflict is returned by a merge with NamesFail, when a named reference points at different roots
+var ErrMergeConflict = errors.New("merge conflict")
+
+// SlotPolicy picks the slot of a node or anchor that is stored in both databases of a merge
+type SlotPolicy byte
+
+const (
+	// SlotsEarliest keeps the earliest slot, when the node was first stored in either database
+	SlotsEarliest SlotPolicy = iota
+	// SlotsLatest keeps the latest slot
+	SlotsLatest
+	// SlotsOurs keeps the slot of the database that is merged into
+	SlotsOurs
+	// SlotsTheirs takes the slot of the merged database
+	SlotsTheirs
+)
+
+func (p SlotPolicy) pick(ours uint64, theirs uint64) uint64 {
+	switch p {
+	case SlotsLatest:
+		if theirs > ours {
+			return theirs
+		}
+	case SlotsTheirs:
+		return theirs
+	case SlotsEarliest:
+		if theirs < ours {
+			return theirs
+		}
+	}
+	return ours
+}
+
+// NamePolicy resolves a named reference that points at different roots in the databases of a merge
+type NamePolicy byte
+
+const (
+	// NamesOurs keeps the reference of the database that is merged into
+	NamesOurs NamePolicy = iota
+	// NamesTheirs takes the reference of the merged database
+	NamesTheirs
+	// NamesFail stops the merge with ErrMergeConflict
+	NamesFail
+)
+
+// MergeOptions configures Merge
+type MergeOptions struct {
+	Slots SlotPolicy
+	Names NamePolicy
+}
+
+// MergeReport counts what a merge changed
+type MergeReport struct {
+	// NewNodes were not stored, UpdatedNodes got another slot, RepairedNodes had a corrupt value
+	NewNodes, UpdatedNodes, RepairedNodes int
+	NewAnchors, UpdatedAnchors            int
+	NewRefs, NewPins                      int
+	// ConflictingRefs are the names of the references that point at different roots, whatever the policy
+	ConflictingRefs []string
+}
+
+func (db *merkleDB) Merge(src TreeReader, opts MergeOptions) (*MergeReport, error) {
+	// the records of the source are streamed as a backup, from a snapshot of it
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := src.Backup(pw)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	report := new(MergeReport)
+	_, err := db.restore(pr, func(b *leveldb.Batch, key []byte, value []byte) error {
+		return db.mergeRecord(b, key, value, &opts, report)
+	})
+	// stops the backup if the merge failed first
+	_ = pr.Close()
+	if srcErr := <-done; srcErr != nil && err == nil {
+		err = srcErr
+	}
+	return report, err
+}
+
+func (db *merkleDB) mergeRecord(b *leveldb.Batch, key []byte, theirs []byte, opts *MergeOptions, report *MergeReport) error {
+	kind, meta := metaKind(key)
+	ours, err := db.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		b.Put(key, theirs)
+		switch {
+		case !meta:
+			report.NewNodes += 1
+		case kind == metaAnchor:
+			report.NewAnchors += 1
+		case kind == metaRef:
+			report.NewRefs += 1
+		case kind == metaPin:
+			report.NewPins += 1
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case !meta:
+		var o, t PairRecord
+		if err := parseValue(ours, &o); err != nil {
+			// the backup records are validated, theirs is intact
+			b.Put(key, theirs)
+			report.RepairedNodes += 1
+			return nil
+		}
+		if err := parseValue(theirs, &t); err != nil {
+			return err
+		}
+		if slot := opts.Slots.pick(o.Slot, t.Slot); slot != o.Slot {
+			o.Slot = slot
+			b.Put(key, encodeValue(&o))
+			report.UpdatedNodes += 1
+		}
+	case kind == metaAnchor:
+		root := toRoot(key[metaKeyLen:])
+		var o, t Anchor
+		if err := o.decode(root, ours); err != nil {
+			return corruptMeta(kind, key[metaKeyLen:], ours, err)
+		}
+		if err := t.decode(root, theirs); err != nil {
+			return err
+		}
+		merged := o
+		merged.Slot = opts.Slots.pick(o.Slot, t.Slot)
+		if merged.Slot != o.Slot || o.InsertedAt.IsZero() {
+			merged.InsertedAt = t.InsertedAt
+		}
+		merged.Canonical = o.Canonical || t.Canonical
+		if merged.Parent == (Root{}) {
+			merged.Parent = t.Parent
+		}
+		if merged.Provenance == (Root{}) {
+			merged.Provenance = t.Provenance
+		}
+		if merged != o {
+			b.Put(key, merged.encode())
+			report.UpdatedAnchors += 1
+		}
+	case kind == metaTags:
+		o, err := decodeTags(ours)
+		if err != nil {
+			return corruptMeta(kind, key[metaKeyLen:], ours, err)
+		}
+		t, err := decodeTags(theirs)
+		if err != nil {
+			return err
+		}
+		if merged := mergeTags(o, t); len(merged) != len(o) {
+			b.Put(key, encodeTags(merged))
+		}
+	case kind == metaRef:
+		if bytes.Equal(ours, theirs) {
+			return nil
+		}
+		name := string(key[metaKeyLen:])
+		report.ConflictingRefs = append(report.ConflictingRefs, name)
+		switch opts.Names {
+		case NamesTheirs:
+			b.Put(key, theirs)
+		case NamesFail:
+			return fmt.Errorf("ref '%s' is %x here, and %x in the source: %w", name, ours, theirs, ErrMergeConflict)
+		}
+	}
+	return nil
+}
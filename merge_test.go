@@ -0,0 +1,93 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Merge(t *testing.T) {
+	hFn := GetHashFn()
+	ldb := newMemoryDB()
+	dst := New(testPrefix, ldb).(*merkleDB)
+	src := New(testPrefix, newMemoryDB())
+	x, y, shared := fullTree(3), fullTree(3), fullTree(3)
+	xRoot, yRoot, sharedRoot := x.MerkleRoot(hFn), y.MerkleRoot(hFn), shared.MerkleRoot(hFn)
+	for _, p := range []struct {
+		db   MerkleDB
+		slot uint64
+		node Node
+	}{{dst, 5, x}, {dst, 3, shared}, {src, 7, y}, {src, 1, shared}} {
+		if _, err := p.db.Put(p.slot, p.node, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dst.SetRef("head", xRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetRef("head", yRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetRef("other", yRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Pin(yRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := dst.Merge(src, MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NewNodes != 15 || report.UpdatedNodes != 15 || report.NewAnchors != 1 || report.UpdatedAnchors != 1 ||
+		report.NewRefs != 1 || report.NewPins != 1 || len(report.ConflictingRefs) != 1 || report.ConflictingRefs[0] != "head" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	out, err := dst.Get(RootGindex, yRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(y, out.Node, RootGindex, hFn, t)
+	// the earliest slot is kept by default, and our name
+	if a, err := dst.GetAnchor(sharedRoot); err != nil || a.Slot != 1 {
+		t.Fatalf("expected the earliest slot, got %d, err: %v", a.Slot, err)
+	}
+	if out, err := dst.Get(RootGindex, sharedRoot); err != nil || out.Slot != 1 {
+		t.Fatalf("expected the earliest slot, got %d, err: %v", out.Slot, err)
+	}
+	if root, err := dst.GetRef("head"); err != nil || root != xRoot {
+		t.Fatalf("expected our ref to stay, got %s, err: %v", root, err)
+	}
+	if root, err := dst.GetRef("other"); err != nil || root != yRoot {
+		t.Fatalf("expected the new ref, got %s, err: %v", root, err)
+	}
+	if pins, err := dst.Pins(); err != nil || len(pins) != 1 || pins[0] != yRoot {
+		t.Fatalf("expected the pin, got %v, err: %v", pins, err)
+	}
+
+	if _, err := dst.Merge(src, MergeOptions{Names: NamesFail}); !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected a merge conflict, got %v", err)
+	}
+	if _, err := dst.Merge(src, MergeOptions{Slots: SlotsLatest, Names: NamesTheirs}); err != nil {
+		t.Fatal(err)
+	}
+	if root, err := dst.GetRef("head"); err != nil || root != yRoot {
+		t.Fatalf("expected their ref, got %s, err: %v", root, err)
+	}
+
+	// a corrupt node is replaced by the intact one of the source
+	var buf [maxKeyLen]byte
+	k, err := dst.buildKey(&buf, RootGindex, sharedRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Put(k, []byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := dst.Merge(src, MergeOptions{}); err != nil || report.RepairedNodes != 1 {
+		t.Fatalf("expected a repaired node, got %+v, err: %v", report, err)
+	}
+	if _, err := dst.Get(RootGindex, sharedRoot); err != nil {
+		t.Fatal(err)
+	}
+}
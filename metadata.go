@@ -0,0 +1,240 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"strings"
+	"time"
+)
+
+// metaPrefix is the metadata block of the prefix, see Open
+const metaPrefix byte = 'M'
+
+const prefixMetadataVersion = 0
+
+// KeyLayout is the version of the layout of the keys and values of nodes
+const KeyLayout = 1
+
+// DefaultHashName is the name of the hash function of SSZ merkleization, used if no other is configured
+const DefaultHashName = "sha256"
+
+// ErrConfigMismatch is returned by Open when the options do not match the metadata block of the prefix
+var ErrConfigMismatch = errors.New("configuration does not match the metadata of the prefix")
+
+// PrefixMetadata describes how the trees under a prefix were written, see Open
+type PrefixMetadata struct {
+	// Created is when the metadata was first written
+	Created time.Time
+	// SchemaVersion is the version of the application schema, see WithSchemaVersion
+	SchemaVersion uint32
+	// HashName is the name of the hash function of the trees, see WithHashName
+	HashName string
+	// KeyLayout is the layout of the node keys and values
+	KeyLayout uint8
+	// DeferredDeletes is true if deletes leave tombstones, to be reclaimed later
+	DeferredDeletes bool
+	// The retention profile
+	Profile        Profile
+	TTL            time.Duration
+	TTLSlots       uint64
+	RetainRefsOnly bool
+}
+
+// maxMetadataName bounds the hash name and the profile, which are stored with a length byte
+const maxMetadataName = 255
+
+func metadataOf(o *Options) PrefixMetadata {
+	hashName := o.HashName
+	if hashName == "" {
+		hashName = DefaultHashName
+	}
+	return PrefixMetadata{
+		SchemaVersion:   o.SchemaVersion,
+		HashName:        hashName,
+		KeyLayout:       KeyLayout,
+		DeferredDeletes: o.DeferredDeletes,
+		Profile:         o.Profile,
+		TTL:             o.TTL,
+		TTLSlots:        o.TTLSlots,
+		RetainRefsOnly:  o.RetainRefsOnly,
+	}
+}
+
+// check fails for the names that do not fit the metadata block
+func (m *PrefixMetadata) check() error {
+	if len(m.HashName) > maxMetadataName {
+		return fmt.Errorf("hash name of %d bytes is longer than %d bytes", len(m.HashName), maxMetadataName)
+	}
+	if len(m.Profile) > maxMetadataName {
+		return fmt.Errorf("profile of %d bytes is longer than %d bytes", len(m.Profile), maxMetadataName)
+	}
+	return nil
+}
+
+func (m *PrefixMetadata) encode() []byte {
+	out := make([]byte, 0, 1+8+4+1+len(m.HashName)+1+1+1+len(m.Profile)+8+8+1)
+	var scratch [8]byte
+	out = append(out, prefixMetadataVersion)
+	binary.LittleEndian.PutUint64(scratch[:], uint64(m.Created.UnixNano()))
+	out = append(out, scratch[:]...)
+	binary.LittleEndian.PutUint32(scratch[:4], m.SchemaVersion)
+	out = append(out, scratch[:4]...)
+	out = append(out, byte(len(m.HashName)))
+	out = append(out, m.HashName...)
+	out = append(out, m.KeyLayout)
+	var flags byte
+	if m.DeferredDeletes {
+		flags |= 1
+	}
+	if m.RetainRefsOnly {
+		flags |= 2
+	}
+	out = append(out, flags)
+	out = append(out, byte(len(m.Profile)))
+	out = append(out, m.Profile...)
+	binary.LittleEndian.PutUint64(scratch[:], uint64(m.TTL))
+	out = append(out, scratch[:]...)
+	binary.LittleEndian.PutUint64(scratch[:], m.TTLSlots)
+	out = append(out, scratch[:]...)
+	return out
+}
+
+func (m *PrefixMetadata) decode(v []byte) error {
+	corrupt := fmt.Errorf("corrupt prefix metadata: '%x'", v)
+	if len(v) < 1 {
+		return corrupt
+	}
+	if v[0] != prefixMetadataVersion {
+		return fmt.Errorf("unknown prefix metadata version: %d", v[0])
+	}
+	v = v[1:]
+	if len(v) < 8+4+1 {
+		return corrupt
+	}
+	*m = PrefixMetadata{
+		Created:       time.Unix(0, int64(binary.LittleEndian.Uint64(v[:8]))),
+		SchemaVersion: binary.LittleEndian.Uint32(v[8:12]),
+	}
+	n := int(v[12])
+	v = v[13:]
+	if len(v) < n+1+1+1 {
+		return corrupt
+	}
+	m.HashName = string(v[:n])
+	m.KeyLayout = v[n]
+	flags := v[n+1]
+	m.DeferredDeletes = flags&1 != 0
+	m.RetainRefsOnly = flags&2 != 0
+	n, v = int(v[n+2]), v[n+3:]
+	if len(v) != n+8+8 {
+		return corrupt
+	}
+	m.Profile = Profile(v[:n])
+	m.TTL = time.Duration(binary.LittleEndian.Uint64(v[n : n+8]))
+	m.TTLSlots = binary.LittleEndian.Uint64(v[n+8:])
+	return nil
+}
+
+// mismatches lists the settings that differ from the other metadata, the creation time does not count
+func (m *PrefixMetadata) mismatches(other *PrefixMetadata) []string {
+	var out []string
+	check := func(name string, a interface{}, b interface{}) {
+		if a != b {
+			out = append(out, fmt.Sprintf("%s is %v, not %v", name, a, b))
+		}
+	}
+	check("schema version", m.SchemaVersion, other.SchemaVersion)
+	check("hash", m.HashName, other.HashName)
+	check("key layout", m.KeyLayout, other.KeyLayout)
+	check("deferred deletes", m.DeferredDeletes, other.DeferredDeletes)
+	check("profile", m.Profile, other.Profile)
+	check("ttl", m.TTL, other.TTL)
+	check("ttl slots", m.TTLSlots, other.TTLSlots)
+	check("retain refs only", m.RetainRefsOnly, other.RetainRefsOnly)
+	return out
+}
+
+// ReadMetadata reads the metadata block of the prefix, leveldb.ErrNotFound if it was never opened with Open
+func ReadMetadata(db *leveldb.DB, prefix [prefixLen]byte) (*PrefixMetadata, error) {
+	v, err := db.Get(metaKeyOf(prefix, metaPrefix, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValueSize(metaPrefix, nil, v, 0); err != nil {
+		return nil, err
+	}
+	var m PrefixMetadata
+	if err := m.decode(v); err != nil {
+		return nil, corruptMeta(metaPrefix, nil, v, err)
+	}
+	return &m, nil
+}
+
+// Open is New, with the options validated against the metadata block of the prefix.
+// The first Open of a prefix writes the block. Later opens fail with ErrConfigMismatch if the schema version,
+// the hash, the key layout, deferred deletes or the retention profile of the options differ,
+// unless WithMetadataRewrite is used to change them on purpose. New reports the mismatch to the background error handler.
+// Open fails for hash names and profiles over 255 bytes too, which New reports,
+// and with WithAuditLog if the last record of the audit log cannot be read.
+// With WithLease, Open fails with a LeaseError while another writer holds the prefix.
+// With a strict WithPrefixCheck, Open fails with a PrefixCollisionError if the prefix appears to be used by another subsystem.
+func Open(prefix [prefixLen]byte, db *leveldb.DB, opts ...Option) (MerkleDB, error) {
+	mdb := New(prefix, db, opts...).(*merkleDB)
+	if mdb.collision != nil && mdb.opts.StrictPrefixCheck {
+		mdb.stop()
+		return nil, mdb.collision
+	}
+	if mdb.invalid != nil {
+		mdb.stop()
+		return nil, mdb.invalid
+	}
+	if err := mdb.checkMetadata(); err != nil {
+		mdb.stop()
+		return nil, err
+	}
+	if mdb.opts.LeaseTTL > 0 {
+		if err := mdb.acquireLease(); err != nil {
+			mdb.stop()
+			return nil, err
+		}
+	}
+	return mdb, nil
+}
+
+// compareMetadata fails with ErrConfigMismatch if the options differ from the stored metadata block of the prefix,
+// unless the metadata is rewritten. New reports it to OnBackgroundError, and Open fails with it.
+func (db *merkleDB) compareMetadata() error {
+	if db.opts.RewriteMetadata {
+		return nil
+	}
+	stored, err := ReadMetadata(db.db, db.prefix)
+	if err == leveldb.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	want := metadataOf(&db.opts)
+	if diff := want.mismatches(stored); len(diff) > 0 {
+		return fmt.Errorf("%w: %s", ErrConfigMismatch, strings.Join(diff, ", "))
+	}
+	return nil
+}
+
+// checkMetadata writes the metadata block of the prefix if there is none, or if it is rewritten.
+// New compared the options with the stored block otherwise.
+func (db *merkleDB) checkMetadata() error {
+	stored, err := ReadMetadata(db.db, db.prefix)
+	if err == nil && !db.opts.RewriteMetadata {
+		return nil
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	want := metadataOf(&db.opts)
+	want.Created = db.now()
+	if stored != nil {
+		want.Created = stored.Created
+	}
+	return db.writeKey(db.metaKey(metaPrefix, nil), want.encode())
+}
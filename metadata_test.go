@@ -0,0 +1,109 @@
+package merkledb
+
+import (
+	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen_Metadata(t *testing.T) {
+	ldb := newMemoryDB()
+	created := time.Unix(1600000000, 0)
+	clock := WithClock(func() time.Time {
+		return created
+	})
+	if _, err := ReadMetadata(ldb, testPrefix); err != leveldb.ErrNotFound {
+		t.Fatalf("expected no metadata before the first open, got %v", err)
+	}
+	mdb, err := Open(testPrefix, ldb, clock, WithSchemaVersion(2), WithProfile(ProfilePruned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mdb.(*merkleDB).stop()
+	m, err := ReadMetadata(ldb, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := PrefixMetadata{Created: created, SchemaVersion: 2, HashName: DefaultHashName, KeyLayout: KeyLayout,
+		DeferredDeletes: true, Profile: ProfilePruned, TTLSlots: DefaultRecentSlots}
+	if !m.Created.Equal(created) {
+		t.Fatalf("unexpected creation time: %s", m.Created)
+	}
+	m.Created = created
+	if *m != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *m)
+	}
+
+	// the same configuration opens again
+	again, err := Open(testPrefix, ldb, WithSchemaVersion(2), WithProfile(ProfilePruned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.(*merkleDB).stop()
+	// other configurations fail loudly
+	_, err = Open(testPrefix, ldb, WithSchemaVersion(3), WithHashName("keccak256"), WithProfile(ProfilePruned))
+	if !errors.Is(err, ErrConfigMismatch) {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "schema version is 3, not 2") || !strings.Contains(err.Error(), "hash is keccak256, not sha256") {
+		t.Fatalf("expected the mismatches in the error: %v", err)
+	}
+	if _, err := Open(testPrefix, ldb, WithSchemaVersion(2)); !errors.Is(err, ErrConfigMismatch) {
+		t.Fatalf("expected a retention mismatch, got %v", err)
+	}
+	// New reports the mismatch, it does not write the metadata
+	var reported error
+	New(testPrefix, ldb, WithSchemaVersion(4), WithProfile(ProfilePruned), WithBackgroundErrors(func(err error) {
+		reported = err
+	})).(*merkleDB).stop()
+	if !errors.Is(reported, ErrConfigMismatch) || !strings.Contains(reported.Error(), "schema version is 4, not 2") {
+		t.Fatalf("expected New to report the mismatch, got %v", reported)
+	}
+	// names that do not fit the metadata block are rejected, instead of corrupting it
+	long := strings.Repeat("x", maxMetadataName+1)
+	if _, err := Open(testPrefix, ldb, WithSchemaVersion(2), WithProfile(ProfilePruned), WithHashName(long)); err == nil || errors.Is(err, ErrConfigMismatch) {
+		t.Fatalf("expected a long hash name to be rejected, got %v", err)
+	}
+	if _, err := Open(testPrefix, newMemoryDB(), WithProfile(Profile(long))); err == nil {
+		t.Fatal("expected a long profile to be rejected")
+	}
+
+	// a rewrite changes the configuration on purpose, and keeps the creation time
+	if _, err := Open(testPrefix, ldb, WithSchemaVersion(3), WithMetadataRewrite()); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ReadMetadata(ldb, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SchemaVersion != 3 || m.Profile != "" || m.DeferredDeletes || !m.Created.Equal(created) {
+		t.Fatalf("unexpected rewritten metadata: %+v", *m)
+	}
+	if _, err := Open(testPrefix, ldb, WithSchemaVersion(3)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefixMetadata_Decode(t *testing.T) {
+	m := PrefixMetadata{Created: time.Unix(0, 42), SchemaVersion: 1, HashName: "sha256", KeyLayout: KeyLayout,
+		Profile: ProfileMinimal, TTL: time.Hour, TTLSlots: 7, RetainRefsOnly: true}
+	enc := m.encode()
+	var got PrefixMetadata
+	if err := got.decode(enc); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(m.Created) {
+		t.Fatal("unexpected creation time")
+	}
+	got.Created = m.Created
+	if got != m {
+		t.Fatalf("expected %+v, got %+v", m, got)
+	}
+	for i := 0; i < len(enc); i++ {
+		if err := got.decode(enc[:i]); err == nil {
+			t.Fatalf("expected %d bytes to be corrupt", i)
+		}
+	}
+}
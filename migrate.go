@@ -0,0 +1,44 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// GindexMapping maps the position of a subtree in an old schema to its position in a new schema
+type GindexMapping struct {
+	From Gindex
+	To   Gindex
+}
+
+// Migrate builds the tree of a new schema version out of a stored tree of the old version, and Puts it.
+//
+// The newBase is the tree of the new schema to start from, e.g. the default value of the new type.
+// The mappings are applied in order: each moves the subtree at From (relative to the old anchor) into To.
+// The stored subtrees are loaded lazily and copied to their new positions, unchanged subtrees are deduplicated as usual.
+// The root node of the migrated tree is returned.
+func Migrate(db MerkleDB, slot uint64, oldAnchor Root, newBase Node, mappings []GindexMapping, fn HashFn) (Node, InsertReport, error) {
+	old, err := db.Get(RootGindex, oldAnchor)
+	if err != nil {
+		return nil, InsertReport{}, fmt.Errorf("failed to load old anchor: %v", err)
+	}
+	node := newBase
+	for i, m := range mappings {
+		sub, err := old.Node.Getter(m.From)
+		if err != nil {
+			return nil, InsertReport{}, fmt.Errorf("mapping %d: failed to get subtree at %v: %v", i, m.From, err)
+		}
+		setter, err := node.Setter(m.To, true)
+		if err != nil {
+			return nil, InsertReport{}, fmt.Errorf("mapping %d: failed to navigate to %v: %v", i, m.To, err)
+		}
+		if node, err = setter(sub); err != nil {
+			return nil, InsertReport{}, fmt.Errorf("mapping %d: failed to set subtree at %v: %v", i, m.To, err)
+		}
+	}
+	report, err := db.Put(slot, node, fn)
+	if err != nil {
+		return nil, InsertReport{}, err
+	}
+	return node, report, nil
+}
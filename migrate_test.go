@@ -0,0 +1,35 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	x := randomTree(6)
+	y := randomTree(6)
+	old := NewPairNode(x, y)
+	if _, err := mdb.Put(1, old, hFn); err != nil {
+		t.Fatal(err)
+	}
+	// the new schema moves x one level down, behind a new field
+	base := NewPairNode(NewPairNode(&ZeroHashes[0], &ZeroHashes[0]), &ZeroHashes[0])
+	migrated, _, err := Migrate(mdb, 2, old.MerkleRoot(hFn), base, []GindexMapping{
+		{From: LeftGindex, To: Gindex64(5)},
+		{From: RightGindex, To: RightGindex},
+	}, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NewPairNode(NewPairNode(&ZeroHashes[0], x), y)
+	if migrated.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatal("migrated tree does not match the expected tree")
+	}
+	out, err := mdb.Get(RootGindex, expected.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(expected, out.Node, RootGindex, hFn, t)
+}
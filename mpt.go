@@ -0,0 +1,252 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// rlpItem is a decoded RLP item: a string, or a list of items
+type rlpItem struct {
+	list  bool
+	data  []byte
+	items []rlpItem
+}
+
+// decodeRLP decodes the first item of the input, and returns the remaining input after it
+func decodeRLP(b []byte) (rlpItem, []byte, error) {
+	if len(b) == 0 {
+		return rlpItem{}, nil, errors.New("rlp: empty input")
+	}
+	prefix := b[0]
+	var offset, size int
+	list := false
+	switch {
+	case prefix < 0x80:
+		offset, size = 0, 1
+	case prefix <= 0xb7:
+		offset, size = 1, int(prefix-0x80)
+	case prefix <= 0xbf:
+		n := int(prefix - 0xb7)
+		s, err := rlpLength(b[1:], n)
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		offset, size = 1+n, s
+	case prefix <= 0xf7:
+		offset, size, list = 1, int(prefix-0xc0), true
+	default:
+		n := int(prefix - 0xf7)
+		s, err := rlpLength(b[1:], n)
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		offset, size, list = 1+n, s, true
+	}
+	if size > len(b)-offset {
+		return rlpItem{}, nil, fmt.Errorf("rlp: item of %d bytes exceeds the input", size)
+	}
+	item := rlpItem{list: list, data: b[offset : offset+size]}
+	if list {
+		for rest := item.data; len(rest) > 0; {
+			var sub rlpItem
+			var err error
+			if sub, rest, err = decodeRLP(rest); err != nil {
+				return rlpItem{}, nil, err
+			}
+			item.items = append(item.items, sub)
+		}
+	}
+	return item, b[offset+size:], nil
+}
+
+func rlpLength(b []byte, n int) (int, error) {
+	if n > 4 || n > len(b) {
+		return 0, fmt.Errorf("rlp: length of %d bytes", n)
+	}
+	size := 0
+	for _, v := range b[:n] {
+		size = size<<8 | int(v)
+	}
+	return size, nil
+}
+
+// MPTEntry is a key and value of an Ethereum Merkle-Patricia trie
+type MPTEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// MPTEntries decodes the entries that the witness, the RLP encoded nodes of a hexary Merkle-Patricia trie, proves
+// under the root. Nodes are referenced by their Keccak256 hash, so every entry is verified by the root.
+// Subtries of which the witness has no nodes are left out, nodes that are not referenced are ignored.
+// The entries are ordered by key.
+func MPTEntries(root Root, witness [][]byte) ([]MPTEntry, error) {
+	nodes := make(map[Root][]byte, len(witness))
+	for _, n := range witness {
+		nodes[Keccak256(n)] = n
+	}
+	var out []MPTEntry
+	// resolve gets the node of a reference: the hash of the node, or the node itself if embedded
+	resolve := func(ref rlpItem) (rlpItem, bool, error) {
+		if ref.list {
+			return ref, true, nil
+		}
+		if len(ref.data) == 0 {
+			return rlpItem{}, false, nil
+		}
+		if len(ref.data) != 32 {
+			return rlpItem{}, false, fmt.Errorf("mpt: reference of %d bytes", len(ref.data))
+		}
+		enc, ok := nodes[toRoot(ref.data)]
+		if !ok {
+			return rlpItem{}, false, nil
+		}
+		node, rest, err := decodeRLP(enc)
+		if err != nil {
+			return rlpItem{}, false, err
+		}
+		if len(rest) != 0 || !node.list {
+			return rlpItem{}, false, fmt.Errorf("mpt: node %x is not a single list", ref.data)
+		}
+		return node, true, nil
+	}
+	var visit func(ref rlpItem, path []byte) error
+	visit = func(ref rlpItem, path []byte) error {
+		node, ok, err := resolve(ref)
+		if err != nil || !ok {
+			return err
+		}
+		switch len(node.items) {
+		case 17:
+			if v := node.items[16]; len(v.data) > 0 {
+				if err := addMPTEntry(&out, path, v.data); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < 16; i++ {
+				if err := visit(node.items[i], append(path[:len(path):len(path)], byte(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		case 2:
+			nibbles, leaf, err := hexPrefix(node.items[0].data)
+			if err != nil {
+				return err
+			}
+			full := append(path[:len(path):len(path)], nibbles...)
+			if leaf {
+				return addMPTEntry(&out, full, node.items[1].data)
+			}
+			return visit(node.items[1], full)
+		default:
+			return fmt.Errorf("mpt: node of %d items", len(node.items))
+		}
+	}
+	ref := rlpItem{data: root[:]}
+	if err := visit(ref, nil); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func addMPTEntry(out *[]MPTEntry, path []byte, value []byte) error {
+	if len(path)%2 != 0 {
+		return fmt.Errorf("mpt: value at a path of %d nibbles", len(path))
+	}
+	key := make([]byte, len(path)/2)
+	for i := range key {
+		key[i] = path[2*i]<<4 | path[2*i+1]
+	}
+	*out = append(*out, MPTEntry{Key: key, Value: append([]byte(nil), value...)})
+	return nil
+}
+
+// hexPrefix decodes the compact path encoding of leaf and extension nodes
+func hexPrefix(b []byte) ([]byte, bool, error) {
+	if len(b) == 0 {
+		return nil, false, errors.New("mpt: empty path")
+	}
+	flag := b[0] >> 4
+	if flag > 3 {
+		return nil, false, fmt.Errorf("mpt: unknown path flag %d", flag)
+	}
+	var nibbles []byte
+	if flag&1 == 1 {
+		nibbles = append(nibbles, b[0]&0x0f)
+	}
+	for _, v := range b[1:] {
+		nibbles = append(nibbles, v>>4, v&0x0f)
+	}
+	return nibbles, flag >= 2, nil
+}
+
+// mptBytes is the binary tree of a byte string: the chunks, padded to a power of two, and the length
+func mptBytes(b []byte) Node {
+	chunks := make([]Node, 0, (len(b)+31)/32)
+	for i := 0; i < len(b); i += 32 {
+		var chunk Root
+		copy(chunk[:], b[i:])
+		chunks = append(chunks, &chunk)
+	}
+	var length Root
+	binary.LittleEndian.PutUint64(length[:], uint64(len(b)))
+	return NewPairNode(mptSubtree(chunks), &length)
+}
+
+// mptSubtree merkleizes the nodes, padded with zero leaves to a power of two, with at least one leaf
+func mptSubtree(nodes []Node) Node {
+	size := 1
+	for size < len(nodes) {
+		size *= 2
+	}
+	level := make([]Node, size)
+	copy(level, nodes)
+	for i := len(nodes); i < size; i++ {
+		level[i] = &Root{}
+	}
+	for len(level) > 1 {
+		next := make([]Node, len(level)/2)
+		for i := range next {
+			next[i] = NewPairNode(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// MPTTree converts the entries to merkledb's binary tree representation, for KeccakHashFn: the entries in order,
+// padded with zero leaves to a power of two, mixed in with their count, like an SSZ list.
+// Every entry is the pair of its key and value, a byte string is the pair of its 32 byte chunks,
+// padded with zero chunks to a power of two, and its length as little-endian uint64.
+func MPTTree(entries []MPTEntry) Node {
+	nodes := make([]Node, len(entries))
+	for i := range entries {
+		nodes[i] = NewPairNode(mptBytes(entries[i].Key), mptBytes(entries[i].Value))
+	}
+	var count Root
+	binary.LittleEndian.PutUint64(count[:], uint64(len(entries)))
+	return NewPairNode(mptSubtree(nodes), &count)
+}
+
+// ImportMPTWitness puts the binary tree of the entries that the Merkle-Patricia trie witness proves under the root,
+// see MPTEntries and MPTTree, hashed with KeccakHashFn. The imported tree can be proven like any other tree,
+// with KeccakHashFn for verification. A partial witness gives the tree of the proven entries only.
+func ImportMPTWitness(db TreeWriter, slot uint64, root Root, witness [][]byte, opts ...PutOption) (Root, InsertReport, error) {
+	entries, err := MPTEntries(root, witness)
+	if err != nil {
+		return Root{}, InsertReport{}, err
+	}
+	if len(entries) == 0 {
+		return Root{}, InsertReport{}, errors.New("witness proves no entries under the root")
+	}
+	tree := MPTTree(entries)
+	out := tree.MerkleRoot(KeccakHashFn)
+	report, err := db.Put(slot, tree, KeccakHashFn, opts...)
+	if err != nil {
+		return Root{}, InsertReport{}, err
+	}
+	return out, report, nil
+}
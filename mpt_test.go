@@ -0,0 +1,177 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/hex"
+	. "github.com/protolambda/ztyp/tree"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func rlpHeader(offset byte, size int) []byte {
+	if size < 56 {
+		return []byte{offset + byte(size)}
+	}
+	var n []byte
+	for s := size; s > 0; s >>= 8 {
+		n = append([]byte{byte(s)}, n...)
+	}
+	return append([]byte{offset + 55 + byte(len(n))}, n...)
+}
+
+func rlpString(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+func rlpList(items ...[]byte) []byte {
+	body := bytes.Join(items, nil)
+	return append(rlpHeader(0xc0, len(body)), body...)
+}
+
+func compactPath(nibbles []byte, leaf bool) []byte {
+	flag := byte(0)
+	if leaf {
+		flag = 2
+	}
+	var out []byte
+	if len(nibbles)%2 == 1 {
+		out = append(out, (flag|1)<<4|nibbles[0])
+		nibbles = nibbles[1:]
+	} else {
+		out = append(out, flag<<4)
+	}
+	for i := 0; i < len(nibbles); i += 2 {
+		out = append(out, nibbles[i]<<4|nibbles[i+1])
+	}
+	return out
+}
+
+type testTrieEntry struct {
+	path  []byte
+	value []byte
+}
+
+// testTrie builds a Merkle-Patricia trie of the entries, and returns its root with the witness of all its nodes
+func testTrie(kv map[string]string) (Root, [][]byte) {
+	var entries []testTrieEntry
+	for k, v := range kv {
+		var path []byte
+		for _, b := range []byte(k) {
+			path = append(path, b>>4, b&0x0f)
+		}
+		entries = append(entries, testTrieEntry{path: path, value: []byte(v)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].path, entries[j].path) < 0
+	})
+	var witness [][]byte
+	ref := func(enc []byte) []byte {
+		if len(enc) < 32 {
+			return enc
+		}
+		witness = append(witness, enc)
+		h := Keccak256(enc)
+		return rlpString(h[:])
+	}
+	var build func(entries []testTrieEntry, depth int) []byte
+	build = func(entries []testTrieEntry, depth int) []byte {
+		if len(entries) == 1 {
+			return rlpList(rlpString(compactPath(entries[0].path[depth:], true)), rlpString(entries[0].value))
+		}
+		common := len(entries[0].path) - depth
+		for _, e := range entries[1:] {
+			i := 0
+			for i < common && i < len(e.path)-depth && e.path[depth+i] == entries[0].path[depth+i] {
+				i++
+			}
+			common = i
+		}
+		if common > 0 {
+			child := build(entries, depth+common)
+			return rlpList(rlpString(compactPath(entries[0].path[depth:depth+common], false)), ref(child))
+		}
+		items := make([][]byte, 17)
+		items[16] = rlpString(nil)
+		for nibble := byte(0); nibble < 16; nibble++ {
+			var sub []testTrieEntry
+			for _, e := range entries {
+				if len(e.path) == depth {
+					items[16] = rlpString(e.value)
+				} else if e.path[depth] == nibble {
+					sub = append(sub, e)
+				}
+			}
+			if len(sub) == 0 {
+				items[nibble] = rlpString(nil)
+			} else {
+				items[nibble] = ref(build(sub, depth+1))
+			}
+		}
+		return rlpList(items...)
+	}
+	enc := build(entries, 0)
+	witness = append(witness, enc)
+	return Keccak256(enc), witness
+}
+
+func TestImportMPTWitness(t *testing.T) {
+	root, witness := testTrie(map[string]string{"doe": "reindeer", "dog": "puppy", "dogglesworth": "cat"})
+	if got := hex.EncodeToString(root[:]); got != "8aad789dff2f538bca5d8ea56e8abe10f4c7ba3a5dea95fea4cd6e7c3a1168d3" {
+		t.Fatalf("unexpected trie root: %s", got)
+	}
+	entries, err := MPTEntries(root, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, string(e.Key)+"="+string(e.Value))
+	}
+	if got := strings.Join(keys, ","); got != "doe=reindeer,dog=puppy,dogglesworth=cat" {
+		t.Fatalf("unexpected entries: %v", keys)
+	}
+
+	mdb := New(testPrefix, newMemoryDB())
+	anchor, _, err := ImportMPTWitness(mdb, 1, root, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchor != MPTTree(entries).MerkleRoot(KeccakHashFn) {
+		t.Fatal("expected the root of the binary tree")
+	}
+	// the value of "dog", the second of 4 entries, is a single chunk
+	valueChunk := uint64(2<<2|1)<<2 | 2
+	proof, err := mdb.Prove(anchor, Gindex64(valueChunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(proof.Leaf[:], []byte("puppy")) || !proof.Verify(anchor, KeccakHashFn) {
+		t.Fatalf("expected a valid proof of the value, got leaf %x", proof.Leaf[:])
+	}
+	if proof.Verify(anchor, GetHashFn()) {
+		t.Fatal("expected the proof to fail with SHA-256")
+	}
+
+	// without the root node the witness proves nothing
+	if _, _, err := ImportMPTWitness(mdb, 2, root, witness[:len(witness)-1]); err == nil {
+		t.Fatal("expected a witness without the root node to fail")
+	}
+	// a partial witness proves the entries of the subtries it has
+	partial, err := MPTEntries(root, [][]byte{witness[len(witness)-1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) >= len(entries) {
+		t.Fatalf("expected fewer entries of a partial witness, got %d", len(partial))
+	}
+	// a corrupt root node does not hash to the root, and is ignored like a missing one
+	corrupt := append([]byte(nil), witness[len(witness)-1]...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, _, err := ImportMPTWitness(mdb, 2, root, [][]byte{corrupt}); err == nil {
+		t.Fatal("expected a corrupt root node to fail")
+	}
+}
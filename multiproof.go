@@ -0,0 +1,43 @@
+package merkledb
+
+import (
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// MultiProof proves multiple leaves of the same tree at once, sharing the branch nodes between them.
+// The Helpers are the roots of the nodes at HelperGindices(Gindices), in the same order.
+type MultiProof = lite.MultiProof
+
+func gindexValue(g Gindex) (uint64, error) {
+	return lite.GindexValue(g)
+}
+
+func gindexValues(gindices []Gindex) ([]uint64, error) {
+	return lite.GindexValues(gindices)
+}
+
+func helperValues(targets []uint64) []uint64 {
+	return lite.HelperValues(targets)
+}
+
+// HelperGindices returns the gindices of the nodes that are needed, next to the given leaves,
+// to verify a multiproof of the leaves. The helpers are ordered by descending gindex, like SSZ multiproofs.
+func HelperGindices(gindices []Gindex) ([]Gindex, error) {
+	return lite.HelperGindices(gindices)
+}
+
+// ProofCheck is a multiproof to verify against the root of its anchor, see VerifyMultiproofBatch
+type ProofCheck = lite.ProofCheck
+
+// VerifyMultiproofBatch verifies many independent multiproofs against their anchors, e.g. incoming witnesses
+// before they are ingested. The proofs are verified by the given number of workers in parallel, 1 if less,
+// that each hash with a HashFn of their own from newHashFn, GetHashFn if nil. It returns if each proof verified,
+// in the order of the checks.
+func VerifyMultiproofBatch(checks []ProofCheck, workers int, newHashFn func() HashFn) []bool {
+	return lite.VerifyMultiproofBatch(checks, workers, newHashFn)
+}
+
+func (db *merkleDB) ProveMulti(anchor Root, gindices []Gindex) (*MultiProof, error) {
+	return lite.ProveMulti(db.descend, anchor, gindices)
+}
@@ -0,0 +1,87 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func fullTree(depth uint) Node {
+	if depth == 0 {
+		return randomRoot()
+	}
+	return NewPairNode(fullTree(depth-1), fullTree(depth-1))
+}
+
+func TestMerkleDB_ProveMulti(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(5)
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := foo.MerkleRoot(hFn)
+	targets := []Gindex{Gindex64(0b100000), Gindex64(0b100001), Gindex64(0b101101), Gindex64(0b111)}
+	proof, err := mdb.ProveMulti(anchor, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range targets {
+		leaf, err := foo.Getter(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Leaves[i] != leaf.MerkleRoot(hFn) {
+			t.Fatalf("leaf %d does not match", i)
+		}
+	}
+	helpers, err := HelperGindices(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range helpers {
+		n, err := foo.Getter(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Helpers[i] != n.MerkleRoot(hFn) {
+			t.Fatalf("helper %d does not match", i)
+		}
+	}
+	if !proof.Verify(anchor, hFn) {
+		t.Fatal("expected proof to verify")
+	}
+	if proof.Verify(Root{1}, hFn) {
+		t.Fatal("expected proof against other anchor to fail")
+	}
+	proof.Leaves[2][0] ^= 1
+	if proof.Verify(anchor, hFn) {
+		t.Fatal("expected tampered proof to fail")
+	}
+	if _, err := mdb.ProveMulti(anchor, nil); err == nil {
+		t.Fatal("expected error for empty targets")
+	}
+}
+
+func TestVerifyMultiproofBatch(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	var checks []ProofCheck
+	for i := uint64(1); i <= 8; i++ {
+		tree := fullTree(4)
+		if _, err := mdb.Put(i, tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+		anchor := tree.MerkleRoot(hFn)
+		proof, err := mdb.ProveMulti(anchor, []Gindex{Gindex64(16 + i), Gindex64(3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks = append(checks, ProofCheck{Anchor: anchor, Proof: proof})
+	}
+	checks[2].Proof.Helpers[0][0] ^= 1
+	for i, ok := range VerifyMultiproofBatch(checks, 3, nil) {
+		if ok != (i != 2) {
+			t.Fatalf("unexpected result of proof %d: %v", i, ok)
+		}
+	}
+}
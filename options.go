@@ -0,0 +1,454 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"time"
+)
+
+// Options configures a MerkleDB
+type Options struct {
+	// Clock provides the insertion time recorded with each anchor.
+	// Insertion times are not recorded if nil.
+	Clock func() time.Time
+	// TTL expires anchors that were inserted longer ago than this. Requires a Clock. Disabled if 0.
+	TTL time.Duration
+	// TTLSlots expires anchors that are more than this many slots behind the highest anchor. Disabled if 0.
+	TTLSlots uint64
+	// Retention keeps fields of expired trees, see WithRetention
+	Retention []RetentionRule
+	// RetainRefsOnly expires every anchor that is not named by a reference.
+	RetainRefsOnly bool
+	// Profile is the storage profile the options were derived from, if any
+	Profile Profile
+	// SweepInterval is the interval to run Expire and Reclaim at in the background. Disabled if 0.
+	// The options that ask for a sweep set it to the shortest of their intervals.
+	SweepInterval time.Duration
+	// MarkMemoryLimit is the number of marked keys that a prune keeps in memory,
+	// more are spilled to a temporary leveldb in MarkSpillDir. Unbounded if 0.
+	MarkMemoryLimit int
+	// MarkSpillDir is the directory for the temporary mark databases, the default directory for temporary files if empty.
+	MarkSpillDir string
+	// Recover makes reads of nodes with corrupt values try to reconstruct the node, see WithRecovery
+	Recover bool
+	// RecoveryScanLimit is the number of candidate children per side that a recovery scans.
+	// DefaultRecoveryScanLimit if 0.
+	RecoveryScanLimit int
+	// DeferredDeletes makes Delete and Prune tombstone nodes, to be reclaimed later by Reclaim.
+	DeferredDeletes bool
+	// DeleteBatchSize is the number of deletions per write batch of Prune and Reclaim.
+	// DefaultDeleteBatchSize if 0.
+	DeleteBatchSize int
+	// CompactDeletes compacts the key range of every written delete batch, to keep large prunes from piling up tombstones.
+	CompactDeletes bool
+	// LevelDB configures the leveldb database, when merkledb opens it.
+	// A database passed to New is already open, see RecommendedLevelDBOptions to open it with the same settings.
+	LevelDB LevelDBOptions
+	// Quota bounds the storage of the prefix, see WithQuota. Unbounded if zero.
+	Quota Quota
+	// Resolver fetches the nodes that are not stored locally, see WithNodeResolver. Not used if nil.
+	Resolver NodeResolver
+	// AuditLog records the puts, deletes and prunes, see WithAuditLog
+	AuditLog bool
+	// Changefeed receives every committed batch, see WithChangefeed. Not used if nil.
+	Changefeed *Changefeed
+	// Dedup decides which children Put checks for existence, see WithDedup. AlwaysProbe if nil.
+	Dedup DedupStrategy
+	// SchemaVersion and HashName are recorded in the metadata block of the prefix, see Open
+	SchemaVersion uint32
+	HashName      string
+	// RewriteMetadata makes Open record the options in the metadata block, instead of validating them
+	RewriteMetadata bool
+	// LeaseTTL makes Open take the lease of the prefix, see WithLease. No lease is taken if 0.
+	LeaseTTL time.Duration
+	// MaxDepth is the depth of the deepest node that is put or navigated to, see WithMaxDepth.
+	// The deepest a key can hold if 0.
+	MaxDepth uint32
+	// Indexes are the indexes on leaves that puts maintain, see WithIndex
+	Indexes []LeafIndex
+	// SSZStorage are the subtrees of put trees that are stored as SSZ too, see WithSSZStorage
+	SSZStorage []SSZSubtree
+	// ValueLog stores large blobs and SSZ encodings in value files, see WithValueLog. Disabled if the Dir is empty.
+	ValueLog ValueLogOptions
+	// MaxValueSize bounds the variable size records, like blobs and SSZ encodings, see WithMaxValueSize.
+	// DefaultMaxValueSize if 0.
+	MaxValueSize int
+	// ProofCacheSize is the number of proofs that Prove keeps, see WithProofCache. Disabled if 0.
+	ProofCacheSize int
+	// HistoryGindices are the gindices of interest of the compact history, see WithCompactHistory
+	HistoryGindices []Gindex
+	// PrefixCheckSamples is the number of records of the prefix that New decodes, see WithPrefixCheck.
+	// DefaultPrefixCheckSamples if 0, not checked if negative.
+	PrefixCheckSamples int
+	// StrictPrefixCheck makes Open fail if the prefix appears to be used by another subsystem
+	StrictPrefixCheck bool
+	// Prefetch is the number of nodes that sequential reads are read ahead by, see WithPrefetch. Disabled if 0.
+	Prefetch int
+	// OnSlowQuery is called with the gets, puts and ranges that take at least SlowQueryThreshold,
+	// see WithSlowQueryLog. Not called if nil.
+	OnSlowQuery        func(q SlowQuery)
+	SlowQueryThreshold time.Duration
+	// OnPut is called with the report of every written put, see WithPutHook. Not called if nil.
+	OnPut func(report InsertReport)
+	// OnBackgroundError is called with errors of background work. Errors are dropped if nil.
+	OnBackgroundError func(err error)
+}
+
+type Option func(o *Options)
+
+// sweepEvery runs the background sweep at the interval, unless another option asked for a shorter one
+func sweepEvery(o *Options, interval time.Duration) {
+	if interval > 0 && (o.SweepInterval == 0 || interval < o.SweepInterval) {
+		o.SweepInterval = interval
+	}
+}
+
+// checkOptions fails for options that cannot be stored, New reports it to OnBackgroundError and Open fails with it
+func checkOptions(o *Options) error {
+	m := metadataOf(o)
+	if err := m.check(); err != nil {
+		return err
+	}
+	for i := range o.Indexes {
+		if err := o.Indexes[i].check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithAuditLog keeps an append-only log of the puts, deletes and prunes, with the time of the Clock,
+// or the system time if none. Every record is written in the batch of its operation. See AuditLog.
+func WithAuditLog() Option {
+	return func(o *Options) {
+		o.AuditLog = true
+	}
+}
+
+// WithChangefeed publishes every batch that the merkledb commits to the feed, for read replicas.
+func WithChangefeed(feed *Changefeed) Option {
+	return func(o *Options) {
+		o.Changefeed = feed
+	}
+}
+
+// WithDedup sets the strategy of Put to find the nodes that are stored already, see DedupStrategy.
+// The best choice depends on the workload: an archive backfill shares few nodes between puts, a head-following
+// process shares most. The known Usage counts rewritten nodes as new, until it is counted again.
+func WithDedup(s DedupStrategy) Option {
+	return func(o *Options) {
+		o.Dedup = s
+	}
+}
+
+// WithClock records the insertion time of every Put, as provided by the clock.
+func WithClock(clock func() time.Time) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// WithTTL expires anchors after the given duration, and runs a background sweep at the given interval,
+// or at the shorter interval of another option.
+func WithTTL(ttl time.Duration, sweepInterval time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = ttl
+		sweepEvery(o, sweepInterval)
+	}
+}
+
+// WithTTLSlots expires anchors that fall the given number of slots behind the highest anchor,
+// and runs a background sweep at the given interval, or at the shorter interval of another option.
+func WithTTLSlots(slots uint64, sweepInterval time.Duration) Option {
+	return func(o *Options) {
+		o.TTLSlots = slots
+		sweepEvery(o, sweepInterval)
+	}
+}
+
+// WithRetention keeps the subtrees of the rules in expired trees, for the slots of the rules.
+// E.g. the balances for a million slots, while the full state expires after 8192 slots.
+// An expired tree is trimmed to the fields of the rules that still apply: the anchor stays,
+// with the fields and the nodes on the paths to them. Reads below the other nodes return ErrSummarized.
+// The rules take effect when anchors expire, with WithTTL, WithTTLSlots or a pruning profile.
+// Deferred deletes only remove whole trees: with them, trees with retained fields are kept as a whole.
+func WithRetention(rules ...RetentionRule) Option {
+	return func(o *Options) {
+		o.Retention = append(o.Retention, rules...)
+	}
+}
+
+// WithIndex maintains the index with every Put and PutStream: the entries of the written nodes are added
+// in the batch of the put, and count towards the quota. Nodes that were stored before the index was declared, or by other writes
+// such as Restore and Merge, are indexed by RebuildIndex. Prunes delete the entries of deleted nodes.
+// The entries are shared by the trees like the nodes, queries select the entries of the nodes in the queried tree.
+// Nodes deeper than 63 bits are not indexed. Open fails for a name of more than 255 bytes, and so do the puts of New.
+func WithIndex(idx LeafIndex) Option {
+	return func(o *Options) {
+		o.Indexes = append(o.Indexes, idx)
+	}
+}
+
+// WithSSZStorage stores the SSZ encoding of the subtrees with every Put, in the batch of the put,
+// so full objects are read back without loading their nodes, see GetSSZ. ExportSSZ uses the stored encoding of the whole tree.
+// Proofs still come from the nodes. The encodings are deleted with the anchor of their tree, by Delete and by prunes.
+// A put fails if a subtree does not match its type. Streamed and partial puts store no encodings.
+func WithSSZStorage(subtrees ...SSZSubtree) Option {
+	return func(o *Options) {
+		o.SSZStorage = append(o.SSZStorage, subtrees...)
+	}
+}
+
+// WithValueLog stores blobs and SSZ encodings of at least the MinSize in append-only value files in the directory,
+// with only pointers to them in leveldb, so compactions do not rewrite large payloads.
+// Reads check the checksum of the value. Prunes delete the pointers, and remove the value files without pointers
+// into them, and move the live values of the files below the MinLiveRatio; with deferred deletes the files are
+// removed by Reclaim. Snapshots read the files too, a value of a snapshot can be gone after a prune.
+// Backups and the changefeed carry the values inline, not the pointers.
+func WithValueLog(opts ValueLogOptions) Option {
+	return func(o *Options) {
+		o.ValueLog = opts
+	}
+}
+
+// WithSchemaVersion records the version of the application schema of the trees, see Open
+func WithSchemaVersion(version uint32) Option {
+	return func(o *Options) {
+		o.SchemaVersion = version
+	}
+}
+
+// WithHashName records the name of the hash function that the trees are put with, see Open.
+// DefaultHashName if empty. The hash function itself is passed to every put.
+func WithHashName(name string) Option {
+	return func(o *Options) {
+		o.HashName = name
+	}
+}
+
+// WithMetadataRewrite makes Open record the options in the metadata block of the prefix, instead of failing
+// when they differ, e.g. to change the retention profile. The trees are not changed.
+func WithMetadataRewrite() Option {
+	return func(o *Options) {
+		o.RewriteMetadata = true
+	}
+}
+
+// WithLease makes Open record this merkledb as the writer of the prefix, with its process ID, host and a heartbeat
+// that is renewed every third of the TTL. Another Open of the prefix fails with a LeaseError until the lease is
+// released by Close, or expires after the TTL without heartbeat. A New handle on the prefix is not checked.
+// If another writer takes over the expired lease, the writes of this merkledb fail with ErrLeaseLost.
+func WithLease(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.LeaseTTL = ttl
+	}
+}
+
+// WithMaxDepth fails puts and navigation of virtual nodes below the given depth with a DepthError,
+// e.g. to reject malformed or cyclic trees early. The root is at depth 0.
+// Depths beyond what a key can hold, 255, are limited to it.
+func WithMaxDepth(depth uint32) Option {
+	return func(o *Options) {
+		o.MaxDepth = depth
+	}
+}
+
+// WithMaxValueSize fails writes of blobs, SSZ encodings and other variable size records over the size
+// with ErrValueTooLarge, and reads of stored values over it with a CorruptValueError.
+// Fixed size records are always checked against their own size, and version byte.
+func WithMaxValueSize(size int) Option {
+	return func(o *Options) {
+		o.MaxValueSize = size
+	}
+}
+
+// WithProofCache keeps the given number of the most recently used proofs of Prove by anchor and target,
+// so frequently requested proofs, like the sync committee branches of light clients, are not read again.
+// A prune drops the proofs of the anchors that it does not keep, Delete, Reclaim and Reorg drop all proofs.
+// Proofs of snapshot views are not cached.
+func WithProofCache(size int) Option {
+	return func(o *Options) {
+		o.ProofCacheSize = size
+	}
+}
+
+// WithCompactHistory records the leaves at the gindices of interest of every full put and PutStream, by slot and anchor,
+// skipping the gindices that a tree does not reach, and enables PutCompact to record only those leaves: a middle ground between storing every tree,
+// and a raw key-value history, like slashing protection keeps. ProveHistory reconstructs the proofs of the leaves
+// from the nearest full anchor, if the other nodes of the tree did not change. The gindices must not be nested.
+func WithCompactHistory(gindices ...Gindex) Option {
+	return func(o *Options) {
+		o.HistoryGindices = gindices
+	}
+}
+
+// WithPrefixCheck configures the check of the prefix keyspace on New: the given number of stored records
+// are decoded, and if any does not decode under the merkledb schema, the prefix appears to be used by another
+// subsystem of a shared leveldb. The PrefixCollisionError is passed to OnBackgroundError, and fails Open if strict.
+// A negative number of samples disables the check. See CheckPrefix.
+func WithPrefixCheck(samples int, strict bool) Option {
+	return func(o *Options) {
+		o.PrefixCheckSamples = samples
+		o.StrictPrefixCheck = strict
+	}
+}
+
+// WithPrefetch reads ahead of sequential reads, like a Walk, or a scan over the leaves with Getter calls:
+// when the nodes at a depth are read in gindex order, the next nodes at that depth are read in the background,
+// up to window nodes ahead, to hide the latency of the leveldb reads.
+func WithPrefetch(window int) Option {
+	return func(o *Options) {
+		o.Prefetch = window
+	}
+}
+
+// WithSlowQueryLog calls fn with every Get, Put, PutStream and Range that takes at least the threshold,
+// with the gindex, root and slots of the query, e.g. to log the trees that cause the tail latency.
+// It is called on the goroutine of the query, after it returns.
+func WithSlowQueryLog(threshold time.Duration, fn func(q SlowQuery)) Option {
+	return func(o *Options) {
+		o.OnSlowQuery = fn
+		o.SlowQueryThreshold = threshold
+	}
+}
+
+// WithPutHook calls fn with the report of every written put, e.g. to export the batch sizes and the time
+// spent hashing and writing. It is called after the batch is written, on the goroutine of the put.
+func WithPutHook(fn func(report InsertReport)) Option {
+	return func(o *Options) {
+		o.OnPut = fn
+	}
+}
+
+// WithBackgroundErrors reports errors of background work, like TTL sweeps, to the given function.
+func WithBackgroundErrors(fn func(err error)) Option {
+	return func(o *Options) {
+		o.OnBackgroundError = fn
+	}
+}
+
+// WithMarkSpill bounds the memory of the mark phase of prunes, reorgs and reclaims to the given number of keys,
+// and spills more keys to a temporary leveldb in the directory, the default directory for temporary files if empty.
+func WithMarkSpill(memoryLimit int, dir string) Option {
+	return func(o *Options) {
+		o.MarkMemoryLimit = memoryLimit
+		o.MarkSpillDir = dir
+	}
+}
+
+// WithRecovery makes reads of nodes with corrupt values try to recover them: through the NodeResolver if any,
+// or else by searching the stored nodes at the child positions for a pair that hashes to the node root.
+// Leaves are restored from their key, if the value still has the type of a leaf. Recovered nodes are written back. Nodes that cannot be recovered are marked for repair, see Repairs.
+// The scan is bounded to scanLimit candidates per child position, DefaultRecoveryScanLimit if 0.
+func WithRecovery(scanLimit int) Option {
+	return func(o *Options) {
+		o.Recover = true
+		o.RecoveryScanLimit = scanLimit
+	}
+}
+
+// WithDeferredDeletes tombstones nodes on Delete and Prune, and reclaims them in a background sweep at the given interval,
+// or at the shorter interval of another option.
+func WithDeferredDeletes(sweepInterval time.Duration) Option {
+	return func(o *Options) {
+		o.DeferredDeletes = true
+		sweepEvery(o, sweepInterval)
+	}
+}
+
+// WithDeleteBatchSize sets the number of deletions per write batch of Prune and Reclaim.
+func WithDeleteBatchSize(size int) Option {
+	return func(o *Options) {
+		o.DeleteBatchSize = size
+	}
+}
+
+// WithCompactDeletes compacts the key range of every delete batch of Prune and Reclaim after writing it.
+func WithCompactDeletes() Option {
+	return func(o *Options) {
+		o.CompactDeletes = true
+	}
+}
+
+// PutOptions configures a single Put
+type PutOptions struct {
+	// Parent is the anchor root of the parent tree, e.g. the state before the block. Not recorded if zero.
+	Parent Root
+	// Provenance identifies what produced the tree, e.g. the beacon block root. Not recorded if zero.
+	Provenance Root
+	// RootMemo remembers the roots of the put nodes between puts, see WithRootMemo. Not used if nil.
+	RootMemo *RootMemo
+	// Commit writes the batch of the put, see WithCommit. The batch is written directly if nil.
+	Commit CommitFn
+	// Fresh skips the existence checks of the put, see WithFresh
+	Fresh bool
+	// IdempotencyKey identifies the put across retries, see WithIdempotencyKey. Not used if empty.
+	IdempotencyKey string
+	// Tags are added to the tags of the anchor, see WithTags
+	Tags []string
+	// partial is set by the puts of partial trees, which have no SSZ encoding
+	partial bool
+}
+
+// CommitFn is responsible for writing the batch of a put to the leveldb of the merkledb.
+// It may add its own keys to the batch first, and coordinate with an external store before writing.
+// If it returns an error the put fails, and it must not have written the batch then.
+type CommitFn func(b *leveldb.Batch) error
+
+type PutOption func(o *PutOptions)
+
+// WithParent records the anchor root of the parent tree with the anchor of the put tree.
+func WithParent(parent Root) PutOption {
+	return func(o *PutOptions) {
+		o.Parent = parent
+	}
+}
+
+// WithProvenance records what produced the put tree with its anchor, e.g. the root of the beacon block.
+func WithProvenance(provenance Root) PutOption {
+	return func(o *PutOptions) {
+		o.Provenance = provenance
+	}
+}
+
+// WithIdempotencyKey records the key with the put, in the same batch. A later put with the same key
+// writes nothing, and reports Replayed, e.g. for a message that a queue delivers again.
+// Puts with a key are serialized. The keys are kept until forgotten with ForgetIdempotencyKeys.
+func WithIdempotencyKey(key string) PutOption {
+	return func(o *PutOptions) {
+		o.IdempotencyKey = key
+	}
+}
+
+// WithRootMemo hashes the nodes of the put tree through the memo, to reuse roots of earlier puts in the session.
+func WithRootMemo(memo *RootMemo) PutOption {
+	return func(o *PutOptions) {
+		o.RootMemo = memo
+	}
+}
+
+// WithFresh skips every existence check of the put, for the initial import into an empty prefix,
+// which by definition has no nodes to reuse: the checks dominate the import time there.
+// The whole tree is written in a single batch. Nodes that are stored already are rewritten with the slot of the put.
+// PutStream still checks that the nodes that were left out of the stream are stored.
+func WithFresh() PutOption {
+	return func(o *PutOptions) {
+		o.Fresh = true
+	}
+}
+
+// WithCommit hands the pending batch of the put to the commit function, instead of writing it.
+// Applications use it to write their own keys, e.g. an index of block metadata, atomically with the tree.
+// The commit is called after the quota check, while prunes wait for the put; it must not call back into the merkledb.
+func WithCommit(commit CommitFn) PutOption {
+	return func(o *PutOptions) {
+		o.Commit = commit
+	}
+}
+
+func applyPutOptions(opts []PutOption) (out PutOptions) {
+	for _, opt := range opts {
+		opt(&out)
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// OrphanReport lists the stored nodes that no anchor or named reference reaches,
+// e.g. left behind by interrupted puts, or by pruning policies that do not work as intended.
+type OrphanReport struct {
+	// Orphans are the unreachable nodes, in key order: by gindex, then by root
+	Orphans []NodeRef
+	// Bytes is the number of key and value bytes of the orphans
+	Bytes int
+}
+
+func (db *merkleDB) FindOrphans() (report *OrphanReport, err error) {
+	err = db.consistent(func(view *merkleDB) error {
+		anchors, err := view.Anchors()
+		if err != nil {
+			return err
+		}
+		refs, err := view.Refs()
+		if err != nil {
+			return err
+		}
+		pins, err := view.Pins()
+		if err != nil {
+			return err
+		}
+		live := make([]Root, 0, len(anchors)+len(refs)+len(pins))
+		for i := range anchors {
+			live = append(live, anchors[i].Root)
+		}
+		for _, root := range refs {
+			live = append(live, root)
+		}
+		live = append(live, pins...)
+		hidden, err := view.hiddenRoots()
+		if err != nil {
+			return err
+		}
+		live = append(live, hidden...)
+		marked, err := view.mark(live)
+		if err != nil {
+			return err
+		}
+		defer marked.close()
+		report = new(OrphanReport)
+		return view.unmarked(marked, nil, func(key []byte, value []byte) error {
+			if _, ok := metaKind(key); ok {
+				// anchors are live by definition
+				return nil
+			}
+			k, err := ParseNodeKey(key)
+			if err != nil {
+				return err
+			}
+			report.Orphans = append(report.Orphans, NodeRef{Gindex: k.Gindex, Root: k.Root})
+			report.Bytes += len(key) + len(value)
+			return nil
+		})
+	})
+	return report, err
+}
@@ -0,0 +1,69 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_FindOrphans(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	a := randomTree(5)
+	left, right := randomTree(3), randomTree(3)
+	b := NewPairNode(left, right)
+	for i, n := range []Node{a, b} {
+		if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if report, err := mdb.FindOrphans(); err != nil {
+		t.Fatal(err)
+	} else if len(report.Orphans) != 0 || report.Bytes != 0 {
+		t.Fatalf("expected no orphans, got %d", len(report.Orphans))
+	}
+	// deleting the root leaves the rest of the tree behind
+	rootB := b.MerkleRoot(hFn)
+	if err := mdb.Delete(RootGindex, rootB); err != nil {
+		t.Fatal(err)
+	}
+	report, err := mdb.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans := make(map[NodeRef]struct{})
+	for _, o := range report.Orphans {
+		orphans[o] = struct{}{}
+	}
+	for _, child := range []NodeRef{
+		{Gindex: LeftGindex, Root: left.MerkleRoot(hFn)},
+		{Gindex: RightGindex, Root: right.MerkleRoot(hFn)},
+	} {
+		if _, ok := orphans[child]; !ok {
+			t.Fatalf("expected %s at %d to be an orphan", child.Root, child.Gindex)
+		}
+	}
+	if report.Bytes == 0 {
+		t.Fatal("expected orphan bytes")
+	}
+	// a named reference keeps the tree that it names reachable, even without anchor
+	if err := mdb.SetRef(HeadRef, left.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Transplant(LeftGindex, left.MerkleRoot(hFn), RootGindex); err != nil {
+		t.Fatal(err)
+	}
+	after, err := mdb.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Orphans) != len(report.Orphans) {
+		t.Fatalf("expected the named copy to not be orphaned, got %d orphans, expected %d", len(after.Orphans), len(report.Orphans))
+	}
+	// pruning with all anchors removes the orphans
+	if err := mdb.Prune([]Root{a.MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := mdb.FindOrphans(); err != nil || len(report.Orphans) != 0 {
+		t.Fatalf("expected no orphans after prune, err: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"math/bits"
+)
+
+func (db *merkleDB) PutTop(slot uint64, node Node, fn HashFn, maxDepth uint32, opts ...PutOption) (InsertReport, error) {
+	fn = hashFnOrDefault(fn)
+	top, _, err := truncate(node, fn, RootGindex, maxDepth)
+	if err != nil {
+		return InsertReport{}, err
+	}
+	return db.Put(slot, top, fn, append(append([]PutOption(nil), opts...), partialPut)...)
+}
+
+// partialPair is a pair of a partial put with summaries in its subtree, it is stored as a partial pair:
+// later puts do not reuse it, they store the subtree below it.
+type partialPair struct {
+	*PairNode
+}
+
+// withSummaries returns the pair of the children, as a partialPair if the subtree holds summaries
+func withSummaries(left Node, right Node, summaries bool) Node {
+	pair := NewPairNode(left, right)
+	if summaries {
+		return &partialPair{pair}
+	}
+	return pair
+}
+
+// truncate replaces the subtrees at the depth with summaries of their roots, and is true if the result holds any
+func truncate(node Node, fn HashFn, gindex Gindex, depth uint32) (Node, bool, error) {
+	if node.IsLeaf() {
+		_, summary := node.(*summaryNode)
+		return node, summary, nil
+	}
+	if depth == 0 {
+		return &summaryNode{gindex: gindex, root: node.MerkleRoot(fn)}, true, nil
+	}
+	left, err := node.Left()
+	if err != nil {
+		return nil, false, err
+	}
+	right, err := node.Right()
+	if err != nil {
+		return nil, false, err
+	}
+	left, leftSummaries, err := truncate(left, fn, gindex.Left(), depth-1)
+	if err != nil {
+		return nil, false, err
+	}
+	right, rightSummaries, err := truncate(right, fn, gindex.Right(), depth-1)
+	if err != nil {
+		return nil, false, err
+	}
+	summaries := leftSummaries || rightSummaries
+	return withSummaries(left, right, summaries), summaries, nil
+}
+
+// partialPut marks the put of a partial tree
+func partialPut(o *PutOptions) {
+	o.partial = true
+}
+
+func (db *merkleDB) PutSubtrees(slot uint64, node Node, fn HashFn, gindices []Gindex, opts ...PutOption) (InsertReport, error) {
+	fn = hashFnOrDefault(fn)
+	if len(gindices) == 0 {
+		return InsertReport{}, errors.New("no subtrees to put")
+	}
+	targets, err := gindexValues(gindices)
+	if err != nil {
+		return InsertReport{}, err
+	}
+	selected, _, err := selectSubtrees(node, fn, 1, targets)
+	if err != nil {
+		return InsertReport{}, err
+	}
+	return db.Put(slot, selected, fn, append(append([]PutOption(nil), opts...), partialPut)...)
+}
+
+// selectSubtrees keeps the subtrees at the targets and the spine of the node at g to them,
+// and replaces the other subtrees with summaries of their roots. It is true if the result holds any summaries.
+func selectSubtrees(node Node, fn HashFn, g uint64, targets []uint64) (Node, bool, error) {
+	onSpine := false
+	for _, t := range targets {
+		if within(g, t) {
+			return node, false, nil
+		}
+		onSpine = onSpine || within(t, g)
+	}
+	if !onSpine {
+		if node.IsLeaf() {
+			_, summary := node.(*summaryNode)
+			return node, summary, nil
+		}
+		return &summaryNode{gindex: Gindex64(g), root: node.MerkleRoot(fn)}, true, nil
+	}
+	if node.IsLeaf() {
+		return nil, false, NavigationError
+	}
+	if g >= 1<<63 {
+		return nil, false, errGindexTooDeep
+	}
+	left, err := node.Left()
+	if err != nil {
+		return nil, false, err
+	}
+	right, err := node.Right()
+	if err != nil {
+		return nil, false, err
+	}
+	left, leftSummaries, err := selectSubtrees(left, fn, g<<1, targets)
+	if err != nil {
+		return nil, false, err
+	}
+	right, rightSummaries, err := selectSubtrees(right, fn, g<<1|1, targets)
+	if err != nil {
+		return nil, false, err
+	}
+	summaries := leftSummaries || rightSummaries
+	return withSummaries(left, right, summaries), summaries, nil
+}
+
+// within is true if the gindex g is t, or in the subtree of t
+func within(g uint64, t uint64) bool {
+	d := bits.Len64(g) - bits.Len64(t)
+	return d >= 0 && g>>uint(d) == t
+}
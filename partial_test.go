@@ -0,0 +1,109 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_PutTop(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(4)
+	anchor := foo.MerkleRoot(hFn)
+	report, err := mdb.PutTop(3, foo, hFn, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NewNodes != 7 || report.MaxDepth != 2 {
+		t.Fatalf("expected the top 3 levels, got %+v", report)
+	}
+	if _, err := mdb.GetAnchor(anchor); err != nil {
+		t.Fatal(err)
+	}
+	// the boundary is provable
+	summaryNode, err := foo.Getter(Gindex64(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := summaryNode.MerkleRoot(hFn)
+	proof, err := mdb.Prove(anchor, Gindex64(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Leaf != summary || !proof.Verify(anchor, hFn) {
+		t.Fatal("expected a valid proof of the summary leaf")
+	}
+	var rec PairRecord
+	if err := mdb.GetInto(Gindex64(6), summary, &rec); err != nil || rec.Pair || !rec.Summary || rec.Slot != 3 {
+		t.Fatalf("expected a summary, got %+v, err: %v", rec, err)
+	}
+	// below it nothing is stored
+	var summarizedErr ErrSummarized
+	if _, err := mdb.Prove(anchor, Gindex64(12)); !errors.As(err, &summarizedErr) || summarizedErr.AtGindex.(Gindex64) != 6 {
+		t.Fatalf("expected no proof below the boundary, got %v", err)
+	}
+	if _, err := mdb.ProveMulti(anchor, []Gindex{Gindex64(12)}); !errors.As(err, &summarizedErr) {
+		t.Fatalf("expected no multiproof below the boundary, got %v", err)
+	}
+	top, err := mdb.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := top.Node.Getter(Gindex64(12)); !errors.As(err, &summarizedErr) {
+		t.Fatalf("expected a summarized node below the boundary, got %v", err)
+	}
+	c, err := mdb.Completeness(anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Complete() || c.Stored != 3 || len(c.Summarized) != 4 || len(c.Missing) != 0 {
+		t.Fatalf("expected an incomplete tree with 4 summaries, got %+v", c)
+	}
+	// the summaries of a loaded tree cannot be put as if complete
+	if _, err := mdb.Put(4, top.Node, hFn); !errors.Is(err, ErrSummaryNode) {
+		t.Fatalf("expected ErrSummaryNode, got %v", err)
+	}
+
+	// a full put of the same tree fills in the subtrees, the summaries are not reused
+	if report, err := mdb.Put(4, foo, hFn); err != nil || report.NewNodes != 31 || report.ReusedNodes != 0 {
+		t.Fatalf("expected the full tree, got %+v, err: %v", report, err)
+	}
+	if c, err := mdb.Completeness(anchor); err != nil || !c.Complete() {
+		t.Fatalf("expected a complete tree, err: %v", err)
+	}
+}
+
+func TestMerkleDB_PutSubtrees(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(4)
+	anchor := foo.MerkleRoot(hFn)
+	// the subtree at 5 has 7 nodes, the one at 28 is a leaf
+	report, err := mdb.PutSubtrees(1, foo, hFn, []Gindex{Gindex64(5), Gindex64(28)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the paths 1-2-5 and 1-3-7-14-28, the siblings 4, 6, 15, 29, and the 6 nodes below 5
+	if report.NewNodes != 17 {
+		t.Fatalf("expected 17 nodes, got %d", report.NewNodes)
+	}
+	for _, g := range []uint64{5, 20, 21, 28, 29, 6} {
+		proof, err := mdb.Prove(anchor, Gindex64(g))
+		if err != nil {
+			t.Fatalf("gindex %d: %v", g, err)
+		}
+		if !proof.Verify(anchor, hFn) {
+			t.Fatalf("gindex %d: invalid proof", g)
+		}
+	}
+	if _, err := mdb.Prove(anchor, Gindex64(24)); err == nil {
+		t.Fatal("expected the subtree at 6 to not be stored")
+	}
+	if _, err := mdb.PutSubtrees(1, foo, hFn, []Gindex{Gindex64(64)}); err != NavigationError {
+		t.Fatalf("expected a navigation error below a leaf, got %v", err)
+	}
+	if _, err := mdb.PutSubtrees(1, foo, hFn, nil); err == nil {
+		t.Fatal("expected an error without subtrees")
+	}
+}
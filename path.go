@@ -0,0 +1,158 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+)
+
+func pathIndex(p interface{}) (uint64, error) {
+	switch v := p.(type) {
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("negative index %d", v)
+		}
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case uint32:
+		return uint64(v), nil
+	case int64:
+		if v < 0 {
+			return 0, fmt.Errorf("negative index %d", v)
+		}
+		return uint64(v), nil
+	default:
+		return 0, fmt.Errorf("expected an index, got %T", p)
+	}
+}
+
+// basicElemsPerChunk returns how many elements of the basic type are packed in a 32 byte chunk
+func basicElemsPerChunk(typ view.TypeDef) uint64 {
+	return 32 / typ.TypeByteLength()
+}
+
+// ResolvePath resolves a path of field names and list/vector indices into a gindex, relative to a value of the given type.
+// It also returns the type of the value the path ends at. Paths into packed basic elements
+// end at the chunk that holds the element, and the returned type is the element type.
+func ResolvePath(typ view.TypeDef, path ...interface{}) (Gindex, view.TypeDef, error) {
+	gindices := make([]Gindex, 0, len(path))
+	for i, p := range path {
+		var g Gindex
+		var err error
+		switch td := typ.(type) {
+		case *view.ContainerTypeDef:
+			var fieldIndex uint64
+			if name, ok := p.(string); ok {
+				fieldIndex = uint64(len(td.Fields))
+				for j, f := range td.Fields {
+					if f.Name == name {
+						fieldIndex = uint64(j)
+						break
+					}
+				}
+				if fieldIndex == uint64(len(td.Fields)) {
+					return nil, nil, fmt.Errorf("path %d: container %s has no field '%s'", i, td.ContainerName, name)
+				}
+			} else if fieldIndex, err = pathIndex(p); err != nil {
+				return nil, nil, fmt.Errorf("path %d: %v", i, err)
+			}
+			g, err = ContainerFieldGindex(uint64(len(td.Fields)), fieldIndex)
+			if err == nil {
+				typ = td.Fields[fieldIndex].Type
+			}
+		case *view.ComplexListTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = ListElementGindex(td.ListLimit, 1, index)
+				typ = td.ElemType
+			}
+		case *view.ComplexVectorTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = VectorElementGindex(td.VectorLength, 1, index)
+				typ = td.ElemType
+			}
+		case *view.BasicListTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = ListElementGindex(td.ListLimit, basicElemsPerChunk(td.ElemType), index)
+				typ = td.ElemType
+			}
+		case *view.BasicVectorTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = VectorElementGindex(td.VectorLength, basicElemsPerChunk(td.ElemType), index)
+				typ = td.ElemType
+			}
+		case *view.BitListTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = ListElementGindex(td.BitLimit, 256, index)
+				typ = view.BoolType
+			}
+		case *view.BitVectorTypeDef:
+			var index uint64
+			if index, err = pathIndex(p); err == nil {
+				g, err = VectorElementGindex(td.BitLength, 256, index)
+				typ = view.BoolType
+			}
+		default:
+			return nil, nil, fmt.Errorf("path %d: cannot navigate into type %s", i, typ.String())
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("path %d: %v", i, err)
+		}
+		gindices = append(gindices, g)
+	}
+	g, err := ConcatGindices(gindices...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, typ, nil
+}
+
+// lookup finds the root of the node at the gindex, in the tree of the anchor.
+// A missing node on the way down is reported as ErrSummarized, a missing anchor as leveldb.ErrNotFound.
+func (db *merkleDB) lookup(anchor Root, target Gindex) (Root, error) {
+	iter, depth := target.BitIter()
+	var rec PairRecord
+	var gindex Gindex = RootGindex
+	node := anchor
+	for i := uint32(0); i < depth; i++ {
+		if err := db.GetInto(gindex, node, &rec); err != nil {
+			if i > 0 {
+				return Root{}, summarized(err, gindex, node)
+			}
+			return Root{}, err
+		}
+		if !rec.Pair {
+			return Root{}, leafError(&rec, gindex, node)
+		}
+		right, _ := iter.Next()
+		if right {
+			node = rec.Right
+			gindex = gindex.Right()
+		} else {
+			node = rec.Left
+			gindex = gindex.Left()
+		}
+	}
+	return node, nil
+}
+
+func (db *merkleDB) GetPath(anchor Root, typ view.TypeDef, path ...interface{}) (SlottedNode, error) {
+	gindex, _, err := ResolvePath(typ, path...)
+	if err != nil {
+		return SlottedNode{}, err
+	}
+	root, err := db.lookup(anchor, gindex)
+	if err != nil {
+		return SlottedNode{}, err
+	}
+	out, err := db.Get(gindex, root)
+	if err != nil && !gindex.IsRoot() {
+		return SlottedNode{}, summarized(err, gindex, root)
+	}
+	return out, err
+}
@@ -0,0 +1,101 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"testing"
+)
+
+var testValidatorType = view.ContainerType("Validator", []view.FieldDef{
+	{Name: "pubkey", Type: view.RootType},
+	{Name: "effective_balance", Type: view.Uint64Type},
+	{Name: "slashed", Type: view.BoolType},
+})
+
+var testStateType = view.ContainerType("State", []view.FieldDef{
+	{Name: "genesis_time", Type: view.Uint64Type},
+	{Name: "slot", Type: view.Uint64Type},
+	{Name: "validators", Type: view.ComplexListType(testValidatorType, 1<<10)},
+	{Name: "balances", Type: view.BasicListType(view.Uint64Type, 1<<10)},
+})
+
+func testState(t *testing.T, slot uint64, validators uint64) *view.ContainerView {
+	state := testStateType.New()
+	if err := state.Set(1, view.Uint64View(slot)); err != nil {
+		t.Fatal(err)
+	}
+	vals, err := state.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bals, err := state.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < validators; i++ {
+		v := testValidatorType.New()
+		pubkey := view.RootView(*randomRoot())
+		if err := v.Set(0, &pubkey); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Set(1, view.Uint64View(32_000_000_000+i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := vals.(*view.ComplexListView).Append(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := bals.(*view.BasicListView).Append(view.Uint64View(31_000_000_000 + i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+func TestResolvePath(t *testing.T) {
+	g, typ, err := ResolvePath(testStateType, "validators", 5, "effective_balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 fields: depth 2, validators at index 2, then contents and 10 levels, then 3 fields: depth 2, index 1
+	expected := Gindex64(((((0b1_10<<1)|0)<<10 | 5) << 2) | 1)
+	if g != expected {
+		t.Fatalf("got gindex %d, expected %d", g, expected)
+	}
+	if typ != view.Uint64Type {
+		t.Fatalf("unexpected type: %s", typ.String())
+	}
+	if _, _, err := ResolvePath(testStateType, "foobar"); err == nil {
+		t.Fatal("expected unknown field error")
+	}
+	if _, _, err := ResolvePath(testStateType, "slot", 1); err == nil {
+		t.Fatal("expected error for navigating into a basic type")
+	}
+}
+
+func TestMerkleDB_GetPath(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 123, 20)
+	anchor := state.HashTreeRoot(hFn)
+	if _, err := mdb.Put(123, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.GetPath(anchor, testStateType, "validators", 5, "effective_balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := out.Node.MerkleRoot(hFn)
+	if got := binary.LittleEndian.Uint64(leaf[:8]); got != 32_000_000_005 {
+		t.Fatalf("unexpected effective balance: %d", got)
+	}
+	// balances are packed 4 per chunk, index 9 is the 2nd element of chunk 2
+	out, err = mdb.GetPath(anchor, testStateType, "balances", 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf = out.Node.MerkleRoot(hFn)
+	if got := binary.LittleEndian.Uint64(leaf[8:16]); got != 31_000_000_009 {
+		t.Fatalf("unexpected balance: %d", got)
+	}
+}
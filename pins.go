@@ -0,0 +1,66 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const metaPin byte = 'p'
+
+func (db *merkleDB) Pin(root Root) error {
+	return db.writeKey(db.metaKey(metaPin, root[:]), nil)
+}
+
+func (db *merkleDB) Unpin(root Root) error {
+	return db.deleteKey(db.metaKey(metaPin, root[:]))
+}
+
+func (db *merkleDB) Pins() ([]Root, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaPin, nil)), nil)
+	defer iter.Release()
+	var out []Root
+	for iter.Next() {
+		out = append(out, toRoot(iter.Key()[metaKeyLen:]))
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// withPins adds the pinned and the hidden roots to the live roots of a prune
+func (db *merkleDB) withPins(liveRoots []Root) ([]Root, error) {
+	pins, err := db.Pins()
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := db.hiddenRoots()
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) == 0 && len(hidden) == 0 {
+		return liveRoots, nil
+	}
+	out := make([]Root, 0, len(liveRoots)+len(pins)+len(hidden))
+	return append(append(append(out, liveRoots...), pins...), hidden...), nil
+}
+
+// retained is the set of anchor roots that retention policies keep: the named and the pinned anchors
+func (db *merkleDB) retained() (map[Root]struct{}, error) {
+	refs, err := db.Refs()
+	if err != nil {
+		return nil, err
+	}
+	pins, err := db.Pins()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[Root]struct{}, len(refs)+len(pins))
+	for _, root := range refs {
+		out[root] = struct{}{}
+	}
+	for _, root := range pins {
+		out[root] = struct{}{}
+	}
+	return out, nil
+}
@@ -0,0 +1,73 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Pin(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithTTLSlots(10, 0))
+	genesis := randomTree(5)
+	genesisRoot := genesis.MerkleRoot(hFn)
+	// a root can be pinned before the tree is stored
+	if err := mdb.Pin(genesisRoot); err != nil {
+		t.Fatal(err)
+	}
+	var roots []Root
+	for slot, n := range []Node{genesis, randomTree(5), randomTree(5)} {
+		if _, err := mdb.Put(uint64(slot)*100, n, hFn); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.MerkleRoot(hFn))
+	}
+	if pins, err := mdb.Pins(); err != nil || len(pins) != 1 || pins[0] != genesisRoot {
+		t.Fatalf("unexpected pins: %v, err: %v", pins, err)
+	}
+	// the pinned tree does not expire, and is not pruned
+	if n, err := mdb.Expire(); err != nil || n != 1 {
+		t.Fatalf("expected 1 expired anchor, got %d, err: %v", n, err)
+	}
+	expectAnchors(t, mdb, genesisRoot, roots[2])
+	if err := mdb.Prune([]Root{roots[2]}); err != nil {
+		t.Fatal(err)
+	}
+	expectAnchors(t, mdb, genesisRoot, roots[2])
+	if report, err := mdb.Completeness(genesisRoot); err != nil || !report.Complete() {
+		t.Fatalf("expected the pinned tree to be complete, err: %v", err)
+	}
+	if err := mdb.Unpin(genesisRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Prune([]Root{roots[2]}); err != nil {
+		t.Fatal(err)
+	}
+	expectAnchors(t, mdb, roots[2])
+}
+
+func TestMerkleDB_PinReorg(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	base, a, b := randomTree(4), randomTree(4), randomTree(4)
+	baseRoot, rootA, rootB := base.MerkleRoot(hFn), a.MerkleRoot(hFn), b.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, base, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, a, hFn, WithParent(baseRoot)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, b, hFn, WithParent(baseRoot)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Pin(rootA); err != nil {
+		t.Fatal(err)
+	}
+	report, err := mdb.Reorg(rootA, rootB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Abandoned) != 1 || report.Abandoned[0] != rootA || report.Reclaimed != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	expectAnchors(t, mdb, baseRoot, rootA, rootB)
+}
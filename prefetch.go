@@ -0,0 +1,212 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// prefetchStreak is the number of reads of consecutive gindices at a depth that starts the read-ahead at that depth
+const prefetchStreak = 2
+
+type prefetchKey struct {
+	gindex uint64
+	root   Root
+}
+
+// prefetcher detects sequential reads, like a Walk or the Getter calls of a linear scan over the leaves,
+// and reads the next nodes at the same depth ahead, in the background.
+// The next nodes are found through the recently read pairs above them: the read-ahead of the parents
+// makes the read-ahead of their children possible.
+type prefetcher struct {
+	db     *merkleDB
+	window int
+	lock   sync.Mutex
+	// last is the last read gindex, and streak the number of consecutive gindices before it, by depth
+	last   map[int]uint64
+	streak map[int]int
+	// parents are the recently read pairs, to find the roots of the nodes ahead
+	parents map[uint64]PairRecord
+	// ready are the records that were read ahead, and not taken yet
+	ready    map[prefetchKey]PairRecord
+	inflight map[prefetchKey]struct{}
+	// order of insertion of the parents and ready records, oldest first, to bound them
+	parentOrder []uint64
+	readyOrder  []prefetchKey
+	// sem bounds the reads in the background
+	sem chan struct{}
+	// gen changes with every reset, reads ahead of an older generation are dropped
+	gen uint64
+}
+
+func newPrefetcher(db *merkleDB, window int) *prefetcher {
+	return &prefetcher{
+		db:       db,
+		window:   window,
+		last:     make(map[int]uint64),
+		streak:   make(map[int]int),
+		parents:  make(map[uint64]PairRecord),
+		ready:    make(map[prefetchKey]PairRecord),
+		inflight: make(map[prefetchKey]struct{}),
+		sem:      make(chan struct{}, window),
+	}
+}
+
+// capacity bounds the parents and the ready records
+func (p *prefetcher) capacity() int {
+	return 4 * p.window
+}
+
+// take removes and returns the record of the node, if it was read ahead
+func (p *prefetcher) take(gindex Gindex, root Root, dst *PairRecord) bool {
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return false
+	}
+	k := prefetchKey{gindex: g, root: root}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	rec, ok := p.ready[k]
+	if !ok {
+		return false
+	}
+	delete(p.ready, k)
+	*dst = rec
+	p.observe(g, rec)
+	return true
+}
+
+// read records the read of a node, and reads ahead if the reads at its depth are sequential
+func (p *prefetcher) read(gindex Gindex, rec PairRecord) {
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.observe(g, rec)
+}
+
+func (p *prefetcher) observe(g uint64, rec PairRecord) {
+	if rec.Pair {
+		p.addParent(g, rec)
+	}
+	depth := bits.Len64(g) - 1
+	last, ok := p.last[depth]
+	switch {
+	case ok && g == last+1:
+		p.streak[depth] += 1
+	case ok && g == last:
+		// the Getter calls of a scan read the same parents again
+		return
+	default:
+		p.streak[depth] = 0
+	}
+	p.last[depth] = g
+	if p.streak[depth] < prefetchStreak {
+		return
+	}
+	end := uint64(1) << uint(depth+1)
+	for n := g + 1; n < end && n <= g+uint64(p.window); n++ {
+		parent, ok := p.parents[n>>1]
+		if !ok {
+			if parent, ok = p.readyParent(n >> 1); !ok {
+				return
+			}
+		}
+		root := parent.Left
+		if n&1 == 1 {
+			root = parent.Right
+		}
+		k := prefetchKey{gindex: n, root: root}
+		if _, ok := p.ready[k]; ok {
+			continue
+		}
+		if _, ok := p.inflight[k]; ok {
+			continue
+		}
+		select {
+		case p.sem <- struct{}{}:
+		default:
+			// enough reads in the background already
+			return
+		}
+		p.inflight[k] = struct{}{}
+		go p.fetch(k, p.gen)
+	}
+}
+
+// readyParent finds a pair that was read ahead, but not taken yet
+func (p *prefetcher) readyParent(g uint64) (PairRecord, bool) {
+	for k, rec := range p.ready {
+		if k.gindex == g && rec.Pair {
+			return rec, true
+		}
+	}
+	return PairRecord{}, false
+}
+
+func (p *prefetcher) fetch(k prefetchKey, gen uint64) {
+	defer func() {
+		<-p.sem
+	}()
+	var rec PairRecord
+	err := p.db.getLocal(Gindex64(k.gindex), k.root, &rec)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.inflight, k)
+	if err != nil || gen != p.gen {
+		// the read itself reports the error, if the node is read at all
+		return
+	}
+	atomic.AddUint64(&p.db.counters.prefetches, 1)
+	p.ready[k] = rec
+	p.readyOrder = append(p.readyOrder, k)
+	for len(p.ready) > p.capacity() {
+		oldest := p.readyOrder[0]
+		p.readyOrder = p.readyOrder[1:]
+		delete(p.ready, oldest)
+	}
+	// taken records leave stale entries in the order
+	if len(p.readyOrder) > 2*p.capacity() {
+		order := p.readyOrder[:0]
+		for _, k := range p.readyOrder {
+			if _, ok := p.ready[k]; ok {
+				order = append(order, k)
+			}
+		}
+		p.readyOrder = order
+	}
+}
+
+func (p *prefetcher) addParent(g uint64, rec PairRecord) {
+	if _, ok := p.parents[g]; !ok {
+		p.parentOrder = append(p.parentOrder, g)
+	}
+	p.parents[g] = rec
+	for len(p.parents) > p.capacity() {
+		oldest := p.parentOrder[0]
+		p.parentOrder = p.parentOrder[1:]
+		delete(p.parents, oldest)
+	}
+}
+
+// reset drops everything that was read ahead, e.g. when nodes are deleted
+func (db *merkleDB) resetPrefetch() {
+	if db.prefetch != nil {
+		db.prefetch.reset()
+	}
+}
+
+func (p *prefetcher) reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.gen += 1
+	p.ready = make(map[prefetchKey]PairRecord)
+	p.readyOrder = nil
+	p.parents = make(map[uint64]PairRecord)
+	p.parentOrder = nil
+	p.last = make(map[int]uint64)
+	p.streak = make(map[int]int)
+}
@@ -0,0 +1,125 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+	"time"
+)
+
+// settle waits for the reads in the background to finish
+func settle(t *testing.T, p *prefetcher) {
+	for i := 0; i < 1000; i++ {
+		p.lock.Lock()
+		n := len(p.inflight)
+		p.lock.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("reads ahead did not finish")
+}
+
+func TestMerkleDB_PrefetchGetter(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithPrefetch(4)).(*merkleDB)
+	hFn := GetHashFn()
+	tree := fullTree(5)
+	if _, err := mdb.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mdb.Get(RootGindex, tree.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a linear scan over the leaves, like serializing a full state
+	for i := uint64(0); i < 32; i++ {
+		leaf, err := got.Node.Getter(Gindex64(32 + i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := tree.Getter(Gindex64(32 + i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+			t.Fatalf("leaf %d differs", i)
+		}
+		settle(t, mdb.prefetch)
+	}
+	stats := mdb.Stats()
+	if stats.Prefetches == 0 || stats.PrefetchHits == 0 {
+		t.Fatalf("expected the leaves to be read ahead: %+v", stats)
+	}
+}
+
+func TestMerkleDB_PrefetchWalk(t *testing.T) {
+	hFn := GetHashFn()
+	tree := fullTree(6)
+	plain := New(testPrefix, newMemoryDB())
+	prefetched := New(testPrefix, newMemoryDB(), WithPrefetch(8))
+	var expected, got []StreamNode
+	for _, x := range []struct {
+		db  MerkleDB
+		out *[]StreamNode
+	}{{plain, &expected}, {prefetched, &got}} {
+		if _, err := x.db.Put(1, tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+		out := x.out
+		if err := x.db.Walk(tree.MerkleRoot(hFn), DepthFirst, func(node StreamNode) error {
+			*out = append(*out, node)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if got[i].Root != expected[i].Root || got[i].Gindex != expected[i].Gindex {
+			t.Fatalf("node %d differs", i)
+		}
+	}
+}
+
+func TestMerkleDB_PrefetchReset(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithPrefetch(4)).(*merkleDB)
+	hFn := GetHashFn()
+	tree := fullTree(3)
+	if _, err := mdb.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	rootAt := func(g uint64) Root {
+		n, err := tree.Getter(Gindex64(g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.MerkleRoot(hFn)
+	}
+	// reading the nodes in gindex order reads the leaves after them ahead
+	var rec PairRecord
+	for g := uint64(1); g < 11; g++ {
+		if err := mdb.GetInto(Gindex64(g), rootAt(g), &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	settle(t, mdb.prefetch)
+	mdb.prefetch.lock.Lock()
+	_, ok := mdb.prefetch.ready[prefetchKey{gindex: 11, root: rootAt(11)}]
+	mdb.prefetch.lock.Unlock()
+	if !ok {
+		t.Fatal("expected the next leaf to be read ahead")
+	}
+	// deleted nodes are not served from what was read ahead
+	if err := mdb.Delete(Gindex64(11), rootAt(11)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.GetInto(Gindex64(11), rootAt(11), &rec); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the deleted node to be gone, got %v", err)
+	}
+	if err := mdb.GetInto(Gindex64(12), rootAt(12), &rec); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strconv"
+)
+
+// DefaultPrefixCheckSamples is the number of records of the prefix that New decodes, if not configured
+const DefaultPrefixCheckSamples = 32
+
+// ErrPrefixCollision is matched by every PrefixCollisionError, see errors.Is
+var ErrPrefixCollision = errors.New("prefix is used by another subsystem")
+
+// ForeignRecord is a record in the keyspace of a prefix that does not decode under the merkledb schema
+type ForeignRecord struct {
+	Key   []byte
+	Value []byte
+	Err   error
+}
+
+// PrefixCollisionError is returned by CheckPrefix when sampled records of the prefix do not decode,
+// i.e. the prefix appears to be used by another subsystem of the leveldb
+type PrefixCollisionError struct {
+	Prefix [prefixLen]byte
+	// Sampled is the number of records that were decoded, and Foreign those that failed
+	Sampled int
+	Foreign []ForeignRecord
+}
+
+func (e *PrefixCollisionError) Error() string {
+	return fmt.Sprintf("prefix '%x' appears to be used by another subsystem: %d of %d sampled records do not decode, e.g. key '%x': %v",
+		e.Prefix, len(e.Foreign), e.Sampled, e.Foreign[0].Key, e.Foreign[0].Err)
+}
+
+func (e *PrefixCollisionError) Is(target error) bool {
+	return target == ErrPrefixCollision
+}
+
+// CheckPrefix decodes a sample of the records in the keyspace of the prefix: the first and the last samples/2,
+// which cover the metadata records, the nodes of the shallowest and the deepest gindices, and keys that sort
+// around them. It returns a PrefixCollisionError if any of them does not decode under the merkledb schema.
+// An unused prefix, or one holding only merkledb records, returns nil.
+func CheckPrefix(db *leveldb.DB, prefix [prefixLen]byte, samples int) error {
+	iter := db.NewIterator(util.BytesPrefix(prefix[:]), nil)
+	defer iter.Release()
+	collision := &PrefixCollisionError{Prefix: prefix}
+	check := func() {
+		collision.Sampled += 1
+		if err := checkPrefixRecord(iter.Key(), iter.Value()); err != nil {
+			collision.Foreign = append(collision.Foreign, ForeignRecord{
+				Key:   append([]byte(nil), iter.Key()...),
+				Value: append([]byte(nil), iter.Value()...),
+				Err:   err,
+			})
+		}
+	}
+	first := samples - samples/2
+	var last []byte
+	for ok := iter.First(); ok && collision.Sampled < first; ok = iter.Next() {
+		check()
+		last = append(last[:0], iter.Key()...)
+	}
+	// the last records, down to those sampled from the start
+	for ok := iter.Last(); ok && collision.Sampled < samples && string(iter.Key()) > string(last); ok = iter.Prev() {
+		check()
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if len(collision.Foreign) > 0 {
+		return collision
+	}
+	return nil
+}
+
+// checkPrefixRecord checks that a record decodes as a node, or as metadata of a known kind
+func checkPrefixRecord(key []byte, value []byte) error {
+	kind, ok := metaKind(key)
+	if !ok {
+		if _, err := ParseNodeKey(key); err != nil {
+			return err
+		}
+		_, err := ParseNodeValue(value)
+		return err
+	}
+	if _, known := recordSizes[kind]; !known {
+		return fmt.Errorf("%w: unknown metadata kind %s", ErrMalformed, strconv.QuoteRune(rune(kind)))
+	}
+	id := key[metaKeyLen:]
+	if err := checkValueSize(kind, id, value, 0); err != nil {
+		return err
+	}
+	if kind == metaAnchor || kind == metaHidden {
+		if len(id) != 32 {
+			return fmt.Errorf("%w: anchor root of %d bytes", ErrMalformed, len(id))
+		}
+		var a Anchor
+		return a.decode(toRoot(id), value)
+	}
+	return nil
+}
+
+// checkPrefix runs CheckPrefix for New, and reports a collision to OnBackgroundError.
+// Open fails with it if the check is strict, see WithPrefixCheck.
+func (db *merkleDB) checkPrefix() {
+	samples := db.opts.PrefixCheckSamples
+	if samples == 0 {
+		samples = DefaultPrefixCheckSamples
+	}
+	if samples < 0 {
+		return
+	}
+	db.collision = CheckPrefix(db.db, db.prefix, samples)
+	if db.collision != nil && db.opts.OnBackgroundError != nil {
+		db.opts.OnBackgroundError(db.collision)
+	}
+}
@@ -0,0 +1,55 @@
+package merkledb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckPrefix(t *testing.T) {
+	ldb, opts, _, _ := corruptTestDB(t)
+	// every record of a merkledb decodes
+	if err := CheckPrefix(ldb, testPrefix, 1<<20); err != nil {
+		t.Fatalf("expected no collision: %v", err)
+	}
+	if err := CheckPrefix(ldb, [prefixLen]byte{9, 9, 9}, DefaultPrefixCheckSamples); err != nil {
+		t.Fatalf("expected an unused prefix to pass: %v", err)
+	}
+
+	// another subsystem that writes its own records under the prefix
+	foreign := append(append([]byte(nil), testPrefix[:]...), "\xffsession/42"...)
+	if err := ldb.Put(foreign, []byte("{}"), nil); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckPrefix(ldb, testPrefix, DefaultPrefixCheckSamples)
+	var collision *PrefixCollisionError
+	if !errors.Is(err, ErrPrefixCollision) || !errors.As(err, &collision) || len(collision.Foreign) != 1 ||
+		string(collision.Foreign[0].Key) != string(foreign) {
+		t.Fatalf("expected the foreign record, got %v", err)
+	}
+	if collision.Sampled != DefaultPrefixCheckSamples {
+		t.Fatalf("expected %d samples, got %d", DefaultPrefixCheckSamples, collision.Sampled)
+	}
+
+	var reported error
+	New(testPrefix, ldb, WithBackgroundErrors(func(err error) { reported = err }))
+	if !errors.Is(reported, ErrPrefixCollision) {
+		t.Fatalf("expected New to report the collision, got %v", reported)
+	}
+	if _, err := Open(testPrefix, ldb, WithPrefixCheck(DefaultPrefixCheckSamples, true)); !errors.Is(err, ErrPrefixCollision) {
+		t.Fatalf("expected a strict Open to fail, got %v", err)
+	}
+	if _, err := Open(testPrefix, ldb, append(opts, WithPrefixCheck(-1, true))...); err != nil {
+		t.Fatalf("expected a disabled check to pass: %v", err)
+	}
+
+	// metadata of an unknown kind is foreign too
+	if err := ldb.Delete(foreign, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Put(metaKeyOf(testPrefix, '#', nil), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPrefix(ldb, testPrefix, DefaultPrefixCheckSamples); !errors.Is(err, ErrPrefixCollision) {
+		t.Fatalf("expected an unknown metadata kind to collide, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+func (db *merkleDB) Preload(anchor Root, depth uint8) (int, error) {
+	if depth > 63 {
+		return 0, fmt.Errorf("preload depth %d too large", depth)
+	}
+	type ref struct {
+		gindex uint64
+		root   Root
+	}
+	// breadth-first, every level is read in the order of its keys
+	level := []ref{{gindex: 1, root: anchor}}
+	n := 0
+	var rec PairRecord
+	for d := uint8(0); len(level) > 0; d++ {
+		var next []ref
+		for _, r := range level {
+			if err := db.GetInto(Gindex64(r.gindex), r.root, &rec); err != nil {
+				return n, fmt.Errorf("failed to preload node %d %s: %w", r.gindex, r.root, err)
+			}
+			n += 1
+			if rec.Pair && d < depth {
+				next = append(next, ref{gindex: r.gindex << 1, root: rec.Left}, ref{gindex: r.gindex<<1 | 1, root: rec.Right})
+			}
+		}
+		level = next
+	}
+	return n, nil
+}
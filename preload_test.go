@@ -0,0 +1,45 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Preload(t *testing.T) {
+	hFn := GetHashFn()
+	backend := New(testPrefix, newMemoryDB())
+	tree := fullTree(6)
+	root := tree.MerkleRoot(hFn)
+	if _, err := backend.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := backend.Preload(root, 3); err != nil || n != 15 {
+		t.Fatalf("expected 15 nodes down to depth 3, got %d, err: %v", n, err)
+	}
+	// the leaves end the preload before the depth
+	if n, err := backend.Preload(root, 10); err != nil || n != 127 {
+		t.Fatalf("expected all 127 nodes, got %d, err: %v", n, err)
+	}
+	if _, err := backend.Preload(*randomRoot(), 3); err == nil {
+		t.Fatal("expected an unknown anchor to fail")
+	}
+
+	c := NewCachingDB(backend, [prefixLen]byte{1, 2, 3}, newMemoryDB())
+	if n, err := c.Preload(root, 2); err != nil || n != 7 {
+		t.Fatalf("expected 7 nodes down to depth 2, got %d, err: %v", n, err)
+	}
+	left, err := tree.Getter(Gindex64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.cache.Has(Gindex64(7), left.MerkleRoot(hFn)); err != nil || !ok {
+		t.Fatalf("expected the preloaded node in the cache, err: %v", err)
+	}
+	below, err := tree.Getter(Gindex64(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.cache.Has(Gindex64(8), below.MerkleRoot(hFn)); err != nil || ok {
+		t.Fatalf("expected no node below the depth in the cache, err: %v", err)
+	}
+}
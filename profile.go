@@ -0,0 +1,44 @@
+package merkledb
+
+import "time"
+
+// Profile is a named storage preset, wiring together the retention and pruning options.
+type Profile string
+
+const (
+	// ProfileArchive keeps everything
+	ProfileArchive Profile = "archive"
+	// ProfilePruned keeps the named anchors (e.g. finalized), and the anchors of the most recent slots
+	ProfilePruned Profile = "pruned"
+	// ProfileMinimal keeps only the named anchors
+	ProfileMinimal Profile = "minimal"
+)
+
+// DefaultRecentSlots is the number of recent slots that ProfilePruned keeps: one historical-roots period.
+const DefaultRecentSlots = 8192
+
+// DefaultSweepInterval is the background sweep interval of the pruning profiles, unless another option asks for a shorter one.
+const DefaultSweepInterval = time.Minute
+
+// WithProfile applies the storage profile. Options after it can override individual settings.
+func WithProfile(p Profile) Option {
+	return func(o *Options) {
+		o.Profile = p
+		o.TTL = 0
+		o.TTLSlots = 0
+		o.RetainRefsOnly = false
+		switch p {
+		case ProfileArchive:
+			o.DeferredDeletes = false
+			o.SweepInterval = 0
+		case ProfilePruned:
+			o.TTLSlots = DefaultRecentSlots
+			o.DeferredDeletes = true
+			sweepEvery(o, DefaultSweepInterval)
+		case ProfileMinimal:
+			o.RetainRefsOnly = true
+			o.DeferredDeletes = true
+			sweepEvery(o, DefaultSweepInterval)
+		}
+	}
+}
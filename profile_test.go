@@ -0,0 +1,72 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func putAnchors(t *testing.T, mdb MerkleDB, slots ...uint64) []Root {
+	hFn := GetHashFn()
+	var out []Root
+	for _, slot := range slots {
+		n := randomTree(5)
+		if _, err := mdb.Put(slot, n, hFn); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n.MerkleRoot(hFn))
+	}
+	return out
+}
+
+func expireAndReclaim(t *testing.T, mdb MerkleDB) {
+	if _, err := mdb.Expire(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectAnchors(t *testing.T, mdb MerkleDB, expected ...Root) {
+	anchors, err := mdb.Anchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != len(expected) {
+		t.Fatalf("expected %d anchors, got %d", len(expected), len(anchors))
+	}
+	for _, root := range expected {
+		if ok, err := mdb.Has(RootGindex, root); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("expected anchor %s to be kept", root)
+		}
+	}
+}
+
+func TestProfileArchive(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithProfile(ProfileArchive))
+	roots := putAnchors(t, mdb, 0, 10000, 20000)
+	expireAndReclaim(t, mdb)
+	expectAnchors(t, mdb, roots...)
+}
+
+func TestProfilePruned(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithProfile(ProfilePruned), WithDeferredDeletes(0))
+	roots := putAnchors(t, mdb, 0, 100, 10000, 20000)
+	if err := mdb.SetRef(FinalizedRef, roots[1]); err != nil {
+		t.Fatal(err)
+	}
+	expireAndReclaim(t, mdb)
+	expectAnchors(t, mdb, roots[1], roots[3])
+}
+
+func TestProfileMinimal(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithProfile(ProfileMinimal), WithDeferredDeletes(0))
+	roots := putAnchors(t, mdb, 0, 100, 200)
+	if err := mdb.SetRef(HeadRef, roots[2]); err != nil {
+		t.Fatal(err)
+	}
+	expireAndReclaim(t, mdb)
+	expectAnchors(t, mdb, roots[2])
+}
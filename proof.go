@@ -0,0 +1,20 @@
+package merkledb
+
+import (
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// MerkleProof proves a Leaf at a Gindex.
+// The Branch is ordered bottom-up: the sibling of the leaf first, the sibling just below the anchor last.
+type MerkleProof = lite.MerkleProof
+
+func (db *merkleDB) Prove(anchor Root, target Gindex) (*MerkleProof, error) {
+	return db.cachedProve(anchor, target, func() (*MerkleProof, error) {
+		if target.IsRoot() {
+			return lite.Prove(db.GetInto, anchor, target)
+		}
+		// every loaded record is navigated below, a summary cannot be
+		return lite.Prove(db.descend, anchor, target)
+	})
+}
@@ -0,0 +1,34 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Prove(t *testing.T) {
+	db := newMemoryDB()
+	mdb := New(testPrefix, db)
+	foo := randomTree(17)
+	hFn := GetHashFn()
+	anchor := foo.MerkleRoot(hFn)
+	if _, err := mdb.Put(randomSlot(), foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		n, gi := randomNode(foo, RootGindex, 10)
+		proof, err := mdb.Prove(anchor, gi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Leaf != n.MerkleRoot(hFn) {
+			t.Fatalf("proof leaf %s does not match node %s at %v", proof.Leaf, n.MerkleRoot(hFn), gi)
+		}
+		if !proof.Verify(anchor, hFn) {
+			t.Fatalf("proof for %v does not verify", gi)
+		}
+		proof.Leaf = *randomRoot()
+		if proof.Verify(anchor, hFn) {
+			t.Fatalf("modified proof for %v verifies", gi)
+		}
+	}
+}
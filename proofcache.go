@@ -0,0 +1,158 @@
+package merkledb
+
+import (
+	"container/list"
+	. "github.com/protolambda/ztyp/tree"
+	"sync"
+	"sync/atomic"
+)
+
+type proofKey struct {
+	anchor Root
+	target uint64
+}
+
+type cachedProof struct {
+	key   proofKey
+	proof MerkleProof
+}
+
+// proofCache keeps the most recently used proofs by anchor and target, see WithProofCache.
+// The trees are content-addressed, a proof only goes stale when the nodes of its anchor are deleted, or the anchor is hidden:
+// a prune drops the proofs of the anchors that it does not keep, Hide those of the anchor, other deletes drop all proofs.
+type proofCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[proofKey]*list.Element
+	// order of use of the entries, most recent first
+	order *list.List
+	// gen changes with every invalidation, proofs that were read before it are not cached
+	gen uint64
+}
+
+func newProofCache(size int) *proofCache {
+	return &proofCache{size: size, entries: make(map[proofKey]*list.Element), order: list.New()}
+}
+
+// copyProof copies the proof, so cached proofs are not changed by the callers
+func copyProof(p *MerkleProof) *MerkleProof {
+	out := *p
+	out.Branch = append([]Root(nil), p.Branch...)
+	return &out
+}
+
+func (c *proofCache) get(key proofKey) (*MerkleProof, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return copyProof(&e.Value.(*cachedProof).proof), true
+}
+
+func (c *proofCache) generation() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.gen
+}
+
+func (c *proofCache) put(key proofKey, p *MerkleProof, gen uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedProof{key: key, proof: *copyProof(p)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedProof).key)
+	}
+}
+
+// retain drops the proofs of the anchors that are not kept
+func (c *proofCache) retain(kept map[Root]struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gen += 1
+	for key, e := range c.entries {
+		if _, ok := kept[key.anchor]; !ok {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// drop drops the proofs of the anchor
+func (c *proofCache) drop(anchor Root) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gen += 1
+	for key, e := range c.entries {
+		if key.anchor == anchor {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *proofCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gen += 1
+	c.entries = make(map[proofKey]*list.Element)
+	c.order.Init()
+}
+
+// cachedProve serves a proof of a target with a 64 bit gindex from the proof cache, or proves and caches it
+func (db *merkleDB) cachedProve(anchor Root, target Gindex, prove func() (*MerkleProof, error)) (*MerkleProof, error) {
+	g, err := gindexValue(target)
+	if db.proofs == nil || err != nil {
+		return prove()
+	}
+	key := proofKey{anchor: anchor, target: g}
+	if p, ok := db.proofs.get(key); ok {
+		atomic.AddUint64(&db.counters.proofHits, 1)
+		return p, nil
+	}
+	atomic.AddUint64(&db.counters.proofMisses, 1)
+	gen := db.proofs.generation()
+	p, err := prove()
+	if err != nil {
+		return nil, err
+	}
+	db.proofs.put(key, p, gen)
+	return p, nil
+}
+
+// resetProofs drops all cached proofs, after nodes were deleted
+func (db *merkleDB) resetProofs() {
+	if db.proofs != nil {
+		db.proofs.reset()
+	}
+}
+
+// dropProofs drops the cached proofs of the anchor, after it was hidden: its tree is stored, but not readable
+func (db *merkleDB) dropProofs(anchor Root) {
+	if db.proofs != nil {
+		db.proofs.drop(anchor)
+	}
+}
+
+// retainProofs drops the cached proofs of the anchors that a prune does not keep
+func (db *merkleDB) retainProofs(kept []Root) {
+	if db.proofs == nil {
+		return
+	}
+	set := make(map[Root]struct{}, len(kept))
+	for _, root := range kept {
+		set[root] = struct{}{}
+	}
+	db.proofs.retain(set)
+}
@@ -0,0 +1,74 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestProofCache(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithProofCache(2))
+	hFn := GetHashFn()
+	a, b := fullTree(4), fullTree(4)
+	rootA, rootB := a.MerkleRoot(hFn), b.MerkleRoot(hFn)
+	for i, tree := range []Node{a, b} {
+		if _, err := mdb.Put(uint64(i+1), tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prove := func(anchor Root, g uint64) *MerkleProof {
+		p, err := mdb.Prove(anchor, Gindex64(g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Verify(anchor, hFn) {
+			t.Fatalf("proof of gindex %d does not verify", g)
+		}
+		return p
+	}
+	expectStats := func(hits, misses uint64) {
+		t.Helper()
+		if s := mdb.Stats(); s.ProofCacheHits != hits || s.ProofCacheMisses != misses {
+			t.Fatalf("expected %d hits and %d misses, got %d and %d", hits, misses, s.ProofCacheHits, s.ProofCacheMisses)
+		}
+	}
+	p := prove(rootA, 17)
+	// the callers get copies of the cached proof
+	p.Branch[0][0] ^= 1
+	prove(rootA, 17)
+	prove(rootB, 17)
+	expectStats(1, 2)
+	// the least recently used proof is evicted
+	prove(rootB, 18)
+	prove(rootA, 17)
+	expectStats(1, 4)
+
+	// the prune keeps b, its proofs stay cached
+	prove(rootB, 18)
+	expectStats(2, 4)
+	if err := mdb.Prune([]Root{rootB}); err != nil {
+		t.Fatal(err)
+	}
+	prove(rootB, 18)
+	expectStats(3, 4)
+	if _, err := mdb.Prove(rootA, Gindex64(17)); err == nil {
+		t.Fatal("expected the proof of the pruned anchor to be dropped")
+	}
+
+	// a delete drops all proofs
+	if err := mdb.Delete(Gindex64(1), rootA); err != nil {
+		t.Fatal(err)
+	}
+	prove(rootB, 18)
+	expectStats(3, 6)
+
+	// hiding the anchor drops its proofs
+	prove(rootB, 18)
+	expectStats(4, 6)
+	if err := mdb.Hide(rootB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Prove(rootB, Gindex64(18)); !errors.Is(err, ErrHidden) {
+		t.Fatalf("expected ErrHidden, got %v", err)
+	}
+}
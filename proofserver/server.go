@@ -0,0 +1,197 @@
+// Package proofserver serves merkle proofs of the trees in a merkledb over HTTP, e.g. for light clients.
+//
+// Endpoints:
+//
+//	GET /proof?anchor=<root>&gindex=<gindex>             single-node proof, branch ordered bottom-up
+//	GET /multiproof?anchor=<root>&gindex=<a>&gindex=<b>  multiproof, helpers ordered by descending gindex
+//	GET /health                                          health status of the database, 503 if unhealthy
+//
+// Roots are 0x-prefixed hex, gindices are decimal. The nodes of a tree do not change while its anchor is stored,
+// so the proofs of stored anchors are cached, and served again as long as the anchor is still stored.
+// The trees of anchors that were pruned, reorged, expired or hidden since are proven again, hidden trees are not found.
+package proofserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/protolambda/merkledb"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCacheSize is the number of proofs the server caches if not configured
+const DefaultCacheSize = 1024
+
+// Server serves the proofs of a merkledb over HTTP
+type Server struct {
+	db    merkledb.TreeReader
+	cache *lru
+	mux   *http.ServeMux
+}
+
+// New creates a server for the database, caching up to cacheSize proofs. DefaultCacheSize is used if 0.
+func New(db merkledb.TreeReader, cacheSize int) *Server {
+	if cacheSize == 0 {
+		cacheSize = DefaultCacheSize
+	}
+	s := &Server{db: db, cache: newLRU(cacheSize), mux: http.NewServeMux()}
+	s.mux.HandleFunc("/proof", s.handleProof)
+	s.mux.HandleFunc("/multiproof", s.handleMultiProof)
+	s.mux.HandleFunc("/health", s.handleHealth)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Proof is the JSON response of the /proof endpoint
+type Proof struct {
+	Gindex uint64 `json:"gindex"`
+	Leaf   Root   `json:"leaf"`
+	Branch []Root `json:"branch"`
+}
+
+// MultiProof is the JSON response of the /multiproof endpoint
+type MultiProof struct {
+	Gindices []uint64 `json:"gindices"`
+	Leaves   []Root   `json:"leaves"`
+	Helpers  []Root   `json:"helpers"`
+}
+
+type request struct {
+	anchor   Root
+	gindices []uint64
+}
+
+func parseRequest(r *http.Request) (*request, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("method %s not allowed", r.Method)
+	}
+	q := r.URL.Query()
+	var req request
+	if err := req.anchor.UnmarshalText([]byte(q.Get("anchor"))); err != nil {
+		return nil, fmt.Errorf("bad anchor: %v", err)
+	}
+	for _, v := range q["gindex"] {
+		g, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || g == 0 {
+			return nil, fmt.Errorf("bad gindex '%s'", v)
+		}
+		req.gindices = append(req.gindices, g)
+	}
+	if len(req.gindices) == 0 {
+		return nil, fmt.Errorf("missing gindex")
+	}
+	return &req, nil
+}
+
+func (req *request) cacheKey(kind string) string {
+	var sb strings.Builder
+	sb.WriteString(kind)
+	sb.WriteString(req.anchor.String())
+	for _, g := range req.gindices {
+		sb.WriteByte(',')
+		sb.WriteString(strconv.FormatUint(g, 10))
+	}
+	return sb.String()
+}
+
+func (s *Server) handleProof(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, "proof", func(req *request) (interface{}, error) {
+		if len(req.gindices) != 1 {
+			return nil, badRequest("expected a single gindex, use /multiproof for more")
+		}
+		p, err := s.db.Prove(req.anchor, Gindex64(req.gindices[0]))
+		if err != nil {
+			return nil, err
+		}
+		return &Proof{Gindex: req.gindices[0], Leaf: p.Leaf, Branch: p.Branch}, nil
+	})
+}
+
+func (s *Server) handleMultiProof(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, "multiproof", func(req *request) (interface{}, error) {
+		gindices := make([]Gindex, len(req.gindices))
+		for i, g := range req.gindices {
+			gindices[i] = Gindex64(g)
+		}
+		p, err := s.db.ProveMulti(req.anchor, gindices)
+		if err != nil {
+			return nil, err
+		}
+		return &MultiProof{Gindices: req.gindices, Leaves: p.Leaves, Helpers: p.Helpers}, nil
+	})
+}
+
+// handleHealth serves the merkledb.HealthStatus, it is never cached
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusBadRequest)
+		return
+	}
+	status := s.db.Health()
+	body, err := json.Marshal(&status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(body)
+}
+
+// stored is true if the anchor is stored and not hidden, and the cached proofs of its tree are still valid
+func (s *Server) stored(anchor Root) bool {
+	_, err := s.db.GetAnchor(anchor)
+	return err == nil
+}
+
+type badRequest string
+
+func (e badRequest) Error() string {
+	return string(e)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, kind string, prove func(req *request) (interface{}, error)) {
+	req, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := req.cacheKey(kind)
+	body, ok := s.cache.get(key)
+	if ok && !s.stored(req.anchor) {
+		s.cache.remove(key)
+		ok = false
+	}
+	if !ok {
+		out, err := prove(req)
+		if err != nil {
+			if _, ok := err.(badRequest); ok {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, leveldb.ErrNotFound) || errors.Is(err, NavigationError) || errors.Is(err, merkledb.ErrHidden) {
+				http.Error(w, "not found", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if body, err = json.Marshal(out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// a prune may have removed the anchor while proving, a later request checks it again
+		if s.stored(req.anchor) {
+			s.cache.add(key, body)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
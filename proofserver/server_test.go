@@ -0,0 +1,171 @@
+package proofserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/protolambda/merkledb"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testTree(depth uint, i *byte) Node {
+	if depth == 0 {
+		*i++
+		return &Root{*i}
+	}
+	return NewPairNode(testTree(depth-1, i), testTree(depth-1, i))
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, out interface{}) int {
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := merkledb.New([3]byte{1, 2, 3}, ldb)
+	defer db.Close()
+	hFn := GetHashFn()
+	var i byte
+	tree := testTree(4, &i)
+	if _, err := db.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := tree.MerkleRoot(hFn)
+	s := New(db, 2)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	var p Proof
+	if code := get(t, srv, fmt.Sprintf("/proof?anchor=%s&gindex=19", anchor), &p); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	proof := merkledb.MerkleProof{Gindex: Gindex64(p.Gindex), Leaf: p.Leaf, Branch: p.Branch}
+	if !proof.Verify(anchor, hFn) {
+		t.Fatal("expected proof to verify")
+	}
+	var mp MultiProof
+	if code := get(t, srv, fmt.Sprintf("/multiproof?anchor=%s&gindex=16&gindex=17&gindex=30", anchor), &mp); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	multi := merkledb.MultiProof{Leaves: mp.Leaves, Helpers: mp.Helpers}
+	for _, g := range mp.Gindices {
+		multi.Gindices = append(multi.Gindices, Gindex64(g))
+	}
+	if !multi.Verify(anchor, hFn) {
+		t.Fatal("expected multiproof to verify")
+	}
+	// cached responses are served again, and the cache stays within its size
+	if code := get(t, srv, fmt.Sprintf("/proof?anchor=%s&gindex=19", anchor), &p); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if code := get(t, srv, fmt.Sprintf("/proof?anchor=%s&gindex=2", anchor), &p); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if n := s.cache.len(); n != 2 {
+		t.Fatalf("expected 2 cached proofs, got %d", n)
+	}
+	if _, ok := s.cache.get(fmt.Sprintf("multiproof%s,16,17,30", anchor)); ok {
+		t.Fatal("expected least recently used proof to be evicted")
+	}
+	for path, expected := range map[string]int{
+		fmt.Sprintf("/proof?anchor=%s&gindex=19&gindex=20", anchor): http.StatusBadRequest,
+		fmt.Sprintf("/proof?anchor=%s&gindex=x", anchor):            http.StatusBadRequest,
+		"/proof?anchor=0x1234&gindex=2":                             http.StatusBadRequest,
+		fmt.Sprintf("/proof?anchor=%s&gindex=2", Root{}):            http.StatusNotFound,
+		fmt.Sprintf("/proof?anchor=%s&gindex=64", anchor):           http.StatusNotFound,
+	} {
+		if code := get(t, srv, path, &p); code != expected {
+			t.Fatalf("%s: expected status %d, got %d", path, expected, code)
+		}
+	}
+}
+
+func TestServer_Invalidation(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := merkledb.New([3]byte{1, 2, 3}, ldb)
+	defer db.Close()
+	hFn := GetHashFn()
+	var i byte
+	tree := testTree(4, &i)
+	if _, err := db.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := tree.MerkleRoot(hFn)
+	s := New(db, 0)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	path := fmt.Sprintf("/proof?anchor=%s&gindex=19", anchor)
+	var p Proof
+	if code := get(t, srv, path, &p); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	// hidden trees are not found, also the proofs that were cached before
+	if err := db.Hide(anchor); err != nil {
+		t.Fatal(err)
+	}
+	for _, hidden := range []string{path, fmt.Sprintf("/proof?anchor=%s&gindex=2", anchor)} {
+		if code := get(t, srv, hidden, &p); code != http.StatusNotFound {
+			t.Fatalf("%s: expected the proof of the hidden tree to be gone, got status %d", hidden, code)
+		}
+	}
+	if err := db.Unhide(anchor); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(t, srv, path, &p); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if err := db.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+	// the cached proof of the pruned tree is not served again
+	if code := get(t, srv, path, &p); code != http.StatusNotFound {
+		t.Fatalf("expected the proof of the pruned tree to be gone, got status %d", code)
+	}
+	if n := s.cache.len(); n != 0 {
+		t.Fatalf("expected no cached proofs, got %d", n)
+	}
+}
+
+func TestServer_Health(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := merkledb.New([3]byte{1, 2, 3}, ldb)
+	var i byte
+	if _, err := db.Put(1, testTree(3, &i), nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(db, 0))
+	defer srv.Close()
+	var status merkledb.HealthStatus
+	if code := get(t, srv, "/health", &status); code != http.StatusOK || !status.Healthy {
+		t.Fatalf("expected healthy, got status %d: %+v", code, status)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(t, srv, "/health", &status); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable after close, got %d", code)
+	}
+}
@@ -0,0 +1,23 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+func (db *merkleDB) ProvenanceAnchors(provenance Root) ([]Anchor, error) {
+	if provenance == (Root{}) {
+		return nil, errors.New("zero provenance, anchors without provenance are not listed")
+	}
+	anchors, err := db.Anchors()
+	if err != nil {
+		return nil, err
+	}
+	out := anchors[:0]
+	for i := range anchors {
+		if anchors[i].Provenance == provenance {
+			out = append(out, anchors[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Provenance(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	block := *randomRoot()
+	a, b := randomTree(4), randomTree(4)
+	rootA, rootB := a.MerkleRoot(hFn), b.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, a, hFn, WithProvenance(block)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(1, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if anchor, err := mdb.GetAnchor(rootA); err != nil {
+		t.Fatal(err)
+	} else if anchor.Provenance != block {
+		t.Fatalf("unexpected provenance: %s", anchor.Provenance)
+	}
+	// putting the tree again keeps the provenance
+	if _, err := mdb.Put(2, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchors, err := mdb.ProvenanceAnchors(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 1 || anchors[0].Root != rootA {
+		t.Fatalf("unexpected anchors: %v", anchors)
+	}
+	if anchor, err := mdb.GetAnchor(rootB); err != nil {
+		t.Fatal(err)
+	} else if anchor.Provenance != (Root{}) {
+		t.Fatalf("expected no provenance, got %s", anchor.Provenance)
+	}
+	if _, err := mdb.ProvenanceAnchors(Root{}); err == nil {
+		t.Fatal("expected an error for the zero provenance")
+	}
+}
@@ -0,0 +1,238 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync/atomic"
+)
+
+// mark all keys reachable from the given anchor roots
+func (db *merkleDB) mark(liveRoots []Root) (*markSet, error) {
+	marked := db.newMarkSet()
+	for _, root := range liveRoots {
+		if err := db.markTree(marked, RootGindex, root); err != nil {
+			_ = marked.close()
+			return nil, err
+		}
+		if err := db.markAnchor(marked, root); err != nil {
+			_ = marked.close()
+			return nil, err
+		}
+	}
+	return marked, nil
+}
+
+// markTree marks the stored subtree at (gindex, root). Subtrees of marked nodes are expected to be marked already.
+func (db *merkleDB) markTree(marked *markSet, gindex Gindex, root Root) error {
+	var buf [maxKeyLen]byte
+	k, err := db.buildKey(&buf, gindex, root)
+	if err != nil {
+		return err
+	}
+	if ok, err := marked.has(k); err != nil || ok {
+		return err
+	}
+	var rec PairRecord
+	if err := db.getLocal(gindex, root, &rec); err == leveldb.ErrNotFound {
+		// partially stored tree, nothing to keep here
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := marked.add(k); err != nil {
+		return err
+	}
+	if !rec.Pair {
+		return nil
+	}
+	if err := db.markTree(marked, gindex.Left(), rec.Left); err != nil {
+		return err
+	}
+	return db.markTree(marked, gindex.Right(), rec.Right)
+}
+
+func (db *merkleDB) markAnchor(marked *markSet, root Root) error {
+	anchorKey := db.metaKey(metaAnchor, root[:])
+	if ok, err := marked.has(anchorKey); err != nil || ok {
+		return err
+	}
+	return marked.add(anchorKey)
+}
+
+func (db *merkleDB) Prune(liveRoots []Root) error {
+	return db.prune(func() ([]Root, map[Root][]uint64, error) {
+		return liveRoots, nil, nil
+	})
+}
+
+// liveSet computes the live roots of a prune, and the fields of the partly retained trees.
+// It returns errNoPrune if there is nothing to prune.
+type liveSet func() (liveRoots []Root, partial map[Root][]uint64, err error)
+
+// errNoPrune stops a prune before it deletes anything, see liveSet
+var errNoPrune = errors.New("nothing to prune")
+
+// prune keeps the trees of the live roots, and the fields of the partly retained trees, see markFields.
+// The live set is computed while the prune holds the prune lock: the anchors of puts that complete before are listed,
+// later puts wait for the prune. The checkpoint records the partly retained trees as live: a resumed prune keeps them
+// as a whole.
+func (db *merkleDB) prune(live liveSet) error {
+	defer db.resetUsage()
+	defer db.resetPrefetch()
+	db.pruneLock.Lock()
+	defer db.pruneLock.Unlock()
+	liveRoots, partial, err := live()
+	if err == errNoPrune {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	liveRoots, err = db.withPins(liveRoots)
+	if err != nil {
+		return err
+	}
+	// the trimmed trees lose the nodes of the proofs of other fields
+	defer db.retainProofs(liveRoots)
+	kept := liveRoots
+	if len(partial) > 0 {
+		kept = append([]Root(nil), liveRoots...)
+		for root := range partial {
+			kept = append(kept, root)
+		}
+	}
+	if db.opts.DeferredDeletes {
+		if err := db.tombstoneAnchors(kept); err != nil {
+			return err
+		}
+		if err := db.pruneBlobs(); err != nil {
+			return err
+		}
+		if err := db.pruneSSZ(); err != nil {
+			return err
+		}
+		if err := db.pruneTags(); err != nil {
+			return err
+		}
+		b := new(leveldb.Batch)
+		db.audit(b, AuditRecord{Op: AuditPrune, Count: uint64(len(kept))})
+		return db.write(b)
+	}
+	defer db.startPrune()()
+	// continue after the last deleted chunk of an interrupted prune of the same live roots
+	var from []byte
+	if c, err := db.PruneCheckpoint(); err == nil && c.sameRoots(kept) {
+		from = c.LastKey
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	marked, err := db.mark(liveRoots)
+	if err != nil {
+		return err
+	}
+	defer marked.close()
+	if err := db.markFields(marked, partial); err != nil {
+		return err
+	}
+	// keys are deleted in key order, in chunks: the anchors sort before all nodes and go first,
+	// an interrupted prune leaves only unreachable nodes, which the next prune deletes.
+	// Every chunk is written with a checkpoint of the progress.
+	checkpoint := PruneCheckpoint{LiveRoots: kept}
+	w := db.newDeleteWriter()
+	w.checkpoint = func(b *leveldb.Batch, last []byte) {
+		checkpoint.LastKey = last
+		b.Put(db.metaKey(metaPruneCheckpoint, nil), checkpoint.encode())
+		atomic.StoreInt32(&db.checkpointed, 1)
+	}
+	if err := db.unmarked(marked, from, func(key []byte, value []byte) error {
+		return w.delete(key)
+	}); err != nil {
+		return err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := db.pruneIndexes(); err != nil {
+		return err
+	}
+	if err := db.pruneBlobs(); err != nil {
+		return err
+	}
+	if err := db.pruneSSZ(); err != nil {
+		return err
+	}
+	if err := db.pruneTags(); err != nil {
+		return err
+	}
+	if _, err := db.collectValueLog(); err != nil {
+		return err
+	}
+	b := new(leveldb.Batch)
+	b.Delete(db.metaKey(metaPruneCheckpoint, nil))
+	db.audit(b, AuditRecord{Op: AuditPrune, Count: uint64(len(kept))})
+	if err := db.write(b); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&db.checkpointed, 0)
+	return nil
+}
+
+// unmarked calls fn, in key order, for every node and anchor under the prefix that is not marked,
+// starting after the from key if not nil. Other metadata is kept by prunes, and skipped.
+func (db *merkleDB) unmarked(marked *markSet, from []byte, fn func(key []byte, value []byte) error) error {
+	scan := util.BytesPrefix(db.prefix[:])
+	if from != nil {
+		scan.Start = append(append([]byte(nil), from...), 0)
+	}
+	iter := db.r.NewIterator(scan, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if kind, ok := metaKind(iter.Key()); ok && kind != metaAnchor {
+			continue
+		}
+		if ok, err := marked.has(iter.Key()); err != nil {
+			return err
+		} else if !ok {
+			if err := fn(iter.Key(), iter.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Error()
+}
+
+// PruneReport describes what a prune removes
+type PruneReport struct {
+	// Anchors are the roots of the pruned trees, ordered by root
+	Anchors []Root
+	// Nodes is the number of unreachable nodes
+	Nodes int
+	// Bytes is the number of key and value bytes of the nodes and anchors
+	Bytes int
+}
+
+func (db *merkleDB) PrunePlan(liveRoots []Root) (report *PruneReport, err error) {
+	err = db.consistent(func(view *merkleDB) error {
+		live, err := view.withPins(liveRoots)
+		if err != nil {
+			return err
+		}
+		marked, err := view.mark(live)
+		if err != nil {
+			return err
+		}
+		defer marked.close()
+		report = new(PruneReport)
+		return view.unmarked(marked, nil, func(key []byte, value []byte) error {
+			if _, ok := metaKind(key); ok {
+				report.Anchors = append(report.Anchors, toRoot(key[metaKeyLen:]))
+			} else {
+				report.Nodes += 1
+			}
+			report.Bytes += len(key) + len(value)
+			return nil
+		})
+	})
+	return report, err
+}
@@ -0,0 +1,110 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"testing"
+)
+
+func countKeys(t *testing.T, mdb *merkleDB) int {
+	iter := mdb.db.NewIterator(util.BytesPrefix(mdb.prefix[:]), nil)
+	defer iter.Release()
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMerkleDB_Prune(t *testing.T) {
+	db := newMemoryDB()
+	mdb := New(testPrefix, db).(*merkleDB)
+	hFn := GetHashFn()
+	a := randomTree(10)
+	b := randomTree(10)
+	// c shares the left subtree with a
+	aLeft, _ := a.Left()
+	c := NewPairNode(aLeft, randomTree(5))
+	for i, n := range []Node{a, b, c} {
+		if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := countKeys(t, mdb)
+	if err := mdb.Prune([]Root{c.MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+	after := countKeys(t, mdb)
+	if after >= before {
+		t.Fatalf("expected keys to be pruned, before: %d, after: %d", before, after)
+	}
+	out, err := mdb.Get(RootGindex, c.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(c, out.Node, RootGindex, hFn, t)
+	for _, n := range []Node{a, b} {
+		if ok, err := mdb.Has(RootGindex, n.MerkleRoot(hFn)); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("expected pruned anchor to be gone")
+		}
+	}
+}
+
+func TestMerkleDB_PrunePlan(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB()).(*merkleDB)
+	shared := randomTree(4)
+	a := NewPairNode(shared, randomTree(4))
+	b := NewPairNode(shared, randomTree(4))
+	c := randomTree(5)
+	var roots []Root
+	for i, n := range []Node{a, b, c} {
+		if _, err := mdb.Put(uint64(i), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.MerkleRoot(hFn))
+	}
+	if err := mdb.SetRef(HeadRef, roots[0]); err != nil {
+		t.Fatal(err)
+	}
+	before, err := mdb.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := countKeys(t, mdb)
+	plan, err := mdb.PrunePlan(roots[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countKeys(t, mdb) != keys {
+		t.Fatal("expected the plan to not delete anything")
+	}
+	if len(plan.Anchors) != 2 {
+		t.Fatalf("expected 2 pruned anchors, got %d", len(plan.Anchors))
+	}
+	for _, root := range plan.Anchors {
+		if root != roots[1] && root != roots[2] {
+			t.Fatalf("unexpected pruned anchor %s", root)
+		}
+	}
+	// the plan matches the prune
+	if err := mdb.Prune(roots[:1]); err != nil {
+		t.Fatal(err)
+	}
+	after, err := mdb.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Nodes != before.Nodes-after.Nodes || plan.Bytes != before.Bytes-after.Bytes {
+		t.Fatalf("plan of %d nodes and %d bytes did not match the prune of %d nodes and %d bytes",
+			plan.Nodes, plan.Bytes, before.Nodes-after.Nodes, before.Bytes-after.Bytes)
+	}
+	if keys-countKeys(t, mdb) != plan.Nodes+len(plan.Anchors) {
+		t.Fatal("expected the planned keys to be deleted")
+	}
+}
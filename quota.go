@@ -0,0 +1,184 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+)
+
+// ErrQuotaExceeded is returned by Put when the tree does not fit in the quota of the prefix
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota bounds the storage of a merkledb, i.e. of its prefix.
+type Quota struct {
+	// MaxNodes is the maximum number of stored nodes. Unbounded if 0.
+	MaxNodes int
+	// MaxBytes is the maximum number of key and value bytes of the nodes, anchors and index entries. Unbounded if 0.
+	MaxBytes int
+	// PruneOnExceed makes a Put that would exceed the quota expire anchors first,
+	// and then prune the oldest anchors that are not named by a ref or pinned, until the tree fits.
+	// A PutStream only makes room while the stored nodes exceed the quota, the stream itself may still be rejected.
+	// Without it a Put is rejected with ErrQuotaExceeded.
+	PruneOnExceed bool
+}
+
+// Usage is the storage used by a merkledb
+type Usage struct {
+	Nodes int
+	Bytes int
+}
+
+// WithQuota enforces the quota on every Put
+func WithQuota(q Quota) Option {
+	return func(o *Options) {
+		o.Quota = q
+	}
+}
+
+func (q *Quota) enabled() bool {
+	return q.MaxNodes > 0 || q.MaxBytes > 0
+}
+
+func (q *Quota) exceeded(u Usage) bool {
+	return (q.MaxNodes > 0 && u.Nodes > q.MaxNodes) || (q.MaxBytes > 0 && u.Bytes > q.MaxBytes)
+}
+
+func (db *merkleDB) Usage() (Usage, error) {
+	db.usageLock.Lock()
+	defer db.usageLock.Unlock()
+	return db.countUsage()
+}
+
+// countUsage counts the usage if it is not known. The usage lock must be held.
+func (db *merkleDB) countUsage() (Usage, error) {
+	if db.usage != nil {
+		return *db.usage, nil
+	}
+	iter := db.r.NewIterator(util.BytesPrefix(db.prefix[:]), nil)
+	defer iter.Release()
+	var u Usage
+	for iter.Next() {
+		if kind, ok := metaKind(iter.Key()); !ok {
+			u.Nodes += 1
+		} else if kind != metaAnchor && kind != metaIndex {
+			continue
+		}
+		u.Bytes += len(iter.Key()) + len(iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		return Usage{}, err
+	}
+	db.usage = &u
+	return u, nil
+}
+
+// resetUsage drops the known usage after deletes and other writes, the next Usage counts again
+func (db *merkleDB) resetUsage() {
+	if db.base != nil {
+		db = db.base
+	}
+	db.usageLock.Lock()
+	defer db.usageLock.Unlock()
+	db.usage = nil
+}
+
+// makeRoom is called before a put: with PruneOnExceed, anchors are pruned
+// while the quota is exceeded by the stored nodes together with the needed usage.
+func (db *merkleDB) makeRoom(need Usage) error {
+	q := &db.opts.Quota
+	if !q.enabled() || !q.PruneOnExceed {
+		return nil
+	}
+	fits := func() (bool, error) {
+		u, err := db.Usage()
+		if err != nil {
+			return false, err
+		}
+		u.Nodes += need.Nodes
+		u.Bytes += need.Bytes
+		return !q.exceeded(u), nil
+	}
+	if ok, err := fits(); err != nil || ok {
+		return err
+	}
+	if _, err := db.Expire(); err != nil {
+		return err
+	}
+	for {
+		if ok, err := fits(); err != nil || ok {
+			return err
+		}
+		pruned := false
+		// the anchors are listed by the prune, the anchors of concurrent puts are not pruned by accident
+		if err := db.prune(func() ([]Root, map[Root][]uint64, error) {
+			anchors, err := db.Anchors()
+			if err != nil {
+				return nil, nil, err
+			}
+			retained, err := db.retained()
+			if err != nil {
+				return nil, nil, err
+			}
+			sort.SliceStable(anchors, func(i, j int) bool {
+				return anchors[i].Slot < anchors[j].Slot
+			})
+			live := make([]Root, 0, len(anchors))
+			for i := range anchors {
+				if _, ok := retained[anchors[i].Root]; !ok && !pruned {
+					pruned = true
+					continue
+				}
+				live = append(live, anchors[i].Root)
+			}
+			if !pruned {
+				return nil, nil, errNoPrune
+			}
+			return live, nil, nil
+		}); err != nil {
+			return err
+		}
+		if !pruned {
+			// only named and pinned anchors are left, the put is rejected by the quota check
+			return nil
+		}
+		if db.opts.DeferredDeletes {
+			if _, err := db.Reclaim(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reserveQuota is called before a put is written, with the put report. It fails if the put does not fit the quota
+// together with the puts that are being written, and else reserves the usage of the put until the release,
+// which accounts for it if the put was written. A put that overwrites records, like the anchor of a tree
+// that is put again, is counted as new until the next count.
+func (db *merkleDB) reserveQuota(report *InsertReport) (release func(written bool), err error) {
+	need := Usage{Nodes: report.NewNodes, Bytes: report.BytesWritten}
+	db.usageLock.Lock()
+	defer db.usageLock.Unlock()
+	if q := &db.opts.Quota; q.enabled() {
+		u, err := db.countUsage()
+		if err != nil {
+			return nil, err
+		}
+		u.Nodes += db.reserved.Nodes + need.Nodes
+		u.Bytes += db.reserved.Bytes + need.Bytes
+		if q.exceeded(u) {
+			return nil, ErrQuotaExceeded
+		}
+	}
+	db.reserved.Nodes += need.Nodes
+	db.reserved.Bytes += need.Bytes
+	return func(written bool) {
+		db.usageLock.Lock()
+		defer db.usageLock.Unlock()
+		db.reserved.Nodes -= need.Nodes
+		db.reserved.Bytes -= need.Bytes
+		if written && db.usage != nil {
+			db.usage.Nodes += need.Nodes
+			db.usage.Bytes += need.Bytes
+		}
+	}, nil
+}
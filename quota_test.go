@@ -0,0 +1,91 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Quota(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithQuota(Quota{MaxNodes: 100})).(*merkleDB)
+	// full trees of depth 5 have 63 nodes, only one fits
+	if _, err := mdb.Put(1, fullTree(5), hFn); err != nil {
+		t.Fatal(err)
+	}
+	u, err := mdb.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Nodes != 63 || countKeys(t, mdb) != 64 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+	if _, err := mdb.Put(2, fullTree(5), hFn); err != ErrQuotaExceeded {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if n := countKeys(t, mdb); n != 64 {
+		t.Fatalf("expected rejected put not to write, got %d keys", n)
+	}
+	// the usage is counted again after deletes
+	if err := mdb.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := mdb.Usage(); err != nil || u.Nodes != 0 || u.Bytes != 0 {
+		t.Fatalf("expected no usage after prune, got %+v, err: %v", u, err)
+	}
+}
+
+func TestMerkleDB_QuotaPrune(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithQuota(Quota{MaxNodes: 200, PruneOnExceed: true}))
+	var roots []Root
+	for slot := uint64(0); slot < 5; slot++ {
+		n := fullTree(5)
+		if _, err := mdb.Put(slot, n, hFn); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, n.MerkleRoot(hFn))
+		if slot == 0 {
+			if err := mdb.SetRef(FinalizedRef, roots[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the named anchor and the most recent ones are kept
+	expectAnchors(t, mdb, roots[0], roots[3], roots[4])
+	if u, err := mdb.Usage(); err != nil || u.Nodes > 200 {
+		t.Fatalf("expected usage within the quota, got %+v, err: %v", u, err)
+	}
+}
+
+func TestMerkleDB_QuotaConcurrent(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithQuota(Quota{MaxNodes: 2 * 63})).(*merkleDB)
+	trees := make([]Node, 16)
+	for i := range trees {
+		trees[i] = fullTree(5)
+		trees[i].MerkleRoot(hFn)
+	}
+	// the usage of the puts that are being written is reserved, concurrent puts do not overshoot the quota together
+	errs := make(chan error, len(trees))
+	for i := range trees {
+		go func(i int) {
+			_, err := mdb.Put(uint64(i), trees[i], hFn)
+			errs <- err
+		}(i)
+	}
+	written := 0
+	for range trees {
+		if err := <-errs; err == nil {
+			written += 1
+		} else if err != ErrQuotaExceeded {
+			t.Fatal(err)
+		}
+	}
+	if written != 2 {
+		t.Fatalf("expected 2 trees to fit, got %d", written)
+	}
+	mdb.resetUsage()
+	if u, err := mdb.Usage(); err != nil || u.Nodes != 2*63 {
+		t.Fatalf("expected the usage of 2 trees, got %+v, err: %v", u, err)
+	}
+}
@@ -0,0 +1,66 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+	"time"
+)
+
+// Range iterates all nodes at the position of the gindex: keys share the gindex as prefix, before the node root.
+// The slot of a node is the slot it was first stored at, nodes that were reused by later trees keep their slot.
+func (db *merkleDB) Range(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
+	if db.opts.OnSlowQuery == nil {
+		return db.rangeAt(startSlot, endSlot, gindex)
+	}
+	start := time.Now()
+	out, err := db.rangeAt(startSlot, endSlot, gindex)
+	db.logSlow(start, SlowQuery{Op: "range", Gindex: gindex, StartSlot: startSlot, EndSlot: endSlot, Nodes: len(out), Err: err})
+	return out, err
+}
+
+func (db *merkleDB) rangeAt(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
+	var buf [maxKeyLen]byte
+	k, err := db.buildKey(&buf, gindex, Root{})
+	if err != nil {
+		return nil, err
+	}
+	position := k[:len(k)-32]
+	iter := db.r.NewIterator(util.BytesPrefix(position), nil)
+	defer iter.Release()
+	var out []SlottedNode
+	var rec PairRecord
+	for iter.Next() {
+		var key Root
+		copy(key[:], iter.Key()[len(position):])
+		if err := decodeValue(key, iter.Value(), &rec); err != nil {
+			return nil, err
+		}
+		if rec.Slot < startSlot || rec.Slot > endSlot {
+			continue
+		}
+		if gindex.IsRoot() {
+			if hidden, err := db.isHidden(key); err != nil {
+				return nil, err
+			} else if hidden {
+				continue
+			}
+		}
+		if rec.Pair {
+			out = append(out, SlottedNode{Slot: rec.Slot, Node: &virtualNode{db: db, gindex: gindex, maxDepth: db.opts.MaxDepth, self: key, slot: rec.Slot, left: rec.Left, right: rec.Right}})
+		} else {
+			out = append(out, SlottedNode{Slot: rec.Slot, Node: leafNode(gindex, key, &rec)})
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Slot < out[j].Slot
+	})
+	return out, nil
+}
+
+func (db *merkleDB) GetAllAtSlot(slot uint64, gindex Gindex) ([]SlottedNode, error) {
+	return db.Range(slot, slot, gindex)
+}
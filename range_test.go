@@ -0,0 +1,62 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Range(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	trees := []struct {
+		slot uint64
+		node Node
+	}{
+		{1, randomTree(5)},
+		{2, randomTree(5)},
+		{2, randomTree(5)},
+		{4, randomTree(5)},
+	}
+	for _, tr := range trees {
+		if _, err := mdb.Put(tr.slot, tr.node, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := mdb.Range(2, 4, RootGindex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(out))
+	}
+	for i := 1; i < len(out); i++ {
+		if out[i].Slot < out[i-1].Slot {
+			t.Fatal("expected nodes ordered by slot")
+		}
+	}
+	forks, err := mdb.GetAllAtSlot(2, RootGindex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 2 {
+		t.Fatalf("expected 2 competing trees, got %d", len(forks))
+	}
+	for _, f := range forks {
+		r := f.Node.MerkleRoot(hFn)
+		if r != trees[1].node.MerkleRoot(hFn) && r != trees[2].node.MerkleRoot(hFn) {
+			t.Fatalf("unexpected tree %s", r)
+		}
+	}
+	// children of the roots are stored at their own position
+	left, err := mdb.GetAllAtSlot(1, RootGindex.Left())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := trees[0].node.Left()
+	if len(left) != 1 || left[0].Node.MerkleRoot(hFn) != expected.MerkleRoot(hFn) {
+		t.Fatal("expected the left child of the slot 1 tree")
+	}
+	if none, err := mdb.GetAllAtSlot(3, RootGindex); err != nil || len(none) != 0 {
+		t.Fatalf("expected no nodes at slot 3, got %d, err: %v", len(none), err)
+	}
+}
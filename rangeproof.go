@@ -0,0 +1,93 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"sort"
+)
+
+// ProveRange proves the contiguous range of nodes [start, end), at the given depth below the base gindex,
+// e.g. the validators i to j at the contents gindex of the validators list, in the tree of the given anchor root.
+// The proof is a regular multiproof, with the nodes in order as leaves.
+// The helpers follow from the range boundaries: only the nodes that overlap the range are loaded.
+func (db *merkleDB) ProveRange(anchor Root, base Gindex, depth uint8, start uint64, end uint64) (*MultiProof, error) {
+	if start >= end {
+		return nil, errors.New("empty range")
+	}
+	b, err := gindexValue(base)
+	if err != nil {
+		return nil, err
+	}
+	baseDepth, _, _ := GindexDepthIndex(base)
+	if baseDepth+uint32(depth) >= 64 {
+		return nil, errGindexTooDeep
+	}
+	if end > 1<<depth {
+		return nil, errors.New("range exceeds the subtree")
+	}
+	type helper struct {
+		g    uint64
+		root Root
+	}
+	var helpers []helper
+	out := &MultiProof{}
+	var rec PairRecord
+	// the branch from the anchor down to the base
+	node := anchor
+	g := uint64(1)
+	for d := int(baseDepth) - 1; d >= 0; d-- {
+		if err := db.GetInto(Gindex64(g), node, &rec); err != nil {
+			return nil, err
+		}
+		if !rec.Pair {
+			return nil, leafError(&rec, Gindex64(g), node)
+		}
+		if (b>>uint(d))&1 == 1 {
+			helpers = append(helpers, helper{g << 1, rec.Left})
+			node, g = rec.Right, g<<1|1
+		} else {
+			helpers = append(helpers, helper{g<<1 | 1, rec.Right})
+			node, g = rec.Left, g<<1
+		}
+	}
+	// the subtree below the base: children that do not overlap the range are helpers
+	var visit func(g uint64, root Root, level uint8, offset uint64) error
+	visit = func(g uint64, root Root, level uint8, offset uint64) error {
+		if level == depth {
+			out.Gindices = append(out.Gindices, Gindex64(g))
+			out.Leaves = append(out.Leaves, root)
+			return nil
+		}
+		if err := db.GetInto(Gindex64(g), root, &rec); err != nil {
+			return err
+		}
+		if !rec.Pair {
+			return leafError(&rec, Gindex64(g), root)
+		}
+		left, right := rec.Left, rec.Right
+		half := uint64(1) << (depth - level - 1)
+		if offset < end && start < offset+half {
+			if err := visit(g<<1, left, level+1, offset); err != nil {
+				return err
+			}
+		} else {
+			helpers = append(helpers, helper{g << 1, left})
+		}
+		if offset+half < end && start < offset+2*half {
+			return visit(g<<1|1, right, level+1, offset+half)
+		}
+		helpers = append(helpers, helper{g<<1 | 1, right})
+		return nil
+	}
+	if err := visit(b, node, 0, 0); err != nil {
+		return nil, err
+	}
+	sort.Slice(helpers, func(i, j int) bool {
+		return helpers[i].g > helpers[j].g
+	})
+	out.Helpers = make([]Root, len(helpers))
+	for i := range helpers {
+		out.Helpers[i] = helpers[i].root
+	}
+	return out, nil
+}
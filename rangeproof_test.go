@@ -0,0 +1,53 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_ProveRange(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(7)
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := foo.MerkleRoot(hFn)
+	base := Gindex64(0b10)
+	for _, r := range [][2]uint64{{3, 11}, {0, 64}, {7, 8}, {32, 64}, {63, 64}} {
+		proof, err := mdb.ProveRange(anchor, base, 6, r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.Verify(anchor, hFn) {
+			t.Fatalf("range %v: expected proof to verify", r)
+		}
+		var targets []Gindex
+		for i := r[0]; i < r[1]; i++ {
+			targets = append(targets, Gindex64(0b10<<6|i))
+		}
+		expected, err := mdb.ProveMulti(anchor, targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof.Leaves) != len(expected.Leaves) || len(proof.Helpers) != len(expected.Helpers) {
+			t.Fatalf("range %v: proof differs from generic multiproof", r)
+		}
+		for i := range expected.Leaves {
+			if proof.Leaves[i] != expected.Leaves[i] {
+				t.Fatalf("range %v: leaf %d differs", r, i)
+			}
+		}
+		for i := range expected.Helpers {
+			if proof.Helpers[i] != expected.Helpers[i] {
+				t.Fatalf("range %v: helper %d differs", r, i)
+			}
+		}
+	}
+	if _, err := mdb.ProveRange(anchor, base, 6, 5, 5); err == nil {
+		t.Fatal("expected empty range error")
+	}
+	if _, err := mdb.ProveRange(anchor, base, 6, 0, 65); err == nil {
+		t.Fatal("expected range beyond the subtree to fail")
+	}
+}
@@ -0,0 +1,196 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strconv"
+)
+
+const metaRepair byte = 'x'
+
+// DefaultRecoveryScanLimit is the number of candidate children per side that a recovery scans, if not configured
+const DefaultRecoveryScanLimit = 1024
+
+// ErrCorruptValue is matched by every CorruptValueError, see errors.Is
+var ErrCorruptValue = errors.New("corrupt value")
+
+// CorruptValueError is returned when a stored record cannot be decoded, with what its key decodes to:
+// the gindex and root of a node, or the kind and id of a metadata record. Decoders never panic on corrupt records.
+type CorruptValueError struct {
+	Gindex Gindex
+	Root   Root
+	// Kind is the kind of a metadata record, 0 for a node, and ID the id of the record
+	Kind  byte
+	ID    []byte
+	Value []byte
+	Err   error
+}
+
+func (e *CorruptValueError) Error() string {
+	if e.Kind != 0 {
+		return fmt.Sprintf("metadata record %s '%x' has %v", strconv.QuoteRune(rune(e.Kind)), e.ID, e.Err)
+	}
+	if e.Gindex == nil {
+		return fmt.Sprintf("key '%x' has %v", e.Root, e.Err)
+	}
+	return fmt.Sprintf("node '%x' at gindex %v has %v", e.Root, e.Gindex, e.Err)
+}
+
+func (e *CorruptValueError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptValueError) Is(target error) bool {
+	return target == ErrCorruptValue
+}
+
+// corruptMeta wraps the decoding error of the stored value of the metadata record of the kind and id
+func corruptMeta(kind byte, id []byte, value []byte, err error) error {
+	return &CorruptValueError{Kind: kind, ID: append([]byte(nil), id...), Value: append([]byte(nil), value...), Err: err}
+}
+
+// repairKey derives the repair mark key from a node key
+func (db *merkleDB) repairKey(nodeKey []byte) []byte {
+	return db.metaKey(metaRepair, nodeKey[prefixLen:])
+}
+
+// recover reconstructs a node with a corrupt value, writes it back, and marks it for repair if that fails.
+// The prune lock must not be held, the writes take it like puts.
+func (db *merkleDB) recover(corrupt *CorruptValueError, dst *PairRecord) error {
+	var buf [maxKeyLen]byte
+	k, err := db.buildKey(&buf, corrupt.Gindex, corrupt.Root)
+	if err != nil {
+		return err
+	}
+	rec, ok, err := db.reconstruct(corrupt)
+	if err != nil {
+		return err
+	}
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	// a prune may have deleted the node since it was read, it must not be written back then
+	if ok, err := db.db.Has(k, nil); err != nil {
+		return err
+	} else if !ok {
+		return leveldb.ErrNotFound
+	}
+	b := new(leveldb.Batch)
+	if !ok {
+		b.Put(db.repairKey(k), nil)
+		if err := db.write(b); err != nil {
+			return err
+		}
+		return corrupt
+	}
+	b.Put(k, encodeValue(&rec))
+	b.Delete(db.repairKey(k))
+	if err := db.write(b); err != nil {
+		return err
+	}
+	db.resetUsage()
+	*dst = rec
+	return nil
+}
+
+func (db *merkleDB) reconstruct(corrupt *CorruptValueError) (PairRecord, bool, error) {
+	if db.opts.Resolver != nil {
+		if rec, err := db.opts.Resolver.Resolve(corrupt.Gindex, corrupt.Root); err == nil {
+			// a leaf is its own root, a pair must hash to it
+			if !rec.Pair || ConcurrentHashFn(rec.Left, rec.Right) == corrupt.Root {
+				return rec, true, nil
+			}
+		} else if !errors.Is(err, leveldb.ErrNotFound) {
+			return PairRecord{}, false, err
+		}
+	}
+	// the children of a pair are stored at the child positions, one pair of them hashes to the root
+	limit := db.opts.RecoveryScanLimit
+	if limit <= 0 {
+		limit = DefaultRecoveryScanLimit
+	}
+	lefts, err := db.positionNodes(corrupt.Gindex.Left(), limit)
+	if err != nil {
+		return PairRecord{}, false, err
+	}
+	rights, err := db.positionNodes(corrupt.Gindex.Right(), limit)
+	if err != nil {
+		return PairRecord{}, false, err
+	}
+	for _, l := range lefts {
+		for _, r := range rights {
+			if ConcurrentHashFn(l.root, r.root) != corrupt.Root {
+				continue
+			}
+			rec := PairRecord{Pair: true, Left: l.root, Right: r.root}
+			if len(corrupt.Value) >= 1+8 {
+				rec.Slot = binary.LittleEndian.Uint64(corrupt.Value[1 : 1+8])
+			} else {
+				// a pair is never older than its children
+				rec.Slot = l.slot
+				if r.slot > rec.Slot {
+					rec.Slot = r.slot
+				}
+			}
+			return rec, true, nil
+		}
+	}
+	// a leaf value holds only its type and slot, the root is in the key: a value with the type of a leaf or a summary
+	// is restored from the key, with the slot if it is still there. Other values may be of pairs, and stay marked.
+	if len(corrupt.Value) >= 1 && (corrupt.Value[0] == 0 || corrupt.Value[0] == 2) {
+		rec := PairRecord{Summary: corrupt.Value[0] == 2}
+		if len(corrupt.Value) >= 1+8 {
+			rec.Slot = binary.LittleEndian.Uint64(corrupt.Value[1 : 1+8])
+		}
+		return rec, true, nil
+	}
+	return PairRecord{}, false, nil
+}
+
+type positionNode struct {
+	root Root
+	slot uint64
+}
+
+// positionNodes lists up to limit stored nodes at the position of the gindex
+func (db *merkleDB) positionNodes(gindex Gindex, limit int) ([]positionNode, error) {
+	var buf [maxKeyLen]byte
+	k, err := db.buildKey(&buf, gindex, Root{})
+	if err != nil {
+		return nil, err
+	}
+	position := k[:len(k)-32]
+	iter := db.r.NewIterator(util.BytesPrefix(position), nil)
+	defer iter.Release()
+	var out []positionNode
+	var rec PairRecord
+	for iter.Next() && len(out) < limit {
+		n := positionNode{root: toRoot(iter.Key()[len(position):])}
+		// candidates with corrupt values themselves are still considered, without slot
+		if err := parseValue(iter.Value(), &rec); err == nil {
+			n.slot = rec.Slot
+		}
+		out = append(out, n)
+	}
+	return out, iter.Error()
+}
+
+func (db *merkleDB) Repairs() ([]NodeRef, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaRepair, nil)), nil)
+	defer iter.Release()
+	var out []NodeRef
+	for iter.Next() {
+		k, err := ParseNodeKey(append(append([]byte(nil), db.prefix[:]...), iter.Key()[metaKeyLen:]...))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt repair key: %v", err)
+		}
+		out = append(out, NodeRef{Gindex: k.Gindex, Root: k.Root})
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Recovery(t *testing.T) {
+	hFn := GetHashFn()
+	ldb := newMemoryDB()
+	plain := New(testPrefix, ldb).(*merkleDB)
+	recovering := New(testPrefix, ldb, WithRecovery(0)).(*merkleDB)
+	foo := fullTree(3)
+	anchor := foo.MerkleRoot(hFn)
+	if _, err := plain.Put(4, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	var buf [maxKeyLen]byte
+	k, err := plain.buildKey(&buf, RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k = append([]byte(nil), k...)
+	if err := ldb.Put(k, []byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var corrupt *CorruptValueError
+	if _, err := plain.Get(RootGindex, anchor); !errors.As(err, &corrupt) || corrupt.Root != anchor {
+		t.Fatalf("expected a corrupt value error, got: %v", err)
+	}
+	// the children are found at their positions, the slot is taken from them
+	out, err := recovering.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+	if out.Slot != 4 {
+		t.Fatalf("expected the slot of the children, got %d", out.Slot)
+	}
+	// the recovered node is written back
+	if _, err := plain.Get(RootGindex, anchor); err != nil {
+		t.Fatal(err)
+	}
+
+	// without one of the children the node is marked for repair
+	right := foo.(*PairNode).RightChild.MerkleRoot(hFn)
+	rk, err := plain.buildKey(&buf, RightGindex, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Delete(rk, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Put(k, []byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovering.Get(RootGindex, anchor); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a corrupt value error, got: %v", err)
+	}
+	repairs, err := recovering.Repairs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 1 || repairs[0].Root != anchor || repairs[0].Gindex != RootGindex {
+		t.Fatalf("expected the root to be marked for repair, got: %v", repairs)
+	}
+
+	// a resolver recovers it, and clears the mark
+	remote := New(testPrefix, newMemoryDB())
+	if _, err := remote.Put(4, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	resolving := New(testPrefix, ldb, WithRecovery(0), WithNodeResolver(TreeResolver(remote)))
+	if _, err := resolving.Get(RootGindex, anchor); err != nil {
+		t.Fatal(err)
+	}
+	if repairs, err := resolving.Repairs(); err != nil || len(repairs) != 0 {
+		t.Fatalf("expected no repairs left, got %v, err: %v", repairs, err)
+	}
+
+	// a leaf is restored from its key, with the slot that is left of its value
+	leaf := foo.(*PairNode).LeftChild.(*PairNode).LeftChild.(*PairNode).LeftChild.MerkleRoot(hFn)
+	g := LeftGindex.Left().Left()
+	lk, err := plain.buildKey(&buf, g, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lk = append([]byte(nil), lk...)
+	if err := ldb.Put(lk, []byte{0, 4, 0, 0, 0, 0, 0, 0, 0, 0xff}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Get(g, leaf); !errors.As(err, &corrupt) {
+		t.Fatalf("expected a corrupt leaf, got: %v", err)
+	}
+	if out, err := recovering.Get(g, leaf); err != nil || out.Slot != 4 || !out.Node.IsLeaf() || out.Node.MerkleRoot(hFn) != leaf {
+		t.Fatalf("expected the leaf to be recovered, got %+v, err: %v", out, err)
+	}
+	if _, err := plain.Get(g, leaf); err != nil {
+		t.Fatalf("expected the recovered leaf to be written back, err: %v", err)
+	}
+	// a value of another type is not known to be a leaf
+	if err := ldb.Put(lk, []byte{7}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovering.Get(g, leaf); !errors.As(err, &corrupt) {
+		t.Fatalf("expected the leaf of an unknown type to stay corrupt, got: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// FinalizedRef is the conventional reference name of the finalized anchor
+const FinalizedRef = "finalized"
+
+// HeadRef is the conventional reference name of the head anchor
+const HeadRef = "head"
+
+func (db *merkleDB) SetRef(name string, root Root) error {
+	return db.writeKey(db.metaKey(metaRef, []byte(name)), root[:])
+}
+
+func (db *merkleDB) GetRef(name string) (Root, error) {
+	v, err := db.getMeta(metaRef, []byte(name))
+	if err != nil {
+		return Root{}, err
+	}
+	if len(v) != 32 {
+		return Root{}, corruptMeta(metaRef, []byte(name), v, fmt.Errorf("ref '%s' has corrupt value: '%x'", name, v))
+	}
+	var root Root
+	copy(root[:], v)
+	return root, nil
+}
+
+func (db *merkleDB) DeleteRef(name string) error {
+	return db.deleteKey(db.metaKey(metaRef, []byte(name)))
+}
+
+func (db *merkleDB) Refs() (map[string]Root, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaRef, nil)), nil)
+	defer iter.Release()
+	out := make(map[string]Root)
+	for iter.Next() {
+		name := string(iter.Key()[metaKeyLen:])
+		v := iter.Value()
+		if err := db.checkValue(metaRef, []byte(name), v); err != nil {
+			return nil, err
+		}
+		if len(v) != 32 {
+			return nil, corruptMeta(metaRef, []byte(name), v, fmt.Errorf("ref '%s' has corrupt value: '%x'", name, v))
+		}
+		var root Root
+		copy(root[:], v)
+		out[name] = root
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
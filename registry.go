@@ -0,0 +1,382 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	metaTenant     byte = 'T'
+	metaNextPrefix byte = 'n'
+)
+
+const tenantVersion = 0
+
+// ErrReadOnly is returned by the writes of a read-only tenant handle
+var ErrReadOnly = errors.New("read-only")
+
+// ErrTenantExists is returned when registering a tenant ID that is already registered
+var ErrTenantExists = errors.New("tenant already exists")
+
+// AccessMode is the access of a tenant to its trees
+type AccessMode byte
+
+const (
+	ReadOnly AccessMode = iota
+	ReadWrite
+)
+
+func (m AccessMode) String() string {
+	switch m {
+	case ReadOnly:
+		return "read-only"
+	case ReadWrite:
+		return "read-write"
+	default:
+		return fmt.Sprintf("AccessMode(%d)", byte(m))
+	}
+}
+
+// Tenant is the registry record of an application hosted in the leveldb
+type Tenant struct {
+	ID     string
+	Prefix [prefixLen]byte
+	// Mode is the most access that handles of the tenant get
+	Mode AccessMode
+}
+
+func (t *Tenant) encode() []byte {
+	out := make([]byte, 1+prefixLen+1)
+	out[0] = tenantVersion
+	copy(out[1:1+prefixLen], t.Prefix[:])
+	out[1+prefixLen] = byte(t.Mode)
+	return out
+}
+
+func (t *Tenant) decode(id string, v []byte) error {
+	if len(v) < 1+prefixLen+1 {
+		return fmt.Errorf("tenant '%s' has corrupt record, too short: '%x'", id, v)
+	}
+	if v[0] != tenantVersion {
+		return fmt.Errorf("tenant '%s' has unknown record version: %d", id, v[0])
+	}
+	*t = Tenant{ID: id, Mode: AccessMode(v[1+prefixLen])}
+	copy(t.Prefix[:], v[1:1+prefixLen])
+	return nil
+}
+
+// Registry maps tenant IDs to prefixes, for services that host the trees of many independent applications
+// in one leveldb. The records are stored as metadata under the prefix of the registry, next to the trees,
+// and every change of the registry is written in a single batch.
+type Registry struct {
+	db     *leveldb.DB
+	prefix [prefixLen]byte
+	opts   []Option
+	lock   sync.Mutex
+}
+
+// NewRegistry manages the tenants of the leveldb, with its records under the given prefix.
+// The prefix is never assigned to a tenant. The options configure every tenant handle.
+func NewRegistry(db *leveldb.DB, prefix [prefixLen]byte, opts ...Option) *Registry {
+	return &Registry{db: db, prefix: prefix, opts: opts}
+}
+
+func (r *Registry) tenantKey(id string) []byte {
+	return metaKeyOf(r.prefix, metaTenant, []byte(id))
+}
+
+// Register assigns the next unused prefix to the tenant
+func (r *Registry) Register(id string, mode AccessMode) (Tenant, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err := r.Lookup(id); err == nil {
+		return Tenant{}, ErrTenantExists
+	} else if err != leveldb.ErrNotFound {
+		return Tenant{}, err
+	}
+	next := uint32(1)
+	nextKey := metaKeyOf(r.prefix, metaNextPrefix, nil)
+	if v, err := r.db.Get(nextKey, nil); err == nil {
+		if len(v) != prefixLen {
+			return Tenant{}, fmt.Errorf("corrupt next prefix record: '%x'", v)
+		}
+		next = uint32(v[0])<<16 | uint32(v[1])<<8 | uint32(v[2])
+	} else if err != leveldb.ErrNotFound {
+		return Tenant{}, err
+	}
+	t := Tenant{ID: id, Mode: mode}
+	for ; ; next++ {
+		if next >= 1<<(8*prefixLen) {
+			return Tenant{}, errors.New("no unused prefix left")
+		}
+		t.Prefix = [prefixLen]byte{byte(next >> 16), byte(next >> 8), byte(next)}
+		if t.Prefix == r.prefix {
+			continue
+		}
+		// skip prefixes that hold keys of trees that were not registered
+		iter := r.db.NewIterator(util.BytesPrefix(t.Prefix[:]), nil)
+		used := iter.Next()
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return Tenant{}, err
+		}
+		if !used {
+			break
+		}
+	}
+	next++
+	b := new(leveldb.Batch)
+	b.Put(r.tenantKey(id), t.encode())
+	b.Put(nextKey, []byte{byte(next >> 16), byte(next >> 8), byte(next)})
+	if err := r.db.Write(b, nil); err != nil {
+		return Tenant{}, err
+	}
+	return t, nil
+}
+
+// Lookup gets the record of the tenant
+func (r *Registry) Lookup(id string) (Tenant, error) {
+	v, err := r.db.Get(r.tenantKey(id), nil)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if err := checkValueSize(metaTenant, []byte(id), v, 0); err != nil {
+		return Tenant{}, err
+	}
+	var t Tenant
+	if err := t.decode(id, v); err != nil {
+		return Tenant{}, corruptMeta(metaTenant, []byte(id), v, err)
+	}
+	return t, nil
+}
+
+// Tenants lists all tenants, ordered by ID
+func (r *Registry) Tenants() ([]Tenant, error) {
+	iter := r.db.NewIterator(util.BytesPrefix(metaKeyOf(r.prefix, metaTenant, nil)), nil)
+	defer iter.Release()
+	var out []Tenant
+	for iter.Next() {
+		if err := checkValueSize(metaTenant, iter.Key()[metaKeyLen:], iter.Value(), 0); err != nil {
+			return nil, err
+		}
+		var t Tenant
+		if err := t.decode(string(iter.Key()[metaKeyLen:]), iter.Value()); err != nil {
+			return nil, corruptMeta(metaTenant, iter.Key()[metaKeyLen:], iter.Value(), err)
+		}
+		out = append(out, t)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// SetMode changes the access of the tenant. Handles that are already open keep their access.
+func (r *Registry) SetMode(id string, mode AccessMode) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	t, err := r.Lookup(id)
+	if err != nil {
+		return err
+	}
+	t.Mode = mode
+	return r.db.Put(r.tenantKey(id), t.encode(), nil)
+}
+
+// Remove unregisters the tenant, and deletes all of its trees.
+// The record is removed first, in one write, the trees are deleted in batches after.
+// The prefix of the tenant is not assigned again.
+func (r *Registry) Remove(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	t, err := r.Lookup(id)
+	if err != nil {
+		return err
+	}
+	if err := r.db.Delete(r.tenantKey(id), nil); err != nil {
+		return err
+	}
+	mdb := New(t.Prefix, r.db, r.opts...).(*merkleDB)
+	defer mdb.stop()
+	iter := r.db.NewIterator(util.BytesPrefix(t.Prefix[:]), nil)
+	defer iter.Release()
+	w := mdb.newDeleteWriter()
+	for iter.Next() {
+		if err := w.delete(iter.Key()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// Open returns a handle on the trees of the tenant, with the requested access.
+// Read-write access is only granted to read-write tenants; ErrReadOnly is returned otherwise.
+// Closing the handle does not close the shared leveldb.
+func (r *Registry) Open(id string, mode AccessMode) (MerkleDB, error) {
+	t, err := r.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case ReadWrite:
+		if t.Mode != ReadWrite {
+			return nil, ErrReadOnly
+		}
+		return &tenantDB{New(t.Prefix, r.db, r.opts...).(*merkleDB)}, nil
+	case ReadOnly:
+		// a read-only handle does not sweep tombstones in the background, nor write back recovered nodes
+		opts := append(append([]Option(nil), r.opts...), func(o *Options) {
+			o.SweepInterval = 0
+			o.Recover = false
+		})
+		return &readOnlyDB{tenantDB{New(t.Prefix, r.db, opts...).(*merkleDB)}}, nil
+	default:
+		return nil, fmt.Errorf("unknown access mode: %s", mode)
+	}
+}
+
+// tenantDB is a MerkleDB on a shared leveldb
+type tenantDB struct {
+	*merkleDB
+}
+
+func (t *tenantDB) Close() error {
+	t.stop()
+	return nil
+}
+
+// readOnlyDB rejects every write of the TreeWriter
+type readOnlyDB struct {
+	tenantDB
+}
+
+func (r *readOnlyDB) Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) PutStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) PutTop(slot uint64, node Node, fn HashFn, maxDepth uint32, opts ...PutOption) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) PutSubtrees(slot uint64, node Node, fn HashFn, gindices []Gindex, opts ...PutOption) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) Transplant(srcGindex Gindex, srcRoot Root, dstGindex Gindex) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) Delete(gindex Gindex, key Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Prune(liveRoots []Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) ResumePrune() (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (r *readOnlyDB) Reorg(oldHead Root, newHead Root) (*ReorgReport, error) {
+	return nil, ErrReadOnly
+}
+
+func (r *readOnlyDB) SetRef(name string, root Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) DeleteRef(name string) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Sync() error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Restore(rd io.Reader) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) Merge(src TreeReader, opts MergeOptions) (*MergeReport, error) {
+	return nil, ErrReadOnly
+}
+
+func (r *readOnlyDB) Pin(root Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Unpin(root Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Tag(root Root, tags ...string) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Untag(root Root, tags ...string) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) PruneTagged(tag string) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) Hide(root Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Unhide(root Root) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) SetCanonical(root Root, canonical bool) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) Expire() (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) Reclaim() (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) RebuildIndex(name string) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) PutBlob(root Root, data []byte) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyDB) PutCompact(slot uint64, node Node, fn HashFn) (InsertReport, error) {
+	return InsertReport{}, ErrReadOnly
+}
+
+func (r *readOnlyDB) TrimHistory(before uint64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyDB) ForgetIdempotencyKeys(before time.Time) (int, error) {
+	return 0, ErrReadOnly
+}
+
+var _ MerkleDB = (*readOnlyDB)(nil)
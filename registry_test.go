@@ -0,0 +1,92 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	ldb := newMemoryDB()
+	reg := NewRegistry(ldb, [prefixLen]byte{0, 0, 1})
+	// keys of an unregistered merkledb are left alone
+	other := New([prefixLen]byte{0, 0, 2}, ldb)
+	if _, err := other.Put(1, randomTree(4), nil); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := reg.Register("alice", ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := reg.Register("bob", ReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Prefix != [prefixLen]byte{0, 0, 3} || bob.Prefix != [prefixLen]byte{0, 0, 4} {
+		t.Fatalf("unexpected prefixes: %x, %x", alice.Prefix, bob.Prefix)
+	}
+	if _, err := reg.Register("alice", ReadOnly); err != ErrTenantExists {
+		t.Fatalf("expected existing tenant error, got %v", err)
+	}
+	if tenants, err := reg.Tenants(); err != nil {
+		t.Fatal(err)
+	} else if len(tenants) != 2 || tenants[0] != alice || tenants[1] != bob {
+		t.Fatalf("unexpected tenants: %v", tenants)
+	}
+
+	rw, err := reg.Open("alice", ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := randomTree(5)
+	root := node.MerkleRoot(GetHashFn())
+	if _, err := rw.Put(1, node, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := reg.Open("alice", ReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ro.Get(RootGindex, root); err != nil {
+		t.Fatal(err)
+	} else {
+		compareNodes(node, got.Node, RootGindex, GetHashFn(), t)
+	}
+	if _, err := ro.Put(2, randomTree(3), nil); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if err := ro.Prune(nil); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if _, err := reg.Open("bob", ReadWrite); err != ErrReadOnly {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	if err := reg.SetMode("bob", ReadWrite); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Open("bob", ReadWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reg.Remove("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Lookup("alice"); err != leveldb.ErrNotFound {
+		t.Fatalf("expected removed tenant, got %v", err)
+	}
+	if ok, err := ro.Has(RootGindex, root); err != nil || ok {
+		t.Fatalf("expected trees of the tenant to be deleted, ok: %v, err: %v", ok, err)
+	}
+	// prefixes are not assigned again
+	if carol, err := reg.Register("carol", ReadWrite); err != nil {
+		t.Fatal(err)
+	} else if carol.Prefix != [prefixLen]byte{0, 0, 5} {
+		t.Fatalf("unexpected prefix: %x", carol.Prefix)
+	}
+	if anchors, err := other.Anchors(); err != nil || len(anchors) != 1 {
+		t.Fatalf("expected unregistered trees to be kept, got %d, err: %v", len(anchors), err)
+	}
+}
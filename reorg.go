@@ -0,0 +1,133 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ReorgReport summarizes a Reorg
+type ReorgReport struct {
+	// CommonAncestor is the last anchor shared by the old and the new branch
+	CommonAncestor Root
+	// Abandoned are the anchors of the old branch after the common ancestor, nearest to the old head first.
+	// Pinned anchors are listed, but kept.
+	Abandoned []Root
+	// Reclaimed is the number of deleted nodes. Zero with deferred deletes: the nodes are tombstoned instead.
+	Reclaimed int
+}
+
+const maxAncestry = int(^uint(0) >> 1)
+
+func (db *merkleDB) Reorg(oldHead Root, newHead Root) (*ReorgReport, error) {
+	defer db.resetUsage()
+	defer db.resetProofs()
+	newBranch, err := db.Ancestry(newHead, maxAncestry)
+	if err != nil {
+		return nil, err
+	}
+	onNewBranch := map[Root]struct{}{newHead: {}}
+	for i := range newBranch {
+		onNewBranch[newBranch[i].Root] = struct{}{}
+	}
+	oldBranch, err := db.Ancestry(oldHead, maxAncestry)
+	if err != nil {
+		return nil, err
+	}
+	report := &ReorgReport{}
+	found := false
+	for _, root := range append([]Root{oldHead}, anchorRoots(oldBranch)...) {
+		if _, ok := onNewBranch[root]; ok {
+			report.CommonAncestor = root
+			found = true
+			break
+		}
+		report.Abandoned = append(report.Abandoned, root)
+	}
+	if !found {
+		return nil, errors.New("the branches do not share an ancestor")
+	}
+	if len(report.Abandoned) == 0 {
+		return report, nil
+	}
+	if head, err := db.GetRef(HeadRef); err == nil && head == oldHead {
+		if err := db.SetRef(HeadRef, newHead); err != nil {
+			return nil, err
+		}
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	abandoned := make(map[Root]struct{}, len(report.Abandoned))
+	for _, root := range report.Abandoned {
+		abandoned[root] = struct{}{}
+	}
+	// no tree may be put between listing the live anchors and the sweep, or the tombstones:
+	// the anchor of the put would not be live
+	db.pruneLock.Lock()
+	defer db.pruneLock.Unlock()
+	anchors, err := db.Anchors()
+	if err != nil {
+		return nil, err
+	}
+	// pinned anchors of the old branch are kept
+	pins, err := db.Pins()
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range pins {
+		delete(abandoned, root)
+	}
+	var liveRoots []Root
+	for i := range anchors {
+		if _, ok := abandoned[anchors[i].Root]; !ok {
+			liveRoots = append(liveRoots, anchors[i].Root)
+		}
+	}
+	if db.opts.DeferredDeletes {
+		return report, db.tombstoneAnchors(liveRoots)
+	}
+	hidden, err := db.hiddenRoots()
+	if err != nil {
+		return nil, err
+	}
+	liveRoots = append(liveRoots, hidden...)
+	marked, err := db.mark(liveRoots)
+	if err != nil {
+		return nil, err
+	}
+	defer marked.close()
+	starts := make([]NodeRef, 0, len(abandoned))
+	for _, root := range report.Abandoned {
+		if _, ok := abandoned[root]; ok {
+			starts = append(starts, NodeRef{Gindex: RootGindex, Root: root})
+		}
+	}
+	keys, err := db.sweep(marked, starts)
+	if err != nil {
+		return nil, err
+	}
+	// the anchors go first, like with Prune an interrupted reorg only leaves unreachable nodes
+	b := new(leveldb.Batch)
+	for _, start := range starts {
+		b.Delete(db.metaKey(metaAnchor, start.Root[:]))
+	}
+	if err := db.write(b); err != nil {
+		return nil, err
+	}
+	w := db.newDeleteWriter()
+	for _, k := range keys {
+		if err := w.delete(k); err != nil {
+			return nil, err
+		}
+	}
+	report.Reclaimed = len(keys)
+	return report, w.flush()
+}
+
+func anchorRoots(anchors []Anchor) []Root {
+	out := make([]Root, len(anchors))
+	for i := range anchors {
+		out[i] = anchors[i].Root
+	}
+	return out
+}
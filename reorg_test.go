@@ -0,0 +1,66 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Reorg(t *testing.T) {
+	for _, deferred := range []bool{false, true} {
+		var opts []Option
+		if deferred {
+			opts = append(opts, WithDeferredDeletes(0))
+		}
+		mdb := New(testPrefix, newMemoryDB(), opts...)
+		hFn := GetHashFn()
+		put := func(slot uint64, n Node, parent Root) Root {
+			if _, err := mdb.Put(slot, n, hFn, WithParent(parent)); err != nil {
+				t.Fatal(err)
+			}
+			return n.MerkleRoot(hFn)
+		}
+		base := randomTree(6)
+		baseRoot := put(0, base, Root{})
+		// the old branch reuses the left subtree of the base, which must survive
+		baseLeft, _ := base.Left()
+		old1 := put(1, NewPairNode(baseLeft, randomTree(5)), baseRoot)
+		old2 := put(2, randomTree(6), old1)
+		new1 := put(1, randomTree(6), baseRoot)
+		if err := mdb.SetRef(HeadRef, old2); err != nil {
+			t.Fatal(err)
+		}
+		report, err := mdb.Reorg(old2, new1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.CommonAncestor != baseRoot || len(report.Abandoned) != 2 || report.Abandoned[0] != old2 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		if deferred {
+			if report.Reclaimed != 0 {
+				t.Fatal("expected no nodes reclaimed before Reclaim")
+			}
+			if _, err := mdb.Reclaim(); err != nil {
+				t.Fatal(err)
+			}
+		} else if report.Reclaimed == 0 {
+			t.Fatal("expected nodes to be reclaimed")
+		}
+		for _, root := range []Root{old1, old2} {
+			if ok, _ := mdb.Has(RootGindex, root); ok {
+				t.Fatal("expected abandoned tree to be gone")
+			}
+		}
+		out, err := mdb.Get(RootGindex, baseRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareNodes(base, out.Node, RootGindex, hFn, t)
+		if head, err := mdb.GetRef(HeadRef); err != nil || head != new1 {
+			t.Fatalf("expected head to move to the new branch, err: %v", err)
+		}
+		if _, err := mdb.Reorg(new1, randomTree(2).MerkleRoot(hFn)); err == nil {
+			t.Fatal("expected unknown head error")
+		}
+	}
+}
@@ -0,0 +1,701 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplayNode is a recorded node of a put tree. Left and Right are nil for leaf nodes.
+type ReplayNode struct {
+	Gindex uint64 `json:"gindex"`
+	Root   Root   `json:"root"`
+	Left   *Root  `json:"left,omitempty"`
+	Right  *Root  `json:"right,omitempty"`
+}
+
+// ReplayCall is a recorded call of the TreeWriter API, with its arguments and a fingerprint of its outcome
+type ReplayCall struct {
+	Seq uint64 `json:"seq"`
+	Op  string `json:"op"`
+	// Slot, Root and Nodes are the put tree: its anchor root, and its nodes, every parent before its children.
+	// Nodes that were recorded by an earlier put, since the last call that deletes nodes, are left out.
+	Slot  uint64       `json:"slot,omitempty"`
+	Nodes []ReplayNode `json:"nodes,omitempty"`
+	// Roots and Gindices are the root and gindex arguments of the call, in order
+	Roots    []Root   `json:"roots,omitempty"`
+	Gindices []uint64 `json:"gindices,omitempty"`
+	MaxDepth uint32   `json:"max_depth,omitempty"`
+	// Name is the name of a ref or an index, or the tag of PruneTagged
+	Name      string     `json:"name,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Canonical bool       `json:"canonical,omitempty"`
+	Data      []byte     `json:"data,omitempty"`
+	Before    *time.Time `json:"before,omitempty"`
+	// Parent, Provenance, IdempotencyKey and Fresh are the recordable put options
+	Parent         *Root  `json:"parent,omitempty"`
+	Provenance     *Root  `json:"provenance,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Fresh          bool   `json:"fresh,omitempty"`
+	// Err, Count, NewNodes, ReusedNodes and Replayed are the outcome of the call
+	Err         string `json:"err,omitempty"`
+	Count       int    `json:"count,omitempty"`
+	NewNodes    int    `json:"new_nodes,omitempty"`
+	ReusedNodes int    `json:"reused_nodes,omitempty"`
+	Replayed    bool   `json:"replayed,omitempty"`
+}
+
+func (c *ReplayCall) setErr(err error) {
+	if err != nil {
+		c.Err = err.Error()
+	}
+}
+
+func (c *ReplayCall) setReport(report InsertReport) {
+	c.NewNodes, c.ReusedNodes, c.Replayed = report.NewNodes, report.ReusedNodes, report.Replayed
+}
+
+// outcome summarizes the fingerprint of the outcome, to compare a replayed call with the recorded one
+func (c *ReplayCall) outcome() string {
+	return fmt.Sprintf("err=%q count=%d new=%d reused=%d replayed=%v", c.Err, c.Count, c.NewNodes, c.ReusedNodes, c.Replayed)
+}
+
+func (c *ReplayCall) setPutOptions(opts []PutOption) {
+	o := applyPutOptions(opts)
+	if o.Parent != (Root{}) {
+		c.Parent = &o.Parent
+	}
+	if o.Provenance != (Root{}) {
+		c.Provenance = &o.Provenance
+	}
+	c.IdempotencyKey, c.Fresh, c.Tags = o.IdempotencyKey, o.Fresh, o.Tags
+}
+
+func (c *ReplayCall) putOptions() []PutOption {
+	var opts []PutOption
+	if c.Parent != nil {
+		opts = append(opts, WithParent(*c.Parent))
+	}
+	if c.Provenance != nil {
+		opts = append(opts, WithProvenance(*c.Provenance))
+	}
+	if c.IdempotencyKey != "" {
+		opts = append(opts, WithIdempotencyKey(c.IdempotencyKey))
+	}
+	if c.Fresh {
+		opts = append(opts, WithFresh())
+	}
+	if len(c.Tags) > 0 {
+		opts = append(opts, WithTags(c.Tags...))
+	}
+	return opts
+}
+
+type replayKey struct {
+	gindex uint64
+	root   Root
+}
+
+// Recorder records the calls of the TreeWriter API of the DB to a log, one JSON ReplayCall per line,
+// to re-execute them on a fresh DB with Replay, e.g. to reproduce a corruption report.
+// Reads are passed through and not recorded, they do not change the DB.
+// Calls are recorded in the order they complete: the recorded calls are serialized, so that the log is deterministic.
+// Restore and Merge are recorded without their input, and cannot be replayed. The Commit and RootMemo put options are not recorded.
+// The roots of the recorded nodes are kept in memory until a call that may delete nodes.
+type Recorder struct {
+	MerkleDB
+	lock     sync.Mutex
+	enc      *json.Encoder
+	fn       HashFn
+	seq      uint64
+	recorded map[replayKey]struct{}
+	err      error
+}
+
+var _ MerkleDB = (*Recorder)(nil)
+
+// NewRecorder records the writes to the DB to w
+func NewRecorder(db MerkleDB, w io.Writer) *Recorder {
+	return &Recorder{MerkleDB: db, enc: json.NewEncoder(w), recorded: make(map[replayKey]struct{})}
+}
+
+// Err is the first error of recording, the calls after it are not recorded
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// record runs the call and records it with its outcome. A call that may delete nodes forgets the recorded nodes,
+// so that the puts after it record their nodes again.
+func (r *Recorder) record(c ReplayCall, deletes bool, run func(c *ReplayCall) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err := run(&c)
+	c.setErr(err)
+	if deletes {
+		r.recorded = make(map[replayKey]struct{})
+	}
+	if r.err == nil {
+		r.seq += 1
+		c.Seq = r.seq
+		r.err = r.enc.Encode(&c)
+	}
+	return err
+}
+
+// treeNodes lists the nodes of the tree that were not recorded yet, parents before children.
+// Their keys are returned separately, to remember them only once the put succeeded.
+func (r *Recorder) treeNodes(node Node, fn HashFn) ([]ReplayNode, []replayKey, error) {
+	fn = hashFnOrDefault(fn)
+	var out []ReplayNode
+	var keys []replayKey
+	type item struct {
+		gindex Gindex
+		node   Node
+	}
+	stack := []item{{RootGindex, node}}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		g, err := gindexValue(top.gindex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot record the tree: %w", err)
+		}
+		k := replayKey{gindex: g, root: top.node.MerkleRoot(fn)}
+		if _, ok := r.recorded[k]; ok {
+			continue
+		}
+		n := ReplayNode{Gindex: g, Root: k.root}
+		if !top.node.IsLeaf() {
+			left, err := top.node.Left()
+			if err != nil {
+				return nil, nil, err
+			}
+			right, err := top.node.Right()
+			if err != nil {
+				return nil, nil, err
+			}
+			l, rr := left.MerkleRoot(fn), right.MerkleRoot(fn)
+			n.Left, n.Right = &l, &rr
+			stack = append(stack, item{top.gindex.Right(), right}, item{top.gindex.Left(), left})
+		}
+		out = append(out, n)
+		keys = append(keys, k)
+	}
+	return out, keys, nil
+}
+
+func (r *Recorder) remember(keys []replayKey) {
+	for _, k := range keys {
+		r.recorded[k] = struct{}{}
+	}
+}
+
+// putTree records a put of an in-memory tree, with the put itself
+func (r *Recorder) putTree(op string, slot uint64, node Node, fn HashFn, opts []PutOption,
+	with func(c *ReplayCall), put func() (InsertReport, error)) (report InsertReport, err error) {
+	c := ReplayCall{Op: op, Slot: slot}
+	c.setPutOptions(opts)
+	if with != nil {
+		with(&c)
+	}
+	err = r.record(c, false, func(c *ReplayCall) error {
+		nodes, keys, nerr := r.treeNodes(node, fn)
+		if nerr != nil && r.err == nil {
+			r.err = nerr
+		}
+		c.Roots, c.Nodes = []Root{node.MerkleRoot(hashFnOrDefault(fn))}, nodes
+		report, err = put()
+		c.setReport(report)
+		// compact, top and subtree puts store none or only part of the nodes, later calls still record them
+		if err == nil && op == "put" {
+			r.remember(keys)
+		}
+		return err
+	})
+	return report, err
+}
+
+func (r *Recorder) Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	return r.putTree("put", slot, node, fn, opts, nil, func() (InsertReport, error) {
+		return r.MerkleDB.Put(slot, node, fn, opts...)
+	})
+}
+
+func (r *Recorder) PutTop(slot uint64, node Node, fn HashFn, maxDepth uint32, opts ...PutOption) (InsertReport, error) {
+	return r.putTree("put_top", slot, node, fn, opts, func(c *ReplayCall) {
+		c.MaxDepth = maxDepth
+	}, func() (InsertReport, error) {
+		return r.MerkleDB.PutTop(slot, node, fn, maxDepth, opts...)
+	})
+}
+
+func (r *Recorder) PutSubtrees(slot uint64, node Node, fn HashFn, gindices []Gindex, opts ...PutOption) (InsertReport, error) {
+	values, err := gindexValues(gindices)
+	if err != nil {
+		return InsertReport{}, err
+	}
+	return r.putTree("put_subtrees", slot, node, fn, opts, func(c *ReplayCall) {
+		c.Gindices = values
+	}, func() (InsertReport, error) {
+		return r.MerkleDB.PutSubtrees(slot, node, fn, gindices, opts...)
+	})
+}
+
+// PutCompact records the whole tree, so its leaves of interest can be replayed with the history of the replay DB
+func (r *Recorder) PutCompact(slot uint64, node Node, fn HashFn) (InsertReport, error) {
+	return r.putTree("put_compact", slot, node, fn, nil, nil, func() (InsertReport, error) {
+		return r.MerkleDB.PutCompact(slot, node, fn)
+	})
+}
+
+// recordingSource collects the streamed nodes, to record them after the put
+type recordingSource struct {
+	src   NodeSource
+	nodes []ReplayNode
+	err   error
+}
+
+func (s *recordingSource) Next() (StreamNode, error) {
+	n, err := s.src.Next()
+	if err != nil {
+		return n, err
+	}
+	g, gerr := gindexValue(n.Gindex)
+	if gerr != nil && s.err == nil {
+		s.err = fmt.Errorf("cannot record the stream: %w", gerr)
+	}
+	rec := ReplayNode{Gindex: g, Root: n.Root}
+	if n.Pair {
+		left, right := n.Left, n.Right
+		rec.Left, rec.Right = &left, &right
+	}
+	s.nodes = append(s.nodes, rec)
+	return n, nil
+}
+
+// PutStream records the streamed nodes. They are replayed as a stream as well, which may leave out stored nodes.
+func (r *Recorder) PutStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts ...PutOption) (report InsertReport, err error) {
+	c := ReplayCall{Op: "put_stream", Slot: slot, Roots: []Root{anchor}}
+	c.setPutOptions(opts)
+	err = r.record(c, false, func(c *ReplayCall) error {
+		src := &recordingSource{src: nodes}
+		report, err = r.MerkleDB.PutStream(slot, anchor, src, fn, opts...)
+		if src.err != nil && r.err == nil {
+			r.err = src.err
+		}
+		c.Nodes = src.nodes
+		c.setReport(report)
+		return err
+	})
+	return report, err
+}
+
+func (r *Recorder) Transplant(srcGindex Gindex, srcRoot Root, dstGindex Gindex) (report InsertReport, err error) {
+	values, err := gindexValues([]Gindex{srcGindex, dstGindex})
+	if err != nil {
+		return InsertReport{}, err
+	}
+	err = r.record(ReplayCall{Op: "transplant", Roots: []Root{srcRoot}, Gindices: values}, false, func(c *ReplayCall) error {
+		report, err = r.MerkleDB.Transplant(srcGindex, srcRoot, dstGindex)
+		c.setReport(report)
+		return err
+	})
+	return report, err
+}
+
+func (r *Recorder) Delete(gindex Gindex, key Root) error {
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return err
+	}
+	return r.record(ReplayCall{Op: "delete", Roots: []Root{key}, Gindices: []uint64{g}}, true, func(c *ReplayCall) error {
+		return r.MerkleDB.Delete(gindex, key)
+	})
+}
+
+func (r *Recorder) Prune(liveRoots []Root) error {
+	return r.record(ReplayCall{Op: "prune", Roots: liveRoots}, true, func(c *ReplayCall) error {
+		return r.MerkleDB.Prune(liveRoots)
+	})
+}
+
+func (r *Recorder) ResumePrune() (bool, error) {
+	var resumed bool
+	err := r.record(ReplayCall{Op: "resume_prune"}, true, func(c *ReplayCall) (err error) {
+		resumed, err = r.MerkleDB.ResumePrune()
+		if resumed {
+			c.Count = 1
+		}
+		return err
+	})
+	return resumed, err
+}
+
+func (r *Recorder) Reorg(oldHead Root, newHead Root) (report *ReorgReport, err error) {
+	err = r.record(ReplayCall{Op: "reorg", Roots: []Root{oldHead, newHead}}, true, func(c *ReplayCall) error {
+		report, err = r.MerkleDB.Reorg(oldHead, newHead)
+		if report != nil {
+			c.Count = len(report.Abandoned)
+		}
+		return err
+	})
+	return report, err
+}
+
+func (r *Recorder) SetRef(name string, root Root) error {
+	return r.record(ReplayCall{Op: "set_ref", Name: name, Roots: []Root{root}}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.SetRef(name, root)
+	})
+}
+
+func (r *Recorder) DeleteRef(name string) error {
+	return r.record(ReplayCall{Op: "delete_ref", Name: name}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.DeleteRef(name)
+	})
+}
+
+func (r *Recorder) Pin(root Root) error {
+	return r.record(ReplayCall{Op: "pin", Roots: []Root{root}}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Pin(root)
+	})
+}
+
+func (r *Recorder) Unpin(root Root) error {
+	return r.record(ReplayCall{Op: "unpin", Roots: []Root{root}}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Unpin(root)
+	})
+}
+
+func (r *Recorder) Hide(root Root) error {
+	return r.record(ReplayCall{Op: "hide", Roots: []Root{root}}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Hide(root)
+	})
+}
+
+func (r *Recorder) Unhide(root Root) error {
+	return r.record(ReplayCall{Op: "unhide", Roots: []Root{root}}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Unhide(root)
+	})
+}
+
+func (r *Recorder) Tag(root Root, tags ...string) error {
+	return r.record(ReplayCall{Op: "tag", Roots: []Root{root}, Tags: tags}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Tag(root, tags...)
+	})
+}
+
+func (r *Recorder) Untag(root Root, tags ...string) error {
+	return r.record(ReplayCall{Op: "untag", Roots: []Root{root}, Tags: tags}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Untag(root, tags...)
+	})
+}
+
+func (r *Recorder) PruneTagged(tag string) (n int, err error) {
+	err = r.record(ReplayCall{Op: "prune_tagged", Name: tag}, true, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.PruneTagged(tag)
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) Restore(rd io.Reader) (n int, err error) {
+	err = r.record(ReplayCall{Op: "restore"}, true, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.Restore(rd)
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) Merge(src TreeReader, opts MergeOptions) (report *MergeReport, err error) {
+	err = r.record(ReplayCall{Op: "merge"}, true, func(c *ReplayCall) error {
+		report, err = r.MerkleDB.Merge(src, opts)
+		return err
+	})
+	return report, err
+}
+
+func (r *Recorder) SetCanonical(root Root, canonical bool) error {
+	return r.record(ReplayCall{Op: "set_canonical", Roots: []Root{root}, Canonical: canonical}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.SetCanonical(root, canonical)
+	})
+}
+
+func (r *Recorder) Expire() (n int, err error) {
+	err = r.record(ReplayCall{Op: "expire"}, true, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.Expire()
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) Reclaim() (n int, err error) {
+	err = r.record(ReplayCall{Op: "reclaim"}, true, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.Reclaim()
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) TrimHistory(before uint64) (n int, err error) {
+	err = r.record(ReplayCall{Op: "trim_history", Slot: before}, false, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.TrimHistory(before)
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) RebuildIndex(name string) (n int, err error) {
+	err = r.record(ReplayCall{Op: "rebuild_index", Name: name}, false, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.RebuildIndex(name)
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) PutBlob(root Root, data []byte) error {
+	return r.record(ReplayCall{Op: "put_blob", Roots: []Root{root}, Data: data}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.PutBlob(root, data)
+	})
+}
+
+func (r *Recorder) ForgetIdempotencyKeys(before time.Time) (n int, err error) {
+	err = r.record(ReplayCall{Op: "forget_idempotency_keys", Before: &before}, false, func(c *ReplayCall) error {
+		n, err = r.MerkleDB.ForgetIdempotencyKeys(before)
+		c.Count = n
+		return err
+	})
+	return n, err
+}
+
+func (r *Recorder) Sync() error {
+	return r.record(ReplayCall{Op: "sync"}, false, func(c *ReplayCall) error {
+		return r.MerkleDB.Sync()
+	})
+}
+
+// ReplayDivergence is a replayed call of which the outcome differs from the recorded outcome
+type ReplayDivergence struct {
+	Seq      uint64 `json:"seq"`
+	Op       string `json:"op"`
+	Recorded string `json:"recorded"`
+	Replayed string `json:"replayed"`
+}
+
+// ReplayReport is the result of Replay
+type ReplayReport struct {
+	// Calls is the number of replayed calls
+	Calls int
+	// Divergences are the calls that did not replay the same, in order
+	Divergences []ReplayDivergence
+}
+
+// replayTree rebuilds the recorded tree of the call. Nodes that were left out are resolved from the DB.
+func replayTree(db TreeReader, c *ReplayCall) (Node, error) {
+	if len(c.Roots) != 1 {
+		return nil, errors.New("put without anchor root")
+	}
+	nodes := make(map[uint64]*ReplayNode, len(c.Nodes))
+	for i := range c.Nodes {
+		nodes[c.Nodes[i].Gindex] = &c.Nodes[i]
+	}
+	var build func(gindex Gindex, root Root) (Node, error)
+	build = func(gindex Gindex, root Root) (Node, error) {
+		if g, err := gindexValue(gindex); err == nil {
+			if n, ok := nodes[g]; ok && n.Root == root {
+				if n.Left == nil {
+					leaf := n.Root
+					return &leaf, nil
+				}
+				left, err := build(gindex.Left(), *n.Left)
+				if err != nil {
+					return nil, err
+				}
+				right, err := build(gindex.Right(), *n.Right)
+				if err != nil {
+					return nil, err
+				}
+				return NewPairNode(left, right), nil
+			}
+		}
+		stored, err := db.Get(gindex, root)
+		if err != nil {
+			return nil, fmt.Errorf("node %s left out of the log is not stored: %w", root, err)
+		}
+		return stored.Node, nil
+	}
+	return build(RootGindex, c.Roots[0])
+}
+
+// sliceReplaySource streams the recorded nodes of a PutStream call
+type sliceReplaySource []ReplayNode
+
+func (s *sliceReplaySource) Next() (StreamNode, error) {
+	if len(*s) == 0 {
+		return StreamNode{}, io.EOF
+	}
+	n := (*s)[0]
+	*s = (*s)[1:]
+	out := StreamNode{Gindex: Gindex64(n.Gindex), Root: n.Root}
+	if n.Left != nil && n.Right != nil {
+		out.Pair, out.Left, out.Right = true, *n.Left, *n.Right
+	}
+	return out, nil
+}
+
+func gindices64(values []uint64) []Gindex {
+	out := make([]Gindex, len(values))
+	for i, v := range values {
+		out[i] = Gindex64(v)
+	}
+	return out
+}
+
+func replayArgs(c *ReplayCall, roots int, gindices int) error {
+	if len(c.Roots) != roots || len(c.Gindices) != gindices {
+		return fmt.Errorf("%s with %d roots and %d gindices", c.Op, len(c.Roots), len(c.Gindices))
+	}
+	return nil
+}
+
+// replayCall runs the recorded call on the DB, and fills in the outcome of the result
+func replayCall(db MerkleDB, c *ReplayCall, fn HashFn) (out ReplayCall, err error) {
+	out = ReplayCall{Seq: c.Seq, Op: c.Op}
+	var report InsertReport
+	switch c.Op {
+	case "put", "put_top", "put_subtrees", "put_compact":
+		var tree Node
+		if tree, err = replayTree(db, c); err != nil {
+			return out, err
+		}
+		switch c.Op {
+		case "put":
+			report, err = db.Put(c.Slot, tree, fn, c.putOptions()...)
+		case "put_top":
+			report, err = db.PutTop(c.Slot, tree, fn, c.MaxDepth, c.putOptions()...)
+		case "put_compact":
+			report, err = db.PutCompact(c.Slot, tree, fn)
+		default:
+			report, err = db.PutSubtrees(c.Slot, tree, fn, gindices64(c.Gindices), c.putOptions()...)
+		}
+	case "put_stream":
+		if err = replayArgs(c, 1, 0); err != nil {
+			return out, err
+		}
+		src := sliceReplaySource(c.Nodes)
+		report, err = db.PutStream(c.Slot, c.Roots[0], &src, fn, c.putOptions()...)
+	case "transplant":
+		if err = replayArgs(c, 1, 2); err != nil {
+			return out, err
+		}
+		report, err = db.Transplant(Gindex64(c.Gindices[0]), c.Roots[0], Gindex64(c.Gindices[1]))
+	case "delete":
+		if err = replayArgs(c, 1, 1); err != nil {
+			return out, err
+		}
+		err = db.Delete(Gindex64(c.Gindices[0]), c.Roots[0])
+	case "prune":
+		err = db.Prune(c.Roots)
+	case "resume_prune":
+		var resumed bool
+		if resumed, err = db.ResumePrune(); resumed {
+			out.Count = 1
+		}
+	case "reorg":
+		if err = replayArgs(c, 2, 0); err != nil {
+			return out, err
+		}
+		var r *ReorgReport
+		if r, err = db.Reorg(c.Roots[0], c.Roots[1]); r != nil {
+			out.Count = len(r.Abandoned)
+		}
+	case "set_ref", "pin", "unpin", "hide", "unhide", "tag", "untag", "set_canonical", "put_blob":
+		if err = replayArgs(c, 1, 0); err != nil {
+			return out, err
+		}
+		root := c.Roots[0]
+		switch c.Op {
+		case "set_ref":
+			err = db.SetRef(c.Name, root)
+		case "pin":
+			err = db.Pin(root)
+		case "unpin":
+			err = db.Unpin(root)
+		case "hide":
+			err = db.Hide(root)
+		case "unhide":
+			err = db.Unhide(root)
+		case "tag":
+			err = db.Tag(root, c.Tags...)
+		case "untag":
+			err = db.Untag(root, c.Tags...)
+		case "set_canonical":
+			err = db.SetCanonical(root, c.Canonical)
+		default:
+			err = db.PutBlob(root, c.Data)
+		}
+	case "delete_ref":
+		err = db.DeleteRef(c.Name)
+	case "prune_tagged":
+		out.Count, err = db.PruneTagged(c.Name)
+	case "expire":
+		out.Count, err = db.Expire()
+	case "reclaim":
+		out.Count, err = db.Reclaim()
+	case "trim_history":
+		out.Count, err = db.TrimHistory(c.Slot)
+	case "rebuild_index":
+		out.Count, err = db.RebuildIndex(c.Name)
+	case "forget_idempotency_keys":
+		if c.Before == nil {
+			return out, errors.New("forget_idempotency_keys without time")
+		}
+		out.Count, err = db.ForgetIdempotencyKeys(*c.Before)
+	case "sync":
+		err = db.Sync()
+	default:
+		return out, fmt.Errorf("cannot replay %s", c.Op)
+	}
+	out.setReport(report)
+	out.setErr(err)
+	return out, nil
+}
+
+// Replay re-executes the calls that a Recorder recorded on the DB, fresh or in the state the recording started from,
+// opened with the same options. The trees are hashed with fn, which must be the hash function of the recording.
+// Calls of which the outcome differs from the recorded one are reported, and replaying continues:
+// a recorded call that failed is a divergence if it succeeds now. Only a log that cannot be read is an error.
+func Replay(db MerkleDB, r io.Reader, fn HashFn) (*ReplayReport, error) {
+	report := &ReplayReport{}
+	dec := json.NewDecoder(r)
+	for {
+		var c ReplayCall
+		if err := dec.Decode(&c); err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, fmt.Errorf("failed to read call %d of the log: %w", report.Calls+1, err)
+		}
+		report.Calls += 1
+		out, err := replayCall(db, &c, fn)
+		if err != nil {
+			report.Divergences = append(report.Divergences, ReplayDivergence{
+				Seq: c.Seq, Op: c.Op, Recorded: c.outcome(), Replayed: err.Error()})
+			continue
+		}
+		if got, expected := out.outcome(), c.outcome(); got != expected {
+			report.Divergences = append(report.Divergences, ReplayDivergence{
+				Seq: c.Seq, Op: c.Op, Recorded: expected, Replayed: got})
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestRecorder_Replay(t *testing.T) {
+	var log bytes.Buffer
+	rec := NewRecorder(New(testPrefix, newMemoryDB()), &log)
+	hFn := GetHashFn()
+
+	a := fullTree(4)
+	rootA := a.MerkleRoot(hFn)
+	if _, err := rec.Put(1, a, hFn, WithTags("a")); err != nil {
+		t.Fatal(err)
+	}
+	// b shares the left subtree of a, which is left out of the log
+	left, _ := a.Left()
+	b := NewPairNode(left, fullTree(3))
+	rootB := b.MerkleRoot(hFn)
+	if _, err := rec.Put(2, b, hFn, WithParent(rootA)); err != nil {
+		t.Fatal(err)
+	}
+	c := fullTree(3)
+	rootC := c.MerkleRoot(hFn)
+	if _, err := rec.PutStream(3, rootC, TreeSource(c, hFn), hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.SetRef("head", rootB); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Pin(rootA); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Hide(rootA); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Prune([]Root{rootB}); err != nil {
+		t.Fatal(err)
+	}
+	// recorded with its failure
+	if err := rec.Tag(rootC, "c"); err == nil {
+		t.Fatal("expected tagging a pruned anchor to fail")
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []ReplayCall
+	dec := json.NewDecoder(bytes.NewReader(log.Bytes()))
+	for dec.More() {
+		var c ReplayCall
+		if err := dec.Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, c)
+	}
+	if len(calls) != 8 || calls[0].Op != "put" || calls[2].Op != "put_stream" || calls[7].Seq != 8 {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	if len(calls[0].Nodes) != 31 || len(calls[1].Nodes) != 16 || calls[1].Roots[0] != rootB {
+		t.Fatalf("expected the shared subtree to be left out, got %d and %d nodes", len(calls[0].Nodes), len(calls[1].Nodes))
+	}
+	if calls[7].Err == "" {
+		t.Fatal("expected the failure to be recorded")
+	}
+
+	fresh := New(testPrefix, newMemoryDB())
+	report, err := Replay(fresh, bytes.NewReader(log.Bytes()), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Calls != 8 || len(report.Divergences) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	expectAnchors(t, fresh, rootB)
+	if head, err := fresh.GetRef("head"); err != nil || head != rootB {
+		t.Fatalf("expected the ref to be replayed: %s %v", head, err)
+	}
+	if hidden, err := fresh.Hidden(); err != nil || len(hidden) != 1 || hidden[0].Root != rootA {
+		t.Fatalf("expected the hidden anchor to be replayed: %v %v", hidden, err)
+	}
+	if completeness, err := fresh.Completeness(rootB); err != nil || !completeness.Complete() {
+		t.Fatalf("expected the replayed tree to be complete: %v", err)
+	}
+
+	// replaying again on the same DB reuses the stored nodes
+	report, err = Replay(fresh, bytes.NewReader(log.Bytes()), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Divergences) == 0 || report.Divergences[0].Seq != 1 {
+		t.Fatalf("expected divergences, got %+v", report.Divergences)
+	}
+	if _, err := Replay(fresh, bytes.NewReader([]byte("{")), hFn); err == nil {
+		t.Fatal("expected a broken log to fail")
+	}
+}
+
+func TestRecorder_ReplayPutTop(t *testing.T) {
+	var log bytes.Buffer
+	rec := NewRecorder(New(testPrefix, newMemoryDB()), &log)
+	hFn := GetHashFn()
+	a := fullTree(4)
+	if _, err := rec.PutTop(1, a, hFn, 2); err != nil {
+		t.Fatal(err)
+	}
+	// the full put of an overlapping tree records the nodes below the top of a too
+	left, _ := a.Left()
+	b := NewPairNode(left, fullTree(3))
+	if _, err := rec.Put(2, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	fresh := New(testPrefix, newMemoryDB())
+	report, err := Replay(fresh, bytes.NewReader(log.Bytes()), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Calls != 2 || len(report.Divergences) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	got, err := fresh.Get(RootGindex, b.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(b, got.Node, RootGindex, hFn, t)
+}
@@ -0,0 +1,77 @@
+package merkledb
+
+import (
+	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// DefaultReprefixBatchSize is the number of keys moved per write batch by Reprefix
+const DefaultReprefixBatchSize = 10_000
+
+// ReprefixOptions configures Reprefix
+type ReprefixOptions struct {
+	// BatchSize is the number of keys moved per write batch. DefaultReprefixBatchSize if 0.
+	BatchSize int
+	// Progress is called after every batch with the number of keys moved so far, if not nil.
+	Progress func(moved int)
+	// Resume allows keys under the destination prefix, as left behind by an interrupted move.
+	Resume bool
+}
+
+// Reprefix moves all keys from one prefix to another, in batches, and returns the number of moved keys.
+// The destination prefix must be empty, unless resuming.
+// The move is not atomic: every batch moves its keys atomically, an interrupted move can be resumed.
+func Reprefix(db *leveldb.DB, from [prefixLen]byte, to [prefixLen]byte, opts ReprefixOptions) (int, error) {
+	if from == to {
+		return 0, errors.New("source and destination prefix are the same")
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultReprefixBatchSize
+	}
+	if !opts.Resume {
+		dst := db.NewIterator(util.BytesPrefix(to[:]), nil)
+		nonEmpty := dst.Next()
+		dst.Release()
+		if err := dst.Error(); err != nil {
+			return 0, err
+		}
+		if nonEmpty {
+			return 0, errors.New("destination prefix is not empty")
+		}
+	}
+	// the iterator reads from an implicit snapshot, moved keys do not show up in it again
+	iter := db.NewIterator(util.BytesPrefix(from[:]), nil)
+	defer iter.Release()
+	moved := 0
+	b := new(leveldb.Batch)
+	flush := func() error {
+		if err := db.Write(b, nil); err != nil {
+			return err
+		}
+		b.Reset()
+		if opts.Progress != nil {
+			opts.Progress(moved)
+		}
+		return nil
+	}
+	for iter.Next() {
+		k := iter.Key()
+		dst := make([]byte, len(k))
+		copy(dst, to[:])
+		copy(dst[prefixLen:], k[prefixLen:])
+		b.Put(dst, iter.Value())
+		b.Delete(k)
+		moved += 1
+		if moved%batchSize == 0 {
+			if err := flush(); err != nil {
+				return moved, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return moved, err
+	}
+	return moved, flush()
+}
@@ -0,0 +1,50 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestReprefix(t *testing.T) {
+	db := newMemoryDB()
+	hFn := GetHashFn()
+	foo := randomTree(8)
+	src := New(testPrefix, db)
+	if _, err := src.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	keys := countKeys(t, src.(*merkleDB))
+	dstPrefix := [3]byte{1, 2, 3}
+	var batches []int
+	moved, err := Reprefix(db, testPrefix, dstPrefix, ReprefixOptions{BatchSize: 10, Progress: func(moved int) {
+		batches = append(batches, moved)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != keys || len(batches) != keys/10+1 {
+		t.Fatalf("moved %d keys in %d batches, expected %d keys", moved, len(batches), keys)
+	}
+	if n := countKeys(t, src.(*merkleDB)); n != 0 {
+		t.Fatalf("expected source prefix to be empty, got %d keys", n)
+	}
+	dst := New(dstPrefix, db)
+	out, err := dst.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+	if _, err := dst.GetAnchor(foo.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := src.Put(2, randomTree(3), hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reprefix(db, testPrefix, dstPrefix, ReprefixOptions{}); err == nil {
+		t.Fatal("expected non-empty destination to be refused")
+	}
+	if _, err := Reprefix(db, testPrefix, dstPrefix, ReprefixOptions{Resume: true}); err != nil {
+		t.Fatal(err)
+	}
+}
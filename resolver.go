@@ -0,0 +1,70 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// NodeResolver fetches the records of nodes from a remote source, e.g. a peer or an archive.
+type NodeResolver interface {
+	// Resolve the record of the node at (gindex, root). Returns leveldb.ErrNotFound if the source does not have it.
+	Resolve(gindex Gindex, root Root) (PairRecord, error)
+}
+
+// NodeResolverFunc adapts a function to a NodeResolver
+type NodeResolverFunc func(gindex Gindex, root Root) (PairRecord, error)
+
+func (f NodeResolverFunc) Resolve(gindex Gindex, root Root) (PairRecord, error) {
+	return f(gindex, root)
+}
+
+type treeResolver struct {
+	db TreeReader
+}
+
+// TreeResolver resolves nodes from another merkledb, e.g. a slower archive or a remote-backed database.
+func TreeResolver(db TreeReader) NodeResolver {
+	return treeResolver{db: db}
+}
+
+func (r treeResolver) Resolve(gindex Gindex, root Root) (out PairRecord, err error) {
+	err = r.db.GetInto(gindex, root, &out)
+	return
+}
+
+// WithNodeResolver turns the merkledb into a verifying read-through cache:
+// nodes that are not stored locally are fetched from the resolver, verified against their root, and stored.
+// Resolved nodes are not reachable from any local anchor: a Prune removes them again.
+// Existence checks (Has), Range, Completeness and pruning only consider the local nodes.
+func WithNodeResolver(r NodeResolver) Option {
+	return func(o *Options) {
+		o.Resolver = r
+	}
+}
+
+func (db *merkleDB) resolve(gindex Gindex, key Root, dst *PairRecord) error {
+	rec, err := db.opts.Resolver.Resolve(gindex, key)
+	if err != nil {
+		return err
+	}
+	// a leaf is its own root, a pair must hash to it
+	if rec.Pair && ConcurrentHashFn(rec.Left, rec.Right) != key {
+		return fmt.Errorf("resolved node %s does not match its children", key)
+	}
+	buf := keyPool.Get().(*[maxKeyLen]byte)
+	defer keyPool.Put(buf)
+	k, err := db.buildKey(buf, gindex, key)
+	if err != nil {
+		return err
+	}
+	// like a put, the write must not interleave with the deletes of a prune
+	db.pruneLock.RLock()
+	err = db.writeKey(k, encodeValue(&rec))
+	db.pruneLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	db.resetUsage()
+	*dst = rec
+	return nil
+}
@@ -0,0 +1,44 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestNodeResolver(t *testing.T) {
+	hFn := GetHashFn()
+	remote := New(testPrefix, newMemoryDB())
+	foo := randomTree(6)
+	anchor := foo.MerkleRoot(hFn)
+	if _, err := remote.Put(3, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	local := New(testPrefix, newMemoryDB(), WithNodeResolver(TreeResolver(remote))).(*merkleDB)
+	out, err := local.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+	// the resolved nodes are stored locally now
+	var rec PairRecord
+	if err := local.getLocal(RootGindex, anchor, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Slot != 3 {
+		t.Fatalf("expected the remote slot, got %d", rec.Slot)
+	}
+	if report, err := local.Completeness(anchor); err != nil || !report.Complete() {
+		t.Fatalf("expected the resolved tree to be complete locally, err: %v", err)
+	}
+
+	// a resolver that serves wrong children is rejected
+	bad := New(testPrefix, newMemoryDB(), WithNodeResolver(NodeResolverFunc(func(gindex Gindex, root Root) (PairRecord, error) {
+		return PairRecord{Pair: true, Left: Root{1}, Right: Root{2}}, nil
+	})))
+	if _, err := bad.Get(RootGindex, anchor); err == nil {
+		t.Fatal("expected unverified node to be rejected")
+	}
+	if ok, _ := bad.Has(RootGindex, anchor); ok {
+		t.Fatal("expected rejected node not to be stored")
+	}
+}
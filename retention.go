@@ -0,0 +1,156 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sort"
+)
+
+// metaTrimmed records the fields that an expired tree is trimmed to, by the gindices of the retention rules
+const metaTrimmed byte = 'f'
+
+const trimmedVersion = 0
+
+// RetentionRule keeps the subtree at the Gindex of every tree for Slots slots behind the highest anchor,
+// also after the rest of the tree expired, see WithRetention.
+type RetentionRule struct {
+	Gindex Gindex
+	Slots  uint64
+}
+
+// fields gets the gindices of the rules that still retain a tree of the slot, sorted
+func (db *merkleDB) fields(slot uint64, head uint64) ([]uint64, error) {
+	var out []uint64
+	for _, rule := range db.opts.Retention {
+		if head-slot > rule.Slots {
+			continue
+		}
+		g, err := gindexValue(rule.Gindex)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out, nil
+}
+
+func encodeTrimmed(fields []uint64) []byte {
+	out := make([]byte, 1+8*len(fields))
+	out[0] = trimmedVersion
+	for i, g := range fields {
+		binary.LittleEndian.PutUint64(out[1+8*i:], g)
+	}
+	return out
+}
+
+// trimmed gets the fields that the tree was trimmed to, nil if it was not trimmed
+func (db *merkleDB) trimmed(root Root) ([]uint64, error) {
+	v, err := db.getMeta(metaTrimmed, root[:])
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(v) < 1 || (len(v)-1)%8 != 0 {
+		return nil, corruptMeta(metaTrimmed, root[:], v, fmt.Errorf("trimmed tree %s has corrupt record: '%x'", root, v))
+	}
+	if v[0] != trimmedVersion {
+		return nil, corruptMeta(metaTrimmed, root[:], v, fmt.Errorf("trimmed tree %s has unknown record version: %d", root, v[0]))
+	}
+	out := make([]uint64, (len(v)-1)/8)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint64(v[1+8*i:])
+	}
+	return out, nil
+}
+
+func sameFields(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// markFields marks the anchors of the partly retained trees, their subtrees at the field gindices,
+// and the nodes on the paths to the fields with their siblings. The siblings are kept as summaries, without subtree.
+// The keys of paths and siblings are only marked after all fields: markTree expects a marked node
+// to have its subtree marked, and the same node may be a sibling in one tree and a field in another.
+// The records of the paths and siblings that are not marked otherwise are rewritten before anything is deleted:
+// the pairs on the paths become partial pairs, the sibling pairs summaries, so that later puts do not reuse them.
+func (db *merkleDB) markFields(marked *markSet, partial map[Root][]uint64) error {
+	var summaries [][]byte
+	// the rewritten records by key, a pair on the path of one tree stays a pair if it is a sibling in another
+	trims := make(map[string]PairRecord)
+	var visit func(fields []uint64, g uint64, root Root) error
+	visit = func(fields []uint64, g uint64, root Root) error {
+		onPath := false
+		for _, f := range fields {
+			if within(g, f) {
+				return db.markTree(marked, Gindex64(g), root)
+			}
+			onPath = onPath || within(f, g)
+		}
+		var buf [maxKeyLen]byte
+		k, err := db.buildKey(&buf, Gindex64(g), root)
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, append([]byte(nil), k...))
+		var rec PairRecord
+		if err := db.getLocal(Gindex64(g), root, &rec); err == leveldb.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !rec.Pair {
+			return nil
+		}
+		if !onPath {
+			if _, ok := trims[string(k)]; !ok {
+				trims[string(k)] = PairRecord{Slot: rec.Slot, Summary: true}
+			}
+			return nil
+		}
+		rec.Partial = true
+		trims[string(k)] = rec
+		if err := visit(fields, g<<1, rec.Left); err != nil {
+			return err
+		}
+		return visit(fields, g<<1|1, rec.Right)
+	}
+	for root, fields := range partial {
+		if err := db.markAnchor(marked, root); err != nil {
+			return err
+		}
+		if err := visit(fields, 1, root); err != nil {
+			return err
+		}
+	}
+	b := new(leveldb.Batch)
+	for _, k := range summaries {
+		if ok, err := marked.has(k); err != nil {
+			return err
+		} else if !ok {
+			if err := marked.add(k); err != nil {
+				return err
+			}
+			if rec, ok := trims[string(k)]; ok {
+				b.Put(k, encodeValue(&rec))
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return db.write(b)
+}
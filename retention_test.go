@@ -0,0 +1,89 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestMerkleDB_Retention(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithTTLSlots(2, 0),
+		WithRetention(RetentionRule{Gindex: Gindex64(5), Slots: 10})).(*merkleDB)
+	trees := make(map[Root]Node)
+	put := func(slot uint64) Root {
+		foo := fullTree(4)
+		if _, err := mdb.Put(slot, foo, hFn); err != nil {
+			t.Fatal(err)
+		}
+		root := foo.MerkleRoot(hFn)
+		trees[root] = foo
+		return root
+	}
+	a, b, c := put(1), put(2), put(10)
+	if n, err := mdb.Expire(); err != nil || n != 2 {
+		t.Fatalf("expected 2 trimmed trees, got %d, err: %v", n, err)
+	}
+	for _, root := range []Root{a, b} {
+		if _, err := mdb.GetAnchor(root); err != nil {
+			t.Fatalf("expected the anchor of a trimmed tree to stay: %v", err)
+		}
+		// the field, and the nodes on the path to it with their siblings, are kept
+		for _, g := range []uint64{5, 20, 23, 4, 3} {
+			proof, err := mdb.Prove(root, Gindex64(g))
+			if err != nil {
+				t.Fatalf("gindex %d: %v", g, err)
+			}
+			if !proof.Verify(root, hFn) {
+				t.Fatalf("gindex %d: invalid proof", g)
+			}
+		}
+		for _, g := range []uint64{16, 12, 24} {
+			if _, err := mdb.Prove(root, Gindex64(g)); err == nil {
+				t.Fatalf("gindex %d: expected the node to be trimmed", g)
+			}
+		}
+	}
+	if c, err := mdb.Completeness(c); err != nil || !c.Complete() {
+		t.Fatalf("expected the recent tree to be whole, err: %v", err)
+	}
+	// trees that are trimmed already do not change
+	if n, err := mdb.Expire(); err != nil || n != 0 {
+		t.Fatalf("expected no changes, got %d, err: %v", n, err)
+	}
+
+	// the fields expire too, and the previous head is trimmed
+	d := put(20)
+	if n, err := mdb.Expire(); err != nil || n != 3 {
+		t.Fatalf("expected 3 changed trees, got %d, err: %v", n, err)
+	}
+	for _, root := range []Root{a, b} {
+		if _, err := mdb.GetAnchor(root); !errors.Is(err, leveldb.ErrNotFound) {
+			t.Fatalf("expected the anchor to expire, got %v", err)
+		}
+		if fields, err := mdb.trimmed(root); err != nil || fields != nil {
+			t.Fatalf("expected the trimmed record to be removed, got %v, err: %v", fields, err)
+		}
+	}
+	if _, err := mdb.Prove(c, Gindex64(20)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Prove(c, Gindex64(24)); err == nil {
+		t.Fatal("expected the previous head to be trimmed")
+	}
+	if report, err := mdb.Completeness(d); err != nil || !report.Complete() {
+		t.Fatalf("expected the head to be whole, err: %v", err)
+	}
+	if report, err := mdb.Completeness(c); err != nil || report.Complete() || len(report.Summarized) != 2 {
+		t.Fatalf("expected the siblings 3 and 4 of the trimmed tree as summaries, got %+v, err: %v", report, err)
+	}
+
+	// a put of the whole trimmed tree stores the trimmed nodes again, only the field is reused
+	if report, err := mdb.Put(21, trees[c], hFn); err != nil || report.NewNodes != 24 || report.ReusedNodes != 1 {
+		t.Fatalf("expected 24 new nodes and the reused field, got %+v, err: %v", report, err)
+	}
+	if report, err := mdb.Completeness(c); err != nil || !report.Complete() {
+		t.Fatalf("expected the tree to be whole again, err: %v", err)
+	}
+}
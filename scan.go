@@ -0,0 +1,144 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"math/bits"
+)
+
+// Predicate selects the nodes of a scan, see FilterSource and Scan
+type Predicate func(n *StreamNode) bool
+
+// Leaves selects the leaf nodes
+func Leaves(n *StreamNode) bool {
+	return !n.Pair
+}
+
+// InGindexRange selects the nodes in [start, end) at the depth of start, and the nodes below them.
+// E.g. the gindices of the chunks of validators 8 to 16 select their fields.
+func InGindexRange(start Gindex, end Gindex) Predicate {
+	s, errS := gindexValue(start)
+	e, errE := gindexValue(end)
+	if errS != nil || errE != nil {
+		return func(n *StreamNode) bool {
+			return false
+		}
+	}
+	depth := bits.Len64(s) - 1
+	return func(n *StreamNode) bool {
+		g, err := gindexValue(n.Gindex)
+		if err != nil {
+			return false
+		}
+		d := bits.Len64(g) - 1
+		if d < depth {
+			return false
+		}
+		g >>= uint(d - depth)
+		return g >= s && g < e
+	}
+}
+
+// InSlotRange selects the nodes that were stored at a slot in [start, end], inclusive like Range
+func InSlotRange(start uint64, end uint64) Predicate {
+	return func(n *StreamNode) bool {
+		return n.Slot >= start && n.Slot <= end
+	}
+}
+
+// LeafBytes selects the leaves with the pattern at the offset in their value, e.g. a balance in a packed chunk.
+// A zero byte in the mask ignores the byte of the pattern; all bytes are compared if the mask is nil.
+func LeafBytes(offset int, pattern []byte, mask []byte) Predicate {
+	return func(n *StreamNode) bool {
+		if n.Pair || offset < 0 || offset+len(pattern) > len(n.Root) {
+			return false
+		}
+		v := n.Root[offset : offset+len(pattern)]
+		if mask == nil {
+			return bytes.Equal(v, pattern)
+		}
+		for i := range pattern {
+			if i < len(mask) && v[i]&mask[i] != pattern[i]&mask[i] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// And selects the nodes that all predicates select
+func And(preds ...Predicate) Predicate {
+	return func(n *StreamNode) bool {
+		for _, p := range preds {
+			if !p(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or selects the nodes that any of the predicates selects
+func Or(preds ...Predicate) Predicate {
+	return func(n *StreamNode) bool {
+		for _, p := range preds {
+			if p(n) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not selects the nodes that the predicate does not select
+func Not(pred Predicate) Predicate {
+	return func(n *StreamNode) bool {
+		return !pred(n)
+	}
+}
+
+type filterSource struct {
+	src  NodeSource
+	pred Predicate
+}
+
+// FilterSource streams the nodes of the source that the predicate selects.
+// The stream no longer has every parent before its children, it is not for PutStream.
+func FilterSource(src NodeSource, pred Predicate) NodeSource {
+	return &filterSource{src: src, pred: pred}
+}
+
+func (f *filterSource) Next() (StreamNode, error) {
+	for {
+		n, err := f.src.Next()
+		if err != nil {
+			return StreamNode{}, err
+		}
+		if f.pred(&n) {
+			return n, nil
+		}
+	}
+}
+
+// Scan calls fn with the nodes of the subtree at the base gindex, in the tree of the anchor, that the predicate selects,
+// depth-first. The base limits the nodes that are read, e.g. to the balances; RootGindex scans the whole tree.
+// The nodes are read through the reader as they are scanned: scan a Snapshot to not observe concurrent prunes.
+func Scan(db TreeReader, anchor Root, base Gindex, pred Predicate, fn func(n StreamNode) error) error {
+	roots, err := ExtractColumn(db, []Root{anchor}, base)
+	if err != nil {
+		return err
+	}
+	src := FilterSource(SubtreeSource(db, NodeRef{Gindex: base, Root: roots[0]}, DepthFirst), pred)
+	for {
+		n, err := src.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"testing"
+)
+
+func scanGindices(t *testing.T, db TreeReader, anchor Root, base Gindex, pred Predicate) []uint64 {
+	var out []uint64
+	if err := Scan(db, anchor, base, pred, func(n StreamNode) error {
+		g, err := gindexValue(n.Gindex)
+		out = append(out, g)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestScan(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 1, 8)
+	if _, err := mdb.Put(1, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	first := state.HashTreeRoot(hFn)
+
+	// the chunk with the balance of validator 5
+	var balance [8]byte
+	binary.LittleEndian.PutUint64(balance[:], 31_000_000_005)
+	if got := scanGindices(t, mdb, first, Gindex64(7), And(Leaves, LeafBytes(8, balance[:], nil))); len(got) != 1 || got[0] != 14<<8|1 {
+		t.Fatalf("unexpected balance hits: %v", got)
+	}
+	// validator 4, first in the chunk, has the balance with the low bit cleared: it matches when the mask ignores the bit
+	mask := []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if got := scanGindices(t, mdb, first, Gindex64(7), LeafBytes(0, balance[:], nil)); len(got) != 0 {
+		t.Fatalf("unexpected balance hits: %v", got)
+	}
+	if got := scanGindices(t, mdb, first, Gindex64(7), LeafBytes(0, balance[:], mask)); len(got) != 1 || got[0] != 14<<8|1 {
+		t.Fatalf("unexpected masked balance hits: %v", got)
+	}
+
+	// the 4 field leaves of validators 2 and 3
+	elements := uint64(12) << 10
+	got := scanGindices(t, mdb, first, RootGindex, And(Leaves, InGindexRange(Gindex64(elements+2), Gindex64(elements+4))))
+	if len(got) != 8 {
+		t.Fatalf("expected the leaves of 2 validators, got %v", got)
+	}
+	for _, g := range got {
+		if e := g >> 2; e != elements+2 && e != elements+3 {
+			t.Fatalf("unexpected leaf gindex %d", g)
+		}
+	}
+
+	// only the changed chunk is stored at the slot of the second put
+	bals, err := state.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bals.(*view.BasicListView).Set(6, view.Uint64View(7)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	second := state.HashTreeRoot(hFn)
+	if got := scanGindices(t, mdb, second, Gindex64(7), And(Leaves, InSlotRange(2, 2))); len(got) != 1 || got[0] != 14<<8|1 {
+		t.Fatalf("unexpected changed leaves: %v", got)
+	}
+	if got := scanGindices(t, mdb, second, Gindex64(7), And(Leaves, Not(InSlotRange(2, 2)), Or(LeafBytes(0, balance[:], nil), LeafBytes(8, balance[:], nil)))); len(got) != 0 {
+		t.Fatalf("expected the changed chunk to be left out: %v", got)
+	}
+}
@@ -0,0 +1,107 @@
+package merkledb
+
+import (
+	"fmt"
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+	"math/bits"
+)
+
+// Scheme is the shape of the stored trees: the arity of the branch nodes, how a branch node commits to its children,
+// and the stored value of a node. The slot, anchor and pruning machinery addresses nodes by gindex and root only,
+// so a scheme of wide nodes with vector commitments, e.g. verkle trees, can share it.
+type Scheme interface {
+	// Arity is the number of children of a branch node, a power of two
+	Arity() int
+	// Commit computes the root of a branch node from the roots of its children
+	Commit(children []Root) Root
+	// Child is the gindex of the i-th child of the node at the gindex.
+	// Every level of the tree takes log2(Arity) bits of the gindex.
+	Child(gindex Gindex, i int) (Gindex, error)
+	// AppendValue appends the stored value of a node to dst: a leaf if there are no children, a branch node otherwise
+	AppendValue(dst []byte, slot uint64, children []Root) []byte
+	// ParseValue decodes a stored value, children is nil for a leaf
+	ParseValue(value []byte) (slot uint64, children []Root, err error)
+}
+
+type binaryScheme struct {
+	fn HashFn
+}
+
+// BinaryScheme is the scheme of binary merkle trees, what a MerkleDB stores: pairs that commit to their children
+// with the hash function, ConcurrentHashFn if nil.
+func BinaryScheme(fn HashFn) Scheme {
+	return binaryScheme{fn: hashFnOrDefault(fn)}
+}
+
+func (binaryScheme) Arity() int {
+	return 2
+}
+
+func (s binaryScheme) Commit(children []Root) Root {
+	return s.fn(children[0], children[1])
+}
+
+func (binaryScheme) Child(gindex Gindex, i int) (Gindex, error) {
+	return childGindex(gindex, 2, i)
+}
+
+func (binaryScheme) AppendValue(dst []byte, slot uint64, children []Root) []byte {
+	rec := PairRecord{Slot: slot, Pair: len(children) == 2}
+	if rec.Pair {
+		rec.Left, rec.Right = children[0], children[1]
+	}
+	return appendValue(dst, &rec)
+}
+
+func (binaryScheme) ParseValue(value []byte) (uint64, []Root, error) {
+	var rec PairRecord
+	if err := parseValue(value, &rec); err != nil {
+		return 0, nil, err
+	}
+	if !rec.Pair {
+		return rec.Slot, nil, nil
+	}
+	return rec.Slot, []Root{rec.Left, rec.Right}, nil
+}
+
+// childGindex is the gindex of the i-th child of a node of the given arity, for any scheme
+func childGindex(gindex Gindex, arity int, i int) (Gindex, error) {
+	if arity < 2 || arity&(arity-1) != 0 {
+		return nil, fmt.Errorf("arity %d is not a power of two", arity)
+	}
+	if i < 0 || i >= arity {
+		return nil, fmt.Errorf("child %d out of range for arity %d", i, arity)
+	}
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return nil, err
+	}
+	shift := uint(bits.TrailingZeros(uint(arity)))
+	// Gindex64 silently overflows past 63 bits
+	if bits.Len64(g)+int(shift) > 64 {
+		return nil, fmt.Errorf("child of gindex %d too deep", g)
+	}
+	return Gindex64(g<<shift | uint64(i)), nil
+}
+
+// appendValue appends the stored value of a binary node: the type, 0 for a leaf and 1 for a pair,
+// the slot, and the roots of the children of a pair.
+func appendValue(dst []byte, rec *PairRecord) []byte {
+	return lite.AppendValue(dst, rec)
+}
+
+func encodeValue(rec *PairRecord) []byte {
+	return appendValue(make([]byte, 0, 1+8+32+32), rec)
+}
+
+func decodeValue(key Root, out []byte, dst *PairRecord) error {
+	if err := parseValue(out, dst); err != nil {
+		return fmt.Errorf("key '%x' has %v", key, err)
+	}
+	return nil
+}
+
+func parseValue(out []byte, dst *PairRecord) error {
+	return lite.ParseValue(out, dst)
+}
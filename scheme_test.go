@@ -0,0 +1,65 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestBinaryScheme(t *testing.T) {
+	hFn := GetHashFn()
+	s := BinaryScheme(hFn)
+	if s.Arity() != 2 {
+		t.Fatalf("unexpected arity: %d", s.Arity())
+	}
+	mdb := New(testPrefix, newMemoryDB())
+	tree := randomTree(4)
+	if _, err := mdb.Put(3, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot(hFn)
+	var rec PairRecord
+	if err := mdb.GetInto(RootGindex, root, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if s.Commit([]Root{rec.Left, rec.Right}) != root {
+		t.Fatal("expected the commitment of the children to be the root")
+	}
+	value := s.AppendValue(nil, 3, []Root{rec.Left, rec.Right})
+	parsed, err := ParseNodeValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != rec {
+		t.Fatalf("expected the stored record, got %+v", parsed)
+	}
+	slot, children, err := s.ParseValue(s.AppendValue(nil, 7, nil))
+	if err != nil || slot != 7 || children != nil {
+		t.Fatalf("unexpected leaf value: %d %v %v", slot, children, err)
+	}
+	if _, _, err := s.ParseValue([]byte{4, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("expected an unknown value type to fail")
+	}
+
+	child, err := s.Child(Gindex64(5), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := gindexValue(child); v != 11 {
+		t.Fatalf("unexpected child gindex: %d", v)
+	}
+	if _, err := s.Child(Gindex64(5), 2); err == nil {
+		t.Fatal("expected an out of range child to fail")
+	}
+	if _, err := s.Child(Gindex64(1<<63), 0); err == nil {
+		t.Fatal("expected a too deep child to fail")
+	}
+	// wider schemes take more bits of the gindex per level
+	if g, err := childGindex(Gindex64(5), 16, 3); err != nil {
+		t.Fatal(err)
+	} else if v, _ := gindexValue(g); v != 5<<4|3 {
+		t.Fatalf("unexpected wide child gindex: %d", v)
+	}
+	if _, err := childGindex(Gindex64(5), 3, 0); err == nil {
+		t.Fatal("expected an arity that is not a power of two to fail")
+	}
+}
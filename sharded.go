@@ -0,0 +1,289 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sort"
+)
+
+// metaShard records the index of a shard and the number of shards, in every shard
+const metaShard byte = 'S'
+
+// ShardedDB spreads the nodes and anchors of the trees over multiple leveldb instances, by the root of every node,
+// for trees that outgrow a single instance. Reads of a node go to its shard, reads of ranges and anchors
+// are gathered from all shards.
+//
+// A Put writes a batch per shard. The batches are not atomic together: the batch with the anchor is written last,
+// so an interrupted put only leaves nodes that no anchor references.
+//
+// Pruning, proofs other than single-node proofs, and the other MerkleDB operations are not sharded:
+// they need the nodes of a tree in a single instance. A Put only writes the nodes and the anchor: it does not check
+// quotas, run put hooks, commits or watches, claim idempotency keys, nor write audit records.
+type ShardedDB struct {
+	shards []*merkleDB
+}
+
+// NewShardedDB opens the shards under the prefix. The shards must be given in the same order every time:
+// each shard records its index, and the count, the first time it is opened.
+//
+// Options that expire, prune or reclaim nodes are rejected: a shard only knows the anchors it holds itself,
+// and would delete the nodes of live trees that are referenced from other shards. So are quotas, which Put does not check.
+func NewShardedDB(prefix [prefixLen]byte, dbs []*leveldb.DB, opts ...Option) (*ShardedDB, error) {
+	if len(dbs) == 0 {
+		return nil, errors.New("no shards")
+	}
+	if len(dbs) > 1<<16 {
+		return nil, fmt.Errorf("too many shards: %d", len(dbs))
+	}
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := checkShardedOptions(&o); err != nil {
+		return nil, err
+	}
+	s := &ShardedDB{shards: make([]*merkleDB, 0, len(dbs))}
+	for i, ldb := range dbs {
+		shard := New(prefix, ldb, opts...).(*merkleDB)
+		if err := checkShard(shard, i, len(dbs)); err != nil {
+			shard.stop()
+			for _, opened := range s.shards {
+				opened.stop()
+			}
+			return nil, err
+		}
+		s.shards = append(s.shards, shard)
+	}
+	return s, nil
+}
+
+// checkShardedOptions rejects the options that a ShardedDB cannot apply to the trees over all shards
+func checkShardedOptions(o *Options) error {
+	switch {
+	case o.TTL != 0 || o.TTLSlots != 0 || o.RetainRefsOnly || len(o.Retention) != 0:
+		return errors.New("sharded DB cannot expire anchors")
+	case o.SweepInterval != 0 || o.DeferredDeletes:
+		return errors.New("sharded DB cannot sweep or reclaim nodes")
+	case o.Quota != (Quota{}):
+		return errors.New("sharded DB cannot enforce a quota")
+	}
+	return nil
+}
+
+// checkShard records the index of the shard and the number of shards, or checks them against the recorded ones
+func checkShard(shard *merkleDB, i int, count int) error {
+	var rec [4]byte
+	binary.LittleEndian.PutUint16(rec[0:2], uint16(i))
+	binary.LittleEndian.PutUint16(rec[2:4], uint16(count))
+	key := shard.metaKey(metaShard, nil)
+	if v, err := shard.db.Get(key, nil); err == leveldb.ErrNotFound {
+		return shard.db.Put(key, rec[:], nil)
+	} else if err != nil {
+		return err
+	} else if !bytes.Equal(v, rec[:]) {
+		return fmt.Errorf("shard %d of %d was opened as '%x' before", i, count, v)
+	}
+	return nil
+}
+
+func (s *ShardedDB) shard(root Root) *merkleDB {
+	return s.shards[binary.LittleEndian.Uint64(root[:8])%uint64(len(s.shards))]
+}
+
+// Put writes the tree, with the existence checks of every node in the shard of the node
+func (s *ShardedDB) Put(slot uint64, node Node, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	fn = hashFnOrDefault(fn)
+	batches := make(map[*merkleDB]*leveldb.Batch)
+	batch := func(shard *merkleDB) *leveldb.Batch {
+		b, ok := batches[shard]
+		if !ok {
+			b = new(leveldb.Batch)
+			batches[shard] = b
+		}
+		return b
+	}
+	var report InsertReport
+	var buf [maxKeyLen]byte
+	var add func(gindex Gindex, node Node) error
+	add = func(gindex Gindex, node Node) error {
+		if _, ok := node.(*summaryNode); ok {
+			return ErrSummaryNode
+		}
+		root := node.MerkleRoot(fn)
+		shard := s.shard(root)
+		k, err := shard.buildKey(&buf, gindex, root)
+		if err != nil {
+			return err
+		}
+		rec := PairRecord{Slot: slot}
+		var left, right Node
+		if !node.IsLeaf() {
+			if left, err = node.Left(); err != nil {
+				return err
+			}
+			if right, err = node.Right(); err != nil {
+				return err
+			}
+			rec.Pair, rec.Left, rec.Right = true, left.MerkleRoot(fn), right.MerkleRoot(fn)
+		}
+		batch(shard).Put(k, encodeValue(&rec))
+		report.NewNodes += 1
+		if d := gindex.Depth(); d > report.MaxDepth {
+			report.MaxDepth = d
+		}
+		if !rec.Pair {
+			return nil
+		}
+		for _, child := range []struct {
+			gindex Gindex
+			root   Root
+			node   Node
+		}{{gindex.Left(), rec.Left, left}, {gindex.Right(), rec.Right, right}} {
+			childShard := s.shard(child.root)
+			ck, err := childShard.buildKey(&buf, child.gindex, child.root)
+			if err != nil {
+				return err
+			}
+			if ok, err := childShard.storedFully(ck); err != nil {
+				return err
+			} else if ok {
+				report.ReusedNodes += 1
+			} else if err := add(child.gindex, child.node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add(RootGindex, node); err != nil {
+		return InsertReport{}, err
+	}
+	anchor := node.MerkleRoot(fn)
+	anchorShard := s.shard(anchor)
+	if err := anchorShard.putAnchor(batch(anchorShard), anchor, slot, opts); err != nil {
+		return InsertReport{}, err
+	}
+	for shard, b := range batches {
+		report.BytesWritten += len(b.Dump())
+		if shard == anchorShard {
+			continue
+		}
+		if err := shard.write(b); err != nil {
+			return report, err
+		}
+	}
+	return report, anchorShard.write(batches[anchorShard])
+}
+
+func (s *ShardedDB) Get(gindex Gindex, key Root, opts ...GetOption) (SlottedNode, error) {
+	var rec PairRecord
+	if err := s.GetInto(gindex, key, &rec); err != nil {
+		return SlottedNode{}, err
+	}
+	node, err := loadNode(s, s, s.shards[0].opts.MaxDepth, gindex, key, &rec, applyGetOptions(opts))
+	if err != nil {
+		return SlottedNode{}, err
+	}
+	return SlottedNode{Slot: rec.Slot, Node: node}, nil
+}
+
+func (s *ShardedDB) GetInto(gindex Gindex, key Root, dst *PairRecord) error {
+	return s.shard(key).GetInto(gindex, key, dst)
+}
+
+func (s *ShardedDB) Has(gindex Gindex, key Root) (bool, error) {
+	return s.shard(key).Has(gindex, key)
+}
+
+// Range gathers the nodes at the gindex between the slots from all shards, ordered by slot, then by root
+func (s *ShardedDB) Range(startSlot uint64, endSlot uint64, gindex Gindex) ([]SlottedNode, error) {
+	var out []SlottedNode
+	for _, shard := range s.shards {
+		nodes, err := shard.Range(startSlot, endSlot, gindex)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			// children are read through the shards
+			if v, ok := n.Node.(*virtualNode); ok {
+				v.db = s
+			}
+		}
+		out = append(out, nodes...)
+	}
+	hFn := GetHashFn()
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Slot != out[j].Slot {
+			return out[i].Slot < out[j].Slot
+		}
+		a, b := out[i].Node.MerkleRoot(hFn), out[j].Node.MerkleRoot(hFn)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return out, nil
+}
+
+func (s *ShardedDB) GetAllAtSlot(slot uint64, gindex Gindex) ([]SlottedNode, error) {
+	return s.Range(slot, slot, gindex)
+}
+
+// Prove the node at the target gindex, reading every node on the path from its shard
+func (s *ShardedDB) Prove(anchor Root, target Gindex) (*MerkleProof, error) {
+	iter, depth := target.BitIter()
+	branch := make([]Root, depth)
+	node, gindex := anchor, Gindex(RootGindex)
+	var rec PairRecord
+	for i := uint32(0); ; i++ {
+		right, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err := s.GetInto(gindex, node, &rec); err != nil {
+			return nil, summarized(err, gindex, node)
+		}
+		if !rec.Pair {
+			return nil, leafError(&rec, gindex, node)
+		}
+		if right {
+			branch[depth-1-i] = rec.Left
+			node, gindex = rec.Right, gindex.Right()
+		} else {
+			branch[depth-1-i] = rec.Right
+			node, gindex = rec.Left, gindex.Left()
+		}
+	}
+	return &MerkleProof{Gindex: target, Leaf: node, Branch: branch}, nil
+}
+
+// Anchors gathers the anchors of all shards, ordered by root
+func (s *ShardedDB) Anchors() ([]Anchor, error) {
+	var out []Anchor
+	for _, shard := range s.shards {
+		anchors, err := shard.Anchors()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, anchors...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].Root[:], out[j].Root[:]) < 0
+	})
+	return out, nil
+}
+
+func (s *ShardedDB) GetAnchor(root Root) (Anchor, error) {
+	return s.shard(root).GetAnchor(root)
+}
+
+// Close closes all shards, and returns the first error
+func (s *ShardedDB) Close() error {
+	var first error
+	for _, shard := range s.shards {
+		if err := shard.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,76 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestShardedDB(t *testing.T) {
+	hFn := GetHashFn()
+	dbs := []*leveldb.DB{newMemoryDB(), newMemoryDB(), newMemoryDB()}
+	s, err := NewShardedDB(testPrefix, dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := fullTree(5)
+	anchor := foo.MerkleRoot(hFn)
+	if report, err := s.Put(1, foo, hFn); err != nil || report.NewNodes != 63 {
+		t.Fatalf("expected 63 new nodes, got %+v, err: %v", report, err)
+	}
+	for i, shard := range s.shards {
+		if n := countKeys(t, shard); n < 2 {
+			t.Fatalf("expected shard %d to hold nodes, got %d keys", i, n)
+		}
+	}
+	out, err := s.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+
+	// the stored subtree is found in the shards of its nodes
+	left, _ := foo.Left()
+	bar := NewPairNode(left, fullTree(4))
+	if report, err := s.Put(2, bar, hFn); err != nil || report.ReusedNodes != 1 || report.NewNodes != 32 {
+		t.Fatalf("expected the left subtree to be reused, got %+v, err: %v", report, err)
+	}
+	proof, err := s.Prove(anchor, Gindex64(45))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proof.Verify(anchor, hFn) {
+		t.Fatal("expected a valid proof")
+	}
+	if anchors, err := s.Anchors(); err != nil || len(anchors) != 2 {
+		t.Fatalf("expected 2 anchors, got %v, err: %v", anchors, err)
+	}
+	if a, err := s.GetAnchor(bar.MerkleRoot(hFn)); err != nil || a.Slot != 2 {
+		t.Fatalf("unexpected anchor %v, err: %v", a, err)
+	}
+	nodes, err := s.Range(0, 10, Gindex64(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the left subtree is shared
+	if len(nodes) != 1 || nodes[0].Slot != 1 {
+		t.Fatalf("expected the shared left node, got %d nodes", len(nodes))
+	}
+	compareNodes(left, nodes[0].Node, Gindex64(2), hFn, t)
+
+	// the shards must be opened in the same order
+	if _, err := NewShardedDB(testPrefix, []*leveldb.DB{dbs[1], dbs[0], dbs[2]}); err == nil {
+		t.Fatal("expected an error for reordered shards")
+	}
+	if _, err := NewShardedDB(testPrefix, dbs); err != nil {
+		t.Fatal(err)
+	}
+
+	// a shard would prune the nodes that the anchors of other shards reference
+	for _, opt := range []Option{WithTTLSlots(10, 0), WithProfile(ProfilePruned), WithDeferredDeletes(0),
+		WithQuota(Quota{MaxNodes: 100, PruneOnExceed: true})} {
+		if _, err := NewShardedDB(testPrefix, dbs, opt); err == nil {
+			t.Fatal("expected an error for a pruning option")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sort"
+)
+
+// SlotAnomaly is a node with a recorded slot that is newer than the slot of an anchor that references it.
+// Nodes keep the slot they were first stored at, so under correct usage a tree never reuses newer nodes.
+type SlotAnomaly struct {
+	Node NodeRef
+	Slot uint64
+	// Anchor is the root of the oldest anchor that references the node
+	Anchor     Root
+	AnchorSlot uint64
+}
+
+// SlotReport lists the slot anomalies, see CheckSlots
+type SlotReport struct {
+	// Checked is the number of checked nodes, nodes shared by trees are checked once
+	Checked   int
+	Anomalies []SlotAnomaly
+}
+
+func (db *merkleDB) CheckSlots() (report *SlotReport, err error) {
+	err = db.consistent(func(view *merkleDB) error {
+		anchors, err := view.Anchors()
+		if err != nil {
+			return err
+		}
+		// with the oldest anchors first, a shared node that is too new for a later anchor
+		// was too new for the earlier one already, and is only checked and reported once
+		sort.SliceStable(anchors, func(i, j int) bool {
+			return anchors[i].Slot < anchors[j].Slot
+		})
+		visited := view.newMarkSet()
+		defer visited.close()
+		report = new(SlotReport)
+		var buf [maxKeyLen]byte
+		var rec PairRecord
+		for i := range anchors {
+			a := &anchors[i]
+			stack := []NodeRef{{Gindex: RootGindex, Root: a.Root}}
+			for len(stack) > 0 {
+				ref := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				k, err := view.buildKey(&buf, ref.Gindex, ref.Root)
+				if err != nil {
+					return err
+				}
+				if ok, err := visited.has(k); err != nil {
+					return err
+				} else if ok {
+					continue
+				}
+				if err := visited.add(k); err != nil {
+					return err
+				}
+				if err := view.getLocal(ref.Gindex, ref.Root, &rec); err == leveldb.ErrNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				report.Checked += 1
+				if rec.Slot > a.Slot {
+					report.Anomalies = append(report.Anomalies, SlotAnomaly{
+						Node: ref, Slot: rec.Slot, Anchor: a.Root, AnchorSlot: a.Slot,
+					})
+				}
+				if rec.Pair {
+					stack = append(stack,
+						NodeRef{Gindex: ref.Gindex.Right(), Root: rec.Right},
+						NodeRef{Gindex: ref.Gindex.Left(), Root: rec.Left})
+				}
+			}
+		}
+		return nil
+	})
+	return report, err
+}
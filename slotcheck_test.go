@@ -0,0 +1,53 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_CheckSlots(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	shared := fullTree(3)
+	a := NewPairNode(shared, randomTree(3))
+	b := NewPairNode(randomTree(3), shared)
+	for i, n := range []Node{a, b} {
+		if _, err := mdb.Put(uint64(10+i), n, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := mdb.CheckSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Anomalies) != 0 {
+		t.Fatalf("expected no anomalies, got %d", len(report.Anomalies))
+	}
+	checked := report.Checked
+
+	// an older tree that reuses the shared subtree, stored at slot 10, is impossible
+	c := NewPairNode(shared, fullTree(3))
+	if _, err := mdb.Put(5, c, hFn); err != nil {
+		t.Fatal(err)
+	}
+	report, err = mdb.CheckSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != checked+1+15 {
+		t.Fatalf("expected shared nodes to be checked once, checked %d", report.Checked)
+	}
+	sharedRoot := shared.MerkleRoot(hFn)
+	found := false
+	for _, an := range report.Anomalies {
+		if an.Anchor != c.MerkleRoot(hFn) || an.AnchorSlot != 5 || an.Slot != 10 {
+			t.Fatalf("unexpected anomaly: %+v", an)
+		}
+		if an.Node == (NodeRef{Gindex: LeftGindex, Root: sharedRoot}) {
+			found = true
+		}
+	}
+	if !found || len(report.Anomalies) != 15 {
+		t.Fatalf("expected the 15 shared nodes to be flagged, got %d", len(report.Anomalies))
+	}
+}
@@ -0,0 +1,55 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"time"
+)
+
+// SlowQuery describes a Get, Put or Range that took longer than the threshold of WithSlowQueryLog
+type SlowQuery struct {
+	// Op is "get", "put" or "range"
+	Op   string
+	Took time.Duration
+	// Gindex is the position of the read node or nodes, the root for puts
+	Gindex Gindex
+	// Root is the read node, or the anchor of the put tree. Zero for ranges.
+	Root Root
+	// Slot of the put tree, and the slots of the range
+	Slot      uint64
+	StartSlot uint64
+	EndSlot   uint64
+	// Nodes is the number of nodes written by the put, or returned by the range
+	Nodes int
+	// Err is the error of the operation, if any
+	Err error
+}
+
+func (q SlowQuery) String() string {
+	gindex, err := gindexValue(q.Gindex)
+	position := fmt.Sprintf("gindex=%d depth=%d", gindex, q.Gindex.Depth())
+	if err != nil {
+		position = fmt.Sprintf("depth=%d", q.Gindex.Depth())
+	}
+	out := fmt.Sprintf("slow %s took=%s %s", q.Op, q.Took, position)
+	switch q.Op {
+	case "put":
+		out += fmt.Sprintf(" root=%s slot=%d nodes=%d", q.Root, q.Slot, q.Nodes)
+	case "range":
+		out += fmt.Sprintf(" slots=%d-%d nodes=%d", q.StartSlot, q.EndSlot, q.Nodes)
+	default:
+		out += fmt.Sprintf(" root=%s", q.Root)
+	}
+	if q.Err != nil {
+		out += fmt.Sprintf(" err=%q", q.Err.Error())
+	}
+	return out
+}
+
+// logSlow reports the query if it took longer than the threshold
+func (db *merkleDB) logSlow(start time.Time, q SlowQuery) {
+	q.Took = time.Since(start)
+	if q.Took >= db.opts.SlowQueryThreshold {
+		db.opts.OnSlowQuery(q)
+	}
+}
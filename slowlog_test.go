@@ -0,0 +1,62 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerkleDB_SlowQueryLog(t *testing.T) {
+	var queries []SlowQuery
+	mdb := New(testPrefix, newMemoryDB(), WithSlowQueryLog(0, func(q SlowQuery) {
+		queries = append(queries, q)
+	}))
+	hFn := GetHashFn()
+	tree := fullTree(3)
+	root := tree.MerkleRoot(hFn)
+	if _, err := mdb.Put(5, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Get(RootGindex, root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Get(RootGindex, Root{1}); err == nil {
+		t.Fatal("expected a missing node")
+	}
+	if _, err := mdb.Range(0, 10, Gindex64(8)); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 4 {
+		t.Fatalf("expected 4 queries, got %d: %v", len(queries), queries)
+	}
+	put, get, missing, rng := queries[0], queries[1], queries[2], queries[3]
+	if put.Op != "put" || put.Root != root || put.Slot != 5 || put.Nodes != 15 {
+		t.Fatalf("unexpected put: %v", put)
+	}
+	if get.Op != "get" || get.Root != root || get.Err != nil {
+		t.Fatalf("unexpected get: %v", get)
+	}
+	if missing.Err == nil || !strings.Contains(missing.String(), "err=") {
+		t.Fatalf("expected the error of the get: %v", missing)
+	}
+	if rng.Op != "range" || rng.Nodes != 1 || rng.EndSlot != 10 {
+		t.Fatalf("unexpected range: %v", rng)
+	}
+	if s := rng.String(); !strings.HasPrefix(s, "slow range took=") || !strings.Contains(s, "gindex=8 depth=3 slots=0-10 nodes=1") {
+		t.Fatalf("unexpected line: %s", s)
+	}
+}
+
+func TestMerkleDB_SlowQueryThreshold(t *testing.T) {
+	var queries []SlowQuery
+	mdb := New(testPrefix, newMemoryDB(), WithSlowQueryLog(time.Hour, func(q SlowQuery) {
+		queries = append(queries, q)
+	}))
+	if _, err := mdb.Put(1, fullTree(2), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 0 {
+		t.Fatalf("expected no slow queries: %v", queries)
+	}
+}
@@ -0,0 +1,131 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync"
+)
+
+// reader is the read side of leveldb, implemented by both the database and its snapshots
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+var _ reader = (*leveldb.DB)(nil)
+
+// snapshotReader guards the reads of a leveldb snapshot against its release:
+// leveldb does not return ErrSnapshotReleased for every read after a release.
+type snapshotReader struct {
+	lock     sync.RWMutex
+	snap     *leveldb.Snapshot
+	released bool
+	// done releases the snapshot of the value log, if any
+	done func()
+}
+
+func (s *snapshotReader) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.released {
+		return nil, leveldb.ErrSnapshotReleased
+	}
+	return s.snap.Get(key, ro)
+}
+
+func (s *snapshotReader) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.released {
+		return false, leveldb.ErrSnapshotReleased
+	}
+	return s.snap.Has(key, ro)
+}
+
+func (s *snapshotReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.released {
+		return iterator.NewEmptyIterator(leveldb.ErrSnapshotReleased)
+	}
+	return s.snap.NewIterator(slice, ro)
+}
+
+func (s *snapshotReader) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.snap.Release()
+	if s.done != nil {
+		s.done()
+	}
+}
+
+// Snapshot is a read-only view of a merkledb as it was when the snapshot was taken.
+// Concurrent puts and prunes do not show up in it, so multiple reads see the same trees.
+// Nodes that are fetched by a NodeResolver are stored, but not seen by the snapshot: a snapshot resolves them again.
+// The snapshot must be released when done, virtual nodes that it returned cannot load their children after that.
+type Snapshot struct {
+	TreeReader
+	snap *snapshotReader
+}
+
+// Release the underlying leveldb snapshot
+func (s *Snapshot) Release() {
+	s.snap.release()
+}
+
+func (db *merkleDB) Snapshot() (*Snapshot, error) {
+	view, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{TreeReader: view, snap: view.snap}, nil
+}
+
+// snapshot creates a read-only view, it must not be written to.
+// The value files that the snapshot may read are kept until it is released.
+func (db *merkleDB) snapshot() (*merkleDB, error) {
+	var done func()
+	if db.vlog != nil {
+		done = db.vlog.acquire()
+	}
+	ls, err := db.db.GetSnapshot()
+	if err != nil {
+		if done != nil {
+			done()
+		}
+		return nil, err
+	}
+	snap := &snapshotReader{snap: ls, done: done}
+	base := db
+	if db.base != nil {
+		base = db.base
+	}
+	view := &merkleDB{prefix: db.prefix, db: db.db, r: snap, opts: db.opts, snap: snap, base: base, counters: db.counters,
+		vlog: db.vlog}
+	if db.opts.Prefetch > 0 {
+		view.prefetch = newPrefetcher(view, db.opts.Prefetch)
+	}
+	return view, nil
+}
+
+// consistent runs fn on a snapshot, or on the merkledb itself if it is a snapshot already.
+// Reads that span many leveldb calls use it to never observe half of a put or prune.
+func (db *merkleDB) consistent(fn func(view *merkleDB) error) error {
+	if db.snap != nil {
+		return fn(db)
+	}
+	view, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	defer view.snap.release()
+	return fn(view)
+}
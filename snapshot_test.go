@@ -0,0 +1,64 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestMerkleDB_Snapshot(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	a := randomTree(6)
+	rootA := a.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := mdb.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := randomTree(6)
+	rootB := b.MerkleRoot(hFn)
+	if _, err := mdb.Put(2, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Prune([]Root{rootB}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mdb.Has(RootGindex, rootA); err != nil || ok {
+		t.Fatalf("expected tree to be pruned, ok: %v, err: %v", ok, err)
+	}
+
+	// the snapshot still sees the pruned tree, and not the new one
+	got, err := snap.Get(RootGindex, rootA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(a, got.Node, RootGindex, hFn, t)
+	if report, err := snap.Completeness(rootA); err != nil {
+		t.Fatal(err)
+	} else if !report.Complete() {
+		t.Fatalf("expected complete tree in snapshot, missing %d nodes", len(report.Missing))
+	}
+	walked := 0
+	if err := snap.Walk(rootA, DepthFirst, func(node StreamNode) error {
+		walked += 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if walked == 0 {
+		t.Fatal("expected walked nodes")
+	}
+	if _, err := snap.GetAnchor(rootB); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the new anchor to not be in the snapshot, got %v", err)
+	}
+	if anchors, err := snap.Anchors(); err != nil || len(anchors) != 1 || anchors[0].Root != rootA {
+		t.Fatalf("unexpected snapshot anchors: %v, err: %v", anchors, err)
+	}
+	snap.Release()
+	if _, err := snap.Get(RootGindex, rootA); err != leveldb.ErrSnapshotReleased {
+		t.Fatalf("expected released snapshot, got %v", err)
+	}
+}
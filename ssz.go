@@ -0,0 +1,75 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"math"
+)
+
+// ExportSSZ serializes the tree of the anchor, typed with the given type, to canonical SSZ.
+// The encoding is copied if it was stored with the tree as the type, see WithSSZStorage.
+// Otherwise the nodes are loaded lazily while serializing.
+func ExportSSZ(db TreeReader, w io.Writer, typ view.TypeDef, anchor Root) error {
+	if rec, err := db.GetSSZ(anchor, RootGindex); err == nil && rec.Type == typ.String() {
+		_, err := w.Write(rec.Data)
+		return err
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return fmt.Errorf("failed to get stored SSZ: %v", err)
+	}
+	out, err := db.Get(RootGindex, anchor)
+	if err != nil {
+		return fmt.Errorf("failed to load anchor: %v", err)
+	}
+	v, err := typ.ViewFromBacking(out.Node, nil)
+	if err != nil {
+		return fmt.Errorf("anchor does not match type %s: %v", typ.String(), err)
+	}
+	bw := bufio.NewWriter(w)
+	if err := v.Serialize(codec.NewEncodingWriter(bw)); err != nil {
+		return fmt.Errorf("failed to serialize: %v", err)
+	}
+	return bw.Flush()
+}
+
+// ImportSSZ deserializes a SSZ value of the given type, and Puts its backing tree.
+// The root of the imported tree is returned.
+func ImportSSZ(db TreeWriter, slot uint64, typ view.TypeDef, r io.Reader, fn HashFn) (Root, InsertReport, error) {
+	max := typ.MaxByteLength()
+	data, err := io.ReadAll(io.LimitReader(r, readLimit(max)))
+	if err != nil {
+		return Root{}, InsertReport{}, fmt.Errorf("failed to read SSZ input: %v", err)
+	}
+	if uint64(len(data)) > max {
+		return Root{}, InsertReport{}, fmt.Errorf("SSZ input is larger than the maximum %d bytes of type %s", max, typ.String())
+	}
+	v, err := typ.Deserialize(codecReader(data))
+	if err != nil {
+		return Root{}, InsertReport{}, fmt.Errorf("failed to deserialize %s: %v", typ.String(), err)
+	}
+	node := v.Backing()
+	root := node.MerkleRoot(fn)
+	report, err := db.Put(slot, node, fn)
+	if err != nil {
+		return Root{}, InsertReport{}, err
+	}
+	return root, report, nil
+}
+
+func codecReader(data []byte) *codec.DecodingReader {
+	return codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+}
+
+// readLimit is the limit of a reader of at most max bytes: one more byte than allowed, to detect oversized inputs.
+// Types of lists can have a maximum near math.MaxUint64, which does not fit an int64 limit.
+func readLimit(max uint64) int64 {
+	if max >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(max) + 1
+}
@@ -0,0 +1,72 @@
+package merkledb
+
+import (
+	"bytes"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"math"
+	"testing"
+)
+
+func TestExportSSZ(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 42, 30)
+	if _, err := mdb.Put(42, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	var expected bytes.Buffer
+	if err := state.Serialize(codec.NewEncodingWriter(&expected)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := ExportSSZ(mdb, &got, testStateType, state.HashTreeRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected.Bytes(), got.Bytes()) {
+		t.Fatalf("exported SSZ does not match:\n%x\n%x", expected.Bytes(), got.Bytes())
+	}
+}
+
+func TestImportSSZ(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 42, 30)
+	var data bytes.Buffer
+	if err := state.Serialize(codec.NewEncodingWriter(&data)); err != nil {
+		t.Fatal(err)
+	}
+	root, report, err := ImportSSZ(mdb, 42, testStateType, &data, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != state.HashTreeRoot(hFn) {
+		t.Fatalf("imported root %s does not match %s", root, state.HashTreeRoot(hFn))
+	}
+	if report.NewNodes == 0 {
+		t.Fatal("expected nodes to be written")
+	}
+	out, err := mdb.Get(RootGindex, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(state.Backing(), out.Node, RootGindex, hFn, t)
+}
+
+func TestImportSSZ_LargeLimit(t *testing.T) {
+	// the maximum length of the type does not fit an int64 read limit
+	typ := view.BasicListType(view.Uint8Type, math.MaxUint64)
+	hFn := GetHashFn()
+	root, _, err := ImportSSZ(New(testPrefix, newMemoryDB()), 1, typ, bytes.NewReader([]byte{1, 2, 3}), hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := typ.Deserialize(codecReader([]byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != v.HashTreeRoot(hFn) {
+		t.Fatal("expected the three bytes to be imported")
+	}
+}
@@ -0,0 +1,161 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// metaSSZ stores the SSZ encodings of put trees, by anchor root and gindex, see WithSSZStorage
+const metaSSZ byte = 'z'
+
+const sszRecordVersion = 0
+
+// SSZSubtree is a subtree of the put trees to store the SSZ encoding of, see WithSSZStorage
+type SSZSubtree struct {
+	// Gindex is the position of the subtree, RootGindex for the whole tree
+	Gindex Gindex
+	// Type is the SSZ type of the subtree
+	Type view.TypeDef
+}
+
+// SSZRecord is the stored SSZ encoding of a subtree of a put tree
+type SSZRecord struct {
+	// Type is the name of the type the subtree was encoded as
+	Type string
+	// Data is the SSZ encoding
+	Data []byte
+}
+
+func (r *SSZRecord) encode() []byte {
+	out := make([]byte, 1+2+len(r.Type)+len(r.Data))
+	out[0] = sszRecordVersion
+	binary.LittleEndian.PutUint16(out[1:3], uint16(len(r.Type)))
+	copy(out[3:], r.Type)
+	copy(out[3+len(r.Type):], r.Data)
+	return out
+}
+
+func (r *SSZRecord) decode(v []byte) error {
+	if len(v) < 3 {
+		return fmt.Errorf("corrupt SSZ record, too short: %d bytes", len(v))
+	}
+	if v[0] != sszRecordVersion {
+		return fmt.Errorf("unknown SSZ record version: %d", v[0])
+	}
+	n := int(binary.LittleEndian.Uint16(v[1:3]))
+	if len(v) < 3+n {
+		return fmt.Errorf("corrupt SSZ record, type name of %d bytes does not fit", n)
+	}
+	*r = SSZRecord{Type: string(v[3 : 3+n]), Data: v[3+n:]}
+	return nil
+}
+
+func sszID(anchor Root, g uint64) []byte {
+	id := make([]byte, 32+8)
+	copy(id[:32], anchor[:])
+	binary.BigEndian.PutUint64(id[32:], g)
+	return id
+}
+
+// putSSZ adds the SSZ encodings of the configured subtrees of the put tree to the batch of the put
+func (db *merkleDB) putSSZ(b *leveldb.Batch, root Root, node Node, opts []PutOption) error {
+	if len(db.opts.SSZStorage) == 0 || applyPutOptions(opts).partial {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, s := range db.opts.SSZStorage {
+		g, err := gindexValue(s.Gindex)
+		if err != nil {
+			return err
+		}
+		sub, err := node.Getter(s.Gindex)
+		if err != nil {
+			return fmt.Errorf("failed to find SSZ subtree at gindex %d: %w", g, err)
+		}
+		v, err := s.Type.ViewFromBacking(sub, nil)
+		if err != nil {
+			return fmt.Errorf("SSZ subtree at gindex %d does not match type %s: %v", g, s.Type.String(), err)
+		}
+		buf.Reset()
+		if err := v.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+			return fmt.Errorf("failed to serialize SSZ subtree at gindex %d: %v", g, err)
+		}
+		rec := SSZRecord{Type: s.Type.String(), Data: buf.Bytes()}
+		if err := db.putValue(b, metaSSZ, sszID(root, g), rec.encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *merkleDB) GetSSZ(anchor Root, gindex Gindex) (SSZRecord, error) {
+	g, err := gindexValue(gindex)
+	if err != nil {
+		return SSZRecord{}, err
+	}
+	v, err := db.getValue(metaSSZ, sszID(anchor, g))
+	if err != nil {
+		return SSZRecord{}, err
+	}
+	var rec SSZRecord
+	if err := rec.decode(v); err != nil {
+		return SSZRecord{}, corruptMeta(metaSSZ, sszID(anchor, g), v, err)
+	}
+	return rec, nil
+}
+
+// deleteSSZ adds the deletes of the SSZ encodings of the anchor to the batch, and of their pointers into the value log
+func (db *merkleDB) deleteSSZ(b *leveldb.Batch, anchor Root) error {
+	for _, prefix := range [][]byte{db.metaKey(metaSSZ, anchor[:]), db.pointerKey(metaSSZ, anchor[:])} {
+		iter := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+		for iter.Next() {
+			b.Delete(append([]byte(nil), iter.Key()...))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneSSZ deletes the SSZ encodings of trees that no longer have an anchor, and their pointers into the value log
+func (db *merkleDB) pruneSSZ() error {
+	w := db.newDeleteWriter()
+	scan := func(prefix []byte) error {
+		iter := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+		defer iter.Release()
+		var last Root
+		anchored := false
+		for i := 0; iter.Next(); i++ {
+			// the records are ordered by anchor, each anchor is looked up once
+			if root := toRoot(iter.Key()[len(prefix):]); i == 0 || root != last {
+				has, err := db.anchored(root)
+				if err != nil {
+					return err
+				}
+				last, anchored = root, has
+			}
+			if anchored {
+				continue
+			}
+			if err := w.delete(iter.Key()); err != nil {
+				return err
+			}
+		}
+		return iter.Error()
+	}
+	if err := scan(db.metaKey(metaSSZ, nil)); err != nil {
+		return err
+	}
+	if err := scan(db.pointerKey(metaSSZ, nil)); err != nil {
+		return err
+	}
+	return w.flush()
+}
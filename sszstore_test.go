@@ -0,0 +1,97 @@
+package merkledb
+
+import (
+	"bytes"
+	"github.com/protolambda/ztyp/codec"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func serializeView(t *testing.T, v view.View) []byte {
+	var buf bytes.Buffer
+	if err := v.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMerkleDB_SSZStorage(t *testing.T) {
+	balancesGindex := Gindex64(7)
+	balancesType := view.BasicListType(view.Uint64Type, 1<<10)
+	mdb := New(testPrefix, newMemoryDB(), WithSSZStorage(
+		SSZSubtree{Gindex: RootGindex, Type: testStateType},
+		SSZSubtree{Gindex: balancesGindex, Type: balancesType},
+	))
+	hFn := GetHashFn()
+	kept, expired := testState(t, 1, 10), testState(t, 2, 12)
+	for i, state := range []*view.ContainerView{kept, expired} {
+		if _, err := mdb.Put(uint64(i+1), state.Backing(), hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keptRoot, expiredRoot := kept.HashTreeRoot(hFn), expired.HashTreeRoot(hFn)
+
+	rec, err := mdb.GetSSZ(keptRoot, RootGindex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Type != testStateType.String() || !bytes.Equal(rec.Data, serializeView(t, kept)) {
+		t.Fatalf("unexpected stored state: %s %x", rec.Type, rec.Data)
+	}
+	bals, err := kept.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := mdb.GetSSZ(keptRoot, balancesGindex); err != nil || !bytes.Equal(rec.Data, serializeView(t, bals)) {
+		t.Fatalf("unexpected stored balances: %x, %v", rec.Data, err)
+	}
+
+	// the export copies the stored encoding, without loading nodes
+	r := &countingReader{TreeReader: mdb}
+	var out bytes.Buffer
+	if err := ExportSSZ(r, &out, testStateType, keptRoot); err != nil {
+		t.Fatal(err)
+	}
+	if r.gets != 0 || !bytes.Equal(out.Bytes(), serializeView(t, kept)) {
+		t.Fatalf("expected the stored encoding, with %d gets", r.gets)
+	}
+
+	if err := mdb.Prune([]Root{keptRoot}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GetSSZ(expiredRoot, RootGindex); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the encoding of the pruned tree to be deleted, got %v", err)
+	}
+	if _, err := mdb.GetSSZ(expiredRoot, balancesGindex); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the balances of the pruned tree to be deleted, got %v", err)
+	}
+	if _, err := mdb.GetSSZ(keptRoot, balancesGindex); err != nil {
+		t.Fatalf("expected the balances of the kept tree to stay: %v", err)
+	}
+
+	if err := mdb.Delete(RootGindex, keptRoot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GetSSZ(keptRoot, RootGindex); err != leveldb.ErrNotFound {
+		t.Fatalf("expected the encoding of the deleted tree to be deleted, got %v", err)
+	}
+}
+
+func TestMerkleDB_SSZStorageMismatch(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithSSZStorage(SSZSubtree{Gindex: Gindex64(7), Type: testStateType}))
+	hFn := GetHashFn()
+	// the leaf at gindex 7 is not a state
+	tree := fullTree(2)
+	if _, err := mdb.Put(1, tree, hFn); err == nil {
+		t.Fatal("expected the put to fail")
+	}
+	if _, err := mdb.GetAnchor(tree.MerkleRoot(hFn)); err != leveldb.ErrNotFound {
+		t.Fatalf("expected no anchor, got %v", err)
+	}
+	// partial puts store no encodings
+	if _, err := mdb.PutTop(1, tree, hFn, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are the counters of the operations of a MerkleDB, since it was created
+type Stats struct {
+	// Puts is the number of Put, PutStream and Transplant calls that wrote their batch
+	Puts uint64 `json:"puts"`
+	// Gets is the number of node reads with Get or GetInto
+	Gets uint64 `json:"gets"`
+	// CacheHits is the number of node reads that were served locally, of a CachingDB or with a NodeResolver
+	CacheHits uint64 `json:"cache_hits"`
+	// CacheMisses is the number of node reads that were served by the NodeResolver
+	CacheMisses uint64 `json:"cache_misses"`
+	// Batches is the number of written batches, and BatchBytes their encoded size
+	Batches    uint64 `json:"batches"`
+	BatchBytes uint64 `json:"batch_bytes"`
+	// DeletedKeys is the number of keys deleted by prunes, reclaims and evictions
+	DeletedKeys uint64 `json:"deleted_keys"`
+	// Prunes is the number of completed prunes, and PrunesRunning the number in progress
+	Prunes        uint64 `json:"prunes"`
+	PrunesRunning uint64 `json:"prunes_running"`
+	// Prefetches is the number of nodes that were read ahead, and PrefetchHits the number of reads they served
+	Prefetches   uint64 `json:"prefetches"`
+	PrefetchHits uint64 `json:"prefetch_hits"`
+	// ProofCacheHits and ProofCacheMisses are the number of proofs that were and were not served by the proof cache
+	ProofCacheHits   uint64 `json:"proof_cache_hits"`
+	ProofCacheMisses uint64 `json:"proof_cache_misses"`
+}
+
+// counters are shared by a merkledb and its snapshot views
+type counters struct {
+	puts, gets, hits, misses, batches, batchBytes, deletedKeys, prunes, prunesRunning, prefetches, prefetchHits, proofHits, proofMisses uint64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Puts:             atomic.LoadUint64(&c.puts),
+		Gets:             atomic.LoadUint64(&c.gets),
+		CacheHits:        atomic.LoadUint64(&c.hits),
+		CacheMisses:      atomic.LoadUint64(&c.misses),
+		Batches:          atomic.LoadUint64(&c.batches),
+		BatchBytes:       atomic.LoadUint64(&c.batchBytes),
+		DeletedKeys:      atomic.LoadUint64(&c.deletedKeys),
+		Prunes:           atomic.LoadUint64(&c.prunes),
+		PrunesRunning:    atomic.LoadUint64(&c.prunesRunning),
+		Prefetches:       atomic.LoadUint64(&c.prefetches),
+		PrefetchHits:     atomic.LoadUint64(&c.prefetchHits),
+		ProofCacheHits:   atomic.LoadUint64(&c.proofHits),
+		ProofCacheMisses: atomic.LoadUint64(&c.proofMisses),
+	}
+}
+
+func (db *merkleDB) Stats() Stats {
+	return db.counters.stats()
+}
+
+// write writes the batch, and counts it
+func (db *merkleDB) write(b *leveldb.Batch) error {
+	return db.commit(b, func(b *leveldb.Batch) error {
+		return db.db.Write(b, nil)
+	})
+}
+
+// commit writes the batch with the commit function, counts it, and publishes it to the changefeed if any
+func (db *merkleDB) commit(b *leveldb.Batch, commit CommitFn) error {
+	if atomic.LoadInt32(&db.leaseLost) != 0 {
+		return ErrLeaseLost
+	}
+	if db.opts.Changefeed != nil {
+		if err := db.opts.Changefeed.commit(b, commit, db.inlineValues); err != nil {
+			return err
+		}
+	} else if err := commit(b); err != nil {
+		return err
+	}
+	atomic.AddUint64(&db.counters.batches, 1)
+	atomic.AddUint64(&db.counters.batchBytes, uint64(len(b.Dump())))
+	return nil
+}
+
+func (db *merkleDB) writeKey(key []byte, value []byte) error {
+	b := new(leveldb.Batch)
+	b.Put(key, value)
+	return db.write(b)
+}
+
+func (db *merkleDB) deleteKey(key []byte) error {
+	b := new(leveldb.Batch)
+	b.Delete(key)
+	return db.write(b)
+}
+
+// startPrune counts a running prune, the returned function ends it
+func (db *merkleDB) startPrune() func() {
+	atomic.AddUint64(&db.counters.prunesRunning, 1)
+	return func() {
+		atomic.AddUint64(&db.counters.prunesRunning, ^uint64(0))
+		atomic.AddUint64(&db.counters.prunes, 1)
+	}
+}
+
+// published maps the expvar names to the source of their stats, a database that is opened again with the same name
+// takes over the variable: expvar does not allow to publish a name twice.
+var (
+	published     = make(map[string]*statsVar)
+	publishedLock sync.Mutex
+)
+
+type statsVar struct {
+	src atomic.Value // func() Stats
+}
+
+func (v *statsVar) String() string {
+	out, err := json.Marshal(v.src.Load().(func() Stats)())
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(out)
+}
+
+// PublishStats publishes the Stats of the database under the expvar name, e.g. for /debug/vars.
+// Publishing another database under the same name replaces the previous one.
+// It panics if the name is taken by an expvar that was not published with PublishStats.
+func PublishStats(name string, db interface{ Stats() Stats }) {
+	publishedLock.Lock()
+	defer publishedLock.Unlock()
+	v, ok := published[name]
+	if !ok {
+		v = new(statsVar)
+		published[name] = v
+		expvar.Publish(name, v)
+	}
+	v.src.Store(db.Stats)
+}
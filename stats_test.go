@@ -0,0 +1,58 @@
+package merkledb
+
+import (
+	"encoding/json"
+	"expvar"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Stats(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	foo := fullTree(2)
+	anchor := foo.MerkleRoot(GetHashFn())
+	if _, err := mdb.Put(1, foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Get(RootGindex, anchor); err != nil {
+		t.Fatal(err)
+	}
+	stats := mdb.Stats()
+	if stats.Puts != 1 || stats.Gets != 1 || stats.Batches != 1 || stats.BatchBytes == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if err := mdb.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+	stats = mdb.Stats()
+	// 7 nodes and the anchor
+	if stats.Prunes != 1 || stats.PrunesRunning != 0 || stats.DeletedKeys != 8 {
+		t.Fatalf("unexpected stats after prune: %+v", stats)
+	}
+
+	// the cache counts its hits and misses
+	backend := New(testPrefix, newMemoryDB())
+	if _, err := backend.Put(1, foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	cached := NewCachingDB(backend, testPrefix, newMemoryDB())
+	for i := 0; i < 2; i++ {
+		if _, err := cached.Get(RootGindex, anchor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cached.Stats(); stats.Puts != 1 || stats.Gets != 2 || stats.CacheHits != 1 || stats.CacheMisses != 1 {
+		t.Fatalf("unexpected cache stats: %+v", stats)
+	}
+
+	PublishStats("merkledb_test", mdb)
+	// publishing again takes over the name
+	PublishStats("merkledb_test", cached)
+	var published Stats
+	if err := json.Unmarshal([]byte(expvar.Get("merkledb_test").String()), &published); err != nil {
+		t.Fatal(err)
+	}
+	if published != cached.Stats() {
+		t.Fatalf("expected the stats of the last published db, got %+v", published)
+	}
+}
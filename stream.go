@@ -0,0 +1,207 @@
+package merkledb
+
+import (
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"io"
+	"time"
+)
+
+// StreamNode is a node record as it arrives from a NodeSource
+type StreamNode struct {
+	Gindex Gindex
+	Root   Root
+	// Pair is false for leaf nodes
+	Pair  bool
+	Left  Root
+	Right Root
+	// Slot is the slot the node was stored at, by StoredSource. Zero for nodes that are not stored, and ignored by PutStream.
+	Slot uint64
+}
+
+// NodeSource produces the nodes of a tree, every parent before its children.
+// Next returns io.EOF when there are no nodes left.
+type NodeSource interface {
+	Next() (StreamNode, error)
+}
+
+type treeSource struct {
+	fn    HashFn
+	stack []struct {
+		gindex Gindex
+		node   Node
+	}
+}
+
+// TreeSource streams the nodes of an in-memory tree, depth-first, left before right.
+func TreeSource(node Node, fn HashFn) NodeSource {
+	fn = hashFnOrDefault(fn)
+	src := &treeSource{fn: fn}
+	src.push(RootGindex, node)
+	return src
+}
+
+func (s *treeSource) push(gindex Gindex, node Node) {
+	s.stack = append(s.stack, struct {
+		gindex Gindex
+		node   Node
+	}{gindex, node})
+}
+
+func (s *treeSource) Next() (StreamNode, error) {
+	if len(s.stack) == 0 {
+		return StreamNode{}, io.EOF
+	}
+	top := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	out := StreamNode{Gindex: top.gindex, Root: top.node.MerkleRoot(s.fn)}
+	if top.node.IsLeaf() {
+		return out, nil
+	}
+	left, err := top.node.Left()
+	if err != nil {
+		return StreamNode{}, err
+	}
+	right, err := top.node.Right()
+	if err != nil {
+		return StreamNode{}, err
+	}
+	out.Pair = true
+	out.Left = left.MerkleRoot(s.fn)
+	out.Right = right.MerkleRoot(s.fn)
+	s.push(top.gindex.Right(), right)
+	s.push(top.gindex.Left(), left)
+	return out, nil
+}
+
+func (db *merkleDB) PutStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts ...PutOption) (InsertReport, error) {
+	if db.opts.OnSlowQuery == nil {
+		return db.putStream(slot, anchor, nodes, fn, opts)
+	}
+	start := time.Now()
+	report, err := db.putStream(slot, anchor, nodes, fn, opts)
+	db.logSlow(start, SlowQuery{Op: "put", Gindex: RootGindex, Root: anchor, Slot: slot, Nodes: report.NewNodes, Err: err})
+	return report, err
+}
+
+func (db *merkleDB) putStream(slot uint64, anchor Root, nodes NodeSource, fn HashFn, opts []PutOption) (_ InsertReport, err error) {
+	release, replayed, err := db.claim(opts)
+	if err != nil || replayed {
+		return InsertReport{Replayed: replayed}, err
+	}
+	defer release()
+	// the watches are notified after the prune lock is released
+	defer func() {
+		if err == nil {
+			db.notifyWatches(anchor, slot, opts)
+		}
+	}()
+	fn = hashFnOrDefault(fn)
+	// the stream can only be consumed once, room is made for what is already stored
+	if err := db.makeRoom(Usage{}); err != nil {
+		return InsertReport{}, err
+	}
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	var buf [maxKeyLen]byte
+	// nodes that are referenced by a received parent, but were not received themselves yet
+	expected := make(map[string]struct{})
+	expect := func(gindex Gindex, root Root) error {
+		k, err := db.buildKey(&buf, gindex, root)
+		if err != nil {
+			return err
+		}
+		expected[string(k)] = struct{}{}
+		return nil
+	}
+	if err := expect(RootGindex, anchor); err != nil {
+		return InsertReport{}, err
+	}
+	b := new(leveldb.Batch)
+	var report InsertReport
+	fresh := applyPutOptions(opts).Fresh
+	maxDepth := db.maxDepth()
+	// the received nodes on the paths to the gindices of interest, for the compact history
+	paths := make(map[uint64]StreamNode)
+	for i := 0; ; i++ {
+		n, err := nodes.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return InsertReport{}, fmt.Errorf("failed to read node %d: %v", i, err)
+		}
+		if d := n.Gindex.Depth(); d > maxDepth {
+			return InsertReport{}, fmt.Errorf("node %d: %w", i, &DepthError{Depth: d, Max: maxDepth})
+		}
+		k, err := db.buildKey(&buf, n.Gindex, n.Root)
+		if err != nil {
+			return InsertReport{}, err
+		}
+		if _, ok := expected[string(k)]; !ok {
+			return InsertReport{}, fmt.Errorf("node %d (%v, %s) is not referenced by a received parent", i, n.Gindex, n.Root)
+		}
+		delete(expected, string(k))
+		if n.Pair {
+			start := time.Now()
+			valid := fn(n.Left, n.Right) == n.Root
+			report.HashTime += time.Since(start)
+			if !valid {
+				return InsertReport{}, fmt.Errorf("node %d (%v, %s) does not match the hash of its children", i, n.Gindex, n.Root)
+			}
+		}
+		exists := false
+		if !fresh {
+			if exists, err = db.storedFully(k); err != nil {
+				return InsertReport{}, err
+			}
+		}
+		if exists {
+			report.ReusedNodes += 1
+		} else {
+			b.Put(k, encodeValue(&PairRecord{Slot: slot, Pair: n.Pair, Left: n.Left, Right: n.Right}))
+			report.NewNodes += 1
+			if d := n.Gindex.Depth(); d > report.MaxDepth {
+				report.MaxDepth = d
+			}
+		}
+		if n.Pair && n.Gindex.Depth() < 64 {
+			g, err := gindexValue(n.Gindex)
+			if err != nil {
+				return InsertReport{}, err
+			}
+			for _, h := range db.opts.HistoryGindices {
+				if hg, err := gindexValue(h); err == nil && hg != g && within(hg, g) {
+					paths[g] = n
+					break
+				}
+			}
+		}
+		if n.Pair {
+			if err := expect(n.Gindex.Left(), n.Left); err != nil {
+				return InsertReport{}, err
+			}
+			if err := expect(n.Gindex.Right(), n.Right); err != nil {
+				return InsertReport{}, err
+			}
+		}
+	}
+	// nodes that were not received must be stored already, with their subtree
+	for k := range expected {
+		if exists, err := db.storedFully([]byte(k)); err != nil {
+			return InsertReport{}, err
+		} else if !exists {
+			return InsertReport{}, fmt.Errorf("stream ended with referenced nodes missing")
+		}
+		report.ReusedNodes += 1
+	}
+	if err := db.streamHistory(b, anchor, slot, paths, opts); err != nil {
+		return InsertReport{}, err
+	}
+	if err := db.putAnchor(b, anchor, slot, opts); err != nil {
+		return InsertReport{}, err
+	}
+	report.BytesWritten = len(b.Dump())
+	err = db.writePut(b, &report, opts)
+	return report, err
+}
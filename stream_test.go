@@ -0,0 +1,96 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"testing"
+)
+
+type sliceSource []StreamNode
+
+func (s *sliceSource) Next() (StreamNode, error) {
+	if len(*s) == 0 {
+		return StreamNode{}, io.EOF
+	}
+	n := (*s)[0]
+	*s = (*s)[1:]
+	return n, nil
+}
+
+func collectStream(t *testing.T, src NodeSource) sliceSource {
+	var out sliceSource
+	for {
+		n, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n)
+	}
+}
+
+func TestMerkleDB_PutStream(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := randomTree(10)
+	anchor := foo.MerkleRoot(hFn)
+	nodes := collectStream(t, TreeSource(foo, hFn))
+	if _, err := mdb.PutStream(7, anchor, &nodes, hFn); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Slot != 7 {
+		t.Fatalf("unexpected slot: %d", out.Slot)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+	if a, err := mdb.GetAnchor(anchor); err != nil || a.Slot != 7 {
+		t.Fatalf("expected anchor record, got %v, err: %v", a, err)
+	}
+
+	// a tree that shares the stored subtree only needs to stream the new nodes
+	left, _ := foo.Left()
+	bar := NewPairNode(left, randomRoot())
+	barNodes := collectStream(t, TreeSource(bar, hFn))
+	var partial sliceSource
+	for _, n := range barNodes {
+		if n.Gindex.Depth() == 0 || n.Gindex == RightGindex {
+			partial = append(partial, n)
+		}
+	}
+	if _, err := mdb.PutStream(8, bar.MerkleRoot(hFn), &partial, hFn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerkleDB_PutStreamInvalid(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := randomTree(6)
+	anchor := foo.MerkleRoot(hFn)
+
+	nodes := collectStream(t, TreeSource(foo, hFn))
+	nodes[1].Root = *randomRoot()
+	if _, err := mdb.PutStream(1, anchor, &nodes, hFn); err == nil {
+		t.Fatal("expected unreferenced node to be rejected")
+	}
+
+	nodes = collectStream(t, TreeSource(foo, hFn))
+	nodes[0].Left = *randomRoot()
+	if _, err := mdb.PutStream(1, anchor, &nodes, hFn); err == nil {
+		t.Fatal("expected node with bad children to be rejected")
+	}
+
+	nodes = collectStream(t, TreeSource(foo, hFn))
+	nodes = nodes[:len(nodes)-1]
+	if _, err := mdb.PutStream(1, anchor, &nodes, hFn); err == nil {
+		t.Fatal("expected incomplete stream to be rejected")
+	}
+	if ok, _ := mdb.Has(RootGindex, anchor); ok {
+		t.Fatal("expected nothing to be written")
+	}
+}
@@ -0,0 +1,124 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ErrSummarized is returned when a lookup descends into a node that is not stored,
+// while its parent is: the node was pruned or never stored below a summary, but its root is known and provable.
+// A node that is not known at all is reported as leveldb.ErrNotFound instead.
+// ErrSummarized matches leveldb.ErrNotFound with errors.Is.
+type ErrSummarized struct {
+	AtGindex Gindex
+	Root     Root
+}
+
+func (e ErrSummarized) Error() string {
+	depth, index, _ := GindexDepthIndex(e.AtGindex)
+	return fmt.Sprintf("node %s at depth %d index %d is summarized, its subtree is not stored", e.Root, depth, index)
+}
+
+func (e ErrSummarized) Unwrap() error {
+	return leveldb.ErrNotFound
+}
+
+// summarized converts a not-found error of a node with a known root into ErrSummarized
+func summarized(err error, gindex Gindex, root Root) error {
+	if err == leveldb.ErrNotFound {
+		return ErrSummarized{AtGindex: gindex, Root: root}
+	}
+	return err
+}
+
+// ErrSummaryNode is returned by Put for a tree with a summary node, as read from a summary or with WithSummaryLoad:
+// only its root is known, its subtree is not there to be stored. PutTop and PutSubtrees store summaries.
+var ErrSummaryNode = errors.New("cannot put a summary node, its subtree is not known")
+
+// summaryNode is a node of which only the root is known, not its subtree: reads below it fail with ErrSummarized.
+// Put rejects it, a partial put stores it as a summary record, which later puts do not reuse.
+type summaryNode struct {
+	gindex Gindex
+	root   Root
+}
+
+func (s *summaryNode) summarized() error {
+	return ErrSummarized{AtGindex: s.gindex, Root: s.root}
+}
+
+func (s *summaryNode) Left() (Node, error) {
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) Right() (Node, error) {
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) IsLeaf() bool {
+	return true
+}
+
+func (s *summaryNode) RebindLeft(v Node) (Node, error) {
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) RebindRight(v Node) (Node, error) {
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) Getter(target Gindex) (Node, error) {
+	if target.IsRoot() {
+		return s, nil
+	}
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) Setter(target Gindex, expand bool) (Link, error) {
+	if target.IsRoot() {
+		return Identity, nil
+	}
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) SummarizeInto(target Gindex, h HashFn) (SummaryLink, error) {
+	if target.IsRoot() {
+		return func() (Node, error) {
+			return s, nil
+		}, nil
+	}
+	return nil, s.summarized()
+}
+
+func (s *summaryNode) MerkleRoot(h HashFn) Root {
+	return s.root
+}
+
+// leafNode is the node of the record of a leaf: a summary node for a summary
+func leafNode(gindex Gindex, key Root, rec *PairRecord) Node {
+	if rec.Summary {
+		return &summaryNode{gindex: gindex, root: key}
+	}
+	return &key
+}
+
+// leafError is the error of a navigation below the stored node of the record, which is not a pair:
+// ErrSummarized for a summary, NavigationError for a leaf.
+func leafError(rec *PairRecord, gindex Gindex, root Root) error {
+	if rec.Summary {
+		return ErrSummarized{AtGindex: gindex, Root: root}
+	}
+	return NavigationError
+}
+
+// descend reads the record of a node to navigate below it, failing with ErrSummarized at a summary
+func (db *merkleDB) descend(gindex Gindex, key Root, dst *PairRecord) error {
+	if err := db.GetInto(gindex, key, dst); err != nil {
+		return err
+	}
+	if dst.Summary {
+		return ErrSummarized{AtGindex: gindex, Root: key}
+	}
+	return nil
+}
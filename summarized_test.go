@@ -0,0 +1,47 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"testing"
+)
+
+func TestErrSummarized(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := fullTree(4)
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	pruned, _ := foo.Getter(Gindex64(0b101))
+	if err := mdb.Delete(Gindex64(0b101), pruned.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	out, err := mdb.Get(RootGindex, foo.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = out.Node.Getter(Gindex64(0b10110))
+	var s ErrSummarized
+	if !errors.As(err, &s) {
+		t.Fatalf("expected summarized error, got %v", err)
+	}
+	if g, _ := gindexValue(s.AtGindex); g != 0b101 || s.Root != pruned.MerkleRoot(hFn) {
+		t.Fatalf("unexpected summarized node: %v", s)
+	}
+	if !errors.Is(err, leveldb.ErrNotFound) {
+		t.Fatal("expected summarized error to match not found")
+	}
+	if _, err := mdb.(*merkleDB).lookup(foo.MerkleRoot(hFn), Gindex64(0b10110)); !errors.As(err, &s) {
+		t.Fatalf("expected summarized lookup error, got %v", err)
+	}
+	// a node that is not known at all is just not found
+	if _, err := mdb.(*merkleDB).lookup(Root{1}, Gindex64(0b10110)); err != leveldb.ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	// the sibling subtree is still readable
+	if _, err := out.Node.Getter(Gindex64(0b10010)); err != nil {
+		t.Fatal(err)
+	}
+}
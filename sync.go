@@ -0,0 +1,22 @@
+package merkledb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// metaSyncBarrier is never stored: Sync deletes it, to have a write to sync
+const metaSyncBarrier byte = 's'
+
+func (db *merkleDB) Sync() error {
+	// the values go first, the synced journal makes the pointers to them durable
+	if db.vlog != nil {
+		if err := db.vlog.sync(); err != nil {
+			return err
+		}
+	}
+	// leveldb skips empty batches, and every write syncs the journal up to and including itself
+	b := new(leveldb.Batch)
+	b.Delete(db.metaKey(metaSyncBarrier, nil))
+	return db.db.Write(b, &opt.WriteOptions{Sync: true})
+}
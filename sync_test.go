@@ -0,0 +1,84 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyDir copies the files of the directory, like they would be found after a crash
+func copyDir(t *testing.T, src string, dst string) {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == "LOCK" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dst, e.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleDB_Sync(t *testing.T) {
+	dir := t.TempDir()
+	hFn := GetHashFn()
+	vlog := ValueLogOptions{Dir: filepath.Join(dir, "values"), MinSize: 64}
+	mdb, err := OpenFile(filepath.Join(dir, "db"), WithValueLog(vlog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdb.Close()
+	tree := randomTree(5)
+	root := tree.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	blob := bytes.Repeat([]byte{7}, 100)
+	if err := mdb.PutBlob(root, blob); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// the barrier of the synced write is not stored
+	if ok, err := mdb.(*merkleDB).db.Has(mdb.(*merkleDB).metaKey(metaSyncBarrier, nil), nil); err != nil || ok {
+		t.Fatalf("expected no barrier key, err: %v", err)
+	}
+
+	// the files of the open DB have every synced write
+	crashed := filepath.Join(dir, "crashed")
+	copyDir(t, filepath.Join(dir, "db"), filepath.Join(crashed, "db"))
+	copyDir(t, vlog.Dir, filepath.Join(crashed, "values"))
+	vlog.Dir = filepath.Join(crashed, "values")
+	recovered, err := OpenFile(filepath.Join(crashed, "db"), WithValueLog(vlog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	out, err := recovered.Get(RootGindex, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(tree, out.Node, RootGindex, hFn, t)
+	if got, err := recovered.GetBlob(root); err != nil || !bytes.Equal(got, blob) {
+		t.Fatalf("expected the synced blob, got %x, err: %v", got, err)
+	}
+
+	c := NewCachingDB(mdb, testPrefix, newMemoryDB())
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
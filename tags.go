@@ -0,0 +1,271 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+)
+
+// metaTags records the tags of an anchor, by its root
+const metaTags byte = 'g'
+
+const tagsVersion = 0
+
+// maxTagLen is the maximum length of a tag in bytes
+const maxTagLen = 1<<16 - 1
+
+// WithTags tags the anchor of the put tree, in addition to the tags it has from earlier puts. See Tag.
+func WithTags(tags ...string) PutOption {
+	return func(o *PutOptions) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+func checkTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" {
+			return errors.New("empty tag")
+		}
+		if len(tag) > maxTagLen {
+			return fmt.Errorf("tag of %d bytes, the maximum is %d", len(tag), maxTagLen)
+		}
+	}
+	return nil
+}
+
+// encodeTags encodes the tags, which are sorted and without duplicates
+func encodeTags(tags []string) []byte {
+	size := 1
+	for _, tag := range tags {
+		size += 2 + len(tag)
+	}
+	out := make([]byte, 1, size)
+	out[0] = tagsVersion
+	for _, tag := range tags {
+		out = append(out, byte(len(tag)), byte(len(tag)>>8))
+		out = append(out, tag...)
+	}
+	return out
+}
+
+func decodeTags(v []byte) ([]string, error) {
+	if len(v) < 1 || v[0] != tagsVersion {
+		return nil, fmt.Errorf("%w: corrupt tags record: '%x'", ErrMalformed, v)
+	}
+	var out []string
+	for rest := v[1:]; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("%w: corrupt tags record: '%x'", ErrMalformed, v)
+		}
+		size := int(binary.LittleEndian.Uint16(rest))
+		if len(rest) < 2+size {
+			return nil, fmt.Errorf("%w: corrupt tags record: '%x'", ErrMalformed, v)
+		}
+		out = append(out, string(rest[2:2+size]))
+		rest = rest[2+size:]
+	}
+	return out, nil
+}
+
+// mergeTags adds the tags to the sorted set of tags, and returns the sorted set
+func mergeTags(set []string, tags []string) []string {
+	out := append(append(make([]string, 0, len(set)+len(tags)), set...), tags...)
+	sort.Strings(out)
+	i := 0
+	for _, tag := range out {
+		if i == 0 || out[i-1] != tag {
+			out[i] = tag
+			i++
+		}
+	}
+	return out[:i]
+}
+
+func hasTag(set []string, tag string) bool {
+	i := sort.SearchStrings(set, tag)
+	return i < len(set) && set[i] == tag
+}
+
+// putTags adds the tags of a put of the anchor to the batch. A tree that is put again keeps its tags,
+// the tags of a tree that was deleted are stale, and replaced.
+func (db *merkleDB) putTags(b *leveldb.Batch, root Root, tags []string, again bool) error {
+	if err := checkTags(tags); err != nil {
+		return err
+	}
+	key := db.metaKey(metaTags, root[:])
+	if len(tags) > 0 {
+		var prev []string
+		if again {
+			var err error
+			if prev, err = db.Tags(root); err != nil {
+				return err
+			}
+		}
+		b.Put(key, encodeTags(mergeTags(prev, tags)))
+	} else if !again {
+		if stale, err := db.r.Has(key, nil); err != nil {
+			return err
+		} else if stale {
+			b.Delete(key)
+		}
+	}
+	return nil
+}
+
+func (db *merkleDB) Tag(root Root, tags ...string) error {
+	return db.updateTags(root, func(set []string) []string {
+		return mergeTags(set, tags)
+	}, tags)
+}
+
+func (db *merkleDB) Untag(root Root, tags ...string) error {
+	removed := mergeTags(nil, tags)
+	return db.updateTags(root, func(set []string) []string {
+		out := set[:0]
+		for _, tag := range set {
+			if !hasTag(removed, tag) {
+				out = append(out, tag)
+			}
+		}
+		return out
+	}, tags)
+}
+
+// updateTags changes the tags of the stored anchor, hidden or not
+func (db *merkleDB) updateTags(root Root, fn func(set []string) []string, tags []string) error {
+	if err := checkTags(tags); err != nil {
+		return err
+	}
+	// a prune must not delete the tags between the check of the anchor and the write
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	if ok, err := db.anchored(root); err != nil {
+		return err
+	} else if !ok {
+		return leveldb.ErrNotFound
+	}
+	prev, err := db.Tags(root)
+	if err != nil {
+		return err
+	}
+	if set := fn(prev); len(set) > 0 {
+		return db.writeKey(db.metaKey(metaTags, root[:]), encodeTags(set))
+	}
+	return db.deleteKey(db.metaKey(metaTags, root[:]))
+}
+
+func (db *merkleDB) Tags(root Root) ([]string, error) {
+	v, err := db.getMeta(metaTags, root[:])
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tags, err := decodeTags(v)
+	if err != nil {
+		return nil, corruptMeta(metaTags, root[:], v, err)
+	}
+	return tags, nil
+}
+
+func (db *merkleDB) TaggedAnchors(tag string) ([]Anchor, error) {
+	iter := db.r.NewIterator(util.BytesPrefix(db.metaKey(metaTags, nil)), nil)
+	defer iter.Release()
+	var out []Anchor
+	for iter.Next() {
+		if err := db.checkValue(metaTags, iter.Key()[metaKeyLen:], iter.Value()); err != nil {
+			return nil, err
+		}
+		set, err := decodeTags(iter.Value())
+		if err != nil {
+			return nil, corruptMeta(metaTags, iter.Key()[metaKeyLen:], iter.Value(), err)
+		}
+		if !hasTag(set, tag) {
+			continue
+		}
+		// hidden anchors have no anchor record, and the tags of deleted anchors wait for the next prune
+		a, err := db.GetAnchor(toRoot(iter.Key()[metaKeyLen:]))
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *merkleDB) TaggedRange(startSlot uint64, endSlot uint64, gindex Gindex, tag string) ([]SlottedNode, error) {
+	anchors, err := db.TaggedAnchors(tag)
+	if err != nil {
+		return nil, err
+	}
+	return db.anchorRange(anchors, startSlot, endSlot, gindex)
+}
+
+func (db *merkleDB) PruneTagged(tag string) (int, error) {
+	pruned := 0
+	// the anchors are listed by the prune, the untagged anchors of concurrent puts are kept
+	err := db.prune(func() ([]Root, map[Root][]uint64, error) {
+		anchors, err := db.Anchors()
+		if err != nil {
+			return nil, nil, err
+		}
+		retained, err := db.retained()
+		if err != nil {
+			return nil, nil, err
+		}
+		live := make([]Root, 0, len(anchors))
+		for i := range anchors {
+			root := anchors[i].Root
+			if _, ok := retained[root]; !ok {
+				tags, err := db.Tags(root)
+				if err != nil {
+					return nil, nil, err
+				}
+				if hasTag(tags, tag) {
+					pruned++
+					continue
+				}
+			}
+			live = append(live, root)
+		}
+		if pruned == 0 {
+			return nil, nil, errNoPrune
+		}
+		return live, nil, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// pruneTags deletes the tags of the roots without an anchor, hidden or not
+func (db *merkleDB) pruneTags() error {
+	prefix := db.metaKey(metaTags, nil)
+	iter := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	w := db.newDeleteWriter()
+	for iter.Next() {
+		if ok, err := db.anchored(toRoot(iter.Key()[len(prefix):])); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		if err := w.delete(iter.Key()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return w.flush()
+}
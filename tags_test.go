@@ -0,0 +1,99 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"reflect"
+	"testing"
+)
+
+func TestMerkleDB_Tags(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB())
+	a, b, c := randomTree(4), randomTree(4), randomTree(4)
+	rootA, rootB, rootC := a.MerkleRoot(hFn), b.MerkleRoot(hFn), c.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, a, hFn, WithTags("checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, b, hFn, WithTags("experiment-42", "checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(3, c, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Tag(rootC, "experiment-42"); err != nil {
+		t.Fatal(err)
+	}
+	// a tree that is put again keeps its tags
+	if _, err := mdb.Put(2, b, hFn, WithTags("ws-state")); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := mdb.Tags(rootB); err != nil || !reflect.DeepEqual(tags, []string{"checkpoint", "experiment-42", "ws-state"}) {
+		t.Fatalf("unexpected tags: %v, err: %v", tags, err)
+	}
+	if err := mdb.Tag(rootA, ""); err == nil {
+		t.Fatal("expected an empty tag to fail")
+	}
+	if err := mdb.Tag(*randomRoot(), "checkpoint"); err == nil {
+		t.Fatal("expected tagging an unknown anchor to fail")
+	}
+
+	expectTagged := func(tag string, expected ...Root) {
+		t.Helper()
+		anchors, err := mdb.TaggedAnchors(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, want := map[Root]bool{}, map[Root]bool{}
+		for i := range anchors {
+			got[anchors[i].Root] = true
+		}
+		for _, r := range expected {
+			want[r] = true
+		}
+		if len(anchors) != len(expected) || !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected anchors tagged %q: %v", tag, anchors)
+		}
+	}
+	expectTagged("checkpoint", rootA, rootB)
+	expectTagged("experiment-42", rootB, rootC)
+	expectTagged("unknown")
+
+	nodes, err := mdb.TaggedRange(0, 10, Gindex64(2), "experiment-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Slot != 2 || nodes[1].Slot != 3 || nodes[0].Node.MerkleRoot(hFn) != b.(*PairNode).LeftChild.MerkleRoot(hFn) {
+		t.Fatalf("unexpected tagged range: %v", nodes)
+	}
+
+	// tags survive a backup
+	var buf bytes.Buffer
+	if _, err := mdb.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := New(testPrefix, newMemoryDB())
+	if _, err := restored.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := restored.Tags(rootC); err != nil || !reflect.DeepEqual(tags, []string{"experiment-42"}) {
+		t.Fatalf("unexpected restored tags: %v, err: %v", tags, err)
+	}
+
+	if err := mdb.Untag(rootB, "experiment-42"); err != nil {
+		t.Fatal(err)
+	}
+	expectTagged("experiment-42", rootC)
+	if n, err := mdb.PruneTagged("checkpoint"); err != nil || n != 2 {
+		t.Fatalf("expected 2 pruned anchors, got %d, err: %v", n, err)
+	}
+	expectAnchors(t, mdb, rootC)
+	// the tags of the pruned anchors are deleted with them
+	if tags, err := mdb.Tags(rootA); err != nil || tags != nil {
+		t.Fatalf("expected no tags of the pruned anchor, got %v, err: %v", tags, err)
+	}
+	if _, err := mdb.Put(4, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	expectTagged("checkpoint")
+}
@@ -0,0 +1,212 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/protolambda/merkledb/lite"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+)
+
+// tombstoneKey derives the tombstone key from a node key
+func (db *merkleDB) tombstoneKey(nodeKey []byte) []byte {
+	return db.metaKey(metaTombstone, nodeKey[prefixLen:])
+}
+
+// tombstoneAnchors drops the anchors that are not live, and tombstones their root nodes.
+func (db *merkleDB) tombstoneAnchors(liveRoots []Root) error {
+	live := make(map[Root]struct{}, len(liveRoots))
+	for _, root := range liveRoots {
+		live[root] = struct{}{}
+	}
+	anchors, err := db.Anchors()
+	if err != nil {
+		return err
+	}
+	var buf [maxKeyLen]byte
+	b := new(leveldb.Batch)
+	for i := range anchors {
+		root := anchors[i].Root
+		if _, ok := live[root]; ok {
+			continue
+		}
+		k, err := db.buildKey(&buf, RootGindex, root)
+		if err != nil {
+			return err
+		}
+		b.Put(db.tombstoneKey(k), nil)
+		b.Delete(db.metaKey(metaAnchor, root[:]))
+	}
+	return db.write(b)
+}
+
+// tombstoneNode is the value of the tombstones of Delete, which reclaim the node only.
+// The tombstones of prunes are empty, and reclaim the nodes below them that are not reachable from any anchor too.
+const tombstoneNode byte = 1
+
+type tombstone struct {
+	gindex Gindex
+	root   Root
+	key    []byte
+	// node is true if only the node is reclaimed, not the subtree below it
+	node bool
+}
+
+func (db *merkleDB) tombstones() ([]tombstone, error) {
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaTombstone, nil)), nil)
+	defer iter.Release()
+	var out []tombstone
+	for iter.Next() {
+		k := iter.Key()
+		id := k[metaKeyLen:]
+		if len(id) < gindexLenByteLen+32 {
+			return nil, corruptMeta(metaTombstone, id, iter.Value(), errors.New("corrupt tombstone key"))
+		}
+		bitLen := uint32(binary.LittleEndian.Uint16(id[:gindexLenByteLen]))
+		gindex, err := lite.GindexFromKey(id[gindexLenByteLen:len(id)-32], bitLen)
+		if err != nil {
+			return nil, corruptMeta(metaTombstone, id, iter.Value(), err)
+		}
+		if err := db.checkValue(metaTombstone, id, iter.Value()); err != nil {
+			return nil, err
+		}
+		t := tombstone{gindex: gindex, key: append([]byte(nil), k...), node: len(iter.Value()) == 1}
+		copy(t.root[:], id[len(id)-32:])
+		out = append(out, t)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (db *merkleDB) Reclaim() (int, error) {
+	defer db.resetUsage()
+	defer db.resetPrefetch()
+	defer db.resetProofs()
+	db.pruneLock.Lock()
+	defer db.pruneLock.Unlock()
+	tombstones, err := db.tombstones()
+	if err != nil || len(tombstones) == 0 {
+		return 0, err
+	}
+	anchors, err := db.Anchors()
+	if err != nil {
+		return 0, err
+	}
+	liveRoots := make([]Root, len(anchors))
+	for i := range anchors {
+		liveRoots[i] = anchors[i].Root
+	}
+	hidden, err := db.hiddenRoots()
+	if err != nil {
+		return 0, err
+	}
+	liveRoots = append(liveRoots, hidden...)
+	// the marked set doubles as visited set for the sweep
+	marked, err := db.mark(liveRoots)
+	if err != nil {
+		return 0, err
+	}
+	defer marked.close()
+	var starts []NodeRef
+	for _, t := range tombstones {
+		if !t.node {
+			starts = append(starts, NodeRef{Gindex: t.gindex, Root: t.root})
+		}
+	}
+	keys, err := db.sweep(marked, starts)
+	if err != nil {
+		return 0, err
+	}
+	// the deleted nodes go whether they are reachable or not, like the deletes that are not deferred
+	swept := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		swept[string(k)] = struct{}{}
+	}
+	for _, t := range tombstones {
+		if !t.node {
+			continue
+		}
+		k := append(append([]byte(nil), db.prefix[:]...), t.key[metaKeyLen:]...)
+		if _, ok := swept[string(k)]; ok {
+			continue
+		}
+		if ok, err := db.db.Has(k, nil); err != nil {
+			return 0, err
+		} else if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	w := db.newDeleteWriter()
+	for _, k := range keys {
+		if err := w.delete(k); err != nil {
+			return 0, err
+		}
+	}
+	// tombstones go last, an interrupted reclaim is picked up again by the next one
+	b := new(leveldb.Batch)
+	for _, t := range tombstones {
+		b.Delete(t.key)
+	}
+	if err := w.flush(); err != nil {
+		return 0, err
+	}
+	if err := db.pruneIndexes(); err != nil {
+		return 0, err
+	}
+	// the deferred prunes deleted the pointers into the value log, their files can go now
+	if _, err := db.collectValueLog(); err != nil {
+		return 0, err
+	}
+	return len(keys), db.write(b)
+}
+
+// sweep collects the keys of the stored nodes below, and including, the start nodes, that are not marked.
+// The marked set doubles as visited set. The keys are returned in sorted order.
+func (db *merkleDB) sweep(marked *markSet, starts []NodeRef) ([][]byte, error) {
+	var keys [][]byte
+	var buf [maxKeyLen]byte
+	var visit func(gindex Gindex, root Root) error
+	visit = func(gindex Gindex, root Root) error {
+		k, err := db.buildKey(&buf, gindex, root)
+		if err != nil {
+			return err
+		}
+		if ok, err := marked.has(k); err != nil || ok {
+			return err
+		}
+		if err := marked.add(k); err != nil {
+			return err
+		}
+		var rec PairRecord
+		if err := db.getLocal(gindex, root, &rec); err == leveldb.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		if !rec.Pair {
+			return nil
+		}
+		if err := visit(gindex.Left(), rec.Left); err != nil {
+			return err
+		}
+		return visit(gindex.Right(), rec.Right)
+	}
+	for _, s := range starts {
+		if err := visit(s.Gindex, s.Root); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys, nil
+}
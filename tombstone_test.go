@@ -0,0 +1,79 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_DeferredPrune(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithDeferredDeletes(0)).(*merkleDB)
+	hFn := GetHashFn()
+	a := randomTree(8)
+	aLeft, _ := a.Left()
+	b := NewPairNode(aLeft, randomTree(4))
+	if _, err := mdb.Put(1, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(2, b, hFn); err != nil {
+		t.Fatal(err)
+	}
+	before := countKeys(t, mdb)
+	if err := mdb.Prune([]Root{b.MerkleRoot(hFn)}); err != nil {
+		t.Fatal(err)
+	}
+	// nodes are still there until reclaimed
+	if ok, _ := mdb.Has(RootGindex, a.MerkleRoot(hFn)); !ok {
+		t.Fatal("expected tombstoned node to still exist")
+	}
+	n, err := mdb.Reclaim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("expected nodes to be reclaimed")
+	}
+	if ok, _ := mdb.Has(RootGindex, a.MerkleRoot(hFn)); ok {
+		t.Fatal("expected tombstoned node to be reclaimed")
+	}
+	if after := countKeys(t, mdb); after >= before {
+		t.Fatalf("expected keys to be reclaimed, before: %d, after: %d", before, after)
+	}
+	out, err := mdb.Get(RootGindex, b.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(b, out.Node, RootGindex, hFn, t)
+	if n, err := mdb.Reclaim(); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to reclaim, got %d, err: %v", n, err)
+	}
+}
+
+func TestMerkleDB_DeferredDeleteLive(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB(), WithDeferredDeletes(0))
+	hFn := GetHashFn()
+	a := fullTree(4)
+	if _, err := mdb.Put(1, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	left, _ := a.Left()
+	if err := mdb.Delete(LeftGindex, left.MerkleRoot(hFn)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mdb.Has(LeftGindex, left.MerkleRoot(hFn)); !ok {
+		t.Fatal("expected the tombstoned node to stay until the reclaim")
+	}
+	// like a delete that is not deferred, only the node goes, even though it is reachable from the anchor
+	if n, err := mdb.Reclaim(); err != nil || n != 1 {
+		t.Fatalf("expected 1 reclaimed node, got %d, err: %v", n, err)
+	}
+	if ok, _ := mdb.Has(LeftGindex, left.MerkleRoot(hFn)); ok {
+		t.Fatal("expected the deleted node to be reclaimed")
+	}
+	leftLeft, _ := left.Left()
+	if ok, _ := mdb.Has(LeftGindex.Left(), leftLeft.MerkleRoot(hFn)); !ok {
+		t.Fatal("expected the subtree below the deleted node to stay")
+	}
+	if n, err := mdb.Reclaim(); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to reclaim, got %d, err: %v", n, err)
+	}
+}
@@ -0,0 +1,68 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+	"sync/atomic"
+)
+
+func (db *merkleDB) Transplant(srcGindex Gindex, srcRoot Root, dstGindex Gindex) (InsertReport, error) {
+	defer db.resetUsage()
+	db.pruneLock.RLock()
+	defer db.pruneLock.RUnlock()
+	b := new(leveldb.Batch)
+	var report InsertReport
+	var buf [maxKeyLen]byte
+	var copyNode func(src Gindex, dst Gindex, root Root) error
+	copyNode = func(src Gindex, dst Gindex, root Root) error {
+		// Gindex64 silently overflows past 63 bits
+		if src.Depth() >= 63 || dst.Depth() >= 63 {
+			return errors.New("gindex too large")
+		}
+		k, err := db.buildKey(&buf, dst, root)
+		if err != nil {
+			return err
+		}
+		// if the node is already stored at the destination, then so is its subtree
+		if exists, err := db.storedFully(k); err != nil {
+			return err
+		} else if exists {
+			report.ReusedNodes += 1
+			return nil
+		}
+		var rec PairRecord
+		if err := db.getLocal(src, root, &rec); err == leveldb.ErrNotFound {
+			// partially stored source tree, the destination will be partial too
+			return nil
+		} else if err != nil {
+			return err
+		}
+		b.Put(k, encodeValue(&rec))
+		report.NewNodes += 1
+		if d := dst.Depth(); d > report.MaxDepth {
+			report.MaxDepth = d
+		}
+		if !rec.Pair {
+			return nil
+		}
+		if err := copyNode(src.Left(), dst.Left(), rec.Left); err != nil {
+			return err
+		}
+		return copyNode(src.Right(), dst.Right(), rec.Right)
+	}
+	if ok, err := db.Has(srcGindex, srcRoot); err != nil {
+		return InsertReport{}, err
+	} else if !ok {
+		return InsertReport{}, leveldb.ErrNotFound
+	}
+	if err := copyNode(srcGindex, dstGindex, srcRoot); err != nil {
+		return InsertReport{}, err
+	}
+	report.BytesWritten = len(b.Dump())
+	if err := db.write(b); err != nil {
+		return report, err
+	}
+	atomic.AddUint64(&db.counters.puts, 1)
+	return report, nil
+}
@@ -0,0 +1,42 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Transplant(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	sub := randomTree(8)
+	a := NewPairNode(sub, randomRoot())
+	if _, err := mdb.Put(5, a, hFn); err != nil {
+		t.Fatal(err)
+	}
+	// move the subtree from gindex 2 to gindex 6
+	report, err := mdb.Transplant(LeftGindex, sub.MerkleRoot(hFn), Gindex64(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NewNodes == 0 {
+		t.Fatal("expected nodes to be copied")
+	}
+	out, err := mdb.Get(Gindex64(6), sub.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Slot != 5 {
+		t.Fatalf("expected slot to be kept, got %d", out.Slot)
+	}
+	compareNodes(sub, out.Node, Gindex64(6), hFn, t)
+
+	// a restructured tree reuses the transplanted subtree
+	b := NewPairNode(randomRoot(), NewPairNode(sub, randomRoot()))
+	report, err = mdb.Put(6, b, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReusedNodes != 1 || report.NewNodes != 4 {
+		t.Fatalf("expected transplanted subtree to be reused: %+v", report)
+	}
+}
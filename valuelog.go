@@ -0,0 +1,563 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metaValuePointer stores the pointers into the value log, by the kind and id of the metadata they hold the value of
+const metaValuePointer byte = 'v'
+
+// DefaultValueLogFileSize is the size that value log files are rotated at if none is given
+const DefaultValueLogFileSize = 256 << 20
+
+const valuePointerLen = 4 + 8 + 4 + 4
+
+const valueLogExt = ".vlog"
+
+var valueLogTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ValueLogOptions configures the storage of large values in value files, see WithValueLog
+type ValueLogOptions struct {
+	// Dir is the directory of the value files. The files are named by the prefix, the merkledbs of other prefixes
+	// can share the directory, the merkledbs of the same prefix in other leveldbs can not.
+	Dir string
+	// MinSize is the size from which values are stored in the value files
+	MinSize int
+	// FileSize is the size that the value files are rotated at, DefaultValueLogFileSize if 0
+	FileSize int64
+	// Sync syncs every appended value to disk before the pointer to it is written
+	Sync bool
+	// MinLiveRatio is the fraction of live bytes below which the live values of a file are moved to the current file,
+	// so the file can be removed. Only files without live values are removed if 0.
+	MinLiveRatio float64
+}
+
+// valuePointer locates a value in the value files, with the checksum of the value
+type valuePointer struct {
+	file     uint32
+	offset   uint64
+	size     uint32
+	checksum uint32
+}
+
+func (p *valuePointer) encode() []byte {
+	var out [valuePointerLen]byte
+	binary.LittleEndian.PutUint32(out[0:4], p.file)
+	binary.LittleEndian.PutUint64(out[4:12], p.offset)
+	binary.LittleEndian.PutUint32(out[12:16], p.size)
+	binary.LittleEndian.PutUint32(out[16:20], p.checksum)
+	return out[:]
+}
+
+func (p *valuePointer) decode(v []byte) error {
+	if len(v) != valuePointerLen {
+		return fmt.Errorf("corrupt value pointer, invalid length: %d", len(v))
+	}
+	p.file = binary.LittleEndian.Uint32(v[0:4])
+	p.offset = binary.LittleEndian.Uint64(v[4:12])
+	p.size = binary.LittleEndian.Uint32(v[12:16])
+	p.checksum = binary.LittleEndian.Uint32(v[16:20])
+	return nil
+}
+
+// valueLog appends large values to files, and leaves only pointers to them in leveldb.
+// Files are written once and never changed: a new file is started when the current one is full, or reopened,
+// and a file is removed when no pointer into it is left, see collect.
+// Snapshots may still hold pointers into a collected file: its removal waits until they are released, see acquire.
+type valueLog struct {
+	// name starts the names of the files, the hex prefix of the merkledb
+	name string
+	opts ValueLogOptions
+	lock sync.Mutex
+	// current is the file that values are appended to, nil until the first append
+	current     *os.File
+	currentID   uint32
+	currentSize int64
+	// readers are the open files to read values from
+	readers map[uint32]*os.File
+	// gen counts the acquired snapshots, snapshots are the generations of the snapshots that are not released
+	gen       uint64
+	snapshots map[uint64]struct{}
+	// pending are the collected files that snapshots may still read, by the last generation when they were collected
+	pending map[uint32]uint64
+}
+
+func newValueLog(prefix [prefixLen]byte, opts ValueLogOptions) *valueLog {
+	if opts.FileSize <= 0 {
+		opts.FileSize = DefaultValueLogFileSize
+	}
+	return &valueLog{name: fmt.Sprintf("%x-", prefix[:]), opts: opts, readers: make(map[uint32]*os.File),
+		snapshots: make(map[uint64]struct{}), pending: make(map[uint32]uint64)}
+}
+
+func (l *valueLog) path(id uint32) string {
+	return filepath.Join(l.opts.Dir, fmt.Sprintf("%s%08d%s", l.name, id, valueLogExt))
+}
+
+// files lists the ids of the value files of the prefix in the directory
+func (l *valueLog) files() ([]uint32, error) {
+	entries, err := ioutil.ReadDir(l.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []uint32
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, l.name) || !strings.HasSuffix(name, valueLogExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, l.name), valueLogExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		out = append(out, uint32(id))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out, nil
+}
+
+// rotate starts a new file after the last file in the directory
+func (l *valueLog) rotate() error {
+	if l.current != nil {
+		// the pointers into the file may be synced later, see sync
+		if err := l.current.Sync(); err != nil {
+			return err
+		}
+		if err := l.current.Close(); err != nil {
+			return err
+		}
+		l.current = nil
+	}
+	if _, err := os.Stat(l.opts.Dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(l.opts.Dir, 0o755); err != nil {
+			return err
+		}
+		if err := syncDir(filepath.Dir(filepath.Clean(l.opts.Dir))); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	ids, err := l.files()
+	if err != nil {
+		return err
+	}
+	id := uint32(0)
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	f, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// the pointers into the file are lost with the file if its directory entry is not durable
+	if err := syncDir(l.opts.Dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.current, l.currentID, l.currentSize = f, id, 0
+	return nil
+}
+
+// syncDir syncs the directory, to make the files that were created or removed in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// append writes the value to the current file, and returns the pointer to it
+func (l *valueLog) append(v []byte) (valuePointer, error) {
+	if uint64(len(v)) > 1<<32-1 {
+		return valuePointer{}, fmt.Errorf("value of %d bytes too large for the value log", len(v))
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.current == nil || (l.currentSize > 0 && l.currentSize+int64(len(v)) > l.opts.FileSize) {
+		if err := l.rotate(); err != nil {
+			return valuePointer{}, fmt.Errorf("failed to start a value file: %w", err)
+		}
+	}
+	p := valuePointer{file: l.currentID, offset: uint64(l.currentSize), size: uint32(len(v)),
+		checksum: crc32.Checksum(v, valueLogTable)}
+	n, err := l.current.Write(v)
+	l.currentSize += int64(n)
+	if err != nil {
+		return valuePointer{}, err
+	}
+	if l.opts.Sync {
+		if err := l.current.Sync(); err != nil {
+			return valuePointer{}, err
+		}
+	}
+	return p, nil
+}
+
+// read reads the value that the pointer points to, and checks it against the checksum
+func (l *valueLog) read(p valuePointer) ([]byte, error) {
+	l.lock.Lock()
+	f, ok := l.readers[p.file]
+	if !ok {
+		var err error
+		if f, err = os.Open(l.path(p.file)); err != nil {
+			l.lock.Unlock()
+			return nil, err
+		}
+		l.readers[p.file] = f
+	}
+	l.lock.Unlock()
+	out := make([]byte, p.size)
+	if _, err := f.ReadAt(out, int64(p.offset)); err != nil {
+		return nil, fmt.Errorf("failed to read value of file %d at %d: %w", p.file, p.offset, err)
+	}
+	if crc32.Checksum(out, valueLogTable) != p.checksum {
+		return nil, fmt.Errorf("corrupt value of file %d at %d: checksum mismatch", p.file, p.offset)
+	}
+	return out, nil
+}
+
+// collect removes the files that hold no live values, other than the current file.
+// The files that open snapshots may read are removed when the snapshots are released, see release.
+func (l *valueLog) collect(live map[uint32]struct{}) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ids, err := l.files()
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, ok := live[id]; ok || (l.current != nil && id == l.currentID) {
+			continue
+		}
+		if _, ok := l.pending[id]; !ok {
+			l.pending[id] = l.gen
+		}
+	}
+	return l.removePending()
+}
+
+// acquire registers a snapshot that may read the current files, before the leveldb snapshot is taken,
+// and returns the function that releases it
+func (l *valueLog) acquire() func() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.gen += 1
+	gen := l.gen
+	l.snapshots[gen] = struct{}{}
+	return func() {
+		l.release(gen)
+	}
+}
+
+// release forgets the snapshot, and removes the collected files that no other snapshot may read.
+// Files that fail to be removed stay pending, for the next collect.
+func (l *valueLog) release(gen uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.snapshots, gen)
+	_, _ = l.removePending()
+}
+
+// removePending removes the pending files that were collected after every open snapshot was taken.
+// The lock must be held.
+func (l *valueLog) removePending() (int, error) {
+	removed := 0
+	var err error
+	for id, collected := range l.pending {
+		readable := false
+		for gen := range l.snapshots {
+			if gen <= collected {
+				readable = true
+				break
+			}
+		}
+		if readable {
+			continue
+		}
+		if f, ok := l.readers[id]; ok {
+			_ = f.Close()
+			delete(l.readers, id)
+		}
+		if rerr := os.Remove(l.path(id)); rerr != nil && !os.IsNotExist(rerr) {
+			err = rerr
+			break
+		}
+		delete(l.pending, id)
+		removed += 1
+	}
+	if removed > 0 {
+		if serr := syncDir(l.opts.Dir); err == nil {
+			err = serr
+		}
+	}
+	return removed, err
+}
+
+// sparse lists the files, other than the current file, with live values that take less than the MinLiveRatio of the file
+func (l *valueLog) sparse(live map[uint32]uint64) ([]uint32, error) {
+	if l.opts.MinLiveRatio <= 0 {
+		return nil, nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var out []uint32
+	for id, size := range live {
+		if l.current != nil && id == l.currentID {
+			continue
+		}
+		info, err := os.Stat(l.path(id))
+		if err != nil {
+			return nil, err
+		}
+		if float64(size) < l.opts.MinLiveRatio*float64(info.Size()) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// sync syncs the current file, the files before it were synced when they were rotated
+func (l *valueLog) sync() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.current == nil {
+		return nil
+	}
+	return l.current.Sync()
+}
+
+func (l *valueLog) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var err error
+	if l.current != nil {
+		err = l.current.Close()
+		l.current = nil
+	}
+	for id, f := range l.readers {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		delete(l.readers, id)
+	}
+	return err
+}
+
+// pointerKey is the key of the pointer to the value of the metadata of the kind and id
+func (db *merkleDB) pointerKey(kind byte, id []byte) []byte {
+	return db.metaKey(metaValuePointer, append([]byte{kind}, id...))
+}
+
+// separated is true if the value is stored in the value log, with only a pointer in leveldb
+func (db *merkleDB) separated(v []byte) bool {
+	return db.vlog != nil && len(v) >= db.vlog.opts.MinSize
+}
+
+// putValue adds the value of the metadata of the kind and id to the batch: inline, or in the value log
+// and a pointer in the batch. The other representation is deleted first, in case the value is replaced,
+// see inlineValues.
+func (db *merkleDB) putValue(b *leveldb.Batch, kind byte, id []byte, v []byte) error {
+	if err := db.checkWriteSize(kind, v); err != nil {
+		return err
+	}
+	if !db.separated(v) {
+		if db.vlog != nil {
+			b.Delete(db.pointerKey(kind, id))
+		}
+		b.Put(db.metaKey(kind, id), v)
+		return nil
+	}
+	p, err := db.vlog.append(v)
+	if err != nil {
+		return err
+	}
+	b.Delete(db.metaKey(kind, id))
+	b.Put(db.pointerKey(kind, id), p.encode())
+	return nil
+}
+
+// pointed returns the kind and id of the metadata that the key of a pointer of this merkledb holds the value of
+func (db *merkleDB) pointed(key []byte) (byte, []byte, bool) {
+	if kind, ok := metaKind(key); !ok || kind != metaValuePointer || !bytes.HasPrefix(key, db.prefix[:]) || len(key) <= metaKeyLen {
+		return 0, nil, false
+	}
+	return key[metaKeyLen], key[metaKeyLen+1:], true
+}
+
+// inliner replays a batch with the values that its pointers point to in place of the pointers.
+// The deletes of pointers delete the inline values too, putValue deletes before it puts.
+type inliner struct {
+	db  *merkleDB
+	out *leveldb.Batch
+	err error
+}
+
+func (r *inliner) Put(key []byte, value []byte) {
+	kind, id, ok := r.db.pointed(key)
+	if !ok {
+		r.out.Put(key, value)
+		return
+	}
+	var p valuePointer
+	if err := p.decode(value); err != nil {
+		r.err = corruptMeta(metaValuePointer, key[metaKeyLen:], value, err)
+		return
+	}
+	v, err := r.db.vlog.read(p)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	r.out.Put(r.db.metaKey(kind, id), v)
+}
+
+func (r *inliner) Delete(key []byte) {
+	r.out.Delete(key)
+	if kind, id, ok := r.db.pointed(key); ok {
+		r.out.Delete(r.db.metaKey(kind, id))
+	}
+}
+
+// inlineValues is the encoding of the batch with the values in the value log inline, see Backup and the Changefeed:
+// the value files are not part of them.
+func (db *merkleDB) inlineValues(b *leveldb.Batch) ([]byte, error) {
+	if db.vlog == nil {
+		return b.Dump(), nil
+	}
+	r := inliner{db: db, out: new(leveldb.Batch)}
+	if err := b.Replay(&r); err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.out.Dump(), nil
+}
+
+// getValue gets the value of the metadata of the kind and id, inline or from the value log
+func (db *merkleDB) getValue(kind byte, id []byte) ([]byte, error) {
+	v, err := db.getMeta(kind, id)
+	if err != leveldb.ErrNotFound || db.vlog == nil {
+		return v, err
+	}
+	ptr, err := db.getMeta(metaValuePointer, append([]byte{kind}, id...))
+	if err != nil {
+		return nil, err
+	}
+	var p valuePointer
+	if err := p.decode(ptr); err != nil {
+		return nil, corruptMeta(metaValuePointer, append([]byte{kind}, id...), ptr, err)
+	}
+	if v, err = db.vlog.read(p); err != nil {
+		return nil, err
+	}
+	if err := db.checkValue(kind, id, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// hasValue checks if there is a value of the metadata of the kind and id, inline or in the value log
+func (db *merkleDB) hasValue(kind byte, id []byte) (bool, error) {
+	if ok, err := db.r.Has(db.metaKey(kind, id), nil); err != nil || ok || db.vlog == nil {
+		return ok, err
+	}
+	return db.r.Has(db.pointerKey(kind, id), nil)
+}
+
+// collectValueLog removes the value files without pointers into them, after prunes deleted the pointers,
+// and moves the live values of sparse files to the current file first, see MinLiveRatio.
+// It returns the number of removed files. The prune lock must be held exclusively: values are appended
+// under the shared lock, before the pointers to them are written.
+func (db *merkleDB) collectValueLog() (int, error) {
+	if db.vlog == nil {
+		return 0, nil
+	}
+	live := make(map[uint32]uint64)
+	if err := db.valuePointers(func(key []byte, p valuePointer) error {
+		live[p.file] += uint64(p.size)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	sparse, err := db.vlog.sparse(live)
+	if err != nil {
+		return 0, err
+	}
+	if len(sparse) > 0 {
+		moved := make(map[uint32]struct{}, len(sparse))
+		for _, id := range sparse {
+			moved[id] = struct{}{}
+		}
+		b := new(leveldb.Batch)
+		if err := db.valuePointers(func(key []byte, p valuePointer) error {
+			if _, ok := moved[p.file]; !ok {
+				return nil
+			}
+			v, err := db.vlog.read(p)
+			if err != nil {
+				return err
+			}
+			np, err := db.vlog.append(v)
+			if err != nil {
+				return err
+			}
+			b.Put(append([]byte(nil), key...), np.encode())
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+		// the moved values are on disk before the pointers to them, and the pointers before the files are removed
+		if err := db.vlog.sync(); err != nil {
+			return 0, err
+		}
+		if err := db.write(b); err != nil {
+			return 0, err
+		}
+		for id := range moved {
+			delete(live, id)
+		}
+	}
+	files := make(map[uint32]struct{}, len(live))
+	for id := range live {
+		files[id] = struct{}{}
+	}
+	return db.vlog.collect(files)
+}
+
+// valuePointers calls fn with the key and the decoded pointer of every pointer into the value log
+func (db *merkleDB) valuePointers(fn func(key []byte, p valuePointer) error) error {
+	iter := db.db.NewIterator(util.BytesPrefix(db.metaKey(metaValuePointer, nil)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var p valuePointer
+		if err := p.decode(iter.Value()); err != nil {
+			return corruptMeta(metaValuePointer, iter.Key()[metaKeyLen:], iter.Value(), err)
+		}
+		if err := fn(iter.Key(), p); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
@@ -0,0 +1,254 @@
+package merkledb
+
+import (
+	"bytes"
+	. "github.com/protolambda/ztyp/tree"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestValueLog(t *testing.T) {
+	dir := t.TempDir()
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithValueLog(ValueLogOptions{Dir: dir, MinSize: 64, FileSize: 256}),
+		WithSSZStorage(SSZSubtree{Gindex: RootGindex, Type: testStateType})).(*merkleDB)
+	defer mdb.Close()
+	state := testState(t, 3, 4)
+	if _, err := mdb.Put(3, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	kept := state.HashTreeRoot(hFn)
+	dropped := testState(t, 4, 2)
+	if _, err := mdb.Put(4, dropped.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	droppedRoot := dropped.HashTreeRoot(hFn)
+
+	large := bytes.Repeat([]byte{0xab}, 200)
+	if err := mdb.PutBlob(droppedRoot, large); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.PutBlob(kept, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mdb.db.Has(mdb.metaKey(metaBlob, droppedRoot[:]), nil); ok {
+		t.Fatal("expected no large value in leveldb")
+	}
+	if ok, _ := mdb.db.Has(mdb.pointerKey(metaBlob, droppedRoot[:]), nil); !ok {
+		t.Fatal("expected a pointer to the large value")
+	}
+	for root, expected := range map[Root][]byte{droppedRoot: large, kept: []byte("small")} {
+		if got, err := mdb.GetBlob(root); err != nil || !bytes.Equal(got, expected) {
+			t.Fatalf("unexpected blob: %x, err: %v", got, err)
+		}
+		if ok, err := mdb.HasBlob(root); err != nil || !ok {
+			t.Fatalf("expected the blob, err: %v", err)
+		}
+	}
+	// the SSZ encoding of the state is large too
+	rec, err := mdb.GetSSZ(kept, RootGindex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Data, serializeView(t, state)) {
+		t.Fatal("unexpected SSZ encoding")
+	}
+	if ok, _ := mdb.db.Has(mdb.pointerKey(metaSSZ, sszID(kept, 1)), nil); !ok {
+		t.Fatal("expected a pointer to the SSZ encoding")
+	}
+
+	// replacing a large value with a small one removes the pointer
+	if err := mdb.PutBlob(kept, large); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.PutBlob(kept, []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mdb.db.Has(mdb.pointerKey(metaBlob, kept[:]), nil); ok {
+		t.Fatal("expected the pointer of the replaced value to be deleted")
+	}
+	files, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("expected the files to rotate, got %v", files)
+	}
+
+	// a corrupt value fails its checksum
+	first := mdb.vlog.path(0)
+	data, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[0] ^= 1
+	if err := ioutil.WriteFile(first, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.GetSSZ(kept, RootGindex); err == nil {
+		t.Fatal("expected a corrupt value to fail")
+	}
+	if err := ioutil.WriteFile(first, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the prune deletes the pointers of the dropped tree, and the files that only held dropped values
+	if err := mdb.Prune([]Root{kept}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mdb.HasBlob(droppedRoot); err != nil || ok {
+		t.Fatalf("expected the blob of the dropped tree to be pruned, err: %v", err)
+	}
+	remaining, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) >= len(files) {
+		t.Fatalf("expected files to be removed, got %v of %v", remaining, files)
+	}
+	if _, err := os.Stat(first); err != nil {
+		t.Fatalf("expected the file with the kept SSZ encoding to stay, err: %v", err)
+	}
+	if rec, err := mdb.GetSSZ(kept, RootGindex); err != nil || !bytes.Equal(rec.Data, serializeView(t, state)) {
+		t.Fatalf("expected the kept SSZ encoding after the prune, err: %v", err)
+	}
+}
+
+func TestValueLogSnapshot(t *testing.T) {
+	hFn := GetHashFn()
+	mdb := New(testPrefix, newMemoryDB(), WithValueLog(ValueLogOptions{Dir: t.TempDir(), MinSize: 64, FileSize: 256})).(*merkleDB)
+	defer mdb.Close()
+	kept, dropped := randomTree(3), randomTree(3)
+	keptRoot, droppedRoot := kept.MerkleRoot(hFn), dropped.MerkleRoot(hFn)
+	for i, tree := range []Node{kept, dropped} {
+		if _, err := mdb.Put(uint64(i), tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	large := bytes.Repeat([]byte{0xab}, 200)
+	if err := mdb.PutBlob(droppedRoot, large); err != nil {
+		t.Fatal(err)
+	}
+	// the value of the dropped tree is in its own file, after the file of the first put
+	if err := mdb.PutBlob(keptRoot, large); err != nil {
+		t.Fatal(err)
+	}
+	files, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := mdb.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Prune([]Root{keptRoot}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mdb.HasBlob(droppedRoot); err != nil || ok {
+		t.Fatalf("expected the blob to be pruned, err: %v", err)
+	}
+	// the snapshot still reads the pruned blob, from the file that is kept for it
+	if got, err := snap.GetBlob(droppedRoot); err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("expected the snapshot to read the pruned blob, err: %v", err)
+	}
+	if kept, err := mdb.vlog.files(); err != nil || len(kept) != len(files) {
+		t.Fatalf("expected the files to be kept for the snapshot, got %v of %v, err: %v", kept, files, err)
+	}
+	snap.Release()
+	remaining, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) >= len(files) {
+		t.Fatalf("expected the file to be removed after the release, got %v of %v", remaining, files)
+	}
+	if got, err := mdb.GetBlob(keptRoot); err != nil || !bytes.Equal(got, large) {
+		t.Fatalf("expected the kept blob, err: %v", err)
+	}
+}
+
+func TestValueLogCompaction(t *testing.T) {
+	dir := t.TempDir()
+	hFn := GetHashFn()
+	opts := ValueLogOptions{Dir: dir, MinSize: 64, FileSize: 256, MinLiveRatio: 0.6}
+	feed := NewChangefeed()
+	sub := feed.Subscribe(100)
+	mdb := New(testPrefix, newMemoryDB(), WithValueLog(opts), WithChangefeed(feed)).(*merkleDB)
+	defer mdb.Close()
+	// another prefix shares the directory
+	other := New([prefixLen]byte{9, 9, 9}, newMemoryDB(), WithValueLog(opts)).(*merkleDB)
+	defer other.Close()
+
+	var roots []Root
+	blobs := make(map[Root][]byte)
+	for slot := uint64(0); slot < 6; slot++ {
+		tree := fullTree(2)
+		root := tree.MerkleRoot(hFn)
+		if _, err := mdb.Put(slot, tree, hFn); err != nil {
+			t.Fatal(err)
+		}
+		blobs[root] = bytes.Repeat([]byte{byte(slot)}, 100)
+		if err := mdb.PutBlob(root, blobs[root]); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+	if err := other.PutBlob(roots[0], blobs[roots[0]]); err != nil {
+		t.Fatal(err)
+	}
+	files, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files of 2 values, got %v", files)
+	}
+	if otherFiles, err := other.vlog.files(); err != nil || len(otherFiles) != 1 {
+		t.Fatalf("expected the other prefix to have its own file, got %v, err: %v", otherFiles, err)
+	}
+
+	// every file keeps one of its two values, half of it is live
+	kept := []Root{roots[0], roots[2], roots[4]}
+	if err := mdb.Prune(kept); err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := mdb.vlog.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range remaining {
+		for _, old := range files[:2] {
+			if id == old {
+				t.Fatalf("expected the sparse file %d to be compacted, got %v", id, remaining)
+			}
+		}
+	}
+	for _, root := range kept {
+		if got, err := mdb.GetBlob(root); err != nil || !bytes.Equal(got, blobs[root]) {
+			t.Fatalf("unexpected blob after the compaction: %x, err: %v", got, err)
+		}
+	}
+	if got, err := other.GetBlob(roots[0]); err != nil || !bytes.Equal(got, blobs[roots[0]]) {
+		t.Fatalf("expected the blob of the other prefix to stay, err: %v", err)
+	}
+
+	// the changefeed carries the values, a follower without the value files reads them inline
+	sub.Close()
+	ldb := newMemoryDB()
+	for c := range sub.Changes() {
+		if err := ApplyChange(ldb, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	follower := New(testPrefix, ldb)
+	for _, root := range kept {
+		if got, err := follower.GetBlob(root); err != nil || !bytes.Equal(got, blobs[root]) {
+			t.Fatalf("unexpected blob of the follower: %x, err: %v", got, err)
+		}
+	}
+	if ok, err := follower.HasBlob(roots[1]); err != nil || ok {
+		t.Fatalf("expected the pruned blob to be deleted on the follower, err: %v", err)
+	}
+}
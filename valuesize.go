@@ -0,0 +1,102 @@
+package merkledb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// DefaultMaxValueSize bounds the variable size records, like blobs and SSZ encodings, if not configured
+const DefaultMaxValueSize = 1 << 30
+
+// ErrValueTooLarge is returned by writes of values over the maximum value size, see WithMaxValueSize
+var ErrValueTooLarge = errors.New("value too large")
+
+// recordSize is the expected size of the values of a metadata kind, and their version byte if versioned.
+// The values of variable size kinds have no max, they are bounded by the maximum value size.
+type recordSize struct {
+	min, max  int
+	variable  bool
+	versioned bool
+	version   byte
+}
+
+// recordSizes are the expected value sizes by metadata kind. Every read checks the size and version byte
+// of a value before decoding it, so that the records of another application, that collide with the keyspace
+// of the prefix in a shared leveldb, are rejected early instead of being misread.
+var recordSizes = map[byte]recordSize{
+	metaAnchor:          {min: anchorRecordMinLen, max: anchorRecordLen, versioned: true, version: anchorVersion},
+	metaHidden:          {min: anchorRecordMinLen, max: anchorRecordLen, versioned: true, version: anchorVersion},
+	metaRef:             {min: 32, max: 32},
+	metaTombstone:       {max: 1},
+	metaPin:             {},
+	metaRepair:          {},
+	metaIndex:           {},
+	metaSyncBarrier:     {},
+	metaPruneCheckpoint: {min: 1 + 4, variable: true, versioned: true, version: pruneCheckpointVersion},
+	metaTenant:          {min: 1 + prefixLen + 1, max: 1 + prefixLen + 1, versioned: true, version: tenantVersion},
+	metaNextPrefix:      {min: prefixLen, max: prefixLen},
+	metaShard:           {min: 4, max: 4},
+	metaTrimmed:         {min: 1, variable: true, versioned: true, version: trimmedVersion},
+	metaAudit:           {min: auditRecordLen, max: auditRecordLen, versioned: true, version: auditVersion},
+	metaIdempotency:     {min: idempotencyRecordLen, max: idempotencyRecordLen, versioned: true, version: idempotencyVersion},
+	metaPrefix:          {min: 1, max: 1 + 8 + 4 + 1 + 255 + 1 + 1 + 1 + 255 + 8 + 8, versioned: true, version: prefixMetadataVersion},
+	metaLease:           {min: 38, max: 38 + 255, versioned: true, version: leaseVersion},
+	metaBlob:            {variable: true},
+	metaSSZ:             {min: 3, variable: true, versioned: true, version: sszRecordVersion},
+	metaValuePointer:    {min: valuePointerLen, max: valuePointerLen},
+	metaTags:            {min: 1, variable: true, versioned: true, version: tagsVersion},
+	metaHistory:         {min: 32, max: 32},
+}
+
+// checkValueSize checks the size and version byte of the stored value of the metadata record of the kind and id,
+// with the variable size records bounded by maxSize, DefaultMaxValueSize if 0.
+// The error is a CorruptValueError that wraps ErrMalformed.
+func checkValueSize(kind byte, id []byte, v []byte, maxSize int) error {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxValueSize
+	}
+	size, known := recordSizes[kind]
+	max := size.max
+	if !known || size.variable {
+		max = maxSize
+	}
+	if len(v) > max || len(v) < size.min {
+		return corruptMeta(kind, id, v, fmt.Errorf("%w: record %s of %d bytes, expected %d to %d bytes",
+			ErrMalformed, strconv.QuoteRune(rune(kind)), len(v), size.min, max))
+	}
+	if size.versioned && v[0] != size.version {
+		return corruptMeta(kind, id, v, fmt.Errorf("%w: record %s has unknown version: %d",
+			ErrMalformed, strconv.QuoteRune(rune(kind)), v[0]))
+	}
+	return nil
+}
+
+// checkValue checks the stored value of the metadata record of the kind and id, see checkValueSize
+func (db *merkleDB) checkValue(kind byte, id []byte, v []byte) error {
+	return checkValueSize(kind, id, v, db.opts.MaxValueSize)
+}
+
+// getMeta gets the value of the metadata record of the kind and id, checked by checkValue
+func (db *merkleDB) getMeta(kind byte, id []byte) ([]byte, error) {
+	v, err := db.r.Get(db.metaKey(kind, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkValue(kind, id, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkWriteSize rejects values of variable size records over the maximum value size with ErrValueTooLarge
+func (db *merkleDB) checkWriteSize(kind byte, v []byte) error {
+	max := db.opts.MaxValueSize
+	if max <= 0 {
+		max = DefaultMaxValueSize
+	}
+	if len(v) > max {
+		return fmt.Errorf("%w: record %s of %d bytes, the maximum is %d bytes", ErrValueTooLarge, strconv.QuoteRune(rune(kind)), len(v), max)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMaxValueSize(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb, WithMaxValueSize(16)).(*merkleDB)
+	root := *randomRoot()
+	if err := mdb.PutBlob(root, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.PutBlob(root, make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected an over-long blob to fail, got %v", err)
+	}
+
+	// a value written past the limit, e.g. by another application in the leveldb, fails on read
+	if err := ldb.Put(mdb.metaKey(metaBlob, root[:]), make([]byte, 17), nil); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *CorruptValueError
+	if _, err := mdb.GetBlob(root); !errors.As(err, &corrupt) || corrupt.Kind != metaBlob || !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected an over-long blob to be corrupt, got %v", err)
+	}
+	if _, err := New(testPrefix, ldb).GetBlob(root); err != nil {
+		t.Fatalf("expected the default maximum to allow the blob: %v", err)
+	}
+}
+
+func TestRecordSizes(t *testing.T) {
+	ldb := newMemoryDB()
+	mdb := New(testPrefix, ldb).(*merkleDB)
+	hFn := GetHashFn()
+	tree := randomTree(3)
+	root := tree.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, tree, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SetRef(HeadRef, root); err != nil {
+		t.Fatal(err)
+	}
+	v, err := ldb.Get(mdb.metaKey(metaAnchor, root[:]), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.checkValue(metaAnchor, root[:], v); err != nil {
+		t.Fatal(err)
+	}
+
+	// fixed size records are checked against their own size, whatever the maximum
+	for _, c := range []struct {
+		name  string
+		key   []byte
+		value []byte
+		read  func() error
+	}{
+		{"over-long anchor", mdb.metaKey(metaAnchor, root[:]), append(append([]byte(nil), v...), 0),
+			func() error { _, err := mdb.GetAnchor(root); return err }},
+		{"anchor of another version", mdb.metaKey(metaAnchor, root[:]), append([]byte{1}, v[1:]...),
+			func() error { _, err := mdb.Anchors(); return err }},
+		{"over-long ref", mdb.metaKey(metaRef, []byte(HeadRef)), make([]byte, 33),
+			func() error { _, err := mdb.GetRef(HeadRef); return err }},
+	} {
+		if err := ldb.Put(c.key, c.value, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.read(); !errors.Is(err, ErrCorruptValue) || !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: expected a corrupt value, got %v", c.name, err)
+		}
+	}
+
+	if err := checkValueSize(metaPin, nil, []byte{1}, 0); err == nil {
+		t.Fatal("expected a pin with a value to fail")
+	}
+	if err := checkValueSize(metaTags, nil, make([]byte, 64), 32); err == nil {
+		t.Fatal("expected tags over the maximum to fail")
+	}
+	if err := checkValueSize('?', nil, make([]byte, 32), 32); err != nil {
+		t.Fatalf("expected unknown kinds to be bounded by the maximum only: %v", err)
+	}
+}
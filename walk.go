@@ -0,0 +1,84 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+)
+
+// WalkOrder is the deterministic order in which the nodes of a stored tree are visited.
+// The order only depends on the tree, so exports and replication streams are reproducible.
+type WalkOrder byte
+
+const (
+	// DepthFirst visits every parent before its children, the left subtree before the right subtree,
+	// the same order as TreeSource.
+	DepthFirst WalkOrder = iota
+	// GindexOrder visits the nodes by ascending gindex: level by level, left to right.
+	GindexOrder
+)
+
+type storedSource struct {
+	db      TreeReader
+	order   WalkOrder
+	pending []NodeRef
+	rec     PairRecord
+}
+
+// StoredSource streams the nodes of the stored tree of the anchor, in the given order.
+// A node that is not stored ends the stream with leveldb.ErrNotFound.
+func StoredSource(db TreeReader, anchor Root, order WalkOrder) NodeSource {
+	return SubtreeSource(db, NodeRef{Gindex: RootGindex, Root: anchor}, order)
+}
+
+// SubtreeSource streams the nodes of the stored subtree of the node, in the given order, like StoredSource.
+func SubtreeSource(db TreeReader, node NodeRef, order WalkOrder) NodeSource {
+	return &storedSource{db: db, order: order, pending: []NodeRef{node}}
+}
+
+func (s *storedSource) Next() (StreamNode, error) {
+	if len(s.pending) == 0 {
+		return StreamNode{}, io.EOF
+	}
+	var next NodeRef
+	if s.order == GindexOrder {
+		next = s.pending[0]
+		s.pending = s.pending[1:]
+	} else {
+		next = s.pending[len(s.pending)-1]
+		s.pending = s.pending[:len(s.pending)-1]
+	}
+	if err := s.db.GetInto(next.Gindex, next.Root, &s.rec); err != nil {
+		return StreamNode{}, err
+	}
+	if s.rec.Summary {
+		return StreamNode{}, ErrSummarized{AtGindex: next.Gindex, Root: next.Root}
+	}
+	out := StreamNode{Gindex: next.Gindex, Root: next.Root, Pair: s.rec.Pair, Left: s.rec.Left, Right: s.rec.Right, Slot: s.rec.Slot}
+	if out.Pair {
+		left := NodeRef{Gindex: next.Gindex.Left(), Root: out.Left}
+		right := NodeRef{Gindex: next.Gindex.Right(), Root: out.Right}
+		if s.order == GindexOrder {
+			s.pending = append(s.pending, left, right)
+		} else {
+			s.pending = append(s.pending, right, left)
+		}
+	}
+	return out, nil
+}
+
+func (db *merkleDB) Walk(anchor Root, order WalkOrder, fn func(node StreamNode) error) error {
+	return db.consistent(func(view *merkleDB) error {
+		src := StoredSource(view, anchor, order)
+		for {
+			n, err := src.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestMerkleDB_Walk(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	foo := randomTree(8)
+	anchor := foo.MerkleRoot(hFn)
+	if _, err := mdb.Put(1, foo, hFn); err != nil {
+		t.Fatal(err)
+	}
+	expected := collectStream(t, TreeSource(foo, hFn))
+	var dfs []StreamNode
+	if err := mdb.Walk(anchor, DepthFirst, func(n StreamNode) error {
+		dfs = append(dfs, n)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dfs) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(dfs))
+	}
+	for i := range expected {
+		a, _ := gindexValue(dfs[i].Gindex)
+		b, _ := gindexValue(expected[i].Gindex)
+		if dfs[i].Root != expected[i].Root || a != b {
+			t.Fatalf("node %d differs from the TreeSource order", i)
+		}
+	}
+	var prev uint64
+	count := 0
+	if err := mdb.Walk(anchor, GindexOrder, func(n StreamNode) error {
+		g, err := gindexValue(n.Gindex)
+		if err != nil {
+			return err
+		}
+		if g <= prev {
+			t.Fatalf("gindex %d visited after %d", g, prev)
+		}
+		prev = g
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(expected) {
+		t.Fatalf("expected %d nodes in gindex order, got %d", len(expected), count)
+	}
+	// a stored tree replicates through its stream
+	replica := New(testPrefix, newMemoryDB())
+	if _, err := replica.PutStream(1, anchor, StoredSource(mdb, anchor, GindexOrder), hFn); err != nil {
+		t.Fatal(err)
+	}
+	out, err := replica.Get(RootGindex, anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(foo, out.Node, RootGindex, hFn, t)
+}
@@ -0,0 +1,194 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// LeafChange is a put tree with a different node at the watched gindex than the previous tree
+type LeafChange struct {
+	Gindex Gindex
+	// Anchor and Slot are of the put tree
+	Anchor Root
+	Slot   uint64
+	// Previous and PreviousSlot are of the parent of the put tree, see WithParent,
+	// or of the canonical head if the put has no parent
+	Previous     Root
+	PreviousSlot uint64
+	// Old and New are the roots of the nodes at the gindex, the values of leaves
+	Old Root
+	New Root
+}
+
+// GindexWatch receives the changes of the node at a gindex, see WatchGindex
+type GindexWatch struct {
+	db      *merkleDB
+	gindex  Gindex
+	changes chan LeafChange
+	err     error
+}
+
+func (db *merkleDB) WatchGindex(gindex Gindex, buffer int) *GindexWatch {
+	if db.base != nil {
+		db = db.base
+	}
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	if db.watches == nil {
+		db.watches = make(map[*GindexWatch]struct{})
+	}
+	w := &GindexWatch{db: db, gindex: gindex, changes: make(chan LeafChange, buffer)}
+	db.watches[w] = struct{}{}
+	return w
+}
+
+// Changes delivers the changes in put order, and is closed when the watch ends
+func (w *GindexWatch) Changes() <-chan LeafChange {
+	return w.changes
+}
+
+// Err is why the watch ended, after the changes are closed: ErrLagging, the error of the lookup of its gindex,
+// or nil if it or the merkledb was closed
+func (w *GindexWatch) Err() error {
+	w.db.watchLock.Lock()
+	defer w.db.watchLock.Unlock()
+	return w.err
+}
+
+// Close ends the watch
+func (w *GindexWatch) Close() {
+	w.db.watchLock.Lock()
+	defer w.db.watchLock.Unlock()
+	w.db.dropWatch(w, nil)
+}
+
+func (db *merkleDB) dropWatch(w *GindexWatch, err error) {
+	if _, ok := db.watches[w]; !ok {
+		return
+	}
+	delete(db.watches, w)
+	w.err = err
+	close(w.changes)
+}
+
+func (db *merkleDB) closeWatches() {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	for w := range db.watches {
+		db.dropWatch(w, nil)
+	}
+}
+
+// notifyWatches compares the put tree with the previous tree at the watched gindices, after the put is committed
+// and released its locks. A watch that fails to look up its gindex ends with the error, the others continue.
+func (db *merkleDB) notifyWatches(root Root, slot uint64, opts []PutOption) {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	if len(db.watches) == 0 {
+		return
+	}
+	prev, err := db.previousTree(root, slot, applyPutOptions(opts).Parent)
+	if err != nil {
+		if db.opts.OnBackgroundError != nil {
+			db.opts.OnBackgroundError(err)
+		}
+		return
+	}
+	if prev == nil {
+		return
+	}
+	for w := range db.watches {
+		change, ok, err := db.compareWatched(w.gindex, root, slot, prev)
+		if err != nil {
+			db.dropWatch(w, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case w.changes <- change:
+		default:
+			db.dropWatch(w, ErrLagging)
+		}
+	}
+}
+
+// previousTree is the anchor that the put tree is compared with: its parent if any, or else the canonical head
+// if that is before the slot of the put. Nil if there is none. The watch lock must be held.
+func (db *merkleDB) previousTree(root Root, slot uint64, parent Root) (*Anchor, error) {
+	if parent != (Root{}) {
+		a, err := db.GetAnchor(parent)
+		if err == leveldb.ErrNotFound {
+			return nil, nil
+		}
+		return &a, err
+	}
+	// a tree that is put again keeps its canonical mark, and may move the head
+	if a, err := db.GetAnchor(root); err == nil && a.Canonical {
+		db.headKnown = false
+	}
+	// the head is checked with a single read, it is only searched again after it changed
+	if db.headKnown && db.head != nil {
+		if a, err := db.GetAnchor(db.head.Root); err != nil || a.Slot != db.head.Slot || !a.Canonical {
+			db.headKnown = false
+		}
+	}
+	if !db.headKnown {
+		anchors, err := db.FilterAnchors(CanonicalOnly)
+		if err != nil {
+			return nil, err
+		}
+		db.head = nil
+		for i := range anchors {
+			if db.head == nil || anchors[i].Slot > db.head.Slot {
+				db.head = &anchors[i]
+			}
+		}
+		db.headKnown = true
+	}
+	if db.head == nil || db.head.Slot >= slot || db.head.Root == root {
+		return nil, nil
+	}
+	return db.head, nil
+}
+
+// resetHead makes the next notification search the canonical head again, after anchors were marked or written
+func (db *merkleDB) resetHead() {
+	if db.base != nil {
+		db = db.base
+	}
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	db.headKnown = false
+}
+
+// compareWatched returns the change of the node at the gindex between the previous and the put tree, if any.
+// There is no change if a tree does not reach the gindex, or has it trimmed.
+func (db *merkleDB) compareWatched(gindex Gindex, root Root, slot uint64, prev *Anchor) (LeafChange, bool, error) {
+	now, err := db.lookup(root, gindex)
+	if err != nil {
+		if skippedLookup(err) {
+			return LeafChange{}, false, nil
+		}
+		return LeafChange{}, false, err
+	}
+	old, err := db.lookup(prev.Root, gindex)
+	if err != nil {
+		if skippedLookup(err) {
+			return LeafChange{}, false, nil
+		}
+		return LeafChange{}, false, err
+	}
+	if old == now {
+		return LeafChange{}, false, nil
+	}
+	return LeafChange{Gindex: gindex, Anchor: root, Slot: slot,
+		Previous: prev.Root, PreviousSlot: prev.Slot, Old: old, New: now}, true, nil
+}
+
+// skippedLookup matches trees that do not reach the gindex, and missing nodes, including ErrSummarized
+func skippedLookup(err error) bool {
+	return errors.Is(err, NavigationError) || errors.Is(err, leveldb.ErrNotFound)
+}
@@ -0,0 +1,150 @@
+package merkledb
+
+import (
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+	"testing"
+)
+
+func TestMerkleDB_WatchGindex(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	g, _, err := ResolvePath(testStateType, "balances", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := mdb.WatchGindex(g, 4)
+	put := func(slot uint64, balance uint64, canonical bool) Root {
+		state := testState(t, slot, 8)
+		bals, err := state.Get(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bals.(*view.BasicListView).Set(5, view.Uint64View(balance)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mdb.Put(slot, state.Backing(), hFn); err != nil {
+			t.Fatal(err)
+		}
+		root := state.HashTreeRoot(hFn)
+		if canonical {
+			if err := mdb.SetCanonical(root, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root
+	}
+	// without a previous canonical tree there is nothing to compare with
+	first := put(1, 100, true)
+	// the same balance is no change
+	put(2, 100, false)
+	// a different balance than the canonical tree of slot 1 is
+	changed := put(3, 200, false)
+	select {
+	case c := <-w.Changes():
+		if c.Anchor != changed || c.Slot != 3 || c.Previous != first || c.PreviousSlot != 1 {
+			t.Fatalf("unexpected change: %+v", c)
+		}
+		if v, nv := c.Old[8:16], c.New[8:16]; v[0] != 100 || nv[0] != 200 {
+			t.Fatalf("unexpected values: %x %x", c.Old, c.New)
+		}
+	default:
+		t.Fatal("expected a change")
+	}
+	select {
+	case c := <-w.Changes():
+		t.Fatalf("unexpected change: %+v", c)
+	default:
+	}
+
+	// a watch that does not keep up is dropped
+	lagging := mdb.WatchGindex(g, 0)
+	put(4, 300, false)
+	if _, ok := <-lagging.Changes(); ok || lagging.Err() != ErrLagging {
+		t.Fatalf("expected the watch to lag, got %v", lagging.Err())
+	}
+
+	w.Close()
+	if _, ok := <-w.Changes(); !ok {
+		t.Fatal("expected the buffered change of slot 4")
+	}
+	if _, ok := <-w.Changes(); ok || w.Err() != nil {
+		t.Fatalf("expected the watch to be closed, got %v", w.Err())
+	}
+}
+
+func TestMerkleDB_WatchParentAndErrors(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB()).(*merkleDB)
+	hFn := GetHashFn()
+	with := func(tree Node, g uint64) Node {
+		setter, err := tree.Setter(Gindex64(g), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := setter(randomRoot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	head := fullTree(3)
+	if _, err := mdb.Put(1, head, hFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.SetCanonical(head.MerkleRoot(hFn), true); err != nil {
+		t.Fatal(err)
+	}
+	left, right := mdb.WatchGindex(Gindex64(8), 4), mdb.WatchGindex(Gindex64(12), 4)
+
+	// a put with a parent is compared with the parent, not with the canonical head
+	parent := with(head, 12)
+	if _, err := mdb.Put(2, parent, hFn); err != nil {
+		t.Fatal(err)
+	}
+	<-right.Changes()
+	child := with(parent, 8)
+	if _, err := mdb.Put(3, child, hFn, WithParent(parent.MerkleRoot(hFn))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-left.Changes():
+		if c.Previous != parent.MerkleRoot(hFn) || c.PreviousSlot != 2 {
+			t.Fatalf("expected a change against the parent, got %+v", c)
+		}
+	default:
+		t.Fatal("expected a change of the left leaf")
+	}
+	select {
+	case c := <-right.Changes():
+		t.Fatalf("unexpected change of the right leaf, which the parent has too: %+v", c)
+	default:
+	}
+
+	// a watch that fails to read the head ends with the error, the others still get their changes
+	headLeft, _ := head.Left()
+	var buf [maxKeyLen]byte
+	k, err := mdb.buildKey(&buf, LeftGindex, headLeft.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.db.Put(k, []byte{0xff}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mdb.Put(4, with(with(fullTree(3), 8), 12), hFn); err != nil {
+		t.Fatal(err)
+	}
+	for range left.Changes() {
+	}
+	if !errors.Is(left.Err(), ErrCorruptValue) {
+		t.Fatalf("expected the left watch to end with the corrupt node, got %v", left.Err())
+	}
+	select {
+	case c := <-right.Changes():
+		if c.Previous != head.MerkleRoot(hFn) {
+			t.Fatalf("expected a change against the head, got %+v", c)
+		}
+	default:
+		t.Fatal("expected a change of the right leaf")
+	}
+}
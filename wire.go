@@ -0,0 +1,145 @@
+package merkledb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+)
+
+// ErrUnbound is returned when loading the children of a decoded pair node that is not bound to a db, see BindNode
+var ErrUnbound = errors.New("node is not bound to a db")
+
+const wireVersion = 0
+
+const (
+	wireLeaf byte = 0
+	wirePair byte = 1
+)
+
+const (
+	wireLeafLen = 1 + 1 + 32
+	// the pair has its gindex, 0 if unknown, its root, the roots of its children, and its slot
+	wirePairLen = 1 + 1 + 8 + 32 + 32 + 32 + 8
+)
+
+// MarshalNode encodes the node, without its subtree: a leaf as its root, a pair as its root and the roots of
+// its children. Virtual nodes keep their gindex and slot, so a decoded node can be bound to a db again.
+// The roots of other nodes are computed with the hash function, or the default one if nil.
+func MarshalNode(node Node, fn HashFn) ([]byte, error) {
+	if node.IsLeaf() {
+		out := make([]byte, wireLeafLen)
+		out[0] = wireVersion
+		out[1] = wireLeaf
+		root := node.MerkleRoot(hashFnOrDefault(fn))
+		copy(out[2:], root[:])
+		return out, nil
+	}
+	out := make([]byte, wirePairLen)
+	out[0] = wireVersion
+	out[1] = wirePair
+	var self, left, right Root
+	if v, ok := node.(*virtualNode); ok {
+		if v.gindex != nil {
+			g, err := gindexValue(v.gindex)
+			if err != nil {
+				return nil, err
+			}
+			binary.LittleEndian.PutUint64(out[2:10], g)
+		}
+		self, left, right = v.self, v.left, v.right
+		binary.LittleEndian.PutUint64(out[106:114], v.slot)
+	} else {
+		fn = hashFnOrDefault(fn)
+		l, err := node.Left()
+		if err != nil {
+			return nil, err
+		}
+		r, err := node.Right()
+		if err != nil {
+			return nil, err
+		}
+		self, left, right = node.MerkleRoot(fn), l.MerkleRoot(fn), r.MerkleRoot(fn)
+	}
+	copy(out[10:42], self[:])
+	copy(out[42:74], left[:])
+	copy(out[74:106], right[:])
+	return out, nil
+}
+
+// UnmarshalNode decodes a node of MarshalNode. A leaf is decoded as its root. A pair is decoded as a virtual node
+// that is not bound to a db: its roots are known, loading its children fails with ErrUnbound until bound.
+func UnmarshalNode(data []byte) (Node, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("node too short: '%x'", data)
+	}
+	if data[0] != wireVersion {
+		return nil, fmt.Errorf("unknown node version: %d", data[0])
+	}
+	switch data[1] {
+	case wireLeaf:
+		if len(data) != wireLeafLen {
+			return nil, fmt.Errorf("leaf node of %d bytes", len(data))
+		}
+		root := toRoot(data[2:])
+		return &root, nil
+	case wirePair:
+		if len(data) != wirePairLen {
+			return nil, fmt.Errorf("pair node of %d bytes", len(data))
+		}
+		v := &virtualNode{
+			self:  toRoot(data[10:42]),
+			left:  toRoot(data[42:74]),
+			right: toRoot(data[74:106]),
+			slot:  binary.LittleEndian.Uint64(data[106:114]),
+		}
+		if g := binary.LittleEndian.Uint64(data[2:10]); g != 0 {
+			v.gindex = Gindex64(g)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unknown node kind: %d", data[1])
+	}
+}
+
+// BindNode returns a decoded pair node that loads its children from the db. Other nodes are returned as they are.
+// Pairs without a gindex, which were not virtual nodes when encoded, cannot be bound.
+func BindNode(node Node, db TreeReader) (Node, error) {
+	v, ok := node.(*virtualNode)
+	if !ok {
+		return node, nil
+	}
+	if v.gindex == nil {
+		return nil, errors.New("cannot bind a pair node without gindex")
+	}
+	bound := *v
+	bound.db = db
+	return &bound, nil
+}
+
+// MarshalBinary encodes the slot and the node, see MarshalNode
+func (s SlottedNode) MarshalBinary() ([]byte, error) {
+	if s.Node == nil {
+		return nil, errors.New("no node")
+	}
+	node, err := MarshalNode(s.Node, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 8, 8+len(node))
+	binary.LittleEndian.PutUint64(out, s.Slot)
+	return append(out, node...), nil
+}
+
+// UnmarshalBinary decodes the slot and the node, see UnmarshalNode and BindNode
+func (s *SlottedNode) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("slotted node too short: '%x'", data)
+	}
+	node, err := UnmarshalNode(data[8:])
+	if err != nil {
+		return err
+	}
+	*s = SlottedNode{Slot: binary.LittleEndian.Uint64(data[:8]), Node: node}
+	return nil
+}
@@ -0,0 +1,85 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestSlottedNode_MarshalBinary(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	// the left child is a pair, a random tree of depth 3
+	var node Node = NewPairNode(randomTree(3), randomTree(3))
+	if _, err := mdb.Put(7, node, hFn); err != nil {
+		t.Fatal(err)
+	}
+	got, err := mdb.Get(RootGindex.Left(), node.(*PairNode).LeftChild.MerkleRoot(hFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := got.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SlottedNode
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Slot != 7 || decoded.Node.MerkleRoot(hFn) != got.Node.MerkleRoot(hFn) {
+		t.Fatalf("unexpected decoded node: %+v", decoded)
+	}
+	v := decoded.Node.(VirtualNode)
+	if v.Slot() != 7 || v.LeftRoot() != got.Node.(VirtualNode).LeftRoot() || v.RightRoot() != got.Node.(VirtualNode).RightRoot() {
+		t.Fatal("expected the roots of the children to be decoded")
+	}
+	if _, err := v.Left(); err != ErrUnbound {
+		t.Fatalf("expected an unbound node, got %v", err)
+	}
+	bound, err := BindNode(decoded.Node, mdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareNodes(node.(*PairNode).LeftChild, bound, RootGindex.Left(), hFn, t)
+
+	// leaves decode as their root
+	leafNode := randomRoot()
+	if _, err := mdb.Put(2, leafNode, hFn); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := mdb.Get(RootGindex, *leafNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = leaf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Slot != 2 || *decoded.Node.(*Root) != *leafNode {
+		t.Fatalf("unexpected decoded leaf: %+v", decoded)
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("expected a truncated node to fail")
+	}
+}
+
+func TestMarshalNode_InMemory(t *testing.T) {
+	hFn := GetHashFn()
+	node := randomTree(2)
+	data, err := MarshalNode(node, hFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.MerkleRoot(hFn) != node.MerkleRoot(hFn) {
+		t.Fatal("expected the root to be kept")
+	}
+	if _, err := BindNode(decoded, New(testPrefix, newMemoryDB())); err == nil {
+		t.Fatal("expected a pair without gindex not to bind")
+	}
+}
@@ -0,0 +1,264 @@
+package merkledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+	"sort"
+)
+
+const witnessVersion = 0
+
+var witnessMagic = [4]byte{'M', 'D', 'B', 'W'}
+
+// maxWitnessCount bounds the counts read from a bundle, to not allocate for corrupt counts
+const maxWitnessCount = 1 << 24
+
+// Preimage is a pair node of a witness: the roots of its children, which hash to the root of the node
+type Preimage struct {
+	Gindex uint64
+	Left   Root
+	Right  Root
+}
+
+// WitnessBundle is the witness of the nodes that a block touches, in the tree of the anchor, for zk circuits
+// and stateless clients: a multiproof of the touched nodes, and the preimages of every pair node in their subtrees,
+// so a touched container or list is witnessed down to its leaves.
+type WitnessBundle struct {
+	Anchor Root
+	Slot   uint64
+	// Proof proves the touched nodes, ordered by ascending gindex
+	Proof MultiProof
+	// Preimages are the pair nodes below, and including, the touched nodes, ordered by ascending gindex
+	Preimages []Preimage
+}
+
+// ExportWitness collects the witness of the touched gindices in the tree of the anchor.
+// Touched gindices below other touched gindices are covered by the preimages of those, and left out of the proof.
+func ExportWitness(db TreeReader, anchor Root, touched []Gindex) (*WitnessBundle, error) {
+	out, err := witnessProof(db, anchor, touched)
+	if err != nil {
+		return nil, err
+	}
+	for i, g := range out.Proof.Gindices {
+		src := SubtreeSource(db, NodeRef{Gindex: g, Root: out.Proof.Leaves[i]}, GindexOrder)
+		for {
+			n, err := src.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to collect the subtree at gindex %d: %w", g, err)
+			}
+			if !n.Pair {
+				continue
+			}
+			v, err := gindexValue(n.Gindex)
+			if err != nil {
+				return nil, err
+			}
+			out.Preimages = append(out.Preimages, Preimage{Gindex: v, Left: n.Left, Right: n.Right})
+		}
+	}
+	sort.Slice(out.Preimages, func(i, j int) bool {
+		return out.Preimages[i].Gindex < out.Preimages[j].Gindex
+	})
+	return out, nil
+}
+
+// witnessProof is the bundle of the witness of the touched gindices, without the preimages:
+// the multiproof of the touched nodes that are not below other touched nodes, by ascending gindex
+func witnessProof(db TreeReader, anchor Root, touched []Gindex) (*WitnessBundle, error) {
+	a, err := db.GetAnchor(anchor)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := gindexValues(touched)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i] < targets[j]
+	})
+	set := make(map[uint64]struct{}, len(targets))
+	kept := targets[:0]
+	for _, g := range targets {
+		covered := false
+		for p := g; p >= 1; p >>= 1 {
+			if _, ok := set[p]; ok {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			set[g] = struct{}{}
+			kept = append(kept, g)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("no touched gindices")
+	}
+	gindices := make([]Gindex, len(kept))
+	for i, g := range kept {
+		gindices[i] = Gindex64(g)
+	}
+	proof, err := db.ProveMulti(anchor, gindices)
+	if err != nil {
+		return nil, err
+	}
+	return &WitnessBundle{Anchor: anchor, Slot: a.Slot, Proof: *proof}, nil
+}
+
+// Verify checks the proof against the anchor, and that every preimage hashes to the root of its node
+func (w *WitnessBundle) Verify(fn HashFn) bool {
+	if !w.Proof.Verify(w.Anchor, fn) {
+		return false
+	}
+	known := make(map[uint64]Root, len(w.Proof.Leaves)+2*len(w.Preimages))
+	for i, g := range w.Proof.Gindices {
+		v, err := gindexValue(g)
+		if err != nil {
+			return false
+		}
+		known[v] = w.Proof.Leaves[i]
+	}
+	// parents sort before their children, so the root of every node is known when it is checked
+	for i := range w.Preimages {
+		p := &w.Preimages[i]
+		if i > 0 && p.Gindex <= w.Preimages[i-1].Gindex {
+			return false
+		}
+		root, ok := known[p.Gindex]
+		if !ok || fn(p.Left, p.Right) != root || p.Gindex >= 1<<63 {
+			return false
+		}
+		known[p.Gindex<<1] = p.Left
+		known[p.Gindex<<1|1] = p.Right
+	}
+	return true
+}
+
+// MarshalBinary encodes the bundle, all integers little-endian:
+//
+//	magic "MDBW" (4) | version (1) | anchor (32) | slot (8)
+//	target count (4) | per target: gindex (8), root (32)
+//	helper count (4) | per helper: root (32), in the order of HelperGindices of the targets
+//	preimage count (4) | per preimage: gindex (8), left root (32), right root (32)
+func (w *WitnessBundle) MarshalBinary() ([]byte, error) {
+	if len(w.Proof.Gindices) != len(w.Proof.Leaves) {
+		return nil, errors.New("proof has a different number of gindices and leaves")
+	}
+	var buf bytes.Buffer
+	buf.Grow(4 + 1 + 32 + 8 + 4 + 40*len(w.Proof.Leaves) + 4 + 32*len(w.Proof.Helpers) + 4 + 72*len(w.Preimages))
+	var scratch [8]byte
+	putU32 := func(v int) {
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(v))
+		buf.Write(scratch[:4])
+	}
+	putU64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(scratch[:], v)
+		buf.Write(scratch[:])
+	}
+	buf.Write(witnessMagic[:])
+	buf.WriteByte(witnessVersion)
+	buf.Write(w.Anchor[:])
+	putU64(w.Slot)
+	putU32(len(w.Proof.Gindices))
+	for i, g := range w.Proof.Gindices {
+		v, err := gindexValue(g)
+		if err != nil {
+			return nil, err
+		}
+		putU64(v)
+		buf.Write(w.Proof.Leaves[i][:])
+	}
+	putU32(len(w.Proof.Helpers))
+	for i := range w.Proof.Helpers {
+		buf.Write(w.Proof.Helpers[i][:])
+	}
+	putU32(len(w.Preimages))
+	for i := range w.Preimages {
+		putU64(w.Preimages[i].Gindex)
+		buf.Write(w.Preimages[i].Left[:])
+		buf.Write(w.Preimages[i].Right[:])
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a bundle that was encoded with MarshalBinary. It does not verify the bundle.
+func (w *WitnessBundle) UnmarshalBinary(data []byte) error {
+	const headLen = 4 + 1 + 32 + 8
+	if len(data) < headLen {
+		return fmt.Errorf("witness too short: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:4], witnessMagic[:]) {
+		return errors.New("not a witness bundle")
+	}
+	if data[4] != witnessVersion {
+		return fmt.Errorf("unknown witness version: %d", data[4])
+	}
+	out := WitnessBundle{Anchor: toRoot(data[5:37]), Slot: binary.LittleEndian.Uint64(data[37:45])}
+	pos := headLen
+	// section returns the entries of the next section, with their count, after checking that they fit
+	section := func(name string, size int) ([]byte, int, error) {
+		if len(data)-pos < 4 {
+			return nil, 0, fmt.Errorf("witness ends before the %s count", name)
+		}
+		n := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if n > maxWitnessCount || n*size > len(data)-pos {
+			return nil, 0, fmt.Errorf("%d %s do not fit in the witness", n, name)
+		}
+		entries := data[pos : pos+n*size]
+		pos += n * size
+		return entries, n, nil
+	}
+	gindex := func(b []byte) (uint64, error) {
+		g := binary.LittleEndian.Uint64(b)
+		if g == 0 {
+			return 0, errors.New("witness has a zero gindex")
+		}
+		return g, nil
+	}
+	entries, n, err := section("targets", 8+32)
+	if err != nil {
+		return err
+	}
+	out.Proof.Gindices = make([]Gindex, n)
+	out.Proof.Leaves = make([]Root, n)
+	for i := 0; i < n; i++ {
+		e := entries[i*40:]
+		g, err := gindex(e)
+		if err != nil {
+			return err
+		}
+		out.Proof.Gindices[i] = Gindex64(g)
+		out.Proof.Leaves[i] = toRoot(e[8:40])
+	}
+	if entries, n, err = section("helpers", 32); err != nil {
+		return err
+	}
+	out.Proof.Helpers = make([]Root, n)
+	for i := 0; i < n; i++ {
+		out.Proof.Helpers[i] = toRoot(entries[i*32:])
+	}
+	if entries, n, err = section("preimages", 8+32+32); err != nil {
+		return err
+	}
+	out.Preimages = make([]Preimage, n)
+	for i := 0; i < n; i++ {
+		e := entries[i*72:]
+		g, err := gindex(e)
+		if err != nil {
+			return err
+		}
+		out.Preimages[i] = Preimage{Gindex: g, Left: toRoot(e[8:40]), Right: toRoot(e[40:72])}
+	}
+	if pos != len(data) {
+		return fmt.Errorf("%d trailing bytes after the witness", len(data)-pos)
+	}
+	*w = out
+	return nil
+}
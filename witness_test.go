@@ -0,0 +1,79 @@
+package merkledb
+
+import (
+	. "github.com/protolambda/ztyp/tree"
+	"reflect"
+	"testing"
+)
+
+func TestExportWitness(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 9, 8)
+	if _, err := mdb.Put(9, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := state.HashTreeRoot(hFn)
+	validator := uint64(12)<<10 | 3
+	touched := []Gindex{
+		Gindex64(14<<8 | 1), // the chunk with balances 4 to 7
+		Gindex64(validator),
+		Gindex64(5),                // the slot
+		Gindex64(validator<<2 | 1), // the effective balance of the touched validator
+	}
+	w, err := ExportWitness(mdb, anchor, touched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Anchor != anchor || w.Slot != 9 {
+		t.Fatalf("unexpected bundle header: %s %d", w.Anchor, w.Slot)
+	}
+	var gindices []uint64
+	for _, g := range w.Proof.Gindices {
+		v, _ := gindexValue(g)
+		gindices = append(gindices, v)
+	}
+	if !reflect.DeepEqual(gindices, []uint64{5, 14<<8 | 1, validator}) {
+		t.Fatalf("unexpected proved gindices: %v", gindices)
+	}
+	// the validator container of 3 fields has a root pair and 2 pairs of fields
+	if len(w.Preimages) != 3 || w.Preimages[0].Gindex != validator || w.Preimages[2].Gindex != validator<<1|1 {
+		t.Fatalf("unexpected preimages: %+v", w.Preimages)
+	}
+	if !w.Verify(hFn) {
+		t.Fatal("expected the witness to verify")
+	}
+
+	data, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 4 + 1 + 32 + 8 + 4 + 3*40 + 4 + 32*len(w.Proof.Helpers) + 4 + 3*72; len(data) != expected {
+		t.Fatalf("expected %d bytes, got %d", expected, len(data))
+	}
+	var decoded WitnessBundle
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Verify(hFn) {
+		t.Fatal("expected the decoded witness to verify")
+	}
+	again, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, again) {
+		t.Fatal("expected the same encoding after decoding")
+	}
+
+	decoded.Preimages[2].Right[0] ^= 1
+	if decoded.Verify(hFn) {
+		t.Fatal("expected a tampered preimage to fail")
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("expected a truncated witness to fail")
+	}
+	if err := decoded.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Fatal("expected trailing bytes to fail")
+	}
+}
@@ -0,0 +1,171 @@
+package merkledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/protolambda/ztyp/tree"
+	"io"
+)
+
+var witnessStreamMagic = [4]byte{'M', 'D', 'B', 'S'}
+
+// WriteWitness writes the witness of the touched gindices in the tree of the anchor to w, like ExportWitness,
+// without holding the preimages in memory, for touched subtrees too large to materialize, like a validator registry.
+// The preimages of every touched subtree are written in DepthFirst order, parents before their children, by ascending
+// touched gindex: the same tree and touched gindices always write the same bytes. It returns the number of preimages.
+//
+// The stream is encoded like MarshalBinary, all integers little-endian, with the preimages ended by a zero gindex:
+//
+//	magic "MDBS" (4) | version (1) | anchor (32) | slot (8)
+//	target count (4) | per target: gindex (8), root (32)
+//	helper count (4) | per helper: root (32), in the order of HelperGindices of the targets
+//	per preimage: gindex (8), left root (32), right root (32)
+//	end: zero gindex (8) | preimage count (8)
+func WriteWitness(db TreeReader, anchor Root, touched []Gindex, w io.Writer) (int, error) {
+	bundle, err := witnessProof(db, anchor, touched)
+	if err != nil {
+		return 0, err
+	}
+	head, err := bundle.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	// the head of a bundle without preimages ends with their zero count
+	bw.Write(witnessStreamMagic[:])
+	bw.Write(head[len(witnessMagic) : len(head)-4])
+	var rec [8 + 32 + 32]byte
+	n := 0
+	for i, g := range bundle.Proof.Gindices {
+		src := SubtreeSource(db, NodeRef{Gindex: g, Root: bundle.Proof.Leaves[i]}, DepthFirst)
+		for {
+			node, err := src.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return n, fmt.Errorf("failed to stream the subtree at gindex %d: %w", g, err)
+			}
+			if !node.Pair {
+				continue
+			}
+			v, err := gindexValue(node.Gindex)
+			if err != nil {
+				return n, err
+			}
+			binary.LittleEndian.PutUint64(rec[:8], v)
+			copy(rec[8:40], node.Left[:])
+			copy(rec[40:72], node.Right[:])
+			if _, err := bw.Write(rec[:]); err != nil {
+				return n, err
+			}
+			n += 1
+		}
+	}
+	binary.LittleEndian.PutUint64(rec[:8], 0)
+	binary.LittleEndian.PutUint64(rec[8:16], uint64(n))
+	if _, err := bw.Write(rec[:16]); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// ReadWitness reads a witness stream of WriteWitness, and verifies it while it is read: the proof against the anchor,
+// and every preimage against the root of its node, before fn is called with it. It returns the bundle without the
+// preimages. An error of fn stops the read. The stream is read with memory in the depth of the tree, not its size.
+func ReadWitness(r io.Reader, hFn HashFn, fn func(p Preimage) error) (*WitnessBundle, error) {
+	hFn = hashFnOrDefault(hFn)
+	br := bufio.NewReader(r)
+	var head [4 + 1 + 32 + 8]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, fmt.Errorf("failed to read the witness head: %w", err)
+	}
+	if !bytes.Equal(head[:4], witnessStreamMagic[:]) {
+		return nil, errors.New("not a witness stream")
+	}
+	if head[4] != witnessVersion {
+		return nil, fmt.Errorf("unknown witness version: %d", head[4])
+	}
+	out := &WitnessBundle{Anchor: toRoot(head[5:37]), Slot: binary.LittleEndian.Uint64(head[37:45])}
+	var scratch [8 + 32 + 32]byte
+	count := func(name string) (int, error) {
+		if _, err := io.ReadFull(br, scratch[:4]); err != nil {
+			return 0, fmt.Errorf("witness ends before the %s count: %w", name, err)
+		}
+		n := int(binary.LittleEndian.Uint32(scratch[:4]))
+		if n > maxWitnessCount {
+			return 0, fmt.Errorf("too many %s: %d", name, n)
+		}
+		return n, nil
+	}
+	n, err := count("targets")
+	if err != nil {
+		return nil, err
+	}
+	// pending are the roots of the nodes whose preimages may follow, the next one on top
+	type pendingNode struct {
+		gindex uint64
+		root   Root
+	}
+	pending := make([]pendingNode, n)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(br, scratch[:40]); err != nil {
+			return nil, fmt.Errorf("failed to read target %d: %w", i, err)
+		}
+		g := binary.LittleEndian.Uint64(scratch[:8])
+		if g == 0 {
+			return nil, errors.New("witness has a zero gindex")
+		}
+		out.Proof.Gindices = append(out.Proof.Gindices, Gindex64(g))
+		out.Proof.Leaves = append(out.Proof.Leaves, toRoot(scratch[8:40]))
+		pending[n-1-i] = pendingNode{gindex: g, root: toRoot(scratch[8:40])}
+	}
+	if n, err = count("helpers"); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(br, scratch[:32]); err != nil {
+			return nil, fmt.Errorf("failed to read helper %d: %w", i, err)
+		}
+		out.Proof.Helpers = append(out.Proof.Helpers, toRoot(scratch[:32]))
+	}
+	if !out.Proof.Verify(out.Anchor, hFn) {
+		return nil, errors.New("witness proof does not verify against the anchor")
+	}
+	for n = 0; ; n++ {
+		if _, err := io.ReadFull(br, scratch[:8]); err != nil {
+			return nil, fmt.Errorf("witness ends after %d preimages: %w", n, err)
+		}
+		g := binary.LittleEndian.Uint64(scratch[:8])
+		if g == 0 {
+			break
+		}
+		if _, err := io.ReadFull(br, scratch[8:72]); err != nil {
+			return nil, fmt.Errorf("failed to read preimage %d: %w", n, err)
+		}
+		// the nodes that are skipped have no preimage, they are leaves
+		for len(pending) > 0 && pending[len(pending)-1].gindex != g {
+			pending = pending[:len(pending)-1]
+		}
+		if len(pending) == 0 {
+			return nil, fmt.Errorf("preimage %d at gindex %d is out of order, or not below a touched node", n, g)
+		}
+		p := Preimage{Gindex: g, Left: toRoot(scratch[8:40]), Right: toRoot(scratch[40:72])}
+		if g >= 1<<63 || hFn(p.Left, p.Right) != pending[len(pending)-1].root {
+			return nil, fmt.Errorf("preimage %d at gindex %d does not hash to the root of its node", n, g)
+		}
+		pending = append(pending[:len(pending)-1], pendingNode{gindex: g<<1 | 1, root: p.Right}, pendingNode{gindex: g << 1, root: p.Left})
+		if err := fn(p); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.ReadFull(br, scratch[:8]); err != nil {
+		return nil, fmt.Errorf("witness ends before the preimage count: %w", err)
+	}
+	if c := binary.LittleEndian.Uint64(scratch[:8]); c != uint64(n) {
+		return nil, fmt.Errorf("witness has %d preimages, its end counts %d", n, c)
+	}
+	return out, nil
+}
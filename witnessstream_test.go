@@ -0,0 +1,88 @@
+package merkledb
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/protolambda/ztyp/tree"
+	"testing"
+)
+
+func TestWriteWitness(t *testing.T) {
+	mdb := New(testPrefix, newMemoryDB())
+	hFn := GetHashFn()
+	state := testState(t, 9, 64)
+	if _, err := mdb.Put(9, state.Backing(), hFn); err != nil {
+		t.Fatal(err)
+	}
+	anchor := state.HashTreeRoot(hFn)
+	// the whole validator registry, and the slot
+	touched := []Gindex{Gindex64(12), Gindex64(5)}
+	var stream bytes.Buffer
+	n, err := WriteWitness(mdb, anchor, touched, &stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := ExportWitness(mdb, anchor, touched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bundle.Preimages) {
+		t.Fatalf("expected %d preimages, got %d", len(bundle.Preimages), n)
+	}
+
+	// the same witness streams the same bytes
+	var again bytes.Buffer
+	if _, err := WriteWitness(mdb, anchor, touched, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stream.Bytes(), again.Bytes()) {
+		t.Fatal("expected a deterministic stream")
+	}
+
+	expected := make(map[Preimage]struct{})
+	for _, p := range bundle.Preimages {
+		expected[p] = struct{}{}
+	}
+	var last uint64
+	read, err := ReadWitness(bytes.NewReader(stream.Bytes()), hFn, func(p Preimage) error {
+		if _, ok := expected[p]; !ok {
+			t.Fatalf("unexpected preimage at gindex %d", p.Gindex)
+		}
+		delete(expected, p)
+		last = p.Gindex
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) != 0 || read.Anchor != anchor || read.Slot != 9 || len(read.Proof.Leaves) != 2 {
+		t.Fatalf("unexpected witness: %d preimages missing, %+v", len(expected), read)
+	}
+	// depth first, the last preimage is the rightmost pair of the registry: the last validator
+	if last < 12 || last&1 != 1 {
+		t.Fatalf("unexpected last preimage at gindex %d", last)
+	}
+
+	stop := errors.New("stop")
+	if _, err := ReadWitness(bytes.NewReader(stream.Bytes()), hFn, func(Preimage) error { return stop }); err != stop {
+		t.Fatalf("expected the error of the callback, got %v", err)
+	}
+	data := stream.Bytes()
+	for name, corrupt := range map[string][]byte{
+		"truncated":          data[:len(data)-1],
+		"tampered preimage":  flipByte(data, len(data)-16-40),
+		"tampered proof":     flipByte(data, 4+1+32+8+4+8),
+		"wrong count":        flipByte(data, len(data)-8),
+		"bundle, not stream": append([]byte("MDBW"), data[4:]...),
+	} {
+		if _, err := ReadWitness(bytes.NewReader(corrupt), hFn, func(Preimage) error { return nil }); err == nil {
+			t.Fatalf("%s: expected the witness to fail", name)
+		}
+	}
+}
+
+func flipByte(data []byte, i int) []byte {
+	out := append([]byte(nil), data...)
+	out[i] ^= 1
+	return out
+}